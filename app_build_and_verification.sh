@@ -166,7 +166,7 @@ run_api_tests() {
   if [ ${CURL_STATUS} -ne 0 ]; then
     log_error "   Curl command failed for /ready endpoint (${test_name}). Status: ${CURL_STATUS}"
     test_failed_local=true
-  elif [[ "${READY_RESPONSE}" == "Ready to serve traffic!" ]]; then
+  elif [[ "${READY_RESPONSE}" =~ ^\{\"status\":\"(pass|warn)\" ]]; then
     log_success "   /ready endpoint is Ready."
   else
     log_error "   /ready endpoint FAILED for ${test_name}. Response: '${READY_RESPONSE}'"
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+)
+
+// runAuditVerify implements "key-server audit verify [-head HASH] FILE". It
+// returns the process exit code: 0 if the log is intact, 1 if verification
+// failed and 2 on usage errors.
+func runAuditVerify(args []string) int {
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	head := fs.String("head", "", "expected hash of the final entry, as logged at shutdown (detects truncation)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: key-server audit verify [-head HASH] FILE")
+		fs.PrintDefaults()
+	}
+	switch err := fs.Parse(args); {
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case err != nil:
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening audit log: %v\n", err)
+		return exitUsage
+	}
+	defer f.Close()
+
+	sum, err := audit.Verify(f, *head)
+	if err != nil {
+		fmt.Printf("FAILED: %s: %v (%d entries verified before the failure)\n", fs.Arg(0), err, sum.Entries)
+		return exitError
+	}
+	fmt.Printf("OK: %s: %d entries, head %s\n", fs.Arg(0), sum.Entries, sum.Head)
+	return exitOK
+}
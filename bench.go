@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+)
+
+// Benchmark targets.
+const (
+	benchGenerator = "generator" // keygenerator alone
+	benchService   = "service"   // keyservice with metrics and an in-memory transparency log
+)
+
+// benchResult summarizes one benchmark run.
+type benchResult struct {
+	target  string
+	ops     int64
+	errors  int64
+	bytes   int64
+	latency time.Duration // Sum over successful operations
+	elapsed time.Duration
+}
+
+// runBench implements "key-server bench [flags]". It drives the key
+// generator and key service in process from several goroutines and reports
+// their throughput, so generator or service regressions can be measured
+// without the HTTP stack.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	duration := fs.Duration("duration", 5*time.Second, "how long to run each target")
+	concurrency := fs.Int("concurrency", runtime.GOMAXPROCS(0), "number of concurrent callers")
+	length := fs.Int("length", 32, "key length in bytes")
+	targets := fs.String("targets", benchGenerator+","+benchService, "comma-separated targets: generator, service")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: key-server bench [-duration D] [-concurrency N] [-length N] [-targets T]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 || *duration <= 0 || *concurrency < 1 || *length < 1 || *length > config.MaxKeySizeLimit {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var results []benchResult
+	for _, target := range strings.Split(*targets, ",") {
+		op, err := benchTarget(target, *length)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		results = append(results, benchmark(target, *concurrency, *duration, int64(*length), op))
+	}
+
+	fmt.Printf("Key length %d bytes, %d concurrent callers, %s per target\n\n", *length, *concurrency, *duration)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TARGET\tOPS\tOPS/S\tMB/S\tMEAN\tERRORS\t")
+	status := exitOK
+	for _, r := range results {
+		secs := r.elapsed.Seconds()
+		var mean time.Duration
+		if ok := r.ops - r.errors; ok > 0 {
+			mean = r.latency / time.Duration(ok)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.0f\t%.2f\t%s\t%d\t\n", r.target, r.ops, float64(r.ops)/secs, float64(r.bytes)/secs/1e6, mean, r.errors)
+		if r.errors > 0 {
+			status = exitError
+		}
+	}
+	tw.Flush()
+	return status
+}
+
+// benchTarget returns the operation benchmarked for target.
+func benchTarget(target string, length int) (func(context.Context) error, error) {
+	switch target {
+	case benchGenerator:
+		generator := keygenerator.NewCryptoKeyGenerator()
+		return func(ctx context.Context) error {
+			_, err := generator.Generate(ctx, length)
+			return err
+		}, nil
+	case benchService:
+		cfg := config.Defaults()
+		cfg.MaxSize = max(cfg.MaxSize, length)
+		tlog, err := transparency.Open(transparency.Options{})
+		if err != nil {
+			return nil, err
+		}
+		m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), cfg.MaxSize)
+		svc := keyservice.NewKeyService(keygenerator.NewCryptoKeyGenerator(), config.NewStore(cfg), m,
+			policy.Disabled(), audit.Disabled(), tlog, logging.Discard())
+		return func(ctx context.Context) error {
+			_, err := svc.GenerateKey(ctx, length)
+			return err
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown bench target %q: must be %s or %s", target, benchGenerator, benchService)
+}
+
+// benchmark calls op from concurrency goroutines until duration has passed.
+func benchmark(target string, concurrency int, duration time.Duration, bytesPerOp int64, op func(context.Context) error) benchResult {
+	ctx := context.Background()
+	deadline := time.Now().Add(duration)
+	result := benchResult{target: target}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local benchResult
+			for time.Now().Before(deadline) {
+				t := time.Now()
+				err := op(ctx)
+				local.ops++
+				if err != nil {
+					local.errors++
+					continue
+				}
+				local.latency += time.Since(t)
+				local.bytes += bytesPerOp
+			}
+			mu.Lock()
+			result.ops += local.ops
+			result.errors += local.errors
+			result.bytes += local.bytes
+			result.latency += local.latency
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+	return result
+}
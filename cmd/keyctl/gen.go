@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// Key encodings.
+const (
+	encodingBase64URL = "base64url" // As returned by the server
+	encodingBase64    = "base64"
+	encodingHex       = "hex"
+	encodingRaw       = "raw"
+)
+
+// fileExtensions names the files written with -dir.
+var fileExtensions = map[string]string{
+	encodingBase64URL: "b64",
+	encodingBase64:    "b64",
+	encodingHex:       "hex",
+	encodingRaw:       "bin",
+}
+
+// genResult is one generated key as reported with -output json.
+type genResult struct {
+	Length   int    `json:"length"`
+	Encoding string `json:"encoding"`
+	Key      string `json:"key,omitempty"`  // Omitted when written to a file
+	File     string `json:"file,omitempty"` // Set when written to a file
+	Error    string `json:"error,omitempty"`
+
+	raw []byte
+}
+
+// runGen implements "keyctl gen": it requests count keys of each length,
+// up to parallel at a time, and prints them or writes them to files.
+func runGen(ctx context.Context, c *keyclient.Client, opts globalOptions, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("keyctl gen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	encoding := fs.String("encoding", encodingBase64URL, "Key encoding: base64url, base64, hex or raw")
+	count := fs.Int("count", 1, "Number of keys to generate for each length")
+	parallel := fs.Int("parallel", 4, "Maximum number of concurrent requests")
+	outFile := fs.String("o", "", "Write the key to this file (a single key only)")
+	outDir := fs.String("dir", "", "Write each key to its own file in this directory")
+	force := fs.Bool("force", false, "Replace existing files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: keyctl gen [flags] LENGTH...\n\nGenerates keys of LENGTH bytes. Files are created with mode 0600 and\ndirectories with mode 0700.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	usage := func(format string, args ...any) int {
+		fmt.Fprintf(stderr, "keyctl gen: "+format+"\n", args...)
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		return usage("at least one LENGTH is required")
+	}
+	var lengths []int
+	for _, arg := range fs.Args() {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return usage("invalid length %q: must be a positive integer", arg)
+		}
+		lengths = append(lengths, n)
+	}
+	if _, ok := fileExtensions[*encoding]; !ok {
+		return usage("invalid -encoding %q: must be base64url, base64, hex or raw", *encoding)
+	}
+	if *count < 1 || *parallel < 1 {
+		return usage("-count and -parallel must be at least 1")
+	}
+	total := len(lengths) * *count
+	switch {
+	case *outFile != "" && *outDir != "":
+		return usage("-o and -dir are mutually exclusive")
+	case *outFile != "" && total > 1:
+		return usage("-o writes a single key; use -dir for %d keys", total)
+	case *encoding == encodingRaw && *outFile == "" && *outDir == "" && (total > 1 || opts.output == outputJSON):
+		return usage("raw keys can only be printed one at a time in text output; use -o or -dir")
+	}
+
+	results := make([]genResult, 0, total)
+	for _, n := range lengths {
+		for i := 0; i < *count; i++ {
+			results = append(results, genResult{Length: n, Encoding: *encoding})
+		}
+	}
+	generate(ctx, c, results, *parallel)
+
+	failed := false
+	for i := range results {
+		r := &results[i]
+		if r.Error != "" {
+			failed = true
+			continue
+		}
+		encoded := encode(r.raw, *encoding)
+		path := *outFile
+		if *outDir != "" {
+			path = filepath.Join(*outDir, fmt.Sprintf("key-%04d.%s", i+1, fileExtensions[*encoding]))
+		}
+		if path == "" {
+			r.Key = string(encoded)
+			continue
+		}
+		if *encoding != encodingRaw {
+			encoded = append(encoded, '\n')
+		}
+		if err := writeKeyFile(path, encoded, *force); err != nil {
+			r.Error = err.Error()
+			failed = true
+			continue
+		}
+		r.File = path
+	}
+
+	if opts.output == outputJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintf(stderr, "keyctl gen: %v\n", err)
+			return exitError
+		}
+	} else {
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				fmt.Fprintf(stderr, "keyctl gen: %d-byte key: %s\n", r.Length, r.Error)
+			case r.File != "":
+				fmt.Fprintf(stdout, "Wrote %d-byte %s key to %s\n", r.Length, r.Encoding, r.File)
+			case r.Encoding == encodingRaw:
+				stdout.Write([]byte(r.Key))
+			default:
+				fmt.Fprintln(stdout, r.Key)
+			}
+		}
+	}
+	if failed {
+		return exitError
+	}
+	return exitOK
+}
+
+// generate fills in results concurrently, at most parallel requests at a
+// time.
+func generate(ctx context.Context, c *keyclient.Client, results []genResult, parallel int) {
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *genResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			key, err := c.GenerateKey(ctx, r.Length)
+			if err != nil {
+				r.Error = err.Error()
+				return
+			}
+			r.raw = key
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+func encode(key []byte, encoding string) []byte {
+	switch encoding {
+	case encodingBase64:
+		return []byte(base64.StdEncoding.EncodeToString(key))
+	case encodingHex:
+		return []byte(hex.EncodeToString(key))
+	case encodingRaw:
+		return key
+	default:
+		return []byte(base64.URLEncoding.EncodeToString(key))
+	}
+}
+
+// writeKeyFile writes a key readable only by its owner, creating missing
+// directories with mode 0700. Existing files are left alone unless force is
+// set, in which case the file is replaced atomically.
+func writeKeyFile(path string, data []byte, force bool) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if !force {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return fmt.Errorf("%s already exists; use -force to replace it", path)
+			}
+			return err
+		}
+		if err := writeAndClose(f, data); err != nil {
+			os.Remove(path)
+			return err
+		}
+		return nil
+	}
+
+	// CreateTemp uses mode 0600.
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := writeAndClose(f, data); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func writeAndClose(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
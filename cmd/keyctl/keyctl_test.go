@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestServer starts a TLS server with the key server's /key and /ready
+// endpoints and returns it with the path of its CA bundle.
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		n, _ := strconv.Atoi(r.PathValue("length"))
+		if n > 64 {
+			http.Error(w, "key length out of allowed range", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"key": base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{byte(n)}, n))})
+	})
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/health+json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"status":"fail","draining":true,"checks":{"entropy":{"status":"pass"},"generator":{"status":"fail","error":"broken"}}}`)
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, pemBytes, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return srv, caFile
+}
+
+func TestRun(t *testing.T) {
+	srv, caFile := newTestServer(t)
+	dir := t.TempDir()
+	global := []string{"-server", srv.URL, "-cacert", caFile, "-api-key", "secret"}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStatus int
+		wantStdout []string
+		wantStderr string
+	}{
+		{"Base64url", []string{"gen", "4"}, exitOK, []string{"BAQEBA==\n"}, ""},
+		{"Hex batch", []string{"gen", "-encoding", "hex", "-count", "2", "2", "3"}, exitOK,
+			[]string{"0202\n0202\n030303\n030303\n"}, ""},
+		{"JSON", []string{"-output", "json", "gen", "-encoding", "base64", "1"}, exitOK,
+			[]string{`"length": 1`, `"encoding": "base64"`, `"key": "AQ=="`}, ""},
+		{"Directory", []string{"gen", "-dir", filepath.Join(dir, "keys"), "-encoding", "raw", "2", "8"}, exitOK,
+			[]string{"Wrote 2-byte raw key to " + filepath.Join(dir, "keys", "key-0001.bin")}, ""},
+		{"Server error", []string{"gen", "8", "100"}, exitError, []string{"CAgICAgICAg=\n"}, "400 Bad Request: key length out of allowed range"},
+		{"Unauthenticated", []string{"-api-key", "", "gen", "8"}, exitError, nil, "401 Unauthorized"},
+		{"Untrusted server", []string{"-cacert", "", "gen", "8"}, exitError, nil, "certificate"},
+		{"Not ready", []string{"ready"}, exitError, []string{"Status: fail (draining)", "generator        fail: broken"}, ""},
+		{"Invalid length", []string{"gen", "0"}, exitUsage, nil, "invalid length"},
+		{"Raw batch to stdout", []string{"gen", "-encoding", "raw", "1", "2"}, exitUsage, nil, "use -o or -dir"},
+		{"Unknown command", []string{"nope"}, exitUsage, nil, `unknown command "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := run(context.Background(), append(append([]string{}, global...), tt.args...), &stdout, &stderr)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d; stderr:\n%s", status, tt.wantStatus, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestWriteKeyFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "secrets")
+	path := filepath.Join(dir, "key.hex")
+
+	if err := writeKeyFile(path, []byte(hex.EncodeToString([]byte("one"))), false); err != nil {
+		t.Fatalf("writeKeyFile returned an error: %v", err)
+	}
+	for p, want := range map[string]os.FileMode{dir: 0o700, path: 0o600} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got&^want != 0 {
+			t.Errorf("%s has mode %v, want at most %v", p, got, want)
+		}
+	}
+
+	if err := writeKeyFile(path, []byte("two"), false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("overwriting without force: error = %v, want a hint to use -force", err)
+	}
+	if err := writeKeyFile(path, []byte("two"), true); err != nil {
+		t.Fatalf("writeKeyFile with force returned an error: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "two" {
+		t.Errorf("file contains %q after a forced write, want %q", got, "two")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the key file", len(entries))
+	}
+}
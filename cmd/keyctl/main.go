@@ -0,0 +1,146 @@
+// Command keyctl is a command-line client for the key server.
+//
+// Usage:
+//
+//	keyctl [global flags] gen [flags] LENGTH...
+//	keyctl [global flags] ready
+//	keyctl version
+//
+// Global flags can also be set with KEYCTL_* environment variables; run
+// "keyctl -h" for the list.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/buildinfo"
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// Exit statuses.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Output formats.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// globalOptions are the flags shared by every command.
+type globalOptions struct {
+	server     string
+	caFile     string
+	certFile   string
+	keyFile    string
+	apiKey     string
+	token      string
+	timeout    time.Duration
+	output     string
+	serverName string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line and returns the exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("keyctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts globalOptions
+	fs.StringVar(&opts.server, "server", envOr("KEYCTL_SERVER", "https://localhost:8443"), "Key server base URL (KEYCTL_SERVER)")
+	fs.StringVar(&opts.caFile, "cacert", os.Getenv("KEYCTL_CACERT"), "PEM CA bundle used to verify the server instead of the system roots (KEYCTL_CACERT)")
+	fs.StringVar(&opts.certFile, "cert", os.Getenv("KEYCTL_CERT"), "PEM client certificate for mutual TLS (KEYCTL_CERT)")
+	fs.StringVar(&opts.keyFile, "key", os.Getenv("KEYCTL_KEY"), "PEM private key of the client certificate (KEYCTL_KEY)")
+	fs.StringVar(&opts.serverName, "server-name", os.Getenv("KEYCTL_SERVER_NAME"), "Name to verify the server certificate against, if it differs from the URL host (KEYCTL_SERVER_NAME)")
+	fs.StringVar(&opts.apiKey, "api-key", os.Getenv("KEYCTL_API_KEY"), "API key sent in the X-API-Key header (KEYCTL_API_KEY)")
+	fs.StringVar(&opts.token, "token", os.Getenv("KEYCTL_TOKEN"), "JWT sent as a bearer token (KEYCTL_TOKEN)")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "Timeout for each request attempt; failed requests are retried with backoff")
+	fs.StringVar(&opts.output, "output", outputText, "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: keyctl [global flags] COMMAND [flags] [args]\n\n")
+		fmt.Fprintf(fs.Output(), "Commands:\n")
+		fmt.Fprintf(fs.Output(), "  gen      Generate keys\n")
+		fmt.Fprintf(fs.Output(), "  ready    Show the server's readiness report\n")
+		fmt.Fprintf(fs.Output(), "  version  Print the keyctl version\n\n")
+		fmt.Fprintf(fs.Output(), "Global flags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if opts.output != outputText && opts.output != outputJSON {
+		fmt.Fprintf(stderr, "keyctl: invalid -output %q: must be text or json\n", opts.output)
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	command, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	if command == "version" {
+		fmt.Fprintf(stdout, "keyctl %s\n", buildinfo.Get().Version)
+		return exitOK
+	}
+
+	var cmd func(context.Context, *keyclient.Client, globalOptions, []string, io.Writer, io.Writer) int
+	switch command {
+	case "gen":
+		cmd = runGen
+	case "ready":
+		cmd = runReady
+	default:
+		fmt.Fprintf(stderr, "keyctl: unknown command %q\n", command)
+		fs.Usage()
+		return exitUsage
+	}
+
+	c, err := newClient(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "keyctl: %v\n", err)
+		return exitUsage
+	}
+	return cmd(ctx, c, opts, cmdArgs, stdout, stderr)
+}
+
+// newClient builds an API client from the global options. TLS is verified
+// against -cacert when given and the system roots otherwise.
+func newClient(opts globalOptions) (*keyclient.Client, error) {
+	tlsConfig, err := keyclient.TLSConfigFromFiles(opts.caFile, opts.certFile, opts.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = opts.serverName
+	return keyclient.New(opts.server, keyclient.Options{
+		TLSConfig: tlsConfig,
+		Timeout:   opts.timeout,
+		APIKey:    opts.apiKey,
+		Token:     opts.token,
+		UserAgent: "keyctl/" + buildinfo.Get().Version,
+	})
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// runReady implements "keyctl ready": it prints the server's readiness
+// report and exits non-zero when the server is not ready.
+func runReady(ctx context.Context, c *keyclient.Client, opts globalOptions, args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		fmt.Fprintf(stderr, "keyctl ready: unexpected arguments %q\n", args)
+		return exitUsage
+	}
+	report, err := c.Ready(ctx)
+	if report == nil {
+		fmt.Fprintf(stderr, "keyctl ready: %v\n", err)
+		return exitError
+	}
+
+	if opts.output == outputJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "keyctl ready: %v\n", err)
+			return exitError
+		}
+	} else {
+		status := report.Status
+		if report.Draining {
+			status += " (draining)"
+		}
+		fmt.Fprintf(stdout, "Status: %s\n", status)
+		names := make([]string, 0, len(report.Checks))
+		for name := range report.Checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			check := report.Checks[name]
+			fmt.Fprintf(stdout, "  %-16s %s", name, check.Status)
+			if check.Error != "" {
+				fmt.Fprintf(stdout, ": %s", check.Error)
+			}
+			fmt.Fprintln(stdout)
+		}
+	}
+
+	if err != nil {
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+)
+
+// runConfigCheck implements "key-server config check [flags]". It loads the
+// configuration exactly as the server would (the same file, environment and
+// flags), prints the effective settings as YAML with secrets redacted, and
+// checks that the referenced files exist. It returns the process exit code:
+// 0 if the configuration is valid, 1 if it is not and 2 on usage errors.
+func runConfigCheck(args []string) int {
+	cfg, err := config.Load(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, config.ErrUsage):
+		return 2
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "INVALID: configuration has errors:\n%v\n", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+
+	if err := cfg.CheckFiles(); err != nil {
+		fmt.Fprintf(os.Stderr, "INVALID: configured files are not usable:\n%v\n", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "OK: configuration is valid")
+	return 0
+}
@@ -221,7 +221,7 @@ generate_certificates() {
     else
         openssl genrsa -out server.key 2048
         echo "DEBUG: openssl genrsa exit code: $?"
-        openssl req -x509 -nodes -days 365 -newkey rsa:2048 -keyout server.key -out server.crt -subj "/CN=localhost"
+        openssl req -x509 -nodes -days 365 -newkey rsa:2048 -keyout server.key -out server.crt -subj "/CN=localhost" -addext "subjectAltName=DNS:localhost,IP:127.0.0.1"
         echo "DEBUG: openssl req exit code: $?"
         if [ $? -ne 0 ]; then
             print_error "Failed to generate SSL certificates. Please check OpenSSL installation."
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+)
+
+// runGen implements "key-server gen [-encoding E] [-count N] LENGTH...". It
+// generates keys with the server's key generator, without a running server
+// (so nothing is audited or logged), and prints one key per line.
+func runGen(args []string) int {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	encoding := fs.String("encoding", "base64url", "key encoding: base64url (as served by /key), base64 or hex")
+	count := fs.Int("count", 1, "number of keys to generate for each length")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: key-server gen [-encoding E] [-count N] LENGTH...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 || *count < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	var encode func([]byte) string
+	switch *encoding {
+	case "base64url":
+		encode = base64.URLEncoding.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "hex":
+		encode = hex.EncodeToString
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -encoding %q: must be base64url, base64 or hex\n", *encoding)
+		return exitUsage
+	}
+	var lengths []int
+	for _, arg := range fs.Args() {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > config.MaxKeySizeLimit {
+			fmt.Fprintf(os.Stderr, "Invalid length %q: must be between 1 and %d\n", arg, config.MaxKeySizeLimit)
+			return exitUsage
+		}
+		lengths = append(lengths, n)
+	}
+
+	generator := keygenerator.NewCryptoKeyGenerator()
+	for _, n := range lengths {
+		for i := 0; i < *count; i++ {
+			key, err := generator.Generate(context.Background(), n)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error generating key: %v\n", err)
+				return exitError
+			}
+			fmt.Println(encode(key))
+		}
+	}
+	return exitOK
+}
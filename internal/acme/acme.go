@@ -0,0 +1,928 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/ca"
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+)
+
+// PathPrefix is where the ACME API is mounted on the key server router.
+const PathPrefix = "/acme"
+
+// Object statuses (RFC 8555 §7.1.6).
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+const (
+	challengeHTTP01 = "http-01"
+	orderLifetime   = 7 * 24 * time.Hour
+	// finishedOrderRetention is how long a valid or invalid order (and its
+	// certificate) stays retrievable before it is dropped.
+	finishedOrderRetention = 24 * time.Hour
+)
+
+// Options tunes the ACME server. The zero value is usable.
+type Options struct {
+	// AllowedDomains restricts which DNS identifiers may be ordered. A name is
+	// allowed if it equals an entry or is a subdomain of one. Empty rejects
+	// every order.
+	AllowedDomains []string
+	// CertificateValidity is the lifetime of issued certificates
+	// (defaults to ca.DefaultLeafValidity).
+	CertificateValidity time.Duration
+	// HTTP01Port is the port used to fetch http-01 challenge responses (default 80).
+	HTTP01Port int
+	// HTTPClient is used for http-01 validation requests. Unless it sets
+	// CheckRedirect, redirects are only followed to DNS names over http or
+	// https on ports 80 and 443.
+	HTTPClient *http.Client
+	// MaxAccountsPerClient caps how many accounts one client IP address may
+	// register (default 10).
+	MaxAccountsPerClient int
+	// MaxOrdersPerAccount caps how many orders an account may hold at once
+	// (default 100). Orders are dropped when they expire or
+	// finishedOrderRetention after they become valid or invalid.
+	MaxOrdersPerAccount int
+	// Audit records every certificate issuance (defaults to a disabled
+	// logger). A certificate whose issuance cannot be recorded is withheld.
+	Audit *audit.Logger
+}
+
+// Server implements the RFC 8555 ACME protocol on top of the internal CA.
+type Server struct {
+	authority *ca.Authority
+	opts      Options
+	nonces    *nonceStore
+	logger    *slog.Logger
+
+	mu               sync.Mutex
+	accounts         map[string]*account // by ID
+	accountsByKey    map[string]*account // by JWK thumbprint
+	accountsByClient map[string]int      // accounts registered per client IP
+	orders           map[string]*order
+	authorizations   map[string]*authorization
+	challenges       map[string]*challenge
+	// expiring and finished hold order IDs in the order they must be dropped:
+	// by expiry, and by finishedOrderRetention after the order finished.
+	expiring []orderDeadline
+	finished []orderDeadline
+}
+
+type orderDeadline struct {
+	orderID string
+	at      time.Time
+}
+
+type account struct {
+	ID       string
+	Status   string
+	Contact  []string
+	key      crypto.PublicKey
+	orderIDs []string
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	ID           string
+	AccountID    string
+	Status       string
+	Expires      time.Time
+	Identifiers  []identifier
+	AuthzIDs     []string
+	CertChainPEM []byte
+	Error        *problem
+}
+
+type authorization struct {
+	ID           string
+	AccountID    string
+	OrderID      string
+	Identifier   identifier
+	Status       string
+	Expires      time.Time
+	ChallengeIDs []string
+}
+
+type challenge struct {
+	ID        string
+	AuthzID   string
+	Type      string
+	Token     string
+	Status    string
+	Validated time.Time
+	Error     *problem
+}
+
+// NewServer creates an ACME server that issues certificates from authority.
+func NewServer(authority *ca.Authority, opts Options, logger *slog.Logger) *Server {
+	if opts.HTTP01Port == 0 {
+		opts.HTTP01Port = 80
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.HTTPClient.CheckRedirect == nil {
+		// A copy, so the caller's client is left as it is.
+		client := *opts.HTTPClient
+		client.CheckRedirect = http01RedirectPolicy(opts.HTTP01Port)
+		opts.HTTPClient = &client
+	}
+	if opts.CertificateValidity == 0 {
+		opts.CertificateValidity = ca.DefaultLeafValidity
+	}
+	if opts.MaxAccountsPerClient == 0 {
+		opts.MaxAccountsPerClient = 10
+	}
+	if opts.MaxOrdersPerAccount == 0 {
+		opts.MaxOrdersPerAccount = 100
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Disabled()
+	}
+	return &Server{
+		authority:        authority,
+		opts:             opts,
+		nonces:           newNonceStore(maxNonces),
+		logger:           logger,
+		accounts:         make(map[string]*account),
+		accountsByKey:    make(map[string]*account),
+		accountsByClient: make(map[string]int),
+		orders:           make(map[string]*order),
+		authorizations:   make(map[string]*authorization),
+		challenges:       make(map[string]*challenge),
+	}
+}
+
+// RegisterRoutes mounts the ACME endpoints under PathPrefix.
+func (s *Server) RegisterRoutes(router *mux.Router) {
+	sub := router.PathPrefix(PathPrefix).Subrouter()
+	sub.HandleFunc("/directory", s.handleDirectory).Methods("GET")
+	sub.HandleFunc("/new-nonce", s.handleNewNonce).Methods("HEAD", "GET")
+	sub.HandleFunc("/new-account", s.handleNewAccount).Methods("POST")
+	sub.HandleFunc("/account/{id}", s.handleAccount).Methods("POST")
+	sub.HandleFunc("/account/{id}/orders", s.handleAccountOrders).Methods("POST")
+	sub.HandleFunc("/new-order", s.handleNewOrder).Methods("POST")
+	sub.HandleFunc("/order/{id}", s.handleOrder).Methods("POST")
+	sub.HandleFunc("/order/{id}/finalize", s.handleFinalize).Methods("POST")
+	sub.HandleFunc("/authz/{id}", s.handleAuthorization).Methods("POST")
+	sub.HandleFunc("/chall/{id}", s.handleChallenge).Methods("POST")
+	sub.HandleFunc("/cert/{id}", s.handleCertificate).Methods("POST")
+}
+
+// handleDirectory serves the directory object (RFC 8555 §7.1.1).
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+	directory := map[string]interface{}{
+		"newNonce":   base + "/new-nonce",
+		"newAccount": base + "/new-account",
+		"newOrder":   base + "/new-order",
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(directory); err != nil {
+		s.logger.ErrorContext(r.Context(), "Error encoding ACME directory", "error", err)
+	}
+}
+
+// handleNewNonce issues a fresh anti-replay nonce (RFC 8555 §7.2).
+func (s *Server) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.nonces.issue())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Link", fmt.Sprintf("<%s/directory>;rel=\"index\"", s.baseURL(r)))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleNewAccount creates or looks up an account (RFC 8555 §7.3).
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, true)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, r, malformed("invalid newAccount payload"))
+		return
+	}
+	for _, c := range payload.Contact {
+		if !strings.HasPrefix(c, "mailto:") {
+			s.writeProblem(w, r, &problem{Type: errUnsupportedContact, Detail: fmt.Sprintf("unsupported contact %q", c), Status: http.StatusBadRequest})
+			return
+		}
+	}
+	thumbprint, err := jose.Thumbprint(req.key)
+	if err != nil {
+		s.writeProblem(w, r, &problem{Type: errBadPublicKey, Detail: err.Error(), Status: http.StatusBadRequest})
+		return
+	}
+
+	s.mu.Lock()
+	acct, exists := s.accountsByKey[thumbprint]
+	client := clientIP(r)
+	if !exists && !payload.OnlyReturnExisting {
+		if s.accountsByClient[client] >= s.opts.MaxAccountsPerClient {
+			s.mu.Unlock()
+			s.writeProblem(w, r, rateLimited("too many accounts registered from this address"))
+			return
+		}
+		acct = &account{
+			ID:      newID(),
+			Status:  statusValid,
+			Contact: payload.Contact,
+			key:     req.key,
+		}
+		s.accounts[acct.ID] = acct
+		s.accountsByKey[thumbprint] = acct
+		s.accountsByClient[client]++
+	}
+	var view map[string]interface{}
+	if acct != nil {
+		view = s.accountView(r, acct)
+	}
+	s.mu.Unlock()
+
+	if acct == nil {
+		s.writeProblem(w, r, &problem{Type: errAccountDoesNotExist, Detail: "no account exists for this key", Status: http.StatusBadRequest})
+		return
+	}
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+		s.logger.InfoContext(r.Context(), "ACME account created", "account", acct.ID)
+	}
+	w.Header().Set("Location", s.baseURL(r)+"/account/"+acct.ID)
+	s.writeJSON(w, r, status, view)
+}
+
+// handleAccount fetches, updates or deactivates an account (RFC 8555 §7.3.2, §7.3.6).
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	if req.account.ID != mux.Vars(r)["id"] {
+		s.writeProblem(w, r, unauthorized("account URL does not match kid"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !req.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			s.writeProblem(w, r, malformed("invalid account update payload"))
+			return
+		}
+		if payload.Contact != nil {
+			req.account.Contact = payload.Contact
+		}
+		switch payload.Status {
+		case "":
+		case statusDeactivated:
+			req.account.Status = statusDeactivated
+			s.logger.InfoContext(r.Context(), "ACME account deactivated", "account", req.account.ID)
+		default:
+			s.writeProblem(w, r, malformed("status may only be set to deactivated"))
+			return
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, s.accountView(r, req.account))
+}
+
+// handleAccountOrders lists the account's orders (RFC 8555 §7.1.2.1).
+func (s *Server) handleAccountOrders(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	if req.account.ID != mux.Vars(r)["id"] {
+		s.writeProblem(w, r, unauthorized("account URL does not match kid"))
+		return
+	}
+
+	s.mu.Lock()
+	urls := make([]string, 0, len(req.account.orderIDs))
+	for _, id := range req.account.orderIDs {
+		urls = append(urls, s.baseURL(r)+"/order/"+id)
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{"orders": urls})
+}
+
+// handleNewOrder creates an order with one http-01 authorization per identifier (RFC 8555 §7.4).
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil || len(payload.Identifiers) == 0 {
+		s.writeProblem(w, r, malformed("newOrder requires at least one identifier"))
+		return
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		s.writeProblem(w, r, malformed("notBefore and notAfter are not supported"))
+		return
+	}
+	seen := make(map[string]bool)
+	var identifiers []identifier
+	for _, id := range payload.Identifiers {
+		value := strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		if id.Type != "dns" {
+			s.writeProblem(w, r, &problem{Type: errUnsupportedIdentifier, Detail: fmt.Sprintf("identifier type %q is not supported", id.Type), Status: http.StatusBadRequest})
+			return
+		}
+		if p := s.checkDomain(value); p != nil {
+			s.writeProblem(w, r, p)
+			return
+		}
+		if !seen[value] {
+			seen[value] = true
+			identifiers = append(identifiers, identifier{Type: "dns", Value: value})
+		}
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.pruneLocked(now)
+	if len(req.account.orderIDs) >= s.opts.MaxOrdersPerAccount {
+		s.mu.Unlock()
+		s.writeProblem(w, r, rateLimited("too many outstanding orders for this account"))
+		return
+	}
+	o := &order{
+		ID:          newID(),
+		AccountID:   req.account.ID,
+		Status:      statusPending,
+		Expires:     now.Add(orderLifetime).UTC(),
+		Identifiers: identifiers,
+	}
+	for _, id := range identifiers {
+		chall := &challenge{ID: newID(), Type: challengeHTTP01, Token: newID(), Status: statusPending}
+		authz := &authorization{
+			ID:           newID(),
+			AccountID:    req.account.ID,
+			OrderID:      o.ID,
+			Identifier:   id,
+			Status:       statusPending,
+			Expires:      o.Expires,
+			ChallengeIDs: []string{chall.ID},
+		}
+		chall.AuthzID = authz.ID
+		s.challenges[chall.ID] = chall
+		s.authorizations[authz.ID] = authz
+		o.AuthzIDs = append(o.AuthzIDs, authz.ID)
+	}
+	s.orders[o.ID] = o
+	s.expiring = append(s.expiring, orderDeadline{orderID: o.ID, at: o.Expires})
+	req.account.orderIDs = append(req.account.orderIDs, o.ID)
+	view := s.orderView(r, o)
+	s.mu.Unlock()
+
+	w.Header().Set("Location", s.baseURL(r)+"/order/"+o.ID)
+	s.writeJSON(w, r, http.StatusCreated, view)
+}
+
+// handleOrder returns the current state of an order.
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[mux.Vars(r)["id"]]
+	if !ok || o.AccountID != req.account.ID {
+		s.writeProblem(w, r, notFound("order not found"))
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.orderView(r, o))
+}
+
+// handleAuthorization returns an authorization, or deactivates it (RFC 8555 §7.5, §7.5.2).
+func (s *Server) handleAuthorization(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authz, ok := s.authorizations[mux.Vars(r)["id"]]
+	if !ok || authz.AccountID != req.account.ID {
+		s.writeProblem(w, r, notFound("authorization not found"))
+		return
+	}
+	if !req.postAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil || payload.Status != statusDeactivated {
+			s.writeProblem(w, r, malformed("authorizations may only be deactivated"))
+			return
+		}
+		authz.Status = statusDeactivated
+		s.updateOrderLocked(s.orders[authz.OrderID])
+	}
+	s.writeJSON(w, r, http.StatusOK, s.authorizationView(r, authz))
+}
+
+// handleChallenge returns a challenge, or starts validating it when the
+// client POSTs an empty JSON object (RFC 8555 §7.5.1).
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	chall, ok := s.challenges[mux.Vars(r)["id"]]
+	var authz *authorization
+	if ok {
+		authz = s.authorizations[chall.AuthzID]
+	}
+	if !ok || authz.AccountID != req.account.ID {
+		s.mu.Unlock()
+		s.writeProblem(w, r, notFound("challenge not found"))
+		return
+	}
+	startValidation := !req.postAsGet() && chall.Status == statusPending && authz.Status == statusPending
+	if startValidation {
+		chall.Status = statusProcessing
+	}
+	view := s.challengeView(r, chall)
+	s.mu.Unlock()
+
+	if startValidation {
+		thumbprint, err := jose.Thumbprint(req.account.key)
+		if err != nil {
+			s.writeProblem(w, r, &problem{Type: errServerInternal, Detail: "failed to compute key thumbprint", Status: http.StatusInternalServerError})
+			return
+		}
+		go s.validateChallenge(chall.ID, authz.Identifier.Value, chall.Token, chall.Token+"."+thumbprint)
+	}
+	w.Header().Add("Link", fmt.Sprintf("<%s/authz/%s>;rel=\"up\"", s.baseURL(r), authz.ID))
+	s.writeJSON(w, r, http.StatusOK, view)
+}
+
+// handleFinalize checks the CSR against the order and issues the certificate (RFC 8555 §7.4).
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil || payload.CSR == "" {
+		s.writeProblem(w, r, malformed("finalize requires a csr"))
+		return
+	}
+	der, err := jose.DecodeSegment(payload.CSR)
+	if err != nil {
+		s.writeProblem(w, r, &problem{Type: errBadCSR, Detail: "csr is not base64url encoded", Status: http.StatusBadRequest})
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.writeProblem(w, r, &problem{Type: errBadCSR, Detail: "csr could not be parsed", Status: http.StatusBadRequest})
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.orders[mux.Vars(r)["id"]]
+	if !ok || o.AccountID != req.account.ID {
+		s.mu.Unlock()
+		s.writeProblem(w, r, notFound("order not found"))
+		return
+	}
+	s.updateOrderLocked(o)
+	if o.Status != statusReady {
+		s.mu.Unlock()
+		s.writeProblem(w, r, &problem{Type: errOrderNotReady, Detail: fmt.Sprintf("order is %s, not ready", o.Status), Status: http.StatusForbidden})
+		return
+	}
+	names := make([]string, 0, len(o.Identifiers))
+	for _, id := range o.Identifiers {
+		names = append(names, id.Value)
+	}
+	if p := checkCSR(csr, names, req.account.key); p != nil {
+		s.mu.Unlock()
+		s.writeProblem(w, r, p)
+		return
+	}
+	o.Status = statusProcessing
+	s.mu.Unlock()
+
+	cert, err := s.authority.IssueServerCertificate(csr, names, s.opts.CertificateValidity)
+	event := audit.Event{Principal: "acme:" + req.account.ID, Operation: audit.OpIssueX509, KeyType: "x509-cert"}
+	if err != nil {
+		event.Outcome, event.Reason = audit.OutcomeError, err.Error()
+		s.opts.Audit.RecordOrLog(r.Context(), event)
+	} else {
+		event.KeyID, event.Outcome = fmt.Sprintf("%x", cert.SerialNumber), audit.OutcomeSuccess
+		if aerr := s.opts.Audit.Record(r.Context(), event); aerr != nil {
+			err = fmt.Errorf("failed to record audit entry, withholding certificate: %w", aerr)
+		}
+	}
+
+	s.mu.Lock()
+	if err != nil {
+		o.Status = statusInvalid
+		o.Error = &problem{Type: errServerInternal, Detail: "certificate issuance failed", Status: http.StatusInternalServerError}
+		s.logger.ErrorContext(r.Context(), "ACME certificate issuance failed", "order", o.ID, "error", err)
+	} else {
+		o.Status = statusValid
+		o.CertChainPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), s.authority.CertificatePEM()...)
+		s.logger.InfoContext(r.Context(), "ACME certificate issued", "order", o.ID, "serial", fmt.Sprintf("%x", cert.SerialNumber), "names", names)
+	}
+	s.finishOrderLocked(o)
+	view := s.orderView(r, o)
+	s.mu.Unlock()
+
+	w.Header().Set("Location", s.baseURL(r)+"/order/"+o.ID)
+	s.writeJSON(w, r, http.StatusOK, view)
+}
+
+// handleCertificate downloads the issued PEM certificate chain (RFC 8555 §7.4.2).
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	req, prob := s.verifyRequest(r, false)
+	if prob != nil {
+		s.writeProblem(w, r, prob)
+		return
+	}
+	s.mu.Lock()
+	o, ok := s.orders[mux.Vars(r)["id"]]
+	var chain []byte
+	if ok && o.AccountID == req.account.ID {
+		chain = o.CertChainPEM
+	}
+	s.mu.Unlock()
+	if chain == nil {
+		s.writeProblem(w, r, notFound("certificate not found"))
+		return
+	}
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(chain)
+}
+
+// validateChallenge performs http-01 validation and records the outcome.
+func (s *Server) validateChallenge(challengeID, domain, token, keyAuthorization string) {
+	err := s.fetchHTTP01(domain, token, keyAuthorization)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chall, ok := s.challenges[challengeID]
+	if !ok {
+		// The order was dropped while the challenge was being fetched.
+		return
+	}
+	authz := s.authorizations[chall.AuthzID]
+	if err != nil {
+		chall.Status = statusInvalid
+		chall.Error = &problem{Type: errIncorrectResponse, Detail: err.Error(), Status: http.StatusForbidden}
+		authz.Status = statusInvalid
+		s.logger.Warn("ACME http-01 validation failed", "domain", domain, "error", err)
+	} else {
+		chall.Status = statusValid
+		chall.Validated = time.Now().UTC()
+		authz.Status = statusValid
+	}
+	s.updateOrderLocked(s.orders[authz.OrderID])
+}
+
+// updateOrderLocked recomputes an order's status from its authorizations.
+// The caller must hold s.mu.
+func (s *Server) updateOrderLocked(o *order) {
+	if o.Status != statusPending && o.Status != statusReady {
+		return
+	}
+	if time.Now().After(o.Expires) {
+		o.Status = statusInvalid
+		s.finishOrderLocked(o)
+		return
+	}
+	allValid := true
+	for _, id := range o.AuthzIDs {
+		switch s.authorizations[id].Status {
+		case statusValid:
+		case statusPending, statusProcessing:
+			allValid = false
+		default:
+			o.Status = statusInvalid
+			s.finishOrderLocked(o)
+			return
+		}
+	}
+	if allValid {
+		o.Status = statusReady
+	}
+}
+
+// finishOrderLocked schedules an order that has become valid or invalid to be
+// dropped after finishedOrderRetention. The caller must hold s.mu.
+func (s *Server) finishOrderLocked(o *order) {
+	s.finished = append(s.finished, orderDeadline{orderID: o.ID, at: time.Now().Add(finishedOrderRetention)})
+}
+
+// pruneLocked drops orders, with their authorizations and challenges, whose
+// deadline has passed. Both queues are in deadline order, so only due entries
+// are visited. The caller must hold s.mu.
+func (s *Server) pruneLocked(now time.Time) {
+	for len(s.expiring) > 0 && !now.Before(s.expiring[0].at) {
+		s.deleteOrderLocked(s.expiring[0].orderID)
+		s.expiring = s.expiring[1:]
+	}
+	for len(s.finished) > 0 && !now.Before(s.finished[0].at) {
+		s.deleteOrderLocked(s.finished[0].orderID)
+		s.finished = s.finished[1:]
+	}
+}
+
+// deleteOrderLocked removes an order and everything that belongs to it. An
+// order already dropped through the other queue is ignored. The caller must
+// hold s.mu.
+func (s *Server) deleteOrderLocked(id string) {
+	o, ok := s.orders[id]
+	if !ok {
+		return
+	}
+	for _, authzID := range o.AuthzIDs {
+		if authz, ok := s.authorizations[authzID]; ok {
+			for _, challID := range authz.ChallengeIDs {
+				delete(s.challenges, challID)
+			}
+			delete(s.authorizations, authzID)
+		}
+	}
+	delete(s.orders, id)
+	if acct, ok := s.accounts[o.AccountID]; ok {
+		for i, orderID := range acct.orderIDs {
+			if orderID == id {
+				acct.orderIDs = append(acct.orderIDs[:i], acct.orderIDs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// checkDomain validates a DNS identifier against syntax rules and the allow-list.
+func (s *Server) checkDomain(name string) *problem {
+	reject := func(detail string) *problem {
+		return &problem{Type: errRejectedIdentifier, Detail: detail, Status: http.StatusBadRequest}
+	}
+	if name == "" || len(name) > 253 {
+		return reject("invalid DNS name")
+	}
+	if strings.HasPrefix(name, "*.") {
+		return reject("wildcard identifiers cannot be validated with http-01")
+	}
+	labels := strings.Split(name, ".")
+	// No top-level domain is numeric, so this also catches IPv4 addresses and
+	// the short forms (such as "10.1") that resolvers read as addresses.
+	if net.ParseIP(name) != nil || strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return reject(fmt.Sprintf("%q is an IP address, not a DNS name", name))
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return reject(fmt.Sprintf("invalid DNS name %q", name))
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return reject(fmt.Sprintf("invalid DNS name %q", name))
+			}
+		}
+	}
+	if len(s.opts.AllowedDomains) == 0 {
+		return reject("no domains are configured for ACME issuance")
+	}
+	for _, allowed := range s.opts.AllowedDomains {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if name == allowed || strings.HasSuffix(name, "."+allowed) {
+			return nil
+		}
+	}
+	return reject(fmt.Sprintf("%q is not in an allowed domain", name))
+}
+
+// checkCSR ensures the CSR names exactly the order's identifiers and does not
+// reuse the account key.
+func checkCSR(csr *x509.CertificateRequest, names []string, accountKey crypto.PublicKey) *problem {
+	badCSR := func(detail string) *problem {
+		return &problem{Type: errBadCSR, Detail: detail, Status: http.StatusBadRequest}
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return badCSR("csr signature is invalid")
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return badCSR("csr may only contain DNS names")
+	}
+	requested := make(map[string]bool)
+	for _, n := range csr.DNSNames {
+		requested[strings.ToLower(n)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		requested[strings.ToLower(cn)] = true
+	}
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		want[n] = true
+	}
+	if !reflect.DeepEqual(requested, want) {
+		got := make([]string, 0, len(requested))
+		for n := range requested {
+			got = append(got, n)
+		}
+		sort.Strings(got)
+		return badCSR(fmt.Sprintf("csr names %v do not match order identifiers %v", got, names))
+	}
+	if pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(accountKey) {
+		return badCSR("certificate key must differ from the account key")
+	}
+	return nil
+}
+
+// accountByURL resolves a "kid" URL to an account.
+func (s *Server) accountByURL(r *http.Request, kid string) *account {
+	prefix := s.baseURL(r) + "/account/"
+	if !strings.HasPrefix(kid, prefix) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accounts[strings.TrimPrefix(kid, prefix)]
+}
+
+func (s *Server) accountView(r *http.Request, acct *account) map[string]interface{} {
+	contact := acct.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+	return map[string]interface{}{
+		"status":  acct.Status,
+		"contact": contact,
+		"orders":  s.baseURL(r) + "/account/" + acct.ID + "/orders",
+	}
+}
+
+func (s *Server) orderView(r *http.Request, o *order) map[string]interface{} {
+	base := s.baseURL(r)
+	authzURLs := make([]string, 0, len(o.AuthzIDs))
+	for _, id := range o.AuthzIDs {
+		authzURLs = append(authzURLs, base+"/authz/"+id)
+	}
+	view := map[string]interface{}{
+		"status":         o.Status,
+		"expires":        o.Expires.Format(time.RFC3339),
+		"identifiers":    o.Identifiers,
+		"authorizations": authzURLs,
+		"finalize":       base + "/order/" + o.ID + "/finalize",
+	}
+	if o.CertChainPEM != nil {
+		view["certificate"] = base + "/cert/" + o.ID
+	}
+	if o.Error != nil {
+		view["error"] = o.Error
+	}
+	return view
+}
+
+func (s *Server) authorizationView(r *http.Request, authz *authorization) map[string]interface{} {
+	challenges := make([]map[string]interface{}, 0, len(authz.ChallengeIDs))
+	for _, id := range authz.ChallengeIDs {
+		challenges = append(challenges, s.challengeView(r, s.challenges[id]))
+	}
+	return map[string]interface{}{
+		"identifier": authz.Identifier,
+		"status":     authz.Status,
+		"expires":    authz.Expires.Format(time.RFC3339),
+		"challenges": challenges,
+	}
+}
+
+func (s *Server) challengeView(r *http.Request, chall *challenge) map[string]interface{} {
+	view := map[string]interface{}{
+		"type":   chall.Type,
+		"url":    s.baseURL(r) + "/chall/" + chall.ID,
+		"status": chall.Status,
+		"token":  chall.Token,
+	}
+	if !chall.Validated.IsZero() {
+		view["validated"] = chall.Validated.Format(time.RFC3339)
+	}
+	if chall.Error != nil {
+		view["error"] = chall.Error
+	}
+	return view
+}
+
+// baseURL returns the absolute URL of the ACME API as seen by the client.
+func (s *Server) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + PathPrefix
+}
+
+// requestURL returns the absolute URL of the current request, which must
+// match the "url" JWS header.
+func (s *Server) requestURL(r *http.Request) string {
+	return strings.TrimSuffix(s.baseURL(r), PathPrefix) + r.URL.Path
+}
+
+func (s *Server) setCommonHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.nonces.issue())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf("<%s/directory>;rel=\"index\"", s.baseURL(r)))
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.ErrorContext(r.Context(), "Error encoding ACME response", "error", err)
+	}
+}
+
+func (s *Server) writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		s.logger.ErrorContext(r.Context(), "Error encoding ACME problem", "error", err)
+	}
+}
+
+// clientIP returns the address the request came from, which keys the
+// per-client account limit.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newID returns a random URL-safe identifier.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("acme: failed to read random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,443 @@
+package acme_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/acme"
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/ca"
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+)
+
+// testClient is a minimal ACME client used to drive the server in tests.
+type testClient struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	kid        string
+	directory  map[string]interface{}
+	thumbprint string
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *testClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate account key: %v", err)
+	}
+	thumbprint, err := jose.Thumbprint(key.Public())
+	if err != nil {
+		t.Fatalf("Could not compute thumbprint: %v", err)
+	}
+	c := &testClient{t: t, key: key, thumbprint: thumbprint}
+
+	resp, err := http.Get(server.URL + "/acme/directory")
+	if err != nil {
+		t.Fatalf("Could not fetch directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.directory); err != nil {
+		t.Fatalf("Could not decode directory: %v", err)
+	}
+	return c
+}
+
+func (c *testClient) nonce() string {
+	resp, err := http.Head(c.directory["newNonce"].(string))
+	if err != nil {
+		c.t.Fatalf("Could not fetch nonce: %v", err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Replay-Nonce")
+}
+
+// post sends a JWS-signed request. A nil payload sends POST-as-GET.
+func (c *testClient) post(url string, payload interface{}, nonce string) *http.Response {
+	header := map[string]interface{}{"alg": jose.AlgES256, "nonce": nonce, "url": url}
+	if c.kid == "" {
+		jwk, _ := jose.NewJSONWebKey(c.key.Public())
+		header["jwk"] = jwk
+	} else {
+		header["kid"] = c.kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	protected := jose.EncodeSegment(headerJSON)
+	encodedPayload := ""
+	if payload != nil {
+		payloadJSON, _ := json.Marshal(payload)
+		encodedPayload = jose.EncodeSegment(payloadJSON)
+	}
+	sig, err := jose.Sign(jose.AlgES256, c.key, []byte(protected+"."+encodedPayload))
+	if err != nil {
+		c.t.Fatalf("Could not sign request: %v", err)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"protected": protected,
+		"payload":   encodedPayload,
+		"signature": jose.EncodeSegment(sig),
+	})
+	resp, err := http.Post(url, "application/jose+json", strings.NewReader(string(body)))
+	if err != nil {
+		c.t.Fatalf("POST %s failed: %v", url, err)
+	}
+	return resp
+}
+
+func (c *testClient) postJSON(url string, payload interface{}, wantStatus int) (map[string]interface{}, http.Header) {
+	resp := c.post(url, payload, c.nonce())
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != wantStatus {
+		c.t.Fatalf("POST %s returned status %d, want %d: %v", url, resp.StatusCode, wantStatus, out)
+	}
+	return out, resp.Header
+}
+
+// newACMEServer starts the ACME API for "localhost" backed by a throwaway CA.
+func newACMEServer(t *testing.T, http01Port int) (*httptest.Server, *ca.Authority) {
+	return newACMEServerWithOptions(t, acme.Options{
+		AllowedDomains: []string{"localhost"},
+		HTTP01Port:     http01Port,
+	})
+}
+
+func newACMEServerWithOptions(t *testing.T, opts acme.Options) (*httptest.Server, *ca.Authority) {
+	authority, err := ca.NewSelfSignedAuthority("Test Internal CA")
+	if err != nil {
+		t.Fatalf("Could not create CA: %v", err)
+	}
+	router := mux.NewRouter()
+	acme.NewServer(authority, opts, logging.Discard()).RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, authority
+}
+
+// newChallengeResponder starts a local stand-in for the http-01 target and
+// returns its port together with the map of token -> response it serves.
+func newChallengeResponder(t *testing.T) (int, *sync.Map) {
+	responses := &sync.Map{}
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+		if v, ok := responses.Load(token); ok {
+			io.WriteString(w, v.(string))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(responder.Close)
+	_, portStr, _ := net.SplitHostPort(responder.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return port, responses
+}
+
+func waitForStatus(t *testing.T, c *testClient, url string, want ...string) map[string]interface{} {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		obj, _ := c.postJSON(url, nil, http.StatusOK)
+		for _, w := range want {
+			if obj["status"] == w {
+				return obj
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not reach status %v, last: %v", url, want, obj)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestACME_HTTP01IssuanceFlow(t *testing.T) {
+	port, responses := newChallengeResponder(t)
+	var auditBuf bytes.Buffer
+	server, authority := newACMEServerWithOptions(t, acme.Options{
+		AllowedDomains: []string{"localhost"},
+		HTTP01Port:     port,
+		Audit:          audit.NewLogger(audit.NewWriterSink(&auditBuf)),
+	})
+	c := newTestClient(t, server)
+
+	_, hdr := c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{
+		"termsOfServiceAgreed": true,
+		"contact":              []string{"mailto:ops@example.internal"},
+	}, http.StatusCreated)
+	c.kid = hdr.Get("Location")
+	if c.kid == "" {
+		t.Fatal("newAccount did not return a Location header")
+	}
+
+	order, hdr := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+	}, http.StatusCreated)
+	orderURL := hdr.Get("Location")
+	if order["status"] != "pending" {
+		t.Fatalf("new order status = %v, want pending", order["status"])
+	}
+
+	authzURL := order["authorizations"].([]interface{})[0].(string)
+	authz, _ := c.postJSON(authzURL, nil, http.StatusOK)
+	var challURL, token string
+	for _, ch := range authz["challenges"].([]interface{}) {
+		chall := ch.(map[string]interface{})
+		if chall["type"] == "http-01" {
+			challURL = chall["url"].(string)
+			token = chall["token"].(string)
+		}
+	}
+	if challURL == "" {
+		t.Fatal("authorization has no http-01 challenge")
+	}
+
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"},
+	}, certKey)
+	if err != nil {
+		t.Fatalf("Could not create CSR: %v", err)
+	}
+	csr := map[string]string{"csr": jose.EncodeSegment(csrDER)}
+
+	// Finalizing before the authorization is valid must fail.
+	prob, _ := c.postJSON(order["finalize"].(string), csr, http.StatusForbidden)
+	if prob["type"] != "urn:ietf:params:acme:error:orderNotReady" {
+		t.Errorf("got problem %v, want orderNotReady", prob)
+	}
+
+	responses.Store(token, token+"."+c.thumbprint)
+	c.postJSON(challURL, map[string]interface{}{}, http.StatusOK)
+	waitForStatus(t, c, authzURL, "valid")
+	waitForStatus(t, c, orderURL, "ready")
+
+	finalized, _ := c.postJSON(order["finalize"].(string), csr, http.StatusOK)
+	if finalized["status"] != "valid" {
+		t.Fatalf("finalized order status = %v, want valid", finalized["status"])
+	}
+
+	resp := c.post(finalized["certificate"].(string), nil, c.nonce())
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pem-certificate-chain" {
+		t.Errorf("certificate Content-Type = %q", ct)
+	}
+	chainPEM, _ := io.ReadAll(resp.Body)
+	block, rest := pem.Decode(chainPEM)
+	if block == nil {
+		t.Fatalf("certificate response is not PEM: %q", chainPEM)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Could not parse issued certificate: %v", err)
+	}
+	if caBlock, _ := pem.Decode(rest); caBlock == nil {
+		t.Error("certificate chain does not include the CA certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots}); err != nil {
+		t.Errorf("issued certificate does not verify against the internal CA: %v", err)
+	}
+
+	var event audit.Event
+	if err := json.Unmarshal(auditBuf.Bytes(), &event); err != nil {
+		t.Fatalf("Could not parse audit entry %q: %v", auditBuf.String(), err)
+	}
+	if event.Operation != audit.OpIssueX509 || event.Outcome != audit.OutcomeSuccess || event.KeyID != fmt.Sprintf("%x", leaf.SerialNumber) || !strings.HasPrefix(event.Principal, "acme:") {
+		t.Errorf("audit entry = %+v, want a successful %s for serial %x by the ACME account", event, audit.OpIssueX509, leaf.SerialNumber)
+	}
+}
+
+func TestACME_HTTP01WrongResponseInvalidatesOrder(t *testing.T) {
+	port, responses := newChallengeResponder(t)
+	server, _ := newACMEServer(t, port)
+	c := newTestClient(t, server)
+
+	_, hdr := c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{"termsOfServiceAgreed": true}, http.StatusCreated)
+	c.kid = hdr.Get("Location")
+	order, hdr := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+	}, http.StatusCreated)
+	orderURL := hdr.Get("Location")
+	authzURL := order["authorizations"].([]interface{})[0].(string)
+	authz, _ := c.postJSON(authzURL, nil, http.StatusOK)
+	chall := authz["challenges"].([]interface{})[0].(map[string]interface{})
+
+	responses.Store(chall["token"].(string), "not-the-key-authorization")
+	c.postJSON(chall["url"].(string), map[string]interface{}{}, http.StatusOK)
+	waitForStatus(t, c, authzURL, "invalid")
+	waitForStatus(t, c, orderURL, "invalid")
+}
+
+func TestACME_HTTP01Redirects(t *testing.T) {
+	tests := []struct {
+		name       string
+		targetHost string // Host the challenge path redirects to, on the responder's port
+		want       string
+	}{
+		{"DNS name", "localhost", "valid"},
+		{"IP address", "127.0.0.1", "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The challenge path redirects to /response/, which serves the
+			// right key authorization.
+			responses := &sync.Map{}
+			var port int
+			responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if token, ok := strings.CutPrefix(r.URL.Path, "/.well-known/acme-challenge/"); ok {
+					http.Redirect(w, r, fmt.Sprintf("http://%s/response/%s", net.JoinHostPort(tt.targetHost, strconv.Itoa(port)), token), http.StatusFound)
+					return
+				}
+				if v, ok := responses.Load(strings.TrimPrefix(r.URL.Path, "/response/")); ok {
+					io.WriteString(w, v.(string))
+					return
+				}
+				http.NotFound(w, r)
+			}))
+			t.Cleanup(responder.Close)
+			_, portStr, _ := net.SplitHostPort(responder.Listener.Addr().String())
+			port, _ = strconv.Atoi(portStr)
+
+			server, _ := newACMEServer(t, port)
+			c := newTestClient(t, server)
+			_, hdr := c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{"termsOfServiceAgreed": true}, http.StatusCreated)
+			c.kid = hdr.Get("Location")
+			order, _ := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+				"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+			}, http.StatusCreated)
+			authzURL := order["authorizations"].([]interface{})[0].(string)
+			authz, _ := c.postJSON(authzURL, nil, http.StatusOK)
+			chall := authz["challenges"].([]interface{})[0].(map[string]interface{})
+
+			token := chall["token"].(string)
+			responses.Store(token, token+"."+c.thumbprint)
+			c.postJSON(chall["url"].(string), map[string]interface{}{}, http.StatusOK)
+			waitForStatus(t, c, authzURL, tt.want)
+		})
+	}
+}
+
+func TestACME_RequestValidation(t *testing.T) {
+	server, _ := newACMEServer(t, 80)
+	c := newTestClient(t, server)
+	newAccount := c.directory["newAccount"].(string)
+
+	t.Run("Reused nonce", func(t *testing.T) {
+		nonce := c.nonce()
+		resp := c.post(newAccount, map[string]interface{}{"termsOfServiceAgreed": true}, nonce)
+		resp.Body.Close()
+		resp = c.post(newAccount, map[string]interface{}{"termsOfServiceAgreed": true}, nonce)
+		defer resp.Body.Close()
+		var prob map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&prob)
+		if resp.StatusCode != http.StatusBadRequest || prob["type"] != "urn:ietf:params:acme:error:badNonce" {
+			t.Errorf("got status %d problem %v, want badNonce", resp.StatusCode, prob)
+		}
+		if resp.Header.Get("Replay-Nonce") == "" {
+			t.Error("badNonce response must carry a fresh Replay-Nonce")
+		}
+	})
+
+	t.Run("Existing account is returned", func(t *testing.T) {
+		c.postJSON(newAccount, map[string]interface{}{"onlyReturnExisting": true}, http.StatusOK)
+	})
+
+	t.Run("Disallowed domain is rejected", func(t *testing.T) {
+		_, hdr := c.postJSON(newAccount, map[string]interface{}{}, http.StatusOK)
+		c.kid = hdr.Get("Location")
+		prob, _ := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+			"identifiers": []map[string]string{{"type": "dns", "value": "example.com"}},
+		}, http.StatusBadRequest)
+		if prob["type"] != "urn:ietf:params:acme:error:rejectedIdentifier" {
+			t.Errorf("got problem %v, want rejectedIdentifier", prob)
+		}
+	})
+
+	t.Run("IP address identifier is rejected", func(t *testing.T) {
+		for _, name := range []string{"10.0.0.1", "127.1"} {
+			prob, _ := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+				"identifiers": []map[string]string{{"type": "dns", "value": name}},
+			}, http.StatusBadRequest)
+			if detail, _ := prob["detail"].(string); prob["type"] != "urn:ietf:params:acme:error:rejectedIdentifier" || !strings.Contains(detail, "IP address") {
+				t.Errorf("%s: got problem %v, want rejectedIdentifier for an IP address", name, prob)
+			}
+		}
+	})
+
+	t.Run("Wrong Content-Type", func(t *testing.T) {
+		resp, err := http.Post(newAccount, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestACME_NoAllowedDomainsRejectsOrders(t *testing.T) {
+	server, _ := newACMEServerWithOptions(t, acme.Options{})
+	c := newTestClient(t, server)
+	_, hdr := c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{}, http.StatusCreated)
+	c.kid = hdr.Get("Location")
+
+	prob, _ := c.postJSON(c.directory["newOrder"].(string), map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+	}, http.StatusBadRequest)
+	if prob["type"] != "urn:ietf:params:acme:error:rejectedIdentifier" {
+		t.Errorf("got problem %v, want rejectedIdentifier", prob)
+	}
+}
+
+func TestACME_Limits(t *testing.T) {
+	server, _ := newACMEServerWithOptions(t, acme.Options{
+		AllowedDomains:       []string{"localhost"},
+		MaxAccountsPerClient: 1,
+		MaxOrdersPerAccount:  1,
+	})
+	c := newTestClient(t, server)
+	_, hdr := c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{}, http.StatusCreated)
+	c.kid = hdr.Get("Location")
+
+	t.Run("Orders per account", func(t *testing.T) {
+		order := map[string]interface{}{
+			"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+		}
+		c.postJSON(c.directory["newOrder"].(string), order, http.StatusCreated)
+		prob, _ := c.postJSON(c.directory["newOrder"].(string), order, http.StatusTooManyRequests)
+		if prob["type"] != "urn:ietf:params:acme:error:rateLimited" {
+			t.Errorf("got problem %v, want rateLimited", prob)
+		}
+	})
+
+	t.Run("Accounts per client", func(t *testing.T) {
+		other := newTestClient(t, server)
+		prob, _ := other.postJSON(other.directory["newAccount"].(string), map[string]interface{}{}, http.StatusTooManyRequests)
+		if prob["type"] != "urn:ietf:params:acme:error:rateLimited" {
+			t.Errorf("got problem %v, want rateLimited", prob)
+		}
+		// The existing account is still served.
+		c.kid = ""
+		c.postJSON(c.directory["newAccount"].(string), map[string]interface{}{"onlyReturnExisting": true}, http.StatusOK)
+	})
+}
@@ -0,0 +1,68 @@
+package acme
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxChallengeResponseBytes bounds how much of an http-01 response is read.
+const maxChallengeResponseBytes = 4096
+
+// maxHTTP01Redirects bounds how many redirects http-01 validation follows.
+const maxHTTP01Redirects = 10
+
+// http01RedirectPolicy lets http-01 validation follow redirects only to DNS
+// names over http or https on ports 80 and 443 (RFC 8555 §8.3), or the
+// configured validation port, so that a challenged host cannot point the
+// validator at internal addresses or services.
+func http01RedirectPolicy(port int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxHTTP01Redirects {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		u := req.URL
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("refusing redirect to %s: scheme must be http or https", u)
+		}
+		switch u.Port() {
+		case "", "80", "443", strconv.Itoa(port):
+		default:
+			return fmt.Errorf("refusing redirect to %s: port must be 80 or 443", u)
+		}
+		if net.ParseIP(u.Hostname()) != nil {
+			return fmt.Errorf("refusing redirect to %s: host is an IP address, not a DNS name", u)
+		}
+		return nil
+	}
+}
+
+// fetchHTTP01 retrieves http://{domain}/.well-known/acme-challenge/{token} and
+// compares the body with the expected key authorization (RFC 8555 §8.3).
+func (s *Server) fetchHTTP01(domain, token, keyAuthorization string) error {
+	host := domain
+	if s.opts.HTTP01Port != 80 {
+		host = net.JoinHostPort(domain, strconv.Itoa(s.opts.HTTP01Port))
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+
+	resp, err := s.opts.HTTPClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxChallengeResponseBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %w", url, err)
+	}
+	if got := strings.TrimRight(string(body), " \t\r\n"); got != keyAuthorization {
+		return fmt.Errorf("key authorization from %s does not match", url)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+)
+
+// maxRequestBodyBytes bounds the size of a JWS request body.
+const maxRequestBodyBytes = 64 * 1024
+
+// jwsMessage is the flattened JSON serialization required by RFC 8555 §6.2.
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// protectedHeader holds the JWS protected header members ACME uses.
+type protectedHeader struct {
+	Alg   string           `json:"alg"`
+	Nonce string           `json:"nonce"`
+	URL   string           `json:"url"`
+	JWK   *jose.JSONWebKey `json:"jwk,omitempty"`
+	KID   string           `json:"kid,omitempty"`
+}
+
+// verifiedRequest is the result of authenticating an ACME POST.
+type verifiedRequest struct {
+	payload []byte           // Decoded payload; empty for POST-as-GET
+	key     crypto.PublicKey // Key that signed the request
+	account *account         // Set when the request used "kid"
+}
+
+// postAsGet reports whether the request carried an empty payload (RFC 8555 §6.3).
+func (v *verifiedRequest) postAsGet() bool {
+	return len(v.payload) == 0
+}
+
+// verifyRequest parses and authenticates a JWS-signed ACME request.
+// When useJWK is true the request must embed its key ("jwk"); otherwise it
+// must reference an existing account ("kid").
+func (s *Server) verifyRequest(r *http.Request, useJWK bool) (*verifiedRequest, *problem) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, malformed("Content-Type must be application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes))
+	if err != nil {
+		return nil, malformed("failed to read request body")
+	}
+
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, malformed("request body is not a flattened JWS")
+	}
+	var header protectedHeader
+	if err := jose.DecodeJSONSegment(msg.Protected, &header); err != nil {
+		return nil, malformed("invalid JWS protected header")
+	}
+
+	if !s.nonces.consume(header.Nonce) {
+		return nil, &problem{Type: errBadNonce, Detail: "invalid or reused nonce", Status: http.StatusBadRequest}
+	}
+	if header.URL != s.requestURL(r) {
+		return nil, unauthorized(fmt.Sprintf("JWS url %q does not match request URL", header.URL))
+	}
+	switch header.Alg {
+	case jose.AlgES256, jose.AlgES384, jose.AlgRS256, jose.AlgEdDSA:
+	default:
+		return nil, &problem{Type: errBadSignatureAlgorithm, Detail: fmt.Sprintf("unsupported alg %q", header.Alg), Status: http.StatusBadRequest}
+	}
+	if (header.JWK != nil) == (header.KID != "") {
+		return nil, malformed("exactly one of jwk and kid must be present")
+	}
+
+	result := &verifiedRequest{}
+	switch {
+	case useJWK && header.JWK != nil:
+		key, err := header.JWK.PublicKey()
+		if err != nil {
+			return nil, &problem{Type: errBadPublicKey, Detail: err.Error(), Status: http.StatusBadRequest}
+		}
+		result.key = key
+	case !useJWK && header.KID != "":
+		acct := s.accountByURL(r, header.KID)
+		if acct == nil {
+			return nil, &problem{Type: errAccountDoesNotExist, Detail: "unknown account", Status: http.StatusBadRequest}
+		}
+		if acct.Status != statusValid {
+			return nil, unauthorized("account is not valid")
+		}
+		result.key = acct.key
+		result.account = acct
+	case useJWK:
+		return nil, malformed("this resource requires a jwk header")
+	default:
+		return nil, malformed("this resource requires a kid header")
+	}
+
+	signature, err := jose.DecodeSegment(msg.Signature)
+	if err != nil {
+		return nil, malformed("invalid JWS signature encoding")
+	}
+	if err := jose.Verify(header.Alg, result.key, []byte(msg.Protected+"."+msg.Payload), signature); err != nil {
+		return nil, malformed("JWS signature verification failed")
+	}
+
+	if msg.Payload != "" {
+		result.payload, err = jose.DecodeSegment(msg.Payload)
+		if err != nil {
+			return nil, malformed("invalid JWS payload encoding")
+		}
+	}
+	return result, nil
+}
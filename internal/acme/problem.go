@@ -0,0 +1,112 @@
+package acme
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ACME error types (RFC 8555 §6.7).
+const (
+	errAccountDoesNotExist   = "urn:ietf:params:acme:error:accountDoesNotExist"
+	errBadCSR                = "urn:ietf:params:acme:error:badCSR"
+	errBadNonce              = "urn:ietf:params:acme:error:badNonce"
+	errBadPublicKey          = "urn:ietf:params:acme:error:badPublicKey"
+	errBadSignatureAlgorithm = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	errIncorrectResponse     = "urn:ietf:params:acme:error:incorrectResponse"
+	errMalformed             = "urn:ietf:params:acme:error:malformed"
+	errOrderNotReady         = "urn:ietf:params:acme:error:orderNotReady"
+	errRateLimited           = "urn:ietf:params:acme:error:rateLimited"
+	errRejectedIdentifier    = "urn:ietf:params:acme:error:rejectedIdentifier"
+	errServerInternal        = "urn:ietf:params:acme:error:serverInternal"
+	errUnauthorized          = "urn:ietf:params:acme:error:unauthorized"
+	errUnsupportedContact    = "urn:ietf:params:acme:error:unsupportedContact"
+	errUnsupportedIdentifier = "urn:ietf:params:acme:error:unsupportedIdentifier"
+)
+
+// problem is an RFC 7807 problem document as used by ACME.
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func malformed(detail string) *problem {
+	return &problem{Type: errMalformed, Detail: detail, Status: http.StatusBadRequest}
+}
+
+func unauthorized(detail string) *problem {
+	return &problem{Type: errUnauthorized, Detail: detail, Status: http.StatusForbidden}
+}
+
+func rateLimited(detail string) *problem {
+	return &problem{Type: errRateLimited, Detail: detail, Status: http.StatusTooManyRequests}
+}
+
+func notFound(detail string) *problem {
+	return &problem{Type: errMalformed, Detail: detail, Status: http.StatusNotFound}
+}
+
+// nonceLifetime bounds how long an issued nonce remains redeemable.
+const nonceLifetime = 10 * time.Minute
+
+// maxNonces caps the number of outstanding nonces. newNonce is unauthenticated,
+// so once the cap is reached the oldest nonce is dropped; a client holding it
+// gets badNonce and retries with a fresh one (RFC 8555 §6.5).
+const maxNonces = 10000
+
+type issuedNonce struct {
+	value   string
+	expires time.Time
+}
+
+// nonceStore issues single-use anti-replay nonces. Nonces all live for
+// nonceLifetime, so a ring in issue order is also in expiry order and both
+// pruning and eviction only ever look at its head.
+type nonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	ring   []issuedNonce
+	head   int
+	size   int
+}
+
+func newNonceStore(capacity int) *nonceStore {
+	return &nonceStore{
+		nonces: make(map[string]time.Time, capacity),
+		ring:   make([]issuedNonce, capacity),
+	}
+}
+
+// issue returns a new nonce, dropping expired ones and, when the store is
+// full, the oldest.
+func (n *nonceStore) issue() string {
+	nonce := newID()
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for n.size > 0 && (n.size == len(n.ring) || now.After(n.ring[n.head].expires)) {
+		delete(n.nonces, n.ring[n.head].value)
+		n.ring[n.head] = issuedNonce{}
+		n.head = (n.head + 1) % len(n.ring)
+		n.size--
+	}
+	exp := now.Add(nonceLifetime)
+	n.ring[(n.head+n.size)%len(n.ring)] = issuedNonce{value: nonce, expires: exp}
+	n.size++
+	n.nonces[nonce] = exp
+	return nonce
+}
+
+// consume redeems a nonce; it reports false if the nonce is unknown, expired or already used.
+// A redeemed nonce stays in the ring until it reaches the head.
+func (n *nonceStore) consume(nonce string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	exp, ok := n.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(n.nonces, nonce)
+	return time.Now().Before(exp)
+}
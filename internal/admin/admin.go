@@ -0,0 +1,44 @@
+// Package admin serves the operational endpoints (metrics, health, readiness,
+// runtime profiles and build information) on a listener separate from the
+// public key API, so they can be bound to localhost or a pod-internal
+// address.
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/buildinfo"
+)
+
+// Options configures NewRouter.
+type Options struct {
+	Metrics http.Handler     // Serves /metrics
+	Health  http.HandlerFunc // Serves /health (liveness)
+	Ready   http.HandlerFunc // Serves /ready (readiness)
+}
+
+// NewRouter returns a router serving:
+//
+//	GET /metrics         Prometheus metrics
+//	GET /health          liveness
+//	GET /ready           readiness
+//	GET /buildinfo       version and VCS revision as JSON
+//	GET /debug/pprof/... runtime profiles (see net/http/pprof)
+func NewRouter(opts Options) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/metrics", opts.Metrics).Methods("GET")
+	router.HandleFunc("/health", opts.Health).Methods("GET")
+	router.HandleFunc("/ready", opts.Ready).Methods("GET")
+	router.HandleFunc("/buildinfo", buildinfo.Handler).Methods("GET")
+
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Index also serves the named profiles (heap, goroutine, allocs, ...).
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	return router
+}
@@ -0,0 +1,68 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/admin"
+	"github.com/bajhalshrey/Key-Server-Application/internal/buildinfo"
+)
+
+func TestNewRouter(t *testing.T) {
+	respond := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, body) }
+	}
+	router := admin.NewRouter(admin.Options{
+		Metrics: respond("metrics"),
+		Health:  respond("healthy"),
+		Ready:   respond("ready"),
+	})
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+	}{
+		{"GET", "/metrics", http.StatusOK, "metrics"},
+		{"GET", "/health", http.StatusOK, "healthy"},
+		{"GET", "/ready", http.StatusOK, "ready"},
+		{"GET", "/debug/pprof/", http.StatusOK, "goroutine"},
+		{"GET", "/debug/pprof/goroutine?debug=1", http.StatusOK, "goroutine profile"},
+		{"GET", "/debug/pprof/cmdline", http.StatusOK, ""},
+		{"POST", "/health", http.StatusMethodNotAllowed, ""},
+		{"GET", "/key/16", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	router := admin.NewRouter(admin.Options{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/buildinfo", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, content type = %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var info buildinfo.Info
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if info.Version != buildinfo.Version || info.GoVersion != runtime.Version() {
+		t.Errorf("build info = %+v", info)
+	}
+}
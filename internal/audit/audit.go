@@ -0,0 +1,269 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+)
+
+// Operations recorded in the audit log.
+const (
+	OpGenerateKey = "key.generate" // Random key generation via /key/{length}
+	OpSignSSH     = "ssh.sign"     // SSH certificate issuance via /ssh/sign
+	OpIssueX509   = "x509.issue"   // TLS certificate issuance via ACME
+	OpCreateKey   = "key.create"   // Named key creation via /keys
+	OpRotateKey   = "key.rotate"   // Named key rotation via /keys/{name}/rotate
+	OpReadKey     = "key.read"     // Metadata read of a named key
+	OpListKeys    = "key.list"     // Listing of named keys
+	OpEncrypt     = "key.encrypt"  // Encryption with a named key
+	OpDecrypt     = "key.decrypt"  // Decryption with a named key
+	OpSign        = "key.sign"     // Signing or MAC with a named key
+	OpVerify      = "key.verify"   // Signature or MAC verification with a named key
+	OpKeyState    = "key.state"    // Named key state transition, scheduled or by hand
+)
+
+// Outcomes of an audited operation.
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied" // Rejected by validation or policy
+	OutcomeError   = "error"  // Failed inside the server
+)
+
+// GenesisHash is the prev_hash of the first entry in a log.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Event describes one audited operation. It must never carry key material.
+type Event struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	PrevHash  string    `json:"prev_hash"`
+	RequestID string    `json:"request_id,omitempty"`
+	Principal string    `json:"principal"`
+	Operation string    `json:"operation"`
+	KeyID     string    `json:"key_id,omitempty"`
+	KeyType   string    `json:"key_type,omitempty"`
+	KeyLength int       `json:"key_length,omitempty"`
+	KeyState  string    `json:"key_state,omitempty"` // State a named key moved to, for key.state
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Options selects the sinks audit entries are written to.
+type Options struct {
+	File         string // Append-only JSON-lines file; the chain resumes from its last entry
+	SyslogSocket string // Local syslog socket path (e.g. /dev/log)
+	Stdout       bool   // Also write entries to standard output
+
+	// Logger receives failures of RecordOrLog. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Logger appends hash-chained entries to every configured sink. Each entry
+// commits to the previous one through prev_hash, so edits, deletions and
+// reordering break the chain.
+type Logger struct {
+	mu     sync.Mutex
+	sinks  []Sink
+	seq    uint64
+	head   string
+	logger *slog.Logger
+}
+
+// Disabled returns a Logger without sinks. Recording on it is a no-op.
+func Disabled() *Logger {
+	return NewLogger()
+}
+
+// NewLogger creates a Logger that starts a new chain on sinks.
+func NewLogger(sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks, head: GenesisHash, logger: slog.Default()}
+}
+
+// Open creates a Logger for opts. When a file is configured the chain
+// continues from the file's last entry, which must be intact.
+func Open(opts Options) (*Logger, error) {
+	l := NewLogger()
+	if opts.Logger != nil {
+		l.logger = opts.Logger
+	}
+
+	if opts.File != "" {
+		seq, head, err := lastEntry(opts.File)
+		if err != nil {
+			return nil, err
+		}
+		l.seq, l.head = seq, head
+		sink, err := NewFileSink(opts.File)
+		if err != nil {
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+	if opts.SyslogSocket != "" {
+		sink, err := NewSyslogSink(opts.SyslogSocket)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+	if opts.Stdout {
+		l.sinks = append(l.sinks, NewWriterSink(os.Stdout))
+	}
+	return l, nil
+}
+
+// Enabled reports whether the Logger has any sinks.
+func (l *Logger) Enabled() bool {
+	return len(l.sinks) > 0
+}
+
+// Head returns the sequence number and hash of the latest entry. Recording
+// them externally lets Verify detect truncation of the log's tail.
+func (l *Logger) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.head
+}
+
+// Record appends ev to the log. The sequence number, time and chain hash are
+// assigned here; the request ID and principal default to those in ctx. An
+// error means the entry may not have reached every sink.
+func (l *Logger) Record(ctx context.Context, ev Event) error {
+	if !l.Enabled() {
+		return nil
+	}
+	if ev.RequestID == "" {
+		ev.RequestID = requestid.FromContext(ctx)
+	}
+	if ev.Principal == "" {
+		ev.Principal = "anonymous"
+		if p, ok := auth.PrincipalFromContext(ctx); ok {
+			ev.Principal = p.Name
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ev.Seq = l.seq + 1
+	ev.Time = time.Now().UTC()
+	ev.PrevHash = l.head
+	line, hash, err := encode(ev)
+	if err != nil {
+		return err
+	}
+
+	// The chain advances even if a sink fails so that sinks that did accept
+	// the entry stay verifiable.
+	l.seq, l.head = ev.Seq, hash
+	var errs []error
+	for _, s := range l.sinks {
+		if err := s.Write(line); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to write audit entry %d: %w", ev.Seq, errors.Join(errs...))
+	}
+	return nil
+}
+
+// RecordOrLog records ev and logs, rather than returns, any failure. It is
+// for events where the operation has already been refused.
+func (l *Logger) RecordOrLog(ctx context.Context, ev Event) {
+	if err := l.Record(ctx, ev); err != nil {
+		l.logger.ErrorContext(ctx, "Error recording audit entry", "error", err)
+	}
+}
+
+// Close closes every sink.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	l.sinks = nil
+	return errors.Join(errs...)
+}
+
+// encode serialises ev and appends its hash. The hash covers the exact bytes
+// of the entry without the trailing hash field, so Verify can recompute it
+// without re-encoding.
+func encode(ev Event) ([]byte, string, error) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	line := make([]byte, 0, len(body)+len(hash)+12)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, `,"hash":"`...)
+	line = append(line, hash...)
+	line = append(line, `"}`...)
+	// Cap the slice so sinks appending a newline never share a backing array.
+	return line[:len(line):len(line)], hash, nil
+}
+
+// lastEntry returns the sequence number and hash of the final entry in the
+// file at path, or the genesis values when the file is missing or empty.
+func lastEntry(path string) (uint64, string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, GenesisHash, nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	if info.Size() == 0 {
+		return 0, GenesisHash, nil
+	}
+
+	// Entries are small, so the final one is within the last few KiB.
+	const tailSize = 64 * 1024
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return 0, "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if tail[len(tail)-1] != '\n' {
+		return 0, "", fmt.Errorf("audit log %s ends with a partial entry; run 'key-server audit verify %s'", path, path)
+	}
+	tail = tail[:len(tail)-1]
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	entry, err := parseLine(tail)
+	if err != nil {
+		return 0, "", fmt.Errorf("audit log %s has a corrupt final entry: %w", path, err)
+	}
+	return entry.Seq, entry.Hash, nil
+}
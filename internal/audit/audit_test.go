@@ -0,0 +1,113 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+)
+
+// writeLog records n events to a fresh buffer and returns its lines and head.
+func writeLog(t *testing.T, n int) ([]string, string) {
+	var buf bytes.Buffer
+	l := audit.NewLogger(audit.NewWriterSink(&buf))
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	ctx = auth.WithPrincipal(ctx, &auth.Principal{Name: "alice"})
+	for i := 0; i < n; i++ {
+		err := l.Record(ctx, audit.Event{Operation: audit.OpGenerateKey, KeyType: "symmetric", KeyLength: 32, Outcome: audit.OutcomeSuccess})
+		if err != nil {
+			t.Fatalf("Record returned an error: %v", err)
+		}
+	}
+	_, head := l.Head()
+	return strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n"), head
+}
+
+func TestVerify(t *testing.T) {
+	lines, head := writeLog(t, 4)
+	if !strings.Contains(lines[0], `"principal":"alice"`) || !strings.Contains(lines[0], `"request_id":"req-1"`) {
+		t.Fatalf("entry missing context fields: %s", lines[0])
+	}
+
+	join := func(ls ...string) string {
+		s := strings.Join(ls, "")
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		return s
+	}
+
+	tests := []struct {
+		name         string
+		log          string
+		expectedHead string
+		wantErr      string
+	}{
+		{"Intact log", join(lines...), "", ""},
+		{"Intact log with head", join(lines...), head, ""},
+		{"Empty log", "", "", ""},
+		{"Edited entry", join(lines[0], strings.Replace(lines[1], `"key_length":32`, `"key_length":16`, 1), lines[2], lines[3]), "", "entry modified"},
+		{"Deleted entry", join(lines[0], lines[2], lines[3]), "", "missing or reordered"},
+		{"Reordered entries", join(lines[1], lines[0], lines[2], lines[3]), "", "missing or reordered"},
+		{"Start removed", join(lines[1:]...), "", "missing or reordered"},
+		{"Partial final write", join(lines[:3]...) + lines[3][:40], "", "truncated write"},
+		{"Tail removed with head", join(lines[:3]...), head, "log truncated"},
+		{"Garbage line", join(lines[0], "not json\n"), "", "invalid JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := audit.Verify(strings.NewReader(tt.log), tt.expectedHead)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify() returned unexpected error: %v", err)
+				}
+				return
+			}
+			var verr *audit.VerifyError
+			if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify() error = %v, want VerifyError containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpen_ResumesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		l, err := audit.Open(audit.Options{File: path})
+		if err != nil {
+			t.Fatalf("Open returned an error: %v", err)
+		}
+		for j := 0; j < 3; j++ {
+			if err := l.Record(ctx, audit.Event{Operation: audit.OpSignSSH, Outcome: audit.OutcomeDenied, Reason: "bad request"}); err != nil {
+				t.Fatalf("Record returned an error: %v", err)
+			}
+		}
+		l.Close()
+	}
+
+	f, _ := os.Open(path)
+	sum, err := audit.Verify(f, "")
+	f.Close()
+	if err != nil || sum.Entries != 6 {
+		t.Fatalf("Verify() = %+v, %v; want 6 valid entries", sum, err)
+	}
+
+	// A log whose last write was cut short must not be silently extended.
+	fh, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	fh.WriteString(`{"seq":7,"time"`)
+	fh.Close()
+	if _, err := audit.Open(audit.Options{File: path}); err == nil || !strings.Contains(err.Error(), "partial entry") {
+		t.Errorf("Open() error = %v, want partial entry error", err)
+	}
+}
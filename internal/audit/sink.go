@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+)
+
+// Sink receives encoded audit entries, one JSON object per call.
+type Sink interface {
+	Write(entry []byte) error
+	Close() error
+}
+
+// FileSink appends entries to a file, syncing after each one so an
+// acknowledged operation is never missing from the log after a crash.
+type FileSink struct {
+	f *os.File
+}
+
+// NewFileSink opens path for appending, creating it with owner-only
+// permissions if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Write appends entry followed by a newline.
+func (s *FileSink) Write(entry []byte) error {
+	if _, err := s.f.Write(append(entry, '\n')); err != nil {
+		return fmt.Errorf("audit file: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("audit file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// SyslogSink forwards entries to a local syslog daemon with the
+// LOG_AUTH facility.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog socket at path, trying a datagram
+// socket first and then a stream socket.
+func NewSyslogSink(path string) (*SyslogSink, error) {
+	var err error
+	for _, network := range []string{"unixgram", "unix"} {
+		var w *syslog.Writer
+		w, err = syslog.Dial(network, path, syslog.LOG_INFO|syslog.LOG_AUTH, "key-server-audit")
+		if err == nil {
+			return &SyslogSink{w: w}, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to connect to syslog socket %s: %w", path, err)
+}
+
+// Write sends entry as an informational message.
+func (s *SyslogSink) Write(entry []byte) error {
+	if err := s.w.Info(string(entry)); err != nil {
+		return fmt.Errorf("audit syslog: %w", err)
+	}
+	return nil
+}
+
+// Close closes the syslog connection.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
+
+// WriterSink writes entries as lines to an io.Writer such as os.Stdout.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a WriterSink for w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Write writes entry followed by a newline.
+func (s *WriterSink) Write(entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(entry, '\n')); err != nil {
+		return fmt.Errorf("audit writer: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op; the caller owns the writer.
+func (s *WriterSink) Close() error {
+	return nil
+}
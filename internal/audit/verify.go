@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxEntryBytes bounds a single audit entry when verifying.
+const maxEntryBytes = 64 * 1024
+
+// Entry is an Event together with its chain hash, as stored in the log.
+type Entry struct {
+	Event
+	Hash string `json:"hash"`
+}
+
+// Summary describes a verified log.
+type Summary struct {
+	Entries uint64 // Number of entries in the log
+	Head    string // Hash of the final entry (GenesisHash for an empty log)
+}
+
+// VerifyError reports where and why a log failed verification.
+type VerifyError struct {
+	Line   int // 1-based line number
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// Verify reads a JSON-lines audit log from r and checks that every entry's
+// hash matches its contents, that each entry links to the previous one and
+// that sequence numbers are contiguous from 1. A log whose start was removed
+// or whose final write was cut short fails. If expectedHead is non-empty the
+// final hash must equal it, which also detects removal of trailing entries.
+func Verify(r io.Reader, expectedHead string) (Summary, error) {
+	sum := Summary{Head: GenesisHash}
+	br := bufio.NewReaderSize(r, maxEntryBytes)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return sum, &VerifyError{Line: lineNo, Reason: "entry is too long"}
+		}
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return sum, &VerifyError{Line: lineNo, Reason: "partial entry without a trailing newline (truncated write)"}
+			}
+			break
+		}
+		if err != nil {
+			return sum, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		line = line[:len(line)-1]
+
+		entry, err := parseLine(line)
+		if err != nil {
+			return sum, &VerifyError{Line: lineNo, Reason: err.Error()}
+		}
+		if entry.Seq != sum.Entries+1 {
+			return sum, &VerifyError{Line: lineNo, Reason: fmt.Sprintf("sequence %d, want %d (entries missing or reordered)", entry.Seq, sum.Entries+1)}
+		}
+		if entry.PrevHash != sum.Head {
+			return sum, &VerifyError{Line: lineNo, Reason: "prev_hash does not match the previous entry (chain broken)"}
+		}
+		sum.Entries, sum.Head = entry.Seq, entry.Hash
+	}
+
+	if expectedHead != "" && sum.Head != expectedHead {
+		return sum, &VerifyError{Line: int(sum.Entries), Reason: fmt.Sprintf("final hash %s does not match expected head %s (log truncated)", sum.Head, expectedHead)}
+	}
+	return sum, nil
+}
+
+// parseLine decodes one stored entry and checks its hash against the exact
+// bytes it was computed over.
+func parseLine(line []byte) (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	suffix := []byte(`,"hash":"` + entry.Hash + `"}`)
+	if len(entry.Hash) != sha256.Size*2 || !bytes.HasSuffix(line, suffix) {
+		return nil, errors.New("missing or misplaced hash field")
+	}
+	body := append(line[:len(line)-len(suffix):len(line)-len(suffix)], '}')
+	digest := sha256.Sum256(body)
+	if hex.EncodeToString(digest[:]) != entry.Hash {
+		return nil, errors.New("hash does not match entry contents (entry modified)")
+	}
+	return &entry, nil
+}
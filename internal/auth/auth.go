@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+// Authentication methods recorded on a Principal and in metrics.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// APIKeyHeader is the request header carrying a static API key.
+const APIKeyHeader = "X-API-Key"
+
+// jwtLeeway tolerates small clock differences when checking exp/nbf/iat.
+const jwtLeeway = time.Minute
+
+// errUnauthenticated is returned when a request carries no credentials.
+var errUnauthenticated = errors.New("no credentials presented")
+
+// Principal is the authenticated caller attached to the request context.
+type Principal struct {
+	Name   string   // API key name or JWT "sub" claim
+	Method string   // MethodAPIKey or MethodJWT
+	Roles  []string // Roles from the API key entry or the JWT "roles" claim
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal attached by the middleware, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// apiKeyEntry is one entry of the API keys file. Only the SHA-256 hash of the
+// key is stored, as "sha256:<hex>".
+type apiKeyEntry struct {
+	Name  string   `json:"name"`
+	Hash  string   `json:"hash"`
+	Roles []string `json:"roles"`
+}
+
+// Options configures an Authenticator.
+type Options struct {
+	APIKeysFile string // JSON file of hashed API keys (optional)
+	JWKSFile    string // JWKS file used to validate bearer tokens (optional)
+	Issuer      string // Required "iss" claim, if set
+	Audience    string // Required "aud" claim, if set
+}
+
+// Authenticator validates API keys and JWT bearer tokens.
+type Authenticator struct {
+	apiKeys  map[string]apiKeyEntry // keyed by hex SHA-256 of the key
+	jwks     []jose.JSONWebKey
+	issuer   string
+	audience string
+	metrics  metrics.MetricsService
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// NewAuthenticator loads the configured credential files.
+func NewAuthenticator(opts Options, ms metrics.MetricsService, logger *slog.Logger) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:  make(map[string]apiKeyEntry),
+		issuer:   opts.Issuer,
+		audience: opts.Audience,
+		metrics:  ms,
+		logger:   logger,
+		now:      time.Now,
+	}
+
+	if opts.APIKeysFile != "" {
+		raw, err := os.ReadFile(opts.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %w", err)
+		}
+		var file struct {
+			APIKeys []apiKeyEntry `json:"api_keys"`
+		}
+		if err := json.Unmarshal(raw, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+		}
+		for _, entry := range file.APIKeys {
+			digest, ok := strings.CutPrefix(entry.Hash, "sha256:")
+			if !ok || len(digest) != sha256.Size*2 {
+				return nil, fmt.Errorf("API key %q: hash must be of the form sha256:<64 hex chars>", entry.Name)
+			}
+			if _, err := hex.DecodeString(digest); err != nil {
+				return nil, fmt.Errorf("API key %q: invalid hex digest", entry.Name)
+			}
+			if entry.Name == "" {
+				return nil, errors.New("API key entries must have a name")
+			}
+			a.apiKeys[strings.ToLower(digest)] = entry
+		}
+	}
+
+	if opts.JWKSFile != "" {
+		raw, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		var set jose.JSONWebKeySet
+		if err := json.Unmarshal(raw, &set); err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+		}
+		for _, key := range set.Keys {
+			if _, err := key.VerificationKey(); err != nil {
+				return nil, fmt.Errorf("JWKS key %q: %w", key.Kid, err)
+			}
+		}
+		a.jwks = set.Keys
+	}
+
+	return a, nil
+}
+
+// Enabled reports whether any credential source is configured.
+func (a *Authenticator) Enabled() bool {
+	return len(a.apiKeys) > 0 || len(a.jwks) > 0
+}
+
+// Middleware rejects unauthenticated requests with 401 and attaches the
+// Principal to the context of authenticated ones.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, method, err := a.Authenticate(r)
+		if err != nil {
+			a.metrics.RecordAuthentication(method, "failure")
+			if !errors.Is(err, errUnauthenticated) {
+				a.logger.WarnContext(r.Context(), "Authentication failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="key-server"`)
+			http.Error(w, "Unauthorized: valid API key or bearer token required.", http.StatusUnauthorized)
+			return
+		}
+		a.metrics.RecordAuthentication(method, "success")
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Authenticate extracts and validates the request credentials. It returns the
+// method that was attempted ("none" if no credentials were presented).
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, string, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		p, err := a.authenticateAPIKey(key)
+		return p, MethodAPIKey, err
+	}
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		scheme, token, ok := strings.Cut(authz, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, "none", errors.New("unsupported Authorization scheme")
+		}
+		p, err := a.authenticateJWT(strings.TrimSpace(token))
+		return p, MethodJWT, err
+	}
+	return nil, "none", errUnauthenticated
+}
+
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+	entry, ok := a.apiKeys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, errors.New("unknown API key")
+	}
+	return &Principal{Name: entry.Name, Method: MethodAPIKey, Roles: entry.Roles}, nil
+}
+
+// jwtClaims are the registered claims the key server checks, plus "roles".
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	IssuedAt  *int64          `json:"iat"`
+	Roles     []string        `json:"roles"`
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if len(a.jwks) == 0 {
+		return nil, errors.New("bearer tokens are not accepted")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := jose.DecodeJSONSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed JWT header")
+	}
+	switch header.Alg {
+	case jose.AlgHS256, jose.AlgRS256, jose.AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("JWT alg %q is not allowed", header.Alg)
+	}
+	signature, err := jose.DecodeSegment(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, jwk := range a.jwks {
+		if header.Kid != "" && jwk.Kid != header.Kid {
+			continue
+		}
+		if jwk.Alg != "" && jwk.Alg != header.Alg {
+			continue
+		}
+		key, err := jwk.VerificationKey()
+		if err != nil {
+			continue
+		}
+		if jose.Verify(header.Alg, key, signingInput, signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("JWT signature did not verify against any JWKS key")
+	}
+
+	var claims jwtClaims
+	if err := jose.DecodeJSONSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed JWT claims")
+	}
+	if err := a.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &Principal{Name: claims.Subject, Method: MethodJWT, Roles: claims.Roles}, nil
+}
+
+func (a *Authenticator) validateClaims(c *jwtClaims) error {
+	now := a.now()
+	if c.Subject == "" {
+		return errors.New("JWT has no sub claim")
+	}
+	if c.ExpiresAt == nil {
+		return errors.New("JWT has no exp claim")
+	}
+	if now.After(time.Unix(*c.ExpiresAt, 0).Add(jwtLeeway)) {
+		return errors.New("JWT has expired")
+	}
+	if c.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(*c.NotBefore, 0)) {
+		return errors.New("JWT is not yet valid")
+	}
+	if c.IssuedAt != nil && now.Add(jwtLeeway).Before(time.Unix(*c.IssuedAt, 0)) {
+		return errors.New("JWT was issued in the future")
+	}
+	if a.issuer != "" && c.Issuer != a.issuer {
+		return fmt.Errorf("JWT issuer %q is not trusted", c.Issuer)
+	}
+	if a.audience != "" && !audienceContains(c.Audience, a.audience) {
+		return errors.New("JWT audience does not include this server")
+	}
+	return nil
+}
+
+// audienceContains handles "aud" as either a string or an array of strings.
+func audienceContains(raw json.RawMessage, want string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == want
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for _, aud := range list {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
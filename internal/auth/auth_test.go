@@ -0,0 +1,164 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+const testAPIKey = "ks_test_0123456789abcdef"
+
+var hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func writeFile(t *testing.T, name string, v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Could not marshal %s: %v", name, err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("Could not write %s: %v", name, err)
+	}
+	return path
+}
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := jose.EncodeSegment(header) + "." + jose.EncodeSegment(payload)
+	sig, err := jose.Sign(alg, key, []byte(input))
+	if err != nil {
+		t.Fatalf("Could not sign JWT: %v", err)
+	}
+	return input + "." + jose.EncodeSegment(sig)
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	rsaJWK, _ := jose.NewJSONWebKey(&rsaKey.PublicKey)
+	rsaJWK.Kid = "rsa-1"
+	edJWK, _ := jose.NewJSONWebKey(edPub)
+	edJWK.Kid = "ed-1"
+	hmacJWK := jose.JSONWebKey{Kty: "oct", Kid: "hs-1", K: jose.EncodeSegment(hmacSecret)}
+
+	digest := sha256.Sum256([]byte(testAPIKey))
+	apiKeysFile := writeFile(t, "api_keys.json", map[string]interface{}{
+		"api_keys": []map[string]interface{}{
+			{"name": "billing-service", "hash": "sha256:" + hex.EncodeToString(digest[:]), "roles": []string{"generator"}},
+		},
+	})
+	jwksFile := writeFile(t, "jwks.json", jose.JSONWebKeySet{Keys: []jose.JSONWebKey{rsaJWK, edJWK, hmacJWK}})
+
+	authenticator, err := auth.NewAuthenticator(auth.Options{
+		APIKeysFile: apiKeysFile,
+		JWKSFile:    jwksFile,
+		Issuer:      "https://issuer.internal",
+		Audience:    "key-server",
+	}, metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64), logging.Discard())
+	if err != nil {
+		t.Fatalf("NewAuthenticator returned an error: %v", err)
+	}
+	if !authenticator.Enabled() {
+		t.Fatal("Enabled() = false with credentials configured")
+	}
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":   "payments-job",
+			"iss":   "https://issuer.internal",
+			"aud":   []string{"key-server"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": []string{"admin"},
+		}
+	}
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "someone-else"
+
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		wantStatus    int
+		wantPrincipal string
+		wantMethod    string
+	}{
+		{"No credentials", nil, http.StatusUnauthorized, "", ""},
+		{"Valid API key", map[string]string{"X-API-Key": testAPIKey}, http.StatusOK, "billing-service", auth.MethodAPIKey},
+		{"Unknown API key", map[string]string{"X-API-Key": "nope"}, http.StatusUnauthorized, "", ""},
+		{"RS256 token", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgRS256, "rsa-1", rsaKey, validClaims())}, http.StatusOK, "payments-job", auth.MethodJWT},
+		{"EdDSA token", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgEdDSA, "ed-1", edPriv, validClaims())}, http.StatusOK, "payments-job", auth.MethodJWT},
+		{"HS256 token", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgHS256, "hs-1", hmacSecret, validClaims())}, http.StatusOK, "payments-job", auth.MethodJWT},
+		{"Expired token", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgRS256, "rsa-1", rsaKey, expired)}, http.StatusUnauthorized, "", ""},
+		{"Wrong audience", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgRS256, "rsa-1", rsaKey, wrongAudience)}, http.StatusUnauthorized, "", ""},
+		{"Token signed by unknown key", map[string]string{"Authorization": "Bearer " + signJWT(t, jose.AlgHS256, "hs-1", []byte("another-secret-another-secret-!!"), validClaims())}, http.StatusUnauthorized, "", ""},
+		{"Unsupported scheme", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, http.StatusUnauthorized, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *auth.Principal
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = auth.PrincipalFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/key/32", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			authenticator.Middleware(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				if rr.Header().Get("WWW-Authenticate") == "" {
+					t.Error("401 response is missing WWW-Authenticate")
+				}
+				return
+			}
+			if got == nil || got.Name != tt.wantPrincipal || got.Method != tt.wantMethod {
+				t.Errorf("got principal %+v, want name %q method %q", got, tt.wantPrincipal, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_InvalidFiles(t *testing.T) {
+	ms := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+
+	badHash := writeFile(t, "api_keys.json", map[string]interface{}{
+		"api_keys": []map[string]string{{"name": "x", "hash": "md5:abc"}},
+	})
+	if _, err := auth.NewAuthenticator(auth.Options{APIKeysFile: badHash}, ms, logging.Discard()); err == nil {
+		t.Error("expected an error for a non-sha256 API key hash")
+	}
+
+	shortSecret := writeFile(t, "jwks.json", jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Kty: "oct", K: jose.EncodeSegment([]byte("short"))}}})
+	if _, err := auth.NewAuthenticator(auth.Options{JWKSFile: shortSecret}, ms, logging.Discard()); err == nil {
+		t.Error("expected an error for a too-short HS256 secret")
+	}
+
+	if a, err := auth.NewAuthenticator(auth.Options{}, ms, logging.Discard()); err != nil || a.Enabled() {
+		t.Errorf("empty options: got enabled=%v err=%v, want disabled and no error", a != nil && a.Enabled(), err)
+	}
+}
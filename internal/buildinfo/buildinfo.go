@@ -0,0 +1,50 @@
+// Package buildinfo reports the version and provenance of the running binary.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the release version. Release builds set it with
+//
+//	-ldflags "-X github.com/bajhalshrey/Key-Server-Application/internal/buildinfo.Version=v1.2.3"
+var Version = "dev"
+
+// Info describes the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"` // VCS commit the binary was built from
+	Time      string `json:"time,omitempty"`     // Commit time (RFC 3339)
+	Modified  bool   `json:"modified,omitempty"` // The working tree had uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information, taking the revision from the VCS
+// stamp the Go toolchain embeds when building from a repository.
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// Handler serves the build information as JSON.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Get())
+}
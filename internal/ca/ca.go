@@ -0,0 +1,199 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// DefaultLeafValidity is how long issued leaf certificates are valid for
+// unless the caller asks for something shorter.
+const DefaultLeafValidity = 90 * 24 * time.Hour
+
+// Authority is the key server's internal X.509 certificate authority.
+// It signs leaf certificates for internal services (e.g. via ACME).
+type Authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	signer  crypto.Signer
+	onIssue func(*x509.Certificate) error
+}
+
+// NewAuthority loads the CA certificate and private key from PEM files.
+func NewAuthority(certFile, keyFile string) (*Authority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate %s: %w", certFile, err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key %s: %w", keyFile, err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no CERTIFICATE block found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a CA certificate")
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", keyFile)
+	}
+	signer, err := ParsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		signer:  signer,
+	}, nil
+}
+
+// NewSelfSignedAuthority creates an ephemeral CA with a freshly generated
+// P-256 key. It is intended for development and tests; the CA is lost when
+// the process exits.
+func NewSelfSignedAuthority(commonName string) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to self-sign CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		signer:  key,
+	}, nil
+}
+
+// Certificate returns the CA certificate.
+func (a *Authority) Certificate() *x509.Certificate {
+	return a.cert
+}
+
+// CertificatePEM returns the PEM-encoded CA certificate.
+func (a *Authority) CertificatePEM() []byte {
+	return a.certPEM
+}
+
+// OnIssue registers fn to be called with every certificate the authority
+// issues, e.g. to append it to a transparency log. If fn fails the
+// certificate is not returned to the caller. It must be called before the
+// authority is used.
+func (a *Authority) OnIssue(fn func(*x509.Certificate) error) {
+	a.onIssue = fn
+}
+
+// IssueServerCertificate signs a TLS server/client leaf certificate for the
+// public key in csr, covering exactly dnsNames. The CSR signature must be valid.
+func (a *Authority) IssueServerCertificate(csr *x509.CertificateRequest, dnsNames []string, validity time.Duration) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if len(dnsNames) == 0 {
+		return nil, errors.New("at least one DNS name is required")
+	}
+	if validity <= 0 || validity > DefaultLeafValidity {
+		validity = DefaultLeafValidity
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, csr.PublicKey, a.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if a.onIssue != nil {
+		if err := a.onIssue(cert); err != nil {
+			return nil, fmt.Errorf("failed to record issued certificate: %w", err)
+		}
+	}
+	return cert, nil
+}
+
+// ParsePrivateKey parses a DER private key in PKCS#8, SEC1 (EC) or PKCS#1 (RSA) form.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unrecognised private key format")
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
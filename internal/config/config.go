@@ -1,61 +1,424 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
 )
 
 // Config holds the application's configuration.
 type Config struct {
-	Port     string // Port for the HTTP server to listen on (e.g., "8080" for HTTP, "8443" for HTTPS)
-	MaxSize  int    // Maximum key size allowed
-	CertFile string // Path to the TLS certificate file (e.g., /etc/key-server/tls/server.crt)
-	KeyFile  string // Path to the TLS key file (e.g., /etc/key-server/tls/server.key)
+	File                string        `yaml:"-"`                     // YAML file the configuration was loaded from (empty = none)
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"` // How often File is checked for changes to reload (0 = only on SIGHUP)
+
+	Port     string `yaml:"port"`          // Port for the HTTP server to listen on (e.g., "8080" for HTTP, "8443" for HTTPS)
+	MaxSize  int    `yaml:"max_key_size"`  // Maximum key size allowed
+	CertFile string `yaml:"tls_cert_file"` // Path to the TLS certificate file (e.g., /etc/key-server/tls/server.crt)
+	KeyFile  string `yaml:"tls_key_file"`  // Path to the TLS key file (e.g., /etc/key-server/tls/server.key)
+
+	TLSMinVersion string `yaml:"tls_min_version"` // Oldest TLS version accepted: "1.2" or "1.3"
+
+	Listeners      []string `yaml:"listeners"`        // Addresses to serve on: [tcp://]host:port, unix:///path or systemd[:name] (empty = TCP on Port)
+	UnixSocketMode string   `yaml:"unix_socket_mode"` // Octal permissions of created Unix sockets
+	AdminAddress   string   `yaml:"admin_address"`    // Listener for metrics, health, pprof and build info (empty = metrics and probes on the public listeners)
+
+	ServerReadTimeout       time.Duration `yaml:"server_read_timeout"`        // Time to read a whole request, including the body (0 = none)
+	ServerReadHeaderTimeout time.Duration `yaml:"server_read_header_timeout"` // Time to read request headers (0 = ServerReadTimeout)
+	ServerWriteTimeout      time.Duration `yaml:"server_write_timeout"`       // Time to write a response (0 = none)
+	ServerIdleTimeout       time.Duration `yaml:"server_idle_timeout"`        // How long an idle keep-alive connection is kept open (0 = ServerReadTimeout)
+	ServerMaxHeaderBytes    int           `yaml:"server_max_header_bytes"`    // Largest request header block accepted
+	ServerKeepAlives        bool          `yaml:"server_keep_alives"`         // Reuse connections for several requests
+	ServerShutdownTimeout   time.Duration `yaml:"server_shutdown_timeout"`    // How long in-flight requests may take to finish at shutdown
+	ServerShutdownDelay     time.Duration `yaml:"server_shutdown_delay"`      // How long to keep serving after reporting unready at shutdown
+
+	CACertFile         string   `yaml:"ca_cert_file"`         // Path to the internal CA certificate used for ACME issuance (empty = ephemeral CA)
+	CAKeyFile          string   `yaml:"ca_key_file"`          // Path to the internal CA private key
+	ACMEAllowedDomains []string `yaml:"acme_allowed_domains"` // DNS suffixes the ACME endpoint may issue for (empty = none)
+
+	SSHCAKeyFile  string        `yaml:"ssh_ca_key_file"`  // Path to the SSH CA private key (empty = ephemeral SSH CA)
+	SSHCertMaxTTL time.Duration `yaml:"ssh_cert_max_ttl"` // Longest validity window an SSH certificate may be issued with
+
+	APIKeysFile string `yaml:"auth_api_keys_file"` // Path to the JSON file of hashed API keys (empty = API keys disabled)
+	JWKSFile    string `yaml:"auth_jwks_file"`     // Path to the JWKS file used to validate bearer tokens (empty = JWTs disabled)
+	JWTIssuer   string `yaml:"auth_jwt_issuer"`    // Required "iss" claim for bearer tokens (optional)
+	JWTAudience string `yaml:"auth_jwt_audience"`  // Required "aud" claim for bearer tokens (optional)
+
+	PolicyFile string `yaml:"policy_file"` // Path to the JSON authorization policy (empty = policy enforcement disabled)
+
+	RateLimits []RateLimit `yaml:"rate_limits"` // Per-route rate limits and daily quotas (empty = unlimited)
+
+	CryptoPeriods         []CryptoPeriod `yaml:"crypto_periods"`           // Per-algorithm limits on how long named keys stay active and usable (empty = unlimited)
+	KeyStateCheckInterval time.Duration  `yaml:"key_state_check_interval"` // How often named keys are moved to the states their schedules call for
+	UsageLimits           []UsageLimit   `yaml:"usage_limits"`             // Per-algorithm limits on how much data named keys protect (AES-GCM keys are always limited)
+
+	IdempotencyTTL      time.Duration `yaml:"idempotency_ttl"`       // How long responses to requests with an Idempotency-Key are kept for replay (0 = disabled)
+	IdempotencyMaxBytes int           `yaml:"idempotency_max_bytes"` // Memory for kept responses; the oldest are evicted first
+
+	AuditLogFile      string `yaml:"audit_log_file"`      // Path to the hash-chained JSON-lines audit log (empty = no file sink)
+	AuditSyslogSocket string `yaml:"audit_syslog_socket"` // Local syslog socket to forward audit entries to (e.g. /dev/log)
+	AuditStdout       bool   `yaml:"audit_stdout"`        // Also write audit entries to standard output
+
+	TransparencyLogFile        string `yaml:"transparency_log_file"`         // Path to the transparency log leaf file (empty = in-memory log)
+	TransparencySigningKeyFile string `yaml:"transparency_signing_key_file"` // Path to the Ed25519 key that signs tree heads (empty = ephemeral key)
+
+	LogLevel  slog.Level `yaml:"log_level"`  // Minimum level of log records written
+	LogFormat string     `yaml:"log_format"` // Log output format: "text" or "json"
+
+	TracingExporter     string  `yaml:"tracing_exporter"`      // Span exporter: "otlp", "stdout" or "" (tracing disabled)
+	TracingOTLPEndpoint string  `yaml:"tracing_otlp_endpoint"` // host:port of the OTLP/HTTP collector
+	TracingSampleRatio  float64 `yaml:"tracing_sample_ratio"`  // Fraction of new traces sampled (0-1); parent decisions are honored
+
+	MetricsLengthClasses []int `yaml:"metrics_length_classes"` // Increasing upper bounds of key length metric classes (empty = powers of two up to MaxSize)
+}
+
+// Span exporters supported by TracingExporter.
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+// Client identities a rate limit can be keyed by.
+const (
+	RateLimitByPrincipal = "principal" // Authenticated principal name
+	RateLimitByIP        = "ip"        // Client IP address
+	RateLimitByAPIKey    = "api_key"   // Value of the X-API-Key header
+)
+
+// RateLimitAllRoutes is the route of the limit applied to every protected
+// request before authentication, so that failed logins are throttled too. It
+// is keyed by client IP and has no daily quotas.
+const RateLimitAllRoutes = "*"
+
+// RateLimit configures throttling for a single route. Each client (as selected
+// by KeyBy) gets its own token bucket and daily quota.
+type RateLimit struct {
+	Route             string  `json:"route" yaml:"route"`                             // Route path template, e.g. "/key/{length}", or "*" for all routes before authentication
+	KeyBy             string  `json:"key_by" yaml:"key_by"`                           // "principal" (default), "ip" or "api_key"
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"` // Token refill rate (0 = no rate limit)
+	Burst             int     `json:"burst" yaml:"burst"`                             // Bucket size (defaults to the rate rounded up)
+	DailyKeys         int     `json:"daily_keys" yaml:"daily_keys"`                   // Successful requests per UTC day (0 = unlimited)
+	DailyBytes        int64   `json:"daily_bytes" yaml:"daily_bytes"`                 // Key bytes per UTC day (0 = unlimited)
+}
+
+// CryptoPeriod limits the life of named keys of one key type (algorithm),
+// after NIST SP 800-57. Both periods are measured from activation: a key
+// is deactivated at the end of its active period and destroyed at the end
+// of its usage period.
+type CryptoPeriod struct {
+	KeyType         string        `json:"key_type" yaml:"key_type"`                   // Algorithm such as "aes-256-gcm", or "*" for every other algorithm
+	MaxActivePeriod time.Duration `json:"max_active_period" yaml:"max_active_period"` // Longest time a key encrypts or signs (0 = unlimited)
+	MaxUsagePeriod  time.Duration `json:"max_usage_period" yaml:"max_usage_period"`   // Longest time a key is usable at all (0 = never destroyed)
+}
+
+// UnmarshalJSON accepts periods as duration strings such as "2160h".
+func (p *CryptoPeriod) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		KeyType         string `json:"key_type"`
+		MaxActivePeriod string `json:"max_active_period"`
+		MaxUsagePeriod  string `json:"max_usage_period"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.KeyType = raw.KeyType
+	for _, d := range []struct {
+		value string
+		field *time.Duration
+	}{{raw.MaxActivePeriod, &p.MaxActivePeriod}, {raw.MaxUsagePeriod, &p.MaxUsagePeriod}} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("crypto period for %s: %w", raw.KeyType, err)
+		}
+		*d.field = v
+	}
+	return nil
+}
+
+// CryptoPeriod returns the crypto period for keyType, falling back to the
+// "*" entry. The zero CryptoPeriod sets no limits.
+func (c *Config) CryptoPeriod(keyType string) CryptoPeriod {
+	var fallback CryptoPeriod
+	for _, p := range c.CryptoPeriods {
+		switch p.KeyType {
+		case keyType:
+			return p
+		case "*":
+			fallback = p
+		}
+	}
+	return fallback
+}
+
+// UsageLimit caps how much data named keys of one key type (algorithm)
+// encrypt or sign before they have to be replaced.
+type UsageLimit struct {
+	KeyType       string `json:"key_type" yaml:"key_type"`             // Algorithm such as "aes-256-gcm", or "*" for every other algorithm
+	MaxOperations uint64 `json:"max_operations" yaml:"max_operations"` // Most encrypt or sign operations (0 = unlimited)
+	MaxBytes      uint64 `json:"max_bytes" yaml:"max_bytes"`           // Most bytes encrypted or signed (0 = unlimited)
+	OnLimit       string `json:"on_limit" yaml:"on_limit"`             // "deactivate" (default) or "refuse"
+}
+
+// UsageLimit returns the usage limit for keyType, falling back to the "*"
+// entry. The zero UsageLimit sets no limits.
+func (c *Config) UsageLimit(keyType string) UsageLimit {
+	var fallback UsageLimit
+	for _, l := range c.UsageLimits {
+		switch l.KeyType {
+		case keyType:
+			return l
+		case "*":
+			fallback = l
+		}
+	}
+	return fallback
+}
+
+// Supported TLSMinVersion values.
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// MaxKeySizeLimit is the largest MaxSize that may be configured (1 MiB).
+const MaxKeySizeLimit = 1 << 20
+
+// ErrUsage wraps command-line parsing errors, so callers can tell a mistyped
+// flag from an invalid configuration.
+var ErrUsage = errors.New("usage error")
+
+// Defaults returns the configuration used when nothing is set.
+func Defaults() *Config {
+	return &Config{
+		Port:     "8443", // HTTPS
+		MaxSize:  1024,
+		CertFile: "/etc/key-server/tls/server.crt", // Default path inside container for mounted secret
+		KeyFile:  "/etc/key-server/tls/server.key", // Default path inside container for mounted secret
+
+		TLSMinVersion: TLSVersion12,
+
+		UnixSocketMode: "0660",
+
+		ServerReadTimeout:       10 * time.Second,
+		ServerReadHeaderTimeout: 5 * time.Second,
+		ServerWriteTimeout:      10 * time.Second,
+		ServerIdleTimeout:       60 * time.Second,
+		ServerMaxHeaderBytes:    1 << 20, // http.DefaultMaxHeaderBytes
+		ServerKeepAlives:        true,
+		ServerShutdownTimeout:   5 * time.Second,
+
+		SSHCertMaxTTL: 24 * time.Hour,
+
+		KeyStateCheckInterval: time.Minute,
+
+		IdempotencyTTL:      24 * time.Hour,
+		IdempotencyMaxBytes: 64 << 20,
+
+		LogLevel:  slog.LevelInfo,
+		LogFormat: "text",
+
+		TracingOTLPEndpoint: "localhost:4318",
+		TracingSampleRatio:  1,
+
+		ConfigWatchInterval: 10 * time.Second,
+	}
 }
 
 // NewConfig loads configuration from environment variables or provides defaults.
+// It is Load without command-line flags.
 func NewConfig() (*Config, error) {
-	// --- Server Port Configuration ---
-	// Uses "PORT" environment variable, defaults to "8443" for HTTPS
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8443" // Changed default to 8443 as we are setting up HTTPS
-	}
-
-	// --- Max Key Size Configuration ---
-	// Uses "MAX_KEY_SIZE" environment variable, defaults to 1024
-	maxSizeStr := os.Getenv("MAX_KEY_SIZE")
-	maxSize := 1024 // Default max key size
-	if maxSizeStr != "" {
-		parsedSize, err := strconv.Atoi(maxSizeStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid MAX_KEY_SIZE environment variable: %w", err)
+	return Load(nil)
+}
+
+// Load builds the configuration in layers, each overriding the one before:
+// built-in defaults, the YAML file named by the -config flag or the
+// CONFIG_FILE environment variable, environment variables, and finally the
+// command-line flags in args. Every invalid setting is reported in the
+// returned error, not just the first.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("key-server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to a YAML configuration file (overrides CONFIG_FILE)")
+	flagValues := registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("%w: unexpected arguments %q", ErrUsage, fs.Args())
+	}
+
+	cfg := Defaults()
+
+	// --- File Configuration ---
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
 		}
-		if parsedSize <= 0 {
-			return nil, fmt.Errorf("MAX_KEY_SIZE must be a positive integer")
+		cfg.File = path
+	}
+
+	// --- Environment Configuration ---
+	// Empty variables are treated as unset.
+	var errs []error
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s environment variable: %w", s.env, err))
+			}
 		}
-		maxSize = parsedSize
 	}
 
-	// --- TLS Certificate File Paths Configuration ---
-	// Uses "TLS_CERT_FILE" and "TLS_KEY_FILE" environment variables
-	// Defaults to paths expected when mounted from Kubernetes Secret
-	certFile := os.Getenv("TLS_CERT_FILE")
-	if certFile == "" {
-		certFile = "/etc/key-server/tls/server.crt" // Default path inside container for mounted secret
+	// --- Flag Configuration ---
+	for _, fv := range *flagValues {
+		if err := fv.setting.set(cfg, fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -%s flag: %w", fv.setting.flagName(), err))
+		}
 	}
 
-	keyFile := os.Getenv("TLS_KEY_FILE")
-	if keyFile == "" {
-		keyFile = "/etc/key-server/tls/server.key" // Default path inside container for mounted secret
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
 
-	// --- Create and Return Config ---
-	return &Config{
-		Port:     port,
-		MaxSize:  maxSize,
-		CertFile: certFile, // New field initialized
-		KeyFile:  keyFile,  // New field initialized
-	}, nil
+// loadFile decodes the YAML file at path over c. Unknown keys are rejected so
+// that misspelled settings do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Redacted returns a copy of c that is safe to print: the locations of
+// private keys are replaced by RedactedValue.
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, p := range []*string{&r.KeyFile, &r.CAKeyFile, &r.SSHCAKeyFile, &r.TransparencySigningKeyFile} {
+		if *p != "" {
+			*p = RedactedValue
+		}
+	}
+	return &r
+}
+
+// SocketMode returns UnixSocketMode as file permissions. The configuration
+// is validated, so it always parses.
+func (c *Config) SocketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// RedactedValue replaces secret settings in Redacted.
+const RedactedValue = "[REDACTED]"
+
+// validateCryptoPeriods checks that each period names a known key type once
+// and that keys stay usable at least as long as they are active.
+func validateCryptoPeriods(periods []CryptoPeriod) error {
+	seen := make(map[string]bool, len(periods))
+	for i, p := range periods {
+		if p.KeyType != "*" && keystore.KeySize(p.KeyType) == 0 {
+			return fmt.Errorf("crypto period #%d: unknown key_type %q", i, p.KeyType)
+		}
+		if seen[p.KeyType] {
+			return fmt.Errorf("crypto period for %s is defined more than once", p.KeyType)
+		}
+		seen[p.KeyType] = true
+		if p.MaxActivePeriod < 0 || p.MaxUsagePeriod < 0 {
+			return fmt.Errorf("crypto period for %s: periods must not be negative", p.KeyType)
+		}
+		if p.MaxActivePeriod > 0 && p.MaxUsagePeriod > 0 && p.MaxUsagePeriod < p.MaxActivePeriod {
+			return fmt.Errorf("crypto period for %s: max_usage_period must not be shorter than max_active_period", p.KeyType)
+		}
+	}
+	return nil
+}
+
+// validateUsageLimits checks that each limit names a known key type once
+// and a known action.
+func validateUsageLimits(limits []UsageLimit) error {
+	seen := make(map[string]bool, len(limits))
+	for i, l := range limits {
+		if l.KeyType != "*" && keystore.KeySize(l.KeyType) == 0 {
+			return fmt.Errorf("usage limit #%d: unknown key_type %q", i, l.KeyType)
+		}
+		if seen[l.KeyType] {
+			return fmt.Errorf("usage limit for %s is defined more than once", l.KeyType)
+		}
+		seen[l.KeyType] = true
+		switch l.OnLimit {
+		case "", keystore.OnLimitDeactivate, keystore.OnLimitRefuse:
+		default:
+			return fmt.Errorf("usage limit for %s: on_limit must be %q or %q, got %q", l.KeyType, keystore.OnLimitDeactivate, keystore.OnLimitRefuse, l.OnLimit)
+		}
+	}
+	return nil
+}
+
+// validateRateLimits checks each limit and fills in defaults.
+func validateRateLimits(limits []RateLimit) error {
+	seen := make(map[string]bool, len(limits))
+	for i := range limits {
+		l := &limits[i]
+		if l.Route == "" {
+			return fmt.Errorf("rate limit #%d: route is required", i)
+		}
+		if seen[l.Route] {
+			return fmt.Errorf("rate limit for %s is defined more than once", l.Route)
+		}
+		seen[l.Route] = true
+
+		if l.Route == RateLimitAllRoutes {
+			// Principals and API keys are not checked yet when this limit applies.
+			if l.KeyBy != "" && l.KeyBy != RateLimitByIP {
+				return fmt.Errorf("rate limit for %s: key_by must be %q", l.Route, RateLimitByIP)
+			}
+			if l.DailyKeys != 0 || l.DailyBytes != 0 {
+				return fmt.Errorf("rate limit for %s: daily quotas are not supported", l.Route)
+			}
+			l.KeyBy = RateLimitByIP
+		}
+
+		switch l.KeyBy {
+		case "":
+			l.KeyBy = RateLimitByPrincipal
+		case RateLimitByPrincipal, RateLimitByIP, RateLimitByAPIKey:
+		default:
+			return fmt.Errorf("rate limit for %s: key_by must be %q, %q or %q", l.Route, RateLimitByPrincipal, RateLimitByIP, RateLimitByAPIKey)
+		}
+		if l.RequestsPerSecond < 0 || l.Burst < 0 || l.DailyKeys < 0 || l.DailyBytes < 0 {
+			return fmt.Errorf("rate limit for %s: limits must not be negative", l.Route)
+		}
+		if l.RequestsPerSecond > 0 && l.Burst == 0 {
+			l.Burst = int(math.Ceil(l.RequestsPerSecond))
+		}
+	}
+	return nil
 }
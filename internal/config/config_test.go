@@ -1,8 +1,13 @@
 package config_test
 
 import (
+	"errors"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bajhalshrey/Key-Server-Application/internal/config"
 )
@@ -14,6 +19,33 @@ func TestNewConfig(t *testing.T) {
 		os.Unsetenv("MAX_KEY_SIZE")
 		os.Unsetenv("TLS_CERT_FILE")
 		os.Unsetenv("TLS_KEY_FILE")
+		os.Unsetenv("CA_CERT_FILE")
+		os.Unsetenv("CA_KEY_FILE")
+		os.Unsetenv("ACME_ALLOWED_DOMAINS")
+		os.Unsetenv("SSH_CA_KEY_FILE")
+		os.Unsetenv("SSH_CERT_MAX_TTL")
+		os.Unsetenv("AUTH_API_KEYS_FILE")
+		os.Unsetenv("AUTH_JWKS_FILE")
+		os.Unsetenv("AUTH_JWT_ISSUER")
+		os.Unsetenv("AUTH_JWT_AUDIENCE")
+		os.Unsetenv("POLICY_FILE")
+		os.Unsetenv("RATE_LIMITS")
+		os.Unsetenv("CRYPTO_PERIODS")
+		os.Unsetenv("USAGE_LIMITS")
+		os.Unsetenv("KEY_STATE_CHECK_INTERVAL")
+		os.Unsetenv("AUDIT_LOG_FILE")
+		os.Unsetenv("AUDIT_SYSLOG_SOCKET")
+		os.Unsetenv("AUDIT_STDOUT")
+		os.Unsetenv("TRANSPARENCY_LOG_FILE")
+		os.Unsetenv("TRANSPARENCY_SIGNING_KEY_FILE")
+		os.Unsetenv("LOG_LEVEL")
+		os.Unsetenv("LOG_FORMAT")
+		os.Unsetenv("TRACING_EXPORTER")
+		os.Unsetenv("TRACING_OTLP_ENDPOINT")
+		os.Unsetenv("TRACING_SAMPLE_RATIO")
+		os.Unsetenv("METRICS_LENGTH_CLASSES")
+		os.Unsetenv("TLS_MIN_VERSION")
+		os.Unsetenv("CONFIG_FILE")
 	}
 
 	// Test case 1: Default values
@@ -35,6 +67,15 @@ func TestNewConfig(t *testing.T) {
 		if cfg.KeyFile != "/etc/key-server/tls/server.key" {
 			t.Errorf("Expected default KeyFile '/etc/key-server/tls/server.key', got '%s'", cfg.KeyFile)
 		}
+		if cfg.SSHCertMaxTTL != 24*time.Hour {
+			t.Errorf("Expected default SSHCertMaxTTL 24h, got %s", cfg.SSHCertMaxTTL)
+		}
+		if cfg.IdempotencyTTL != 24*time.Hour || cfg.IdempotencyMaxBytes != 64<<20 {
+			t.Errorf("Expected default idempotency window 24h and 64 MiB, got %s and %d", cfg.IdempotencyTTL, cfg.IdempotencyMaxBytes)
+		}
+		if cfg.KeyStateCheckInterval != time.Minute || len(cfg.CryptoPeriods) != 0 {
+			t.Errorf("Expected a 1m key state check and no crypto periods, got %s and %v", cfg.KeyStateCheckInterval, cfg.CryptoPeriods)
+		}
 	})
 
 	// Test case 2: Custom PORT
@@ -109,4 +150,490 @@ func TestNewConfig(t *testing.T) {
 			t.Error("Expected an error for negative MAX_KEY_SIZE, got nil")
 		}
 	})
+
+	// Test case 8: Internal CA and ACME settings
+	t.Run("Custom CA and ACME Domains", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("CA_CERT_FILE", "/tmp/ca.crt")
+		os.Setenv("CA_KEY_FILE", "/tmp/ca.key")
+		os.Setenv("ACME_ALLOWED_DOMAINS", "svc.cluster.local, internal.example")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for CA settings: %v", err)
+		}
+		if cfg.CACertFile != "/tmp/ca.crt" || cfg.CAKeyFile != "/tmp/ca.key" {
+			t.Errorf("Expected CA files '/tmp/ca.crt' and '/tmp/ca.key', got '%s' and '%s'", cfg.CACertFile, cfg.CAKeyFile)
+		}
+		if len(cfg.ACMEAllowedDomains) != 2 || cfg.ACMEAllowedDomains[1] != "internal.example" {
+			t.Errorf("Expected two ACME allowed domains, got %v", cfg.ACMEAllowedDomains)
+		}
+	})
+
+	// Test case 9: CA certificate without key
+	t.Run("CA Cert Without Key", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("CA_CERT_FILE", "/tmp/ca.crt")
+		_, err := config.NewConfig()
+		if err == nil {
+			t.Error("Expected an error when CA_CERT_FILE is set without CA_KEY_FILE, got nil")
+		}
+	})
+
+	// Test case 10: SSH CA settings
+	t.Run("Custom SSH CA Settings", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("SSH_CA_KEY_FILE", "/tmp/ssh_ca")
+		os.Setenv("SSH_CERT_MAX_TTL", "8h")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for SSH CA settings: %v", err)
+		}
+		if cfg.SSHCAKeyFile != "/tmp/ssh_ca" {
+			t.Errorf("Expected SSHCAKeyFile '/tmp/ssh_ca', got '%s'", cfg.SSHCAKeyFile)
+		}
+		if cfg.SSHCertMaxTTL != 8*time.Hour {
+			t.Errorf("Expected SSHCertMaxTTL 8h, got %s", cfg.SSHCertMaxTTL)
+		}
+	})
+
+	// Test case 11: Invalid SSH_CERT_MAX_TTL
+	t.Run("Invalid SSH_CERT_MAX_TTL", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("SSH_CERT_MAX_TTL", "forever")
+		_, err := config.NewConfig()
+		if err == nil {
+			t.Error("Expected an error for invalid SSH_CERT_MAX_TTL, got nil")
+		}
+	})
+
+	// Test case 12: Authentication settings
+	t.Run("Custom Auth Settings", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("AUTH_API_KEYS_FILE", "/etc/key-server/auth/api_keys.json")
+		os.Setenv("AUTH_JWKS_FILE", "/etc/key-server/auth/jwks.json")
+		os.Setenv("AUTH_JWT_AUDIENCE", "key-server")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for auth settings: %v", err)
+		}
+		if cfg.APIKeysFile != "/etc/key-server/auth/api_keys.json" || cfg.JWKSFile != "/etc/key-server/auth/jwks.json" {
+			t.Errorf("Unexpected auth files: '%s', '%s'", cfg.APIKeysFile, cfg.JWKSFile)
+		}
+		if cfg.JWTAudience != "key-server" || cfg.JWTIssuer != "" {
+			t.Errorf("Unexpected JWT issuer/audience: '%s', '%s'", cfg.JWTIssuer, cfg.JWTAudience)
+		}
+	})
+
+	// Test case 13: Policy file
+	t.Run("Custom POLICY_FILE", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("POLICY_FILE", "/etc/key-server/policy.json")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for POLICY_FILE: %v", err)
+		}
+		if cfg.PolicyFile != "/etc/key-server/policy.json" {
+			t.Errorf("Expected PolicyFile '/etc/key-server/policy.json', got '%s'", cfg.PolicyFile)
+		}
+	})
+
+	// Test case 14: Rate limits
+	t.Run("Custom RATE_LIMITS", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("RATE_LIMITS", `[{"route": "/key/{length}", "requests_per_second": 2.5, "daily_bytes": 4096}, {"route": "/ssh/sign", "key_by": "ip", "burst": 3}, {"route": "*", "requests_per_second": 20}]`)
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for RATE_LIMITS: %v", err)
+		}
+		if len(cfg.RateLimits) != 3 {
+			t.Fatalf("Expected 3 rate limits, got %d", len(cfg.RateLimits))
+		}
+		keyLimit := cfg.RateLimits[0]
+		if keyLimit.KeyBy != config.RateLimitByPrincipal || keyLimit.Burst != 3 || keyLimit.DailyBytes != 4096 {
+			t.Errorf("Unexpected defaults for /key/{length} limit: %+v", keyLimit)
+		}
+		if cfg.RateLimits[1].KeyBy != config.RateLimitByIP {
+			t.Errorf("Expected key_by 'ip', got '%s'", cfg.RateLimits[1].KeyBy)
+		}
+		if all := cfg.RateLimits[2]; all.KeyBy != config.RateLimitByIP || all.Burst != 20 {
+			t.Errorf("Unexpected defaults for * limit: %+v", all)
+		}
+	})
+
+	// Test case 15: Invalid RATE_LIMITS
+	t.Run("Invalid RATE_LIMITS", func(t *testing.T) {
+		for _, raw := range []string{
+			`not json`,
+			`[{"requests_per_second": 1}]`,
+			`[{"route": "/key/{length}", "key_by": "cookie"}]`,
+			`[{"route": "/key/{length}", "daily_keys": -1}]`,
+			`[{"route": "/key/{length}"}, {"route": "/key/{length}"}]`,
+			`[{"route": "*", "key_by": "principal"}]`,
+			`[{"route": "*", "daily_keys": 100}]`,
+		} {
+			clearEnv()
+			os.Setenv("RATE_LIMITS", raw)
+			if _, err := config.NewConfig(); err == nil {
+				t.Errorf("Expected an error for RATE_LIMITS %s, got nil", raw)
+			}
+		}
+	})
+
+	// Test case 15a: Crypto periods
+	t.Run("Custom CRYPTO_PERIODS", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("CRYPTO_PERIODS", `[{"key_type": "aes-256-gcm", "max_active_period": "2160h", "max_usage_period": "8760h"}, {"key_type": "*", "max_active_period": "720h"}]`)
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for CRYPTO_PERIODS: %v", err)
+		}
+		if p := cfg.CryptoPeriod("aes-256-gcm"); p.MaxActivePeriod != 2160*time.Hour || p.MaxUsagePeriod != 8760*time.Hour {
+			t.Errorf("Unexpected aes-256-gcm crypto period: %+v", p)
+		}
+		if p := cfg.CryptoPeriod("ed25519"); p.KeyType != "*" || p.MaxActivePeriod != 720*time.Hour {
+			t.Errorf("Expected the \"*\" crypto period for ed25519, got %+v", p)
+		}
+
+		for _, raw := range []string{
+			`[{"key_type": "des", "max_active_period": "1h"}]`,
+			`[{"key_type": "ed25519", "max_active_period": "soon"}]`,
+			`[{"key_type": "ed25519", "max_active_period": "-1h"}]`,
+			`[{"key_type": "ed25519", "max_active_period": "2h", "max_usage_period": "1h"}]`,
+			`[{"key_type": "*"}, {"key_type": "*"}]`,
+		} {
+			os.Setenv("CRYPTO_PERIODS", raw)
+			if _, err := config.NewConfig(); err == nil {
+				t.Errorf("Expected an error for CRYPTO_PERIODS %s, got nil", raw)
+			}
+		}
+	})
+
+	// Test case 15b: Usage limits
+	t.Run("Custom USAGE_LIMITS", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("USAGE_LIMITS", `[{"key_type": "aes-256-gcm", "max_operations": 1000000, "max_bytes": 68719476736}, {"key_type": "*", "max_operations": 500, "on_limit": "refuse"}]`)
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for USAGE_LIMITS: %v", err)
+		}
+		if l := cfg.UsageLimit("aes-256-gcm"); l.MaxOperations != 1000000 || l.MaxBytes != 1<<36 || l.OnLimit != "" {
+			t.Errorf("Unexpected aes-256-gcm usage limit: %+v", l)
+		}
+		if l := cfg.UsageLimit("hmac-sha256"); l.KeyType != "*" || l.MaxOperations != 500 || l.OnLimit != "refuse" {
+			t.Errorf("Expected the \"*\" usage limit for hmac-sha256, got %+v", l)
+		}
+
+		for _, raw := range []string{
+			`[{"key_type": "des", "max_operations": 1}]`,
+			`[{"key_type": "ed25519", "max_operations": -1}]`,
+			`[{"key_type": "ed25519", "max_bytes": 1, "on_limit": "rotate"}]`,
+			`[{"key_type": "*"}, {"key_type": "*"}]`,
+		} {
+			os.Setenv("USAGE_LIMITS", raw)
+			if _, err := config.NewConfig(); err == nil {
+				t.Errorf("Expected an error for USAGE_LIMITS %s, got nil", raw)
+			}
+		}
+	})
+
+	// Test case 16: Audit sinks
+	t.Run("Custom Audit Settings", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("AUDIT_LOG_FILE", "/var/log/key-server/audit.jsonl")
+		os.Setenv("AUDIT_STDOUT", "true")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for audit settings: %v", err)
+		}
+		if cfg.AuditLogFile != "/var/log/key-server/audit.jsonl" || !cfg.AuditStdout || cfg.AuditSyslogSocket != "" {
+			t.Errorf("Unexpected audit settings: '%s', %v, '%s'", cfg.AuditLogFile, cfg.AuditStdout, cfg.AuditSyslogSocket)
+		}
+
+		os.Setenv("AUDIT_STDOUT", "sometimes")
+		if _, err := config.NewConfig(); err == nil {
+			t.Error("Expected an error for invalid AUDIT_STDOUT, got nil")
+		}
+	})
+
+	// Test case 17: Transparency log
+	t.Run("Custom Transparency Settings", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("TRANSPARENCY_LOG_FILE", "/var/lib/key-server/transparency.jsonl")
+		os.Setenv("TRANSPARENCY_SIGNING_KEY_FILE", "/etc/key-server/transparency.key")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for transparency settings: %v", err)
+		}
+		if cfg.TransparencyLogFile != "/var/lib/key-server/transparency.jsonl" || cfg.TransparencySigningKeyFile != "/etc/key-server/transparency.key" {
+			t.Errorf("Unexpected transparency settings: '%s', '%s'", cfg.TransparencyLogFile, cfg.TransparencySigningKeyFile)
+		}
+	})
+
+	// Test case 18: Logging
+	t.Run("Custom Logging Settings", func(t *testing.T) {
+		clearEnv()
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for default logging settings: %v", err)
+		}
+		if cfg.LogLevel != slog.LevelInfo || cfg.LogFormat != "text" {
+			t.Errorf("Expected default logging info/text, got %s/%s", cfg.LogLevel, cfg.LogFormat)
+		}
+
+		os.Setenv("LOG_LEVEL", "DEBUG")
+		os.Setenv("LOG_FORMAT", "json")
+		cfg, err = config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for logging settings: %v", err)
+		}
+		if cfg.LogLevel != slog.LevelDebug || cfg.LogFormat != "json" {
+			t.Errorf("Expected logging debug/json, got %s/%s", cfg.LogLevel, cfg.LogFormat)
+		}
+
+		os.Setenv("LOG_LEVEL", "verbose")
+		if _, err := config.NewConfig(); err == nil {
+			t.Error("Expected an error for invalid LOG_LEVEL, got nil")
+		}
+		os.Setenv("LOG_LEVEL", "info")
+		os.Setenv("LOG_FORMAT", "xml")
+		if _, err := config.NewConfig(); err == nil {
+			t.Error("Expected an error for invalid LOG_FORMAT, got nil")
+		}
+	})
+
+	// Test case 19: Tracing
+	t.Run("Custom Tracing Settings", func(t *testing.T) {
+		clearEnv()
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for default tracing settings: %v", err)
+		}
+		if cfg.TracingExporter != "" || cfg.TracingOTLPEndpoint != "localhost:4318" || cfg.TracingSampleRatio != 1 {
+			t.Errorf("Unexpected default tracing settings: '%s', '%s', %v", cfg.TracingExporter, cfg.TracingOTLPEndpoint, cfg.TracingSampleRatio)
+		}
+
+		os.Setenv("TRACING_EXPORTER", "otlp")
+		os.Setenv("TRACING_OTLP_ENDPOINT", "otel-collector:4318")
+		os.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+		cfg, err = config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for tracing settings: %v", err)
+		}
+		if cfg.TracingExporter != config.TracingExporterOTLP || cfg.TracingOTLPEndpoint != "otel-collector:4318" || cfg.TracingSampleRatio != 0.25 {
+			t.Errorf("Unexpected tracing settings: '%s', '%s', %v", cfg.TracingExporter, cfg.TracingOTLPEndpoint, cfg.TracingSampleRatio)
+		}
+
+		for _, env := range [][2]string{{"TRACING_EXPORTER", "jaeger"}, {"TRACING_SAMPLE_RATIO", "1.5"}} {
+			clearEnv()
+			os.Setenv(env[0], env[1])
+			if _, err := config.NewConfig(); err == nil {
+				t.Errorf("Expected an error for %s=%s, got nil", env[0], env[1])
+			}
+		}
+	})
+
+	// Test case 20: Metrics length classes
+	t.Run("Custom METRICS_LENGTH_CLASSES", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("METRICS_LENGTH_CLASSES", "32, 128,1024")
+		cfg, err := config.NewConfig()
+		if err != nil {
+			t.Fatalf("NewConfig returned an error for METRICS_LENGTH_CLASSES: %v", err)
+		}
+		if len(cfg.MetricsLengthClasses) != 3 || cfg.MetricsLengthClasses[0] != 32 || cfg.MetricsLengthClasses[2] != 1024 {
+			t.Errorf("Unexpected MetricsLengthClasses: %v", cfg.MetricsLengthClasses)
+		}
+
+		for _, raw := range []string{"32,abc", "64,32", "0,16", "16,16"} {
+			os.Setenv("METRICS_LENGTH_CLASSES", raw)
+			if _, err := config.NewConfig(); err == nil {
+				t.Errorf("Expected an error for METRICS_LENGTH_CLASSES %q, got nil", raw)
+			}
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	for _, env := range []string{
+		"CONFIG_FILE", "PORT", "MAX_KEY_SIZE", "TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_MIN_VERSION",
+		"CA_CERT_FILE", "CA_KEY_FILE", "ACME_ALLOWED_DOMAINS", "SSH_CA_KEY_FILE", "SSH_CERT_MAX_TTL",
+		"AUTH_API_KEYS_FILE", "AUTH_JWKS_FILE", "AUTH_JWT_ISSUER", "AUTH_JWT_AUDIENCE", "POLICY_FILE",
+		"RATE_LIMITS", "CRYPTO_PERIODS", "USAGE_LIMITS", "AUDIT_LOG_FILE", "AUDIT_SYSLOG_SOCKET", "AUDIT_STDOUT", "TRANSPARENCY_LOG_FILE",
+		"TRANSPARENCY_SIGNING_KEY_FILE", "LOG_LEVEL", "LOG_FORMAT", "TRACING_EXPORTER",
+		"TRACING_OTLP_ENDPOINT", "TRACING_SAMPLE_RATIO", "METRICS_LENGTH_CLASSES",
+	} {
+		t.Setenv(env, "") // Empty variables are treated as unset
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile := func(t *testing.T, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, `
+port: "9000"
+max_key_size: 2048
+log_level: debug
+ssh_cert_max_ttl: 8h
+rate_limits:
+  - route: /key/{length}
+    requests_per_second: 1.5
+crypto_periods:
+  - key_type: ed25519
+    max_active_period: 720h
+usage_limits:
+  - key_type: aes-128-gcm
+    max_bytes: 1073741824
+    on_limit: refuse
+`)
+
+	t.Run("Precedence", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", path)
+		t.Setenv("MAX_KEY_SIZE", "4096")
+		t.Setenv("LOG_FORMAT", "json")
+		cfg, err := config.Load([]string{"-max-key-size", "512", "-audit-stdout"})
+		if err != nil {
+			t.Fatalf("Load returned an error: %v", err)
+		}
+		if cfg.Port != "9000" || cfg.LogLevel != slog.LevelDebug || cfg.SSHCertMaxTTL != 8*time.Hour {
+			t.Errorf("file settings not applied: port=%s level=%s ttl=%s", cfg.Port, cfg.LogLevel, cfg.SSHCertMaxTTL)
+		}
+		if cfg.LogFormat != "json" {
+			t.Errorf("LogFormat = %q, want the environment value", cfg.LogFormat)
+		}
+		if cfg.MaxSize != 512 || !cfg.AuditStdout {
+			t.Errorf("flags not applied: MaxSize=%d AuditStdout=%v", cfg.MaxSize, cfg.AuditStdout)
+		}
+		if cfg.CertFile != "/etc/key-server/tls/server.crt" || cfg.TracingOTLPEndpoint != "localhost:4318" {
+			t.Errorf("defaults not kept for unset settings: %s, %s", cfg.CertFile, cfg.TracingOTLPEndpoint)
+		}
+		if len(cfg.RateLimits) != 1 || cfg.RateLimits[0].KeyBy != config.RateLimitByPrincipal || cfg.RateLimits[0].Burst != 2 {
+			t.Errorf("rate limits not decoded with defaults: %+v", cfg.RateLimits)
+		}
+		if p := cfg.CryptoPeriod("ed25519"); p.MaxActivePeriod != 720*time.Hour {
+			t.Errorf("crypto periods not decoded: %+v", cfg.CryptoPeriods)
+		}
+		if l := cfg.UsageLimit("aes-128-gcm"); l.MaxBytes != 1<<30 || l.OnLimit != "refuse" {
+			t.Errorf("usage limits not decoded: %+v", cfg.UsageLimits)
+		}
+	})
+
+	t.Run("Config flag overrides CONFIG_FILE", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+		cfg, err := config.Load([]string{"-config", path})
+		if err != nil {
+			t.Fatalf("Load returned an error: %v", err)
+		}
+		if cfg.Port != "9000" {
+			t.Errorf("Port = %q, want the value from the -config file", cfg.Port)
+		}
+	})
+
+	t.Run("Aggregated errors", func(t *testing.T) {
+		t.Setenv("PORT", "70000")
+		t.Setenv("TRACING_SAMPLE_RATIO", "abc")
+		t.Setenv("TLS_KEY_FILE", "")
+		_, err := config.Load([]string{"-log-format", "xml", "-tls-min-version", "1.1", "-max-key-size", "0", "-server-shutdown-delay", "-1s", "-idempotency-ttl", "-1h"})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		for _, want := range []string{"port:", "TRACING_SAMPLE_RATIO", "log_format:", "tls_min_version:", "max_key_size:", "server_shutdown_delay:", "idempotency_ttl:"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error does not mention %s:\n%v", want, err)
+			}
+		}
+	})
+
+	t.Run("Unknown file key", func(t *testing.T) {
+		writeFile(t, "prot: 9000\n")
+		if _, err := config.Load([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), "prot") {
+			t.Errorf("expected an error naming the unknown key, got %v", err)
+		}
+	})
+
+	t.Run("Usage errors", func(t *testing.T) {
+		for _, args := range [][]string{{"-no-such-flag"}, {"extra"}, {"-h"}} {
+			if _, err := config.Load(args); !errors.Is(err, config.ErrUsage) {
+				t.Errorf("Load(%q) error = %v, want ErrUsage", args, err)
+			}
+		}
+	})
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.CAKeyFile = "/etc/key-server/ca.key"
+	cfg.PolicyFile = "/etc/key-server/policy.json"
+
+	r := cfg.Redacted()
+	if r.KeyFile != config.RedactedValue || r.CAKeyFile != config.RedactedValue {
+		t.Errorf("private key paths not redacted: %q, %q", r.KeyFile, r.CAKeyFile)
+	}
+	if r.SSHCAKeyFile != "" {
+		t.Errorf("unset SSHCAKeyFile = %q, want empty", r.SSHCAKeyFile)
+	}
+	if r.PolicyFile != cfg.PolicyFile || r.CertFile != cfg.CertFile {
+		t.Errorf("non-secret settings changed: %q, %q", r.PolicyFile, r.CertFile)
+	}
+	if cfg.KeyFile == config.RedactedValue {
+		t.Error("Redacted modified the original config")
+	}
+}
+
+func TestConfig_CheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "server.crt")
+	if err := os.WriteFile(cert, []byte("cert"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Defaults()
+	cfg.CertFile = cert
+	cfg.KeyFile = filepath.Join(dir, "server.key")
+	cfg.AuditLogFile = filepath.Join(dir, "audit.jsonl")
+	cfg.TransparencyLogFile = filepath.Join(dir, "missing", "log.jsonl")
+
+	err := cfg.CheckFiles()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "tls_key_file") || !strings.Contains(msg, "transparency_log_file") {
+		t.Errorf("error does not name the missing files:\n%s", msg)
+	}
+	if strings.Contains(msg, "tls_cert_file") || strings.Contains(msg, "audit_log_file") {
+		t.Errorf("error names usable files:\n%s", msg)
+	}
+}
+
+func TestConfig_ApplyReloadable(t *testing.T) {
+	current := config.Defaults()
+	current.File = "/etc/key-server/config.yaml"
+
+	next := config.Defaults()
+	next.MaxSize = 4096
+	next.LogLevel = slog.LevelDebug
+	next.RateLimits = []config.RateLimit{{Route: "/key/{length}", RequestsPerSecond: 1}}
+	next.Port = "9000"
+	next.AuditStdout = true
+
+	merged, ignored := current.ApplyReloadable(next)
+	if merged.MaxSize != 4096 || merged.LogLevel != slog.LevelDebug || len(merged.RateLimits) != 1 {
+		t.Errorf("reloadable settings not applied: %+v", merged)
+	}
+	if merged.Port != current.Port || merged.AuditStdout || merged.File != current.File {
+		t.Errorf("restart-only settings changed: port=%s audit_stdout=%v file=%q", merged.Port, merged.AuditStdout, merged.File)
+	}
+	if strings.Join(ignored, ",") != "port,audit_stdout" {
+		t.Errorf("ignored = %v, want [port audit_stdout]", ignored)
+	}
+	if next.Port != "9000" {
+		t.Error("ApplyReloadable modified its argument")
+	}
+
+	store := config.NewStore(current)
+	if old := store.Swap(merged); old != current || store.Current() != merged {
+		t.Error("Store.Swap did not replace the snapshot")
+	}
 }
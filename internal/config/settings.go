@@ -0,0 +1,239 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds one configuration value to its environment variable and
+// command-line flag. Its key is the field's YAML key; the flag name is the
+// key with dashes instead of underscores.
+type setting struct {
+	key   string
+	env   string
+	usage string
+	bool  bool // Flag may be given without a value
+	set   func(c *Config, value string) error
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+// settings lists every value that can be set from the environment or flags,
+// in the order of the Config fields.
+var settings = []setting{
+	// --- Reload Configuration ---
+	{key: "config_watch_interval", env: "CONFIG_WATCH_INTERVAL", usage: "How often the config file is checked for changes (0 = only reload on SIGHUP)", set: durationField(func(c *Config) *time.Duration { return &c.ConfigWatchInterval })},
+
+	// --- Server Configuration ---
+	{key: "port", env: "PORT", usage: "Port for the HTTPS server to listen on", set: stringField(func(c *Config) *string { return &c.Port })},
+	{key: "max_key_size", env: "MAX_KEY_SIZE", usage: "Maximum key size in bytes", set: intField(func(c *Config) *int { return &c.MaxSize })},
+
+	// --- TLS Configuration ---
+	{key: "tls_cert_file", env: "TLS_CERT_FILE", usage: "Path to the TLS certificate", set: stringField(func(c *Config) *string { return &c.CertFile })},
+	{key: "tls_key_file", env: "TLS_KEY_FILE", usage: "Path to the TLS private key", set: stringField(func(c *Config) *string { return &c.KeyFile })},
+	{key: "tls_min_version", env: "TLS_MIN_VERSION", usage: "Oldest TLS version accepted (1.2 or 1.3)", set: stringField(func(c *Config) *string { return &c.TLSMinVersion })},
+
+	// --- Listener Configuration ---
+	{key: "listeners", env: "LISTENERS", usage: "Comma-separated listen addresses: [tcp://]host:port, unix:///path or systemd[:name]", set: listField(func(c *Config) *[]string { return &c.Listeners })},
+	{key: "admin_address", env: "ADMIN_ADDRESS", usage: "Admin listener for metrics, health, pprof and build info, e.g. 127.0.0.1:9090", set: stringField(func(c *Config) *string { return &c.AdminAddress })},
+	{key: "unix_socket_mode", env: "UNIX_SOCKET_MODE", usage: "Octal permissions of created Unix sockets", set: stringField(func(c *Config) *string { return &c.UnixSocketMode })},
+
+	// --- HTTP Server Configuration ---
+	{key: "server_read_timeout", env: "SERVER_READ_TIMEOUT", usage: "Time to read a whole request (0 = none)", set: durationField(func(c *Config) *time.Duration { return &c.ServerReadTimeout })},
+	{key: "server_read_header_timeout", env: "SERVER_READ_HEADER_TIMEOUT", usage: "Time to read request headers", set: durationField(func(c *Config) *time.Duration { return &c.ServerReadHeaderTimeout })},
+	{key: "server_write_timeout", env: "SERVER_WRITE_TIMEOUT", usage: "Time to write a response (0 = none)", set: durationField(func(c *Config) *time.Duration { return &c.ServerWriteTimeout })},
+	{key: "server_idle_timeout", env: "SERVER_IDLE_TIMEOUT", usage: "How long idle keep-alive connections are kept open", set: durationField(func(c *Config) *time.Duration { return &c.ServerIdleTimeout })},
+	{key: "server_max_header_bytes", env: "SERVER_MAX_HEADER_BYTES", usage: "Largest request header block accepted", set: intField(func(c *Config) *int { return &c.ServerMaxHeaderBytes })},
+	{key: "server_keep_alives", env: "SERVER_KEEP_ALIVES", usage: "Reuse connections for several requests", bool: true, set: boolField(func(c *Config) *bool { return &c.ServerKeepAlives })},
+	{key: "server_shutdown_timeout", env: "SERVER_SHUTDOWN_TIMEOUT", usage: "How long in-flight requests may take to finish at shutdown", set: durationField(func(c *Config) *time.Duration { return &c.ServerShutdownTimeout })},
+	{key: "server_shutdown_delay", env: "SERVER_SHUTDOWN_DELAY", usage: "How long to keep serving after reporting unready at shutdown, so load balancers stop routing first", set: durationField(func(c *Config) *time.Duration { return &c.ServerShutdownDelay })},
+
+	// --- Internal CA / ACME Configuration ---
+	{key: "ca_cert_file", env: "CA_CERT_FILE", usage: "Path to the internal CA certificate", set: stringField(func(c *Config) *string { return &c.CACertFile })},
+	{key: "ca_key_file", env: "CA_KEY_FILE", usage: "Path to the internal CA private key", set: stringField(func(c *Config) *string { return &c.CAKeyFile })},
+	{key: "acme_allowed_domains", env: "ACME_ALLOWED_DOMAINS", usage: "Comma-separated DNS suffixes ACME may issue for", set: listField(func(c *Config) *[]string { return &c.ACMEAllowedDomains })},
+
+	// --- SSH CA Configuration ---
+	{key: "ssh_ca_key_file", env: "SSH_CA_KEY_FILE", usage: "Path to the SSH CA private key", set: stringField(func(c *Config) *string { return &c.SSHCAKeyFile })},
+	{key: "ssh_cert_max_ttl", env: "SSH_CERT_MAX_TTL", usage: "Longest SSH certificate validity", set: durationField(func(c *Config) *time.Duration { return &c.SSHCertMaxTTL })},
+
+	// --- Authentication Configuration ---
+	{key: "auth_api_keys_file", env: "AUTH_API_KEYS_FILE", usage: "Path to the hashed API keys file", set: stringField(func(c *Config) *string { return &c.APIKeysFile })},
+	{key: "auth_jwks_file", env: "AUTH_JWKS_FILE", usage: "Path to the JWKS used to validate bearer tokens", set: stringField(func(c *Config) *string { return &c.JWKSFile })},
+	{key: "auth_jwt_issuer", env: "AUTH_JWT_ISSUER", usage: "Required bearer token issuer", set: stringField(func(c *Config) *string { return &c.JWTIssuer })},
+	{key: "auth_jwt_audience", env: "AUTH_JWT_AUDIENCE", usage: "Required bearer token audience", set: stringField(func(c *Config) *string { return &c.JWTAudience })},
+
+	// --- Authorization Policy Configuration ---
+	{key: "policy_file", env: "POLICY_FILE", usage: "Path to the authorization policy", set: stringField(func(c *Config) *string { return &c.PolicyFile })},
+
+	// --- Rate Limit Configuration ---
+	{key: "rate_limits", env: "RATE_LIMITS", usage: "JSON array of per-route rate limits", set: func(c *Config, value string) error {
+		var limits []RateLimit
+		if err := json.Unmarshal([]byte(value), &limits); err != nil {
+			return err
+		}
+		c.RateLimits = limits
+		return nil
+	}},
+
+	// --- Named Key Configuration ---
+	{key: "crypto_periods", env: "CRYPTO_PERIODS", usage: "JSON array of per-algorithm crypto periods for named keys", set: func(c *Config, value string) error {
+		var periods []CryptoPeriod
+		if err := json.Unmarshal([]byte(value), &periods); err != nil {
+			return err
+		}
+		c.CryptoPeriods = periods
+		return nil
+	}},
+	{key: "usage_limits", env: "USAGE_LIMITS", usage: "JSON array of per-algorithm usage limits for named keys", set: func(c *Config, value string) error {
+		var limits []UsageLimit
+		if err := json.Unmarshal([]byte(value), &limits); err != nil {
+			return err
+		}
+		c.UsageLimits = limits
+		return nil
+	}},
+	{key: "key_state_check_interval", env: "KEY_STATE_CHECK_INTERVAL", usage: "How often named key states are updated", set: durationField(func(c *Config) *time.Duration { return &c.KeyStateCheckInterval })},
+
+	// --- Idempotency Configuration ---
+	{key: "idempotency_ttl", env: "IDEMPOTENCY_TTL", usage: "How long responses to requests with an Idempotency-Key are kept for replay (0 = disabled)", set: durationField(func(c *Config) *time.Duration { return &c.IdempotencyTTL })},
+	{key: "idempotency_max_bytes", env: "IDEMPOTENCY_MAX_BYTES", usage: "Memory for kept idempotent responses", set: intField(func(c *Config) *int { return &c.IdempotencyMaxBytes })},
+
+	// --- Audit Log Configuration ---
+	{key: "audit_log_file", env: "AUDIT_LOG_FILE", usage: "Path to the audit log", set: stringField(func(c *Config) *string { return &c.AuditLogFile })},
+	{key: "audit_syslog_socket", env: "AUDIT_SYSLOG_SOCKET", usage: "Syslog socket to forward audit entries to", set: stringField(func(c *Config) *string { return &c.AuditSyslogSocket })},
+	{key: "audit_stdout", env: "AUDIT_STDOUT", usage: "Also write audit entries to standard output", bool: true, set: boolField(func(c *Config) *bool { return &c.AuditStdout })},
+
+	// --- Transparency Log Configuration ---
+	{key: "transparency_log_file", env: "TRANSPARENCY_LOG_FILE", usage: "Path to the transparency log", set: stringField(func(c *Config) *string { return &c.TransparencyLogFile })},
+	{key: "transparency_signing_key_file", env: "TRANSPARENCY_SIGNING_KEY_FILE", usage: "Path to the tree head signing key", set: stringField(func(c *Config) *string { return &c.TransparencySigningKeyFile })},
+
+	// --- Logging Configuration ---
+	{key: "log_level", env: "LOG_LEVEL", usage: "Minimum log level (debug, info, warn or error)", set: func(c *Config, value string) error {
+		return c.LogLevel.UnmarshalText([]byte(value))
+	}},
+	{key: "log_format", env: "LOG_FORMAT", usage: "Log format (text or json)", set: stringField(func(c *Config) *string { return &c.LogFormat })},
+
+	// --- Tracing Configuration ---
+	{key: "tracing_exporter", env: "TRACING_EXPORTER", usage: "Span exporter (otlp or stdout)", set: stringField(func(c *Config) *string { return &c.TracingExporter })},
+	{key: "tracing_otlp_endpoint", env: "TRACING_OTLP_ENDPOINT", usage: "host:port of the OTLP/HTTP collector", set: stringField(func(c *Config) *string { return &c.TracingOTLPEndpoint })},
+	{key: "tracing_sample_ratio", env: "TRACING_SAMPLE_RATIO", usage: "Fraction of new traces sampled", set: floatField(func(c *Config) *float64 { return &c.TracingSampleRatio })},
+
+	// --- Metrics Configuration ---
+	{key: "metrics_length_classes", env: "METRICS_LENGTH_CLASSES", usage: "Comma-separated upper bounds of key length metric classes", set: func(c *Config, value string) error {
+		var classes []int
+		for _, field := range strings.Split(value, ",") {
+			bound, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return err
+			}
+			classes = append(classes, bound)
+		}
+		c.MetricsLengthClasses = classes
+		return nil
+	}},
+}
+
+// flagValue is a flag given on the command line, applied after the
+// environment so that flags take precedence.
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// registerFlags defines a flag for every setting on fs and returns the list
+// the parsed values are collected in.
+func registerFlags(fs *flag.FlagSet) *[]flagValue {
+	values := new([]flagValue)
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			*values = append(*values, flagValue{setting: s, value: value})
+			return nil
+		}
+		usage := s.usage + " (" + s.env + ")"
+		if s.bool {
+			fs.Var(boolFlag(record), s.flagName(), usage)
+		} else {
+			fs.Func(s.flagName(), usage, record)
+		}
+	}
+	return values
+}
+
+// boolFlag lets a boolean setting be given as -name or -name=value.
+type boolFlag func(string) error
+
+func (f boolFlag) String() string         { return "" }
+func (f boolFlag) Set(value string) error { return f(value) }
+func (f boolFlag) IsBoolFlag() bool       { return true }
+
+func stringField(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intField(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatField(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolField(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationField(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// listField parses a comma-separated list, dropping empty entries.
+func listField(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// reloadable lists the settings, by YAML key, that take effect without a
+// restart. Everything else is read once at startup.
+var reloadable = map[string]bool{
+	"max_key_size":   true,
+	"rate_limits":    true,
+	"crypto_periods": true,
+	"usage_limits":   true,
+	"policy_file":    true,
+	"log_level":      true,
+	"tls_cert_file":  true,
+	"tls_key_file":   true,
+}
+
+// Store holds the current configuration snapshot. Readers should call Current
+// once per operation and use that snapshot throughout, so that a concurrent
+// reload never mixes old and new settings within one request.
+type Store struct {
+	current atomic.Pointer[Config]
+}
+
+// NewStore returns a Store holding cfg.
+func NewStore(cfg *Config) *Store {
+	s := &Store{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Current returns the current snapshot. It must not be modified.
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// Swap replaces the current snapshot with cfg and returns the previous one.
+func (s *Store) Swap(cfg *Config) *Config {
+	return s.current.Swap(cfg)
+}
+
+// ApplyReloadable returns a copy of next in which every setting that only
+// takes effect at startup keeps its value from c, along with the keys of the
+// settings next tried to change that way.
+func (c *Config) ApplyReloadable(next *Config) (*Config, []string) {
+	merged := *next
+	var ignored []string
+
+	cur, out := reflect.ValueOf(c).Elem(), reflect.ValueOf(&merged).Elem()
+	for i := 0; i < out.NumField(); i++ {
+		key, _, _ := strings.Cut(out.Type().Field(i).Tag.Get("yaml"), ",")
+		if reloadable[key] {
+			continue
+		}
+		if !reflect.DeepEqual(cur.Field(i).Interface(), out.Field(i).Interface()) {
+			if key != "-" {
+				ignored = append(ignored, key)
+			}
+			out.Field(i).Set(cur.Field(i))
+		}
+	}
+	return &merged, ignored
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/listener"
+)
+
+// Validate checks the whole configuration and reports every problem found,
+// keyed by the setting's YAML key. It also fills in rate limit defaults.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	// --- Reload ---
+	check(c.ConfigWatchInterval >= 0, "config_watch_interval", "must not be negative, got %s", c.ConfigWatchInterval)
+
+	// --- Server ---
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port >= 1 && port <= 65535, "port", "must be a number between 1 and 65535, got %q", c.Port)
+	check(c.MaxSize >= 1 && c.MaxSize <= MaxKeySizeLimit, "max_key_size", "must be between 1 and %d, got %d", MaxKeySizeLimit, c.MaxSize)
+
+	// --- Listeners ---
+	for _, addr := range c.Listeners {
+		if _, err := listener.Parse(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listeners: %w", err))
+		}
+	}
+	if c.AdminAddress != "" {
+		if _, err := listener.Parse(c.AdminAddress); err != nil {
+			errs = append(errs, fmt.Errorf("admin_address: %w", err))
+		}
+	}
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	check(err == nil && mode <= 0o777, "unix_socket_mode", "must be octal permissions such as 0660, got %q", c.UnixSocketMode)
+
+	// --- HTTP Server ---
+	for _, t := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"server_read_timeout", c.ServerReadTimeout},
+		{"server_read_header_timeout", c.ServerReadHeaderTimeout},
+		{"server_write_timeout", c.ServerWriteTimeout},
+		{"server_idle_timeout", c.ServerIdleTimeout},
+	} {
+		check(t.d >= 0, t.key, "must not be negative, got %s", t.d)
+	}
+	check(c.ServerMaxHeaderBytes >= 1<<10 && c.ServerMaxHeaderBytes <= 16<<20, "server_max_header_bytes", "must be between 1 KiB and 16 MiB, got %d", c.ServerMaxHeaderBytes)
+	check(c.ServerShutdownTimeout > 0, "server_shutdown_timeout", "must be a positive duration, got %s", c.ServerShutdownTimeout)
+	check(c.ServerShutdownDelay >= 0, "server_shutdown_delay", "must not be negative, got %s", c.ServerShutdownDelay)
+
+	// --- TLS ---
+	check((c.CertFile == "") == (c.KeyFile == ""), "tls_cert_file", "tls_cert_file and tls_key_file must be set together")
+	check(c.TLSMinVersion == TLSVersion12 || c.TLSMinVersion == TLSVersion13, "tls_min_version", "must be %q or %q, got %q", TLSVersion12, TLSVersion13, c.TLSMinVersion)
+
+	// --- Internal CA / SSH CA ---
+	check((c.CACertFile == "") == (c.CAKeyFile == ""), "ca_cert_file", "ca_cert_file and ca_key_file must be set together")
+	check(c.SSHCertMaxTTL > 0, "ssh_cert_max_ttl", "must be a positive duration, got %s", c.SSHCertMaxTTL)
+
+	// --- Rate Limits ---
+	if err := validateRateLimits(c.RateLimits); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limits: %w", err))
+	}
+
+	// --- Named Keys ---
+	if err := validateCryptoPeriods(c.CryptoPeriods); err != nil {
+		errs = append(errs, fmt.Errorf("crypto_periods: %w", err))
+	}
+	if err := validateUsageLimits(c.UsageLimits); err != nil {
+		errs = append(errs, fmt.Errorf("usage_limits: %w", err))
+	}
+	check(c.KeyStateCheckInterval > 0, "key_state_check_interval", "must be a positive duration, got %s", c.KeyStateCheckInterval)
+
+	// --- Idempotency ---
+	check(c.IdempotencyTTL >= 0, "idempotency_ttl", "must not be negative, got %s", c.IdempotencyTTL)
+	check(c.IdempotencyMaxBytes >= 1<<10, "idempotency_max_bytes", "must be at least 1 KiB, got %d", c.IdempotencyMaxBytes)
+
+	// --- Logging ---
+	check(c.LogFormat == "text" || c.LogFormat == "json", "log_format", "must be \"text\" or \"json\", got %q", c.LogFormat)
+
+	// --- Tracing ---
+	switch c.TracingExporter {
+	case "", TracingExporterStdout:
+	case TracingExporterOTLP:
+		_, _, err := net.SplitHostPort(c.TracingOTLPEndpoint)
+		check(err == nil, "tracing_otlp_endpoint", "must be host:port, got %q", c.TracingOTLPEndpoint)
+	default:
+		check(false, "tracing_exporter", "must be %q or %q, got %q", TracingExporterOTLP, TracingExporterStdout, c.TracingExporter)
+	}
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "tracing_sample_ratio", "must be between 0 and 1, got %v", c.TracingSampleRatio)
+
+	// --- Metrics ---
+	for i, bound := range c.MetricsLengthClasses {
+		if bound <= 0 || (i > 0 && bound <= c.MetricsLengthClasses[i-1]) {
+			check(false, "metrics_length_classes", "must be strictly increasing positive integers, got %v", c.MetricsLengthClasses)
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckFiles reports configured files that cannot be read, and log files
+// whose directory does not exist. Load does not touch the filesystem, since
+// the server reports these problems itself when it opens the files.
+func (c *Config) CheckFiles() error {
+	var errs []error
+	for _, f := range []struct{ key, path string }{
+		{"tls_cert_file", c.CertFile},
+		{"tls_key_file", c.KeyFile},
+		{"ca_cert_file", c.CACertFile},
+		{"ca_key_file", c.CAKeyFile},
+		{"ssh_ca_key_file", c.SSHCAKeyFile},
+		{"auth_api_keys_file", c.APIKeysFile},
+		{"auth_jwks_file", c.JWKSFile},
+		{"policy_file", c.PolicyFile},
+		{"transparency_signing_key_file", c.TransparencySigningKeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		file, err := os.Open(f.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+			continue
+		}
+		file.Close()
+	}
+
+	// Log files are created on first use.
+	for _, f := range []struct{ key, path string }{
+		{"audit_log_file", c.AuditLogFile},
+		{"transparency_log_file", c.TransparencyLogFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(f.path)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", f.key, filepath.Dir(f.path)))
+		}
+	}
+	return errors.Join(errs...)
+}
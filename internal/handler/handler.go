@@ -2,8 +2,9 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,30 +18,26 @@ import (
 type HTTPHandler struct {
 	keyService keyservice.KeyService
 	metricsSvc metrics.MetricsService
+	logger     *slog.Logger
 }
 
 // NewHTTPHandler creates a new HTTPHandler instance.
-func NewHTTPHandler(ks keyservice.KeyService, ms metrics.MetricsService) *HTTPHandler {
+func NewHTTPHandler(ks keyservice.KeyService, ms metrics.MetricsService, logger *slog.Logger) *HTTPHandler {
 	return &HTTPHandler{
 		keyService: ks,
 		metricsSvc: ms,
+		logger:     logger,
 	}
 }
 
-// HealthCheck handles the /health endpoint.
+// HealthCheck handles the /health (liveness) endpoint. It only shows the
+// process is serving requests and stays cheap; readiness is reported by the
+// health registry on /ready.
 func (h *HTTPHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	h.metricsSvc.IncHTTPStatusCounter(http.StatusOK)
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "Healthy") // No trailing "\n"
 }
 
-// ReadinessCheck handles the /ready endpoint.
-func (h *HTTPHandler) ReadinessCheck(w http.ResponseWriter, r *http.Request) {
-	h.metricsSvc.IncHTTPStatusCounter(http.StatusOK)
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "Ready to serve traffic!") // No trailing "\n"
-}
-
 // GenerateKey handles the /key/{length} endpoint.
 func (h *HTTPHandler) GenerateKey(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -50,23 +47,25 @@ func (h *HTTPHandler) GenerateKey(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// http.Error automatically adds a newline. The string should NOT end with "\n".
 		http.Error(w, "Invalid key length. Must be a positive integer.", http.StatusBadRequest)
-		h.metricsSvc.IncHTTPStatusCounter(http.StatusBadRequest)
 		h.metricsSvc.RecordKeyGeneration(length, false)
 		return
 	}
 
-	key, err := h.keyService.GenerateKey(length)
+	key, err := h.keyService.GenerateKey(r.Context(), length)
 	if err != nil {
+		if errors.Is(err, keyservice.ErrPermissionDenied) {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			h.metricsSvc.RecordKeyGeneration(length, false)
+			return
+		}
 		// http.Error automatically adds a newline. The string should NOT end with "\n".
 		if strings.Contains(err.Error(), "out of allowed range") {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-			h.metricsSvc.IncHTTPStatusCounter(http.StatusBadRequest)
 			h.metricsSvc.RecordKeyGeneration(length, false)
 			return
 		}
 		// http.Error automatically adds a newline. The string should NOT end with "\n".
 		http.Error(w, fmt.Sprintf("Internal server error: Failed to generate key."), http.StatusInternalServerError)
-		h.metricsSvc.IncHTTPStatusCounter(http.StatusInternalServerError)
 		h.metricsSvc.RecordKeyGeneration(length, false)
 		return
 	}
@@ -76,9 +75,9 @@ func (h *HTTPHandler) GenerateKey(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	response := map[string]string{"key": key}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+		// The error is logged without the response, which holds the key.
+		h.logger.ErrorContext(r.Context(), "Error encoding JSON response", "error", err)
 	}
 
-	h.metricsSvc.IncHTTPStatusCounter(http.StatusOK)
 	h.metricsSvc.RecordKeyGeneration(length, true)
 }
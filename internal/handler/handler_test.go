@@ -1,17 +1,21 @@
 package handler_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/bajhalshrey/Key-Server-Application/internal/config"
 	"github.com/bajhalshrey/Key-Server-Application/internal/handler"
 	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice" // Ensure this is imported
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
 	"github.com/gorilla/mux"
 )
 
@@ -21,7 +25,7 @@ type MockKeyService struct {
 }
 
 // GenerateKey implements the keyservice.KeyService interface for the mock.
-func (m *MockKeyService) GenerateKey(length int) (string, error) {
+func (m *MockKeyService) GenerateKey(ctx context.Context, length int) (string, error) {
 	if m.GenerateKeyFunc != nil {
 		return m.GenerateKeyFunc(length)
 	}
@@ -40,16 +44,12 @@ func (m *MockKeyService) GenerateKey(length int) (string, error) {
 
 // MockMetricsService implements metrics.MetricsService for testing.
 type MockMetricsService struct {
-	IncHTTPStatusCounterCalls []int
-	RecordKeyGenerationCalls  []struct {
+	RecordKeyGenerationCalls []struct {
 		Length  int
 		Success bool
 	}
 }
 
-func (m *MockMetricsService) IncHTTPStatusCounter(statusCode int) {
-	m.IncHTTPStatusCounterCalls = append(m.IncHTTPStatusCounterCalls, statusCode)
-}
 func (m *MockMetricsService) RecordKeyGeneration(length int, success bool) {
 	m.RecordKeyGenerationCalls = append(m.RecordKeyGenerationCalls, struct {
 		Length  int
@@ -62,6 +62,7 @@ func (m *MockMetricsService) IncrementInvalidKeyLengthErrors()
 func (m *MockMetricsService) ObserveKeyGenerationDuration(duration float64, length int) {}
 func (m *MockMetricsService) ObserveKeyLength(length float64)                           {}
 func (m *MockMetricsService) IncrementHTTPRequestsTotal(statusCode, path string)        {}
+func (m *MockMetricsService) RecordAuthentication(method, result string)                {}
 
 // MetricsHandler implements the MetricsService interface.
 // It returns a dummy http.Handler for testing purposes.
@@ -77,7 +78,7 @@ func (m *MockMetricsService) MetricsHandler() http.Handler {
 func TestHTTPHandler_HealthCheck(t *testing.T) {
 	mockKeyService := &MockKeyService{}
 	mockMetrics := &MockMetricsService{} // Use mock metrics
-	h := handler.NewHTTPHandler(mockKeyService, mockMetrics)
+	h := handler.NewHTTPHandler(mockKeyService, mockMetrics, logging.Discard())
 
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
@@ -97,10 +98,6 @@ func TestHTTPHandler_HealthCheck(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %q want %q",
 			rr.Body.String(), expected)
 	}
-
-	if len(mockMetrics.IncHTTPStatusCounterCalls) != 1 || mockMetrics.IncHTTPStatusCounterCalls[0] != http.StatusOK {
-		t.Errorf("Expected IncHTTPStatusCounter to be called once with %d, got %v", http.StatusOK, mockMetrics.IncHTTPStatusCounterCalls)
-	}
 }
 
 // TestHTTPHandler_GenerateKey tests the /key/{length} endpoint.
@@ -190,6 +187,17 @@ func TestHTTPHandler_GenerateKey(t *testing.T) {
 			expectRecordKeyGen:  true,
 			recordKeyGenSuccess: false,
 		},
+		{
+			name:      "Policy Denies Key Generation",
+			keyLength: "64",
+			mockGenKeyFunc: func(length int) (string, error) {
+				return "", fmt.Errorf("%w: no rule allows generate for anonymous", keyservice.ErrPermissionDenied)
+			},
+			expectedStatus:      http.StatusForbidden,
+			expectedBody:        "Forbidden: permission denied: no rule allows generate for anonymous\n",
+			expectRecordKeyGen:  true,
+			recordKeyGenSuccess: false,
+		},
 		{
 			name:      "Key Service Internal Error",
 			keyLength: "16",
@@ -207,7 +215,7 @@ func TestHTTPHandler_GenerateKey(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockKeyService := &MockKeyService{GenerateKeyFunc: tt.mockGenKeyFunc}
 			mockMetrics := &MockMetricsService{}
-			h := handler.NewHTTPHandler(mockKeyService, mockMetrics)
+			h := handler.NewHTTPHandler(mockKeyService, mockMetrics, logging.Discard())
 
 			router := mux.NewRouter()
 			router.HandleFunc("/key/{length}", h.GenerateKey).Methods("GET")
@@ -233,9 +241,6 @@ func TestHTTPHandler_GenerateKey(t *testing.T) {
 					tt.name, bodyString, tt.expectedBody)
 			}
 
-			if len(mockMetrics.IncHTTPStatusCounterCalls) != 1 || mockMetrics.IncHTTPStatusCounterCalls[0] != tt.expectedStatus {
-				t.Errorf("Expected IncHTTPStatusCounter to be called once with %d, got %v", tt.expectedStatus, mockMetrics.IncHTTPStatusCounterCalls)
-			}
 			if tt.expectRecordKeyGen {
 				if len(mockMetrics.RecordKeyGenerationCalls) != 1 ||
 					mockMetrics.RecordKeyGenerationCalls[0].Length != func() int {
@@ -254,32 +259,121 @@ func TestHTTPHandler_GenerateKey(t *testing.T) {
 	}
 }
 
-// TestHTTPHandler_ReadinessCheck tests the /ready endpoint.
-func TestHTTPHandler_ReadinessCheck(t *testing.T) {
-	mockKeyService := &MockKeyService{}
-	mockMetrics := &MockMetricsService{}
-	h := handler.NewHTTPHandler(mockKeyService, mockMetrics)
+// MockNamedKeyService answers every operation with Err, or with fixed
+// results when Err is nil.
+type MockNamedKeyService struct {
+	Err     error
+	Created keyservice.CreateKeyRequest
+}
 
-	req, err := http.NewRequest("GET", "/ready", nil)
-	if err != nil {
-		t.Fatalf("Could not create request: %v", err)
+func (m *MockNamedKeyService) CreateKey(ctx context.Context, req keyservice.CreateKeyRequest) (*keystore.Metadata, error) {
+	m.Created = req
+	if m.Err != nil {
+		return nil, m.Err
 	}
+	return &keystore.Metadata{Name: req.Name, Purpose: req.Purpose, Algorithm: keystore.DefaultAlgorithm(req.Purpose)}, nil
+}
 
-	rr := httptest.NewRecorder()
-	h.ReadinessCheck(rr, req)
+func (m *MockNamedKeyService) RotateKey(ctx context.Context, name string) (*keystore.Metadata, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &keystore.Metadata{Name: name, Version: 2}, nil
+}
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+func (m *MockNamedKeyService) GetKey(ctx context.Context, name string) (*keystore.Metadata, error) {
+	if m.Err != nil {
+		return nil, m.Err
 	}
+	return &keystore.Metadata{Name: name}, nil
+}
 
-	expected := "Ready to serve traffic!" // No trailing newline
-	if rr.Body.String() != expected {
-		t.Errorf("handler returned unexpected body: got %q want %q",
-			rr.Body.String(), expected)
+func (m *MockNamedKeyService) ListKeys(ctx context.Context, selector keystore.Selector) ([]keystore.Metadata, error) {
+	return []keystore.Metadata{{Name: "a"}}, m.Err
+}
+
+func (m *MockNamedKeyService) Encrypt(ctx context.Context, name string, plaintext, aad []byte) ([]byte, error) {
+	return []byte("sealed"), m.Err
+}
+
+func (m *MockNamedKeyService) Decrypt(ctx context.Context, name string, ciphertext, aad []byte) ([]byte, error) {
+	return []byte("hello"), m.Err
+}
+
+func (m *MockNamedKeyService) Sign(ctx context.Context, name string, message []byte) ([]byte, error) {
+	return []byte("signed"), m.Err
+}
+
+func (m *MockNamedKeyService) Verify(ctx context.Context, name string, message, signature []byte) (bool, error) {
+	return true, m.Err
+}
+
+func (m *MockNamedKeyService) SetState(ctx context.Context, name, state, reason string) (*keystore.Metadata, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &keystore.Metadata{Name: name, State: state, StateReason: reason}, nil
+}
+
+func (m *MockNamedKeyService) UpdateStates(ctx context.Context) int { return 0 }
+
+// TestKeysHandler tests the /keys endpoints and how service errors map to
+// status codes.
+func TestKeysHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Create", "POST", "/keys", `{"name":"k","purpose":"encrypt"}`, nil, http.StatusCreated, `"algorithm":"aes-256-gcm"`},
+		{"Create with TTL", "POST", "/keys", `{"name":"k","purpose":"mac","ttl":"24h"}`, nil, http.StatusCreated, `"name":"k"`},
+		{"Create with TTL and expiry", "POST", "/keys", `{"name":"k","ttl":"24h","expires_at":"2030-01-01T00:00:00Z"}`, nil, http.StatusBadRequest, "Invalid ttl"},
+		{"Create with unknown field", "POST", "/keys", `{"name":"k","size":32}`, nil, http.StatusBadRequest, "Invalid request body"},
+		{"Create taken name", "POST", "/keys", `{"name":"k","purpose":"mac"}`, keystore.ErrExists, http.StatusConflict, "Conflict"},
+		{"List", "GET", "/keys?selector=env%3Dprod", "", nil, http.StatusOK, `{"keys":[{"name":"a"`},
+		{"List with invalid selector", "GET", "/keys?selector=%3Dprod", "", nil, http.StatusBadRequest, "Invalid selector"},
+		{"Get missing key", "GET", "/keys/k", "", keystore.ErrNotFound, http.StatusNotFound, "Not Found"},
+		{"Encrypt", "POST", "/keys/k/encrypt", `{"plaintext":"aGVsbG8="}`, nil, http.StatusOK, `{"ciphertext":"c2VhbGVk"}`},
+		{"Encrypt with bad base64", "POST", "/keys/k/encrypt", `{"plaintext":"!"}`, nil, http.StatusBadRequest, "Invalid request body"},
+		{"Decrypt failure", "POST", "/keys/k/decrypt", `{"ciphertext":"AA=="}`, keystore.ErrDecrypt, http.StatusBadRequest, "could not be decrypted"},
+		{"Sign with expired key", "POST", "/keys/k/sign", `{"message":"aGVsbG8="}`, keystore.ErrExpired, http.StatusConflict, "expired"},
+		{"Sign with wrong purpose", "POST", "/keys/k/sign", `{"message":"aGVsbG8="}`, keystore.ErrWrongPurpose, http.StatusBadRequest, "purpose"},
+		{"Verify", "POST", "/keys/k/verify", `{"message":"aGVsbG8=","signature":"AA=="}`, nil, http.StatusOK, `{"valid":true}`},
+		{"Verify denied", "POST", "/keys/k/verify", `{}`, keyservice.ErrPermissionDenied, http.StatusForbidden, "Forbidden"},
+		{"Set state", "POST", "/keys/k/state", `{"state":"compromised","reason":"leaked"}`, nil, http.StatusOK, `"state":"compromised"`},
+		{"Rotate", "POST", "/keys/k/rotate", "", nil, http.StatusOK, `"version":2`},
+		{"Rotate pre-active key", "POST", "/keys/k/rotate", "", keystore.ErrState, http.StatusConflict, "Conflict"},
+		{"Set state not allowed", "POST", "/keys/k/state", `{"state":"deactivated"}`, keystore.ErrState, http.StatusConflict, "Conflict"},
+		{"Encrypt with pre-active key", "POST", "/keys/k/encrypt", `{}`, keystore.ErrState, http.StatusConflict, "state"},
+		{"Encrypt past the usage limit", "POST", "/keys/k/encrypt", `{}`, keystore.ErrUsageLimit, http.StatusConflict, "usage limit"},
+		{"Internal error", "POST", "/keys/k/sign", `{}`, errors.New("boom"), http.StatusInternalServerError, "Internal server error"},
 	}
 
-	if len(mockMetrics.IncHTTPStatusCounterCalls) != 1 || mockMetrics.IncHTTPStatusCounterCalls[0] != http.StatusOK {
-		t.Errorf("Expected IncHTTPStatusCounter to be called once with %d, got %v", http.StatusOK, mockMetrics.IncHTTPStatusCounterCalls)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &MockNamedKeyService{Err: tt.err}
+			h := handler.NewKeysHandler(svc, logging.Discard())
+			router := mux.NewRouter()
+			h.RegisterCreate(router)
+			h.RegisterRoutes(router)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d: %s", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("body = %q, want it to contain %q", rr.Body, tt.expectedBody)
+			}
+			if tt.name == "Create with TTL" && svc.Created.ExpiresAt.IsZero() {
+				t.Error("ttl was not turned into an expiry time")
+			}
+		})
 	}
 }
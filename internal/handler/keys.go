@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+)
+
+// maxKeyRequestBytes bounds the size of a named key request body.
+const maxKeyRequestBytes = 1 << 20
+
+// KeysHandler serves the named key API under /keys.
+type KeysHandler struct {
+	keys   keyservice.NamedKeyService
+	logger *slog.Logger
+}
+
+// NewKeysHandler creates a new KeysHandler.
+func NewKeysHandler(ks keyservice.NamedKeyService, logger *slog.Logger) *KeysHandler {
+	return &KeysHandler{keys: ks, logger: logger}
+}
+
+// RegisterRoutes adds the named key routes to r. Creation and rotation are
+// registered separately with RegisterCreate so they can sit behind
+// idempotency handling.
+func (h *KeysHandler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/keys", h.List).Methods("GET")
+	r.HandleFunc("/keys/{name}", h.Get).Methods("GET")
+	r.HandleFunc("/keys/{name}/encrypt", h.Encrypt).Methods("POST")
+	r.HandleFunc("/keys/{name}/decrypt", h.Decrypt).Methods("POST")
+	r.HandleFunc("/keys/{name}/sign", h.Sign).Methods("POST")
+	r.HandleFunc("/keys/{name}/verify", h.Verify).Methods("POST")
+	r.HandleFunc("/keys/{name}/state", h.SetState).Methods("POST")
+}
+
+// RegisterCreate adds the routes that draw new key material to r: key
+// creation and rotation.
+func (h *KeysHandler) RegisterCreate(r *mux.Router) {
+	r.HandleFunc("/keys", h.Create).Methods("POST")
+	r.HandleFunc("/keys/{name}/rotate", h.Rotate).Methods("POST")
+}
+
+// createKeyRequest is the body of POST /keys. ExpiresAt and TTL are
+// alternatives; TTL counts from activation.
+type createKeyRequest struct {
+	Name        string            `json:"name"`
+	Purpose     string            `json:"purpose"`
+	Algorithm   string            `json:"algorithm"`
+	Labels      map[string]string `json:"labels"`
+	ActivatesAt time.Time         `json:"activates_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	TTL         string            `json:"ttl"`
+}
+
+// Create handles POST /keys, responding 201 Created with the new key's
+// metadata.
+func (h *KeysHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	expiresAt := req.ExpiresAt
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || !expiresAt.IsZero() {
+			http.Error(w, "Invalid ttl. Must be a positive duration such as 720h, and not combined with expires_at.", http.StatusBadRequest)
+			return
+		}
+		start := req.ActivatesAt
+		if start.IsZero() {
+			start = time.Now()
+		}
+		expiresAt = start.Add(ttl)
+	}
+
+	meta, err := h.keys.CreateKey(r.Context(), keyservice.CreateKeyRequest{
+		Name:        req.Name,
+		Purpose:     req.Purpose,
+		Algorithm:   req.Algorithm,
+		Labels:      req.Labels,
+		ActivatesAt: req.ActivatesAt,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/keys/"+meta.Name)
+	h.writeJSON(w, r, http.StatusCreated, meta)
+}
+
+// List handles GET /keys, optionally filtered by a label selector such as
+// ?selector=env=prod,team!=billing.
+func (h *KeysHandler) List(w http.ResponseWriter, r *http.Request) {
+	selector, err := keystore.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, "Invalid selector: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	keys, err := h.keys.ListKeys(r.Context(), selector)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// Get handles GET /keys/{name}.
+func (h *KeysHandler) Get(w http.ResponseWriter, r *http.Request) {
+	meta, err := h.keys.GetKey(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, meta)
+}
+
+// Encrypt handles POST /keys/{name}/encrypt. Binary fields are base64.
+func (h *KeysHandler) Encrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Plaintext []byte `json:"plaintext"`
+		AAD       []byte `json:"aad"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	ciphertext, err := h.keys.Encrypt(r.Context(), mux.Vars(r)["name"], req.Plaintext, req.AAD)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, map[string][]byte{"ciphertext": ciphertext})
+}
+
+// Decrypt handles POST /keys/{name}/decrypt.
+func (h *KeysHandler) Decrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ciphertext []byte `json:"ciphertext"`
+		AAD        []byte `json:"aad"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	plaintext, err := h.keys.Decrypt(r.Context(), mux.Vars(r)["name"], req.Ciphertext, req.AAD)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, map[string][]byte{"plaintext": plaintext})
+}
+
+// Sign handles POST /keys/{name}/sign.
+func (h *KeysHandler) Sign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message []byte `json:"message"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	signature, err := h.keys.Sign(r.Context(), mux.Vars(r)["name"], req.Message)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, map[string][]byte{"signature": signature})
+}
+
+// Verify handles POST /keys/{name}/verify. An invalid signature is reported
+// as {"valid": false} with 200 OK.
+func (h *KeysHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message   []byte `json:"message"`
+		Signature []byte `json:"signature"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	valid, err := h.keys.Verify(r.Context(), mux.Vars(r)["name"], req.Message, req.Signature)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, map[string]bool{"valid": valid})
+}
+
+// SetState handles POST /keys/{name}/state, moving a key to the state in
+// {"state": "compromised", "reason": "..."} and returning its metadata.
+func (h *KeysHandler) SetState(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		State  string `json:"state"`
+		Reason string `json:"reason"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	meta, err := h.keys.SetState(r.Context(), mux.Vars(r)["name"], req.State, req.Reason)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, meta)
+}
+
+// Rotate handles POST /keys/{name}/rotate, replacing the key with a new
+// version and returning its metadata.
+func (h *KeysHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	meta, err := h.keys.RotateKey(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, http.StatusOK, meta)
+}
+
+// decodeBody decodes the JSON request body into v, responding 400 Bad
+// Request and returning false when it is not valid.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxKeyRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeError maps a key service error to its HTTP status.
+func (h *KeysHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, keyservice.ErrPermissionDenied):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, keystore.ErrNotFound):
+		http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+	case errors.Is(err, keystore.ErrExists):
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+	case errors.Is(err, keystore.ErrExpired), errors.Is(err, keystore.ErrState), errors.Is(err, keystore.ErrUsageLimit):
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+	case keyservice.IsRejection(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		h.logger.ErrorContext(r.Context(), "Named key operation failed", "error", err)
+		http.Error(w, "Internal server error: Key operation failed.", http.StatusInternalServerError)
+	}
+}
+
+func (h *KeysHandler) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// The error is logged without the response, which may hold secrets.
+		h.logger.ErrorContext(r.Context(), "Error encoding JSON response", "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Generator is the part of keygenerator.CryptoKeyGenerator the self-test uses.
+type Generator interface {
+	Generate(ctx context.Context, length int) ([]byte, error)
+}
+
+// GeneratorCheck is a known-answer-free self-test of the key generator: two
+// 32-byte keys must be produced with the right length and must differ.
+func GeneratorCheck(gen Generator) CheckFunc {
+	return func(ctx context.Context) error {
+		const length = 32
+		a, err := gen.Generate(ctx, length)
+		if err != nil {
+			return fmt.Errorf("generate: %w", err)
+		}
+		b, err := gen.Generate(ctx, length)
+		if err != nil {
+			return fmt.Errorf("generate: %w", err)
+		}
+		if len(a) != length || len(b) != length {
+			return fmt.Errorf("generated %d and %d bytes, want %d", len(a), len(b), length)
+		}
+		if bytes.Equal(a, b) {
+			return errors.New("generator returned the same key twice")
+		}
+		return nil
+	}
+}
+
+// KeyStore is the part of keystore.Store the key store check uses.
+type KeyStore interface {
+	SelfTest() error
+}
+
+// KeyStoreCheck runs the named key store's self-test: the store must be
+// readable and keys of every purpose must round-trip.
+func KeyStoreCheck(store KeyStore) CheckFunc {
+	return func(ctx context.Context) error {
+		return store.SelfTest()
+	}
+}
+
+// entropySampleBytes is the sample size of the FIPS 140-2 monobit test.
+const entropySampleBytes = 2500
+
+// EntropyCheck reads a sample from the system random source (crypto/rand.Reader
+// in production) and applies the FIPS 140-2 monobit test: the 20,000 sampled
+// bits must contain between 9,725 and 10,275 ones. A failing or biased source
+// makes every generated key suspect.
+func EntropyCheck(random io.Reader) CheckFunc {
+	return func(ctx context.Context) error {
+		sample := make([]byte, entropySampleBytes)
+		if _, err := io.ReadFull(random, sample); err != nil {
+			return fmt.Errorf("read random source: %w", err)
+		}
+		ones := 0
+		for _, b := range sample {
+			ones += bits.OnesCount8(b)
+		}
+		if ones < 9725 || ones > 10275 {
+			return fmt.Errorf("random source failed the monobit test (%d ones in %d bits)", ones, entropySampleBytes*8)
+		}
+		return nil
+	}
+}
+
+// CertificateCheck fails when the certificate returned by current is missing,
+// not yet valid or expired, and warns when it expires within warnWithin.
+func CertificateCheck(current func() *tls.Certificate, warnWithin time.Duration) CheckFunc {
+	return func(ctx context.Context) error {
+		cert := current()
+		if cert == nil || len(cert.Certificate) == 0 {
+			return errors.New("no TLS certificate loaded")
+		}
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("parse TLS certificate: %w", err)
+			}
+		}
+
+		now := time.Now()
+		switch {
+		case now.Before(leaf.NotBefore):
+			return fmt.Errorf("TLS certificate is not valid until %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+		case now.After(leaf.NotAfter):
+			return fmt.Errorf("TLS certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+		case leaf.NotAfter.Sub(now) < warnWithin:
+			return Warnf("TLS certificate expires at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}
+}
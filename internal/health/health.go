@@ -0,0 +1,198 @@
+// Package health aggregates the readiness checks that components register
+// into a single /ready report.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check statuses, as in the IETF health check response format.
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn" // Degraded but still able to serve
+	StatusFail = "fail"
+)
+
+// DefaultTimeout bounds how long a single check may run.
+const DefaultTimeout = 2 * time.Second
+
+// warning is a check error reported with StatusWarn.
+type warning struct{ error }
+
+// Warnf returns a check error that reports the component as degraded: the
+// check is reported with StatusWarn and does not make the instance unready.
+func Warnf(format string, args ...any) error {
+	return warning{fmt.Errorf(format, args...)}
+}
+
+// CheckFunc reports a component's health. It should honor ctx's deadline.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the aggregated readiness of the instance. Status is the worst
+// status of any check.
+type Report struct {
+	Status   string            `json:"status"`
+	Draining bool              `json:"draining,omitempty"`
+	Checks   map[string]Result `json:"checks"`
+}
+
+// Registry holds the registered readiness checks.
+type Registry struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]CheckFunc
+}
+
+// NewRegistry returns an empty registry whose checks time out after
+// DefaultTimeout.
+func NewRegistry() *Registry {
+	return &Registry{timeout: DefaultTimeout, checks: make(map[string]CheckFunc)}
+}
+
+// Register adds a named check, replacing any check with the same name.
+func (r *Registry) Register(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// SetDraining marks the instance as shutting down. While draining, the
+// instance is reported unready regardless of its checks, so load balancers
+// stop sending it new requests.
+func (r *Registry) SetDraining(draining bool) {
+	r.draining.Store(draining)
+}
+
+// Draining reports whether SetDraining(true) was called.
+func (r *Registry) Draining() bool {
+	return r.draining.Load()
+}
+
+// Check runs every check concurrently and aggregates the results.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.mu.RUnlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = r.run(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass, Checks: make(map[string]Result, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		report.Status = worst(report.Status, results[i].Status)
+	}
+	if r.Draining() {
+		report.Status, report.Draining = StatusFail, true
+	}
+	return report
+}
+
+// Cached wraps check so that it runs at most once per ttl, for checks too
+// expensive to run on every probe. Concurrent callers wait for the run in
+// progress and share its result. A run cut short by its caller's context is
+// not kept.
+func Cached(check CheckFunc, ttl time.Duration) CheckFunc {
+	var (
+		mu      sync.Mutex
+		err     error
+		checked time.Time
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < ttl {
+			return err
+		}
+		result := check(ctx)
+		if ctx.Err() != nil {
+			return result
+		}
+		err, checked = result, time.Now()
+		return err
+	}
+}
+
+// run executes one check with the registry's timeout.
+func (r *Registry) run(ctx context.Context, check CheckFunc) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{Status: StatusPass, Duration: time.Since(start).String()}
+	switch {
+	case err == nil:
+	case errors.As(err, new(warning)):
+		res.Status, res.Error = StatusWarn, err.Error()
+	default:
+		res.Status, res.Error = StatusFail, err.Error()
+	}
+	return res
+}
+
+// worst returns the more severe of two statuses.
+func worst(a, b string) string {
+	rank := map[string]int{StatusPass: 0, StatusWarn: 1, StatusFail: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// ReadyHandler serves the aggregated Report as JSON: 200 OK when the instance
+// can take traffic (every check passes or warns) and 503 Service Unavailable
+// otherwise.
+func (r *Registry) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	report := r.Check(req.Context())
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/health+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,201 @@
+package health_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/health"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+)
+
+func TestRegistry_ReadyHandler(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	warn := func(context.Context) error { return health.Warnf("pool %d%% full", 95) }
+	fail := func(context.Context) error { return errors.New("sealed") }
+	slow := func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }
+
+	tests := []struct {
+		name       string
+		checks     map[string]health.CheckFunc
+		draining   bool
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{"No checks", nil, false, http.StatusOK, health.StatusPass, map[string]string{}},
+		{"All pass", map[string]health.CheckFunc{"a": pass, "b": pass}, false, http.StatusOK, health.StatusPass,
+			map[string]string{"a": health.StatusPass, "b": health.StatusPass}},
+		{"Warning stays ready", map[string]health.CheckFunc{"a": pass, "pool": warn}, false, http.StatusOK, health.StatusWarn,
+			map[string]string{"a": health.StatusPass, "pool": health.StatusWarn}},
+		{"Failure", map[string]health.CheckFunc{"pool": warn, "seal": fail}, false, http.StatusServiceUnavailable, health.StatusFail,
+			map[string]string{"pool": health.StatusWarn, "seal": health.StatusFail}},
+		{"Timeout", map[string]health.CheckFunc{"store": slow}, false, http.StatusServiceUnavailable, health.StatusFail,
+			map[string]string{"store": health.StatusFail}},
+		{"Draining", map[string]health.CheckFunc{"a": pass}, true, http.StatusServiceUnavailable, health.StatusFail,
+			map[string]string{"a": health.StatusPass}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := health.NewRegistry()
+			for name, check := range tt.checks {
+				r.Register(name, check)
+			}
+			r.SetDraining(tt.draining)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			rr := httptest.NewRecorder()
+			r.ReadyHandler(rr, httptest.NewRequest("GET", "/ready", nil).WithContext(ctx))
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rr.Code, tt.wantCode)
+			}
+			var report health.Report
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatalf("invalid JSON %q: %v", rr.Body.String(), err)
+			}
+			if report.Status != tt.wantStatus || report.Draining != tt.draining {
+				t.Errorf("report status = %q draining = %v, want %q %v", report.Status, report.Draining, tt.wantStatus, tt.draining)
+			}
+			if len(report.Checks) != len(tt.wantChecks) {
+				t.Errorf("report has %d checks, want %d", len(report.Checks), len(tt.wantChecks))
+			}
+			for name, want := range tt.wantChecks {
+				got := report.Checks[name]
+				if got.Status != want || (want != health.StatusPass) != (got.Error != "") {
+					t.Errorf("check %s = %+v, want status %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorCheck(t *testing.T) {
+	if err := health.GeneratorCheck(keygenerator.NewCryptoKeyGenerator())(context.Background()); err != nil {
+		t.Errorf("check failed for the real generator: %v", err)
+	}
+	if err := health.GeneratorCheck(stuckGenerator{})(context.Background()); err == nil {
+		t.Error("expected a failure for a generator that repeats itself")
+	}
+}
+
+type stuckGenerator struct{}
+
+func (stuckGenerator) Generate(_ context.Context, length int) ([]byte, error) {
+	return make([]byte, length), nil
+}
+
+func TestEntropyCheck(t *testing.T) {
+	if err := health.EntropyCheck(rand.Reader)(context.Background()); err != nil {
+		t.Errorf("check failed for crypto/rand: %v", err)
+	}
+	if err := health.EntropyCheck(bytes.NewReader(make([]byte, 4096)))(context.Background()); err == nil {
+		t.Error("expected a failure for an all-zero source")
+	}
+	if err := health.EntropyCheck(strings.NewReader("short"))(context.Background()); err == nil {
+		t.Error("expected a failure for a source that runs dry")
+	}
+}
+
+func TestKeyStoreCheck(t *testing.T) {
+	if err := health.KeyStoreCheck(keystore.NewStore())(context.Background()); err != nil {
+		t.Errorf("check failed for a new key store: %v", err)
+	}
+	if err := health.KeyStoreCheck(&keystore.Store{})(context.Background()); err == nil {
+		t.Error("expected a failure for an uninitialized key store")
+	}
+}
+
+func TestCached(t *testing.T) {
+	runs := 0
+	fail := errors.New("boom")
+	check := health.Cached(func(ctx context.Context) error {
+		runs++
+		return fail
+	}, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := check(context.Background()); !errors.Is(err, fail) {
+			t.Fatalf("call %d: error = %v, want %v", i, err, fail)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("check ran %d times within the TTL, want 1", runs)
+	}
+	time.Sleep(60 * time.Millisecond)
+	check(context.Background())
+	if runs != 2 {
+		t.Errorf("check ran %d times after the TTL, want 2", runs)
+	}
+
+	// A run cut short by its caller is not reused.
+	runs = 0
+	check = health.Cached(func(ctx context.Context) error {
+		runs++
+		return ctx.Err()
+	}, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	check(ctx)
+	if err := check(context.Background()); err != nil || runs != 2 {
+		t.Errorf("after a canceled run: error = %v after %d runs, want nil after 2", err, runs)
+	}
+}
+
+func TestCertificateCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      string
+	}{
+		{"Valid", now.Add(-time.Hour), now.Add(30 * 24 * time.Hour), health.StatusPass},
+		{"Expiring soon", now.Add(-time.Hour), now.Add(24 * time.Hour), health.StatusWarn},
+		{"Expired", now.Add(-48 * time.Hour), now.Add(-time.Hour), health.StatusFail},
+		{"Not yet valid", now.Add(time.Hour), now.Add(48 * time.Hour), health.StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := newCertificate(t, tt.notBefore, tt.notAfter)
+			r := health.NewRegistry()
+			r.Register("tls", health.CertificateCheck(func() *tls.Certificate { return cert }, 7*24*time.Hour))
+			if got := r.Check(context.Background()).Checks["tls"]; got.Status != tt.want {
+				t.Errorf("check = %+v, want status %s", got, tt.want)
+			}
+		})
+	}
+
+	missing := health.CertificateCheck(func() *tls.Certificate { return nil }, time.Hour)
+	if err := missing(context.Background()); err == nil {
+		t.Error("expected a failure without a certificate")
+	}
+}
+
+func newCertificate(t *testing.T, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notBefore, NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,332 @@
+// Package idempotency makes key-issuing requests safe to retry. A client
+// that sends an Idempotency-Key header gets the response of the first
+// successful request with that key back for every retry within the
+// configured window, instead of a second key.
+//
+// Responses are kept in memory, scoped to the caller (the authenticated
+// principal, or the client IP without authentication) and encrypted with a
+// key generated at startup, since they contain key material.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+// Header carries the client's idempotency key on requests.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set to "true" on responses replayed from the cache.
+const ReplayedHeader = "Idempotent-Replayed"
+
+const (
+	maxKeyLength = 255     // Longest accepted Idempotency-Key value
+	maxBodyBytes = 1 << 20 // Largest request body fingerprinted
+)
+
+// Outcomes of requests carrying an Idempotency-Key, recorded in metrics.
+const (
+	OutcomeStored     = "stored"      // First request; the response was kept
+	OutcomeReplayed   = "replayed"    // Retry answered from the cache
+	OutcomeMismatch   = "mismatch"    // Key reused for a different request
+	OutcomeInProgress = "in_progress" // Retry arrived while the first request was running
+)
+
+// Options configures a Cache.
+type Options struct {
+	TTL      time.Duration // How long responses are kept (0 disables the cache)
+	MaxBytes int           // Total size of kept responses; the oldest are evicted first
+	Metrics  *metrics.PrometheusMetrics
+}
+
+// Cache keeps the responses of requests made with an Idempotency-Key.
+type Cache struct {
+	ttl      time.Duration
+	maxBytes int
+	metrics  *metrics.PrometheusMetrics
+	aead     cipher.AEAD
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	order   []*entry // Completed entries, oldest first
+	size    int      // Total size of the sealed responses in order
+}
+
+// entry is one idempotency key of one caller.
+type entry struct {
+	id          string
+	fingerprint [sha256.Size]byte
+	done        bool      // The response has been stored
+	expires     time.Time // When done
+	sealed      []byte    // Encrypted response, when done
+}
+
+// response is what is kept of a successful response.
+type response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// New creates a Cache with a fresh encryption key.
+func New(opts Options) (*Cache, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate idempotency cache key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		ttl:      opts.TTL,
+		maxBytes: opts.MaxBytes,
+		metrics:  opts.Metrics,
+		aead:     aead,
+		entries:  make(map[string]*entry),
+	}, nil
+}
+
+// Middleware replays the stored response for a request whose
+// Idempotency-Key was already used by the same caller for the same request,
+// and responds 409 Conflict when the key was used for a different request or
+// the first request is still running. Only 2xx responses are stored, so
+// failed requests can be retried with the same key. It must run after
+// authentication so responses are scoped to the principal, and before rate
+// limiting so replays do not use up quota.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || c.ttl <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, fmt.Sprintf("Invalid %s header. Must be at most %d characters.", Header, maxKeyLength), http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "Request body too large.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id := cacheID(r, key)
+		e, sealed, outcome := c.begin(id, fingerprint(r, body))
+		switch outcome {
+		case OutcomeMismatch:
+			c.metrics.RecordIdempotency(outcome)
+			http.Error(w, fmt.Sprintf("Conflict: %s was already used for a different request.", Header), http.StatusConflict)
+			return
+		case OutcomeInProgress:
+			c.metrics.RecordIdempotency(outcome)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Conflict: a request with this %s is still in progress.", Header), http.StatusConflict)
+			return
+		case OutcomeReplayed:
+			resp, err := c.open(id, sealed)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			c.metrics.RecordIdempotency(outcome)
+			replay(w, resp)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK, limit: c.maxBytes}
+		defer func() { c.finish(e, rec) }()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// begin looks up id. When there is no usable entry it creates one, marked in
+// progress, and returns it with an empty outcome; otherwise it returns the
+// outcome for the request and, for a replay, the sealed response.
+func (c *Cache) begin(id string, fp [sha256.Size]byte) (*entry, []byte, string) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(now)
+
+	if e, ok := c.entries[id]; ok && (!e.done || now.Before(e.expires)) {
+		switch {
+		case e.fingerprint != fp:
+			return nil, nil, OutcomeMismatch
+		case !e.done:
+			return nil, nil, OutcomeInProgress
+		}
+		return nil, e.sealed, OutcomeReplayed
+	}
+	e := &entry{id: id, fingerprint: fp}
+	c.entries[id] = e
+	return e, nil, ""
+}
+
+// finish stores the recorded response of e's request, or forgets e when the
+// response is not kept.
+func (c *Cache) finish(e *entry, rec *recorder) {
+	var sealed []byte
+	if rec.status/100 == 2 && !rec.overflow {
+		plain, err := json.Marshal(response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+		if err == nil {
+			sealed = c.seal(e.id, plain)
+		}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sealed == nil || len(sealed) > c.maxBytes {
+		if c.entries[e.id] == e {
+			delete(c.entries, e.id)
+		}
+		return
+	}
+	e.done, e.expires, e.sealed = true, now.Add(c.ttl), sealed
+	c.order = append(c.order, e)
+	c.size += len(sealed)
+	c.pruneLocked(now)
+	c.metrics.RecordIdempotency(OutcomeStored)
+}
+
+// pruneLocked evicts expired responses, and the oldest responses while the
+// cache is over its size limit. Entries expire in the order they were
+// stored, since they all have the same TTL.
+func (c *Cache) pruneLocked(now time.Time) {
+	for len(c.order) > 0 && (c.size > c.maxBytes || !now.Before(c.order[0].expires)) {
+		e := c.order[0]
+		c.order[0] = nil
+		c.order = c.order[1:]
+		c.size -= len(e.sealed)
+		if c.entries[e.id] == e {
+			delete(c.entries, e.id)
+		}
+	}
+}
+
+// seal encrypts a response, bound to the entry it belongs to.
+func (c *Cache) seal(id string, plain []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil
+	}
+	return c.aead.Seal(nonce, nonce, plain, []byte(id))
+}
+
+func (c *Cache) open(id string, sealed []byte) (*response, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed response too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := json.Unmarshal(plain, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// replay writes a stored response. Headers already set for this request,
+// such as its request ID, are kept.
+func replay(w http.ResponseWriter, resp *response) {
+	h := w.Header()
+	for name, values := range resp.Header {
+		if _, ok := h[name]; !ok {
+			h[name] = values
+		}
+	}
+	h.Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// cacheID identifies an idempotency key of the calling principal, or of the
+// client IP when the request is not authenticated, so callers cannot replay
+// each other's responses.
+func cacheID(r *http.Request, key string) string {
+	scope := "ip:" + clientIP(r)
+	if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+		scope = "principal:" + p.Name
+	}
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint identifies the request an idempotency key was used for.
+func fingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range []string{r.Method, r.URL.Path, r.URL.RawQuery} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	var fp [sha256.Size]byte
+	h.Sum(fp[:0])
+	return fp
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recorder passes a response through while keeping a copy, up to limit
+// bytes, for the cache.
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.header == nil {
+		r.status = code
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > r.limit {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
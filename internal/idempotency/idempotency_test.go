@@ -0,0 +1,285 @@
+package idempotency_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/idempotency"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+// counter is a handler that answers every request with a new number, so
+// replays are easy to tell from fresh responses.
+type counter struct {
+	calls  atomic.Int64
+	status int           // Response status; 0 means 200
+	block  chan struct{} // When set, requests wait for it to be closed
+}
+
+func (c *counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := c.calls.Add(1)
+	if c.block != nil {
+		<-c.block
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Request-ID", fmt.Sprintf("req-%d", n))
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+	}
+	fmt.Fprintf(w, `{"key":"key-%d"}`, n)
+}
+
+func newCache(t *testing.T, ttl time.Duration, maxBytes int) (*idempotency.Cache, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	m := metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+	c, err := idempotency.New(idempotency.Options{TTL: ttl, MaxBytes: maxBytes, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, registry
+}
+
+// call sends a request through h as principal (empty for none) with the
+// given Idempotency-Key.
+func call(h http.Handler, principal, key, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(idempotency.Header, key)
+	}
+	if principal != "" {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Name: principal}))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int // Status of the handler's responses
+		second    func(h http.Handler) *httptest.ResponseRecorder
+		wantCode  int
+		wantBody  string
+		wantCalls int64
+	}{
+		{
+			name: "Replay",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "k1", "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusOK,
+			wantBody:  `{"key":"key-1"}`,
+			wantCalls: 1,
+		},
+		{
+			name: "Different body",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "k1", "POST", "/ssh/sign", `{"x":1}`)
+			},
+			wantCode:  http.StatusConflict,
+			wantCalls: 1,
+		},
+		{
+			name: "Different path",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "k1", "POST", "/key/32", "{}")
+			},
+			wantCode:  http.StatusConflict,
+			wantCalls: 1,
+		},
+		{
+			name: "Other principal",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "bob", "k1", "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusOK,
+			wantBody:  `{"key":"key-2"}`,
+			wantCalls: 2,
+		},
+		{
+			name: "Other key",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "k2", "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusOK,
+			wantBody:  `{"key":"key-2"}`,
+			wantCalls: 2,
+		},
+		{
+			name: "No key",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "", "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusOK,
+			wantBody:  `{"key":"key-2"}`,
+			wantCalls: 2,
+		},
+		{
+			name:   "Failures are not kept",
+			status: http.StatusInternalServerError,
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", "k1", "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantBody:  `{"key":"key-2"}`,
+			wantCalls: 2,
+		},
+		{
+			name: "Key too long",
+			second: func(h http.Handler) *httptest.ResponseRecorder {
+				return call(h, "alice", strings.Repeat("k", 256), "POST", "/ssh/sign", "{}")
+			},
+			wantCode:  http.StatusBadRequest,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, _ := newCache(t, time.Minute, 1<<20)
+			next := &counter{status: tt.status}
+			h := cache.Middleware(next)
+
+			call(h, "alice", "k1", "POST", "/ssh/sign", "{}")
+			w := tt.second(h)
+			if w.Code != tt.wantCode {
+				t.Errorf("second response status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("second response body = %q, want %q", w.Body, tt.wantBody)
+			}
+			if got := next.calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ReplayHeaders(t *testing.T) {
+	cache, registry := newCache(t, time.Minute, 1<<20)
+	h := cache.Middleware(&counter{})
+
+	call(h, "", "k1", "GET", "/key/32", "")
+	r := httptest.NewRequest("GET", "/key/32", nil)
+	r.Header.Set(idempotency.Header, "k1")
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-ID", "retry")
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get(idempotency.ReplayedHeader); got != "true" {
+		t.Errorf("%s = %q, want true", idempotency.ReplayedHeader, got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want the stored application/json", got)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "retry" {
+		t.Errorf("X-Request-ID = %q, want the retry's own ID", got)
+	}
+
+	expected := `
+# HELP key_server_idempotency_requests_total Total number of requests carrying an Idempotency-Key, by outcome (stored, replayed, mismatch or in_progress).
+# TYPE key_server_idempotency_requests_total counter
+key_server_idempotency_requests_total{outcome="replayed"} 1
+key_server_idempotency_requests_total{outcome="stored"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "key_server_idempotency_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMiddleware_InProgress(t *testing.T) {
+	cache, _ := newCache(t, time.Minute, 1<<20)
+	next := &counter{block: make(chan struct{})}
+	h := cache.Middleware(next)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- call(h, "alice", "k1", "GET", "/key/32", "") }()
+	for next.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := call(h, "alice", "k1", "GET", "/key/32", "")
+	if w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("concurrent retry got %d (Retry-After %q), want 409 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	close(next.block)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Fatalf("first request got %d", first.Code)
+	}
+	if w := call(h, "alice", "k1", "GET", "/key/32", ""); w.Body.String() != `{"key":"key-1"}` {
+		t.Errorf("retry after completion got %q, want the first response", w.Body)
+	}
+}
+
+func TestMiddleware_Expiry(t *testing.T) {
+	t.Run("TTL", func(t *testing.T) {
+		cache, _ := newCache(t, 20*time.Millisecond, 1<<20)
+		next := &counter{}
+		h := cache.Middleware(next)
+		call(h, "alice", "k1", "GET", "/key/32", "")
+		time.Sleep(30 * time.Millisecond)
+		if w := call(h, "alice", "k1", "GET", "/key/32", ""); w.Body.String() != `{"key":"key-2"}` {
+			t.Errorf("request after the TTL got %q, want a fresh response", w.Body)
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		// Each sealed response is about 150 bytes, so 1 KiB holds a few.
+		cache, _ := newCache(t, time.Minute, 1<<10)
+		next := &counter{}
+		h := cache.Middleware(next)
+		for i := 0; i < 20; i++ {
+			call(h, "alice", fmt.Sprintf("k%d", i), "GET", "/key/32", "")
+		}
+		if w := call(h, "alice", "k19", "GET", "/key/32", ""); w.Body.String() != `{"key":"key-20"}` {
+			t.Errorf("newest response not replayed: %q", w.Body)
+		}
+		if w := call(h, "alice", "k0", "GET", "/key/32", ""); w.Body.String() != `{"key":"key-21"}` {
+			t.Errorf("oldest response not evicted: %q", w.Body)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cache, _ := newCache(t, 0, 1<<20)
+		next := &counter{}
+		h := cache.Middleware(next)
+		call(h, "alice", "k1", "GET", "/key/32", "")
+		call(h, "alice", "k1", "GET", "/key/32", "")
+		if got := next.calls.Load(); got != 2 {
+			t.Errorf("handler called %d times with the cache disabled, want 2", got)
+		}
+	})
+}
+
+func TestMiddleware_Anonymous(t *testing.T) {
+	cache, _ := newCache(t, time.Minute, 1<<20)
+	next := &counter{}
+	h := cache.Middleware(next)
+
+	send := func(remoteAddr string) string {
+		r := httptest.NewRequest("GET", "/key/32", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(idempotency.Header, "k1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	first := send("192.0.2.1:1000")
+	if got := send("192.0.2.1:2000"); got != first {
+		t.Errorf("same client IP got %q, want the replayed %q", got, first)
+	}
+	if got := send("192.0.2.2:1000"); got == first {
+		t.Error("another client IP was given the first client's response")
+	}
+}
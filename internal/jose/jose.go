@@ -0,0 +1,326 @@
+package jose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Supported JWS algorithm identifiers (RFC 7518, RFC 8037).
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrInvalidSignature is returned when a signature does not verify.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// JSONWebKey is the wire representation of a public key (RFC 7517).
+// Only the members needed for EC, RSA and OKP (Ed25519) keys are modelled.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	K   string `json:"k,omitempty"` // Symmetric key value for "oct" keys
+}
+
+// JSONWebKeySet is a set of keys as published in a JWKS document.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicKey decodes the JWK into a Go public key
+// (*ecdsa.PublicKey, *rsa.PublicKey or ed25519.PublicKey).
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA modulus of %d bits is too small", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OKP x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// VerificationKey returns the key material used to verify signatures: a
+// []byte secret for "oct" keys, otherwise the decoded public key.
+func (k JSONWebKey) VerificationKey() (interface{}, error) {
+	if k.Kty != "oct" {
+		return k.PublicKey()
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(k.K)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oct key value: %w", err)
+	}
+	if len(secret) < 32 {
+		return nil, errors.New("oct keys must be at least 256 bits")
+	}
+	return secret, nil
+}
+
+// NewJSONWebKey encodes a Go public key as a JWK.
+func NewJSONWebKey(pub crypto.PublicKey) (JSONWebKey, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JSONWebKey{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	default:
+		return JSONWebKey{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint of a public key,
+// base64url encoded without padding.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := NewJSONWebKey(pub)
+	if err != nil {
+		return "", err
+	}
+	// The required members must appear in lexicographic order with no whitespace.
+	var canonical string
+	switch jwk.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Verify checks signature over signingInput using alg and key.
+// For HS256 key must be a []byte secret; for all other algorithms it must
+// be a public key matching the algorithm family.
+func Verify(alg string, key interface{}, signingInput, signature []byte) error {
+	switch alg {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires a shared secret", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an RSA key", alg)
+		}
+		digest := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgES256, AlgES384:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an EC key", alg)
+		}
+		var digest []byte
+		wantCurve := "P-256"
+		if alg == AlgES256 {
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		} else {
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+			wantCurve = "P-384"
+		}
+		if pub.Curve.Params().Name != wantCurve {
+			return fmt.Errorf("algorithm %s requires curve %s", alg, wantCurve)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(pub, signingInput, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// Sign produces a JWS signature over signingInput. For HS256 key must be a
+// []byte secret; otherwise it must be the private key for the algorithm.
+func Sign(alg string, key interface{}, signingInput []byte) ([]byte, error) {
+	switch alg {
+	case AlgHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires a shared secret", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	case AlgRS256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an RSA key", alg)
+		}
+		digest := sha256.Sum256(signingInput)
+		return rsa.SignPKCS1v15(nil, priv, crypto.SHA256, digest[:])
+	case AlgES256, AlgES384:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an EC key", alg)
+		}
+		var digest []byte
+		if alg == AlgES256 {
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		} else {
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case AlgEdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an Ed25519 key", alg)
+		}
+		return ed25519.Sign(priv, signingInput), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// DecodeSegment decodes a base64url segment without padding.
+func DecodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// EncodeSegment encodes b as base64url without padding.
+func EncodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeJSONSegment decodes a base64url segment and unmarshals its JSON into v.
+func DecodeJSONSegment(s string, v interface{}) error {
+	raw, err := DecodeSegment(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
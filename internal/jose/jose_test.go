@@ -0,0 +1,92 @@
+package jose_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/jose"
+)
+
+// TestThumbprint checks the RFC 7638 §3.1 example vector.
+func TestThumbprint(t *testing.T) {
+	jwk := jose.JSONWebKey{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey() returned error: %v", err)
+	}
+	got, err := jose.Thumbprint(pub)
+	if err != nil {
+		t.Fatalf("Thumbprint() returned error: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %q, want %q", got, want)
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name    string
+		alg     string
+		signKey interface{}
+		pubKey  interface{}
+	}{
+		{"HS256", jose.AlgHS256, secret, secret},
+		{"RS256", jose.AlgRS256, rsaKey, &rsaKey.PublicKey},
+		{"ES256", jose.AlgES256, ecKey, &ecKey.PublicKey},
+		{"ES384", jose.AlgES384, ec384Key, &ec384Key.PublicKey},
+		{"EdDSA", jose.AlgEdDSA, edPriv, edPub},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte("header.payload")
+			sig, err := jose.Sign(tt.alg, tt.signKey, input)
+			if err != nil {
+				t.Fatalf("Sign() returned error: %v", err)
+			}
+			if err := jose.Verify(tt.alg, tt.pubKey, input, sig); err != nil {
+				t.Errorf("Verify() returned error for a valid signature: %v", err)
+			}
+			if err := jose.Verify(tt.alg, tt.pubKey, []byte("header.tampered"), sig); err == nil {
+				t.Error("Verify() accepted a signature over different input")
+			}
+
+			// Round-trip asymmetric keys through their JWK encoding.
+			if tt.alg == jose.AlgHS256 {
+				return
+			}
+			jwk, err := jose.NewJSONWebKey(tt.pubKey)
+			if err != nil {
+				t.Fatalf("NewJSONWebKey() returned error: %v", err)
+			}
+			decoded, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatalf("JSONWebKey.PublicKey() returned error: %v", err)
+			}
+			if err := jose.Verify(tt.alg, decoded, input, sig); err != nil {
+				t.Errorf("Verify() with JWK-decoded key returned error: %v", err)
+			}
+		})
+	}
+
+	t.Run("Algorithm/key mismatch", func(t *testing.T) {
+		sig, _ := jose.Sign(jose.AlgES256, ecKey, []byte("x"))
+		if err := jose.Verify(jose.AlgRS256, &ecKey.PublicKey, []byte("x"), sig); err == nil {
+			t.Error("Verify() accepted an EC key for RS256")
+		}
+	})
+}
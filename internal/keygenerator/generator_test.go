@@ -1,6 +1,7 @@
 package keygenerator_test
 
 import (
+	"context"
 	"encoding/base64"
 	"testing"
 
@@ -27,7 +28,7 @@ func TestCryptoKeyGenerator_Generate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			key, err := generator.Generate(tt.length)
+			key, err := generator.Generate(context.Background(), tt.length)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Generate() error = %v, wantErr %v", err, tt.wantErr)
@@ -1,13 +1,18 @@
 package keygenerator
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/tracing"
 )
 
 // CryptoKeyGenerator defines the interface for cryptographic key generation.
 type CryptoKeyGenerator interface {
-	Generate(length int) ([]byte, error) // Returns []byte, error
+	Generate(ctx context.Context, length int) ([]byte, error) // Returns []byte, error
 }
 
 // cryptoKeyGenerator implements the CryptoKeyGenerator interface.
@@ -19,15 +24,25 @@ func NewCryptoKeyGenerator() CryptoKeyGenerator {
 }
 
 // Generate generates a cryptographically secure random byte slice of the specified length.
-func (g *cryptoKeyGenerator) Generate(length int) ([]byte, error) { // Returns []byte, error
+// It is traced as a child span of ctx.
+func (g *cryptoKeyGenerator) Generate(ctx context.Context, length int) ([]byte, error) { // Returns []byte, error
+	_, span := otel.Tracer("github.com/bajhalshrey/Key-Server-Application/internal/keygenerator").
+		Start(ctx, "CryptoKeyGenerator.Generate")
+	defer span.End()
+	span.SetAttributes(tracing.KeyLength(length))
+
 	if length <= 0 {
-		return nil, fmt.Errorf("key length must be a positive integer")
+		err := fmt.Errorf("key length must be a positive integer")
+		tracing.RecordError(span, err)
+		return nil, err
 	}
 
 	key := make([]byte, length)
 	_, err := rand.Read(key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read random bytes: %w", err)
+		err = fmt.Errorf("failed to read random bytes: %w", err)
+		tracing.RecordError(span, err)
+		return nil, err
 	}
 	return key, nil
 }
@@ -0,0 +1,604 @@
+package keyservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/tracing"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+)
+
+// Operations on named keys, used as the operation label of
+// key_server_key_operations_total.
+const (
+	OperationCreate  = "create"
+	OperationRotate  = "rotate"
+	OperationRead    = "read"
+	OperationList    = "list"
+	OperationEncrypt = "encrypt"
+	OperationDecrypt = "decrypt"
+	OperationSign    = "sign"
+	OperationVerify  = "verify"
+	OperationState   = "state"
+)
+
+// schedulerPrincipal is recorded in the audit log for scheduled state
+// transitions.
+const schedulerPrincipal = "key-scheduler"
+
+// CreateKeyRequest describes a named key to create.
+type CreateKeyRequest struct {
+	Name        string
+	Purpose     string            // keystore.PurposeEncrypt, PurposeSign or PurposeMAC
+	Algorithm   string            // Empty selects the purpose's default algorithm
+	Labels      map[string]string // Optional
+	ActivatesAt time.Time         // Zero means the key is active at once
+	ExpiresAt   time.Time         // Deactivation time; zero means the end of the crypto period, if any
+}
+
+// NamedKeyService creates named keys and performs cryptographic operations
+// with them. Every operation is authorized by policy and audited; keys can
+// only be used for the operations of their purpose and state.
+type NamedKeyService interface {
+	CreateKey(ctx context.Context, req CreateKeyRequest) (*keystore.Metadata, error)
+	// RotateKey replaces a named key with a new version with fresh key
+	// material. Earlier versions still decrypt and verify.
+	RotateKey(ctx context.Context, name string) (*keystore.Metadata, error)
+	GetKey(ctx context.Context, name string) (*keystore.Metadata, error)
+	ListKeys(ctx context.Context, selector keystore.Selector) ([]keystore.Metadata, error)
+	Encrypt(ctx context.Context, name string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, name string, ciphertext, aad []byte) ([]byte, error)
+	Sign(ctx context.Context, name string, message []byte) ([]byte, error)
+	Verify(ctx context.Context, name string, message, signature []byte) (bool, error)
+	// SetState moves a key to keystore.StateDeactivated, StateCompromised
+	// or StateDestroyed.
+	SetState(ctx context.Context, name, state, reason string) (*keystore.Metadata, error)
+	// UpdateStates records the state transitions that keys' schedules have
+	// made due and returns how many were made.
+	UpdateStates(ctx context.Context) int
+}
+
+// namedKeyService implements NamedKeyService on a keystore.Store.
+type namedKeyService struct {
+	keys         *keystore.Store
+	config       *config.Store
+	keyGenerator keygenerator.CryptoKeyGenerator
+	metrics      *metrics.PrometheusMetrics
+	policy       *policy.Engine
+	audit        *audit.Logger
+	tlog         *transparency.Log
+	logger       *slog.Logger
+
+	createMu sync.Mutex // Serializes creation so a name is only claimed once
+}
+
+// NewNamedKeyService creates a NamedKeyService that keeps keys in ks and
+// draws their material from kg. Crypto periods are read from cfg when a key
+// is created.
+func NewNamedKeyService(
+	ks *keystore.Store,
+	cfg *config.Store,
+	kg keygenerator.CryptoKeyGenerator,
+	m *metrics.PrometheusMetrics,
+	pe *policy.Engine,
+	al *audit.Logger,
+	tl *transparency.Log,
+	logger *slog.Logger,
+) NamedKeyService {
+	return &namedKeyService{
+		keys:         ks,
+		config:       cfg,
+		keyGenerator: kg,
+		metrics:      m,
+		policy:       pe,
+		audit:        al,
+		tlog:         tl,
+		logger:       logger,
+	}
+}
+
+// CreateKey creates a named key owned by the principal in ctx. The creation
+// is authorized as the "generate" action for the key's algorithm, size and
+// name before any key material is drawn, published to the transparency log
+// (with the public key of signing keys) and audited; the key is only stored
+// once both succeed.
+func (s *namedKeyService) CreateKey(ctx context.Context, req CreateKeyRequest) (meta *keystore.Metadata, err error) {
+	if req.Algorithm == "" {
+		req.Algorithm = keystore.DefaultAlgorithm(req.Purpose)
+	}
+	ctx, span := startSpan(ctx, "KeyService.CreateKey", req.Name)
+	span.SetAttributes(attribute.String("key.type", req.Algorithm))
+	defer func() { endSpan(span, err) }()
+
+	size := keystore.KeySize(req.Algorithm)
+	event := audit.Event{Operation: audit.OpCreateKey, KeyID: req.Name, KeyType: req.Algorithm, KeyLength: size}
+	defer func() { s.record(OperationCreate, event.Outcome) }()
+
+	meta, err = s.newMetadata(ctx, req, size)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	if err := authorize(ctx, s.policy, s.metrics, policy.Request{Action: policy.ActionGenerate, KeyType: req.Algorithm, KeySize: size, KeyName: req.Name, KeyOwner: meta.Owner}); err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	material, err := s.keyGenerator.Generate(ctx, size)
+	if err != nil {
+		return nil, s.fail(ctx, &event, fmt.Errorf("failed to generate key material: %w", err))
+	}
+	key, err := keystore.NewKey(*meta, material)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	if _, err := s.keys.Get(req.Name); err == nil {
+		return nil, s.fail(ctx, &event, fmt.Errorf("%w: %s", keystore.ErrExists, req.Name))
+	}
+
+	m := key.Metadata()
+	leaf := transparency.Leaf{Type: transparency.LeafNamedKey, PrincipalDigest: transparency.PrincipalDigest(m.Owner), KeyType: m.Algorithm, KeyLength: size, KeyName: m.Name, Data: m.PublicKey}
+	if _, err := s.tlog.Append(leaf); err != nil {
+		s.logger.ErrorContext(ctx, "Error appending to transparency log, not creating key", "key_name", req.Name, "error", err)
+		return nil, s.fail(ctx, &event, fmt.Errorf("failed to publish key creation: %w", err))
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Error recording audit entry, not creating key", "key_name", req.Name, "error", err)
+		event.Outcome = audit.OutcomeError
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	if err := s.keys.Add(key); err != nil {
+		event.Outcome = audit.OutcomeError
+		return nil, err
+	}
+	return &m, nil
+}
+
+// RotateKey replaces the named key with a new version that keeps its name,
+// purpose, algorithm, labels and owner and takes the crypto period and usage
+// limit configured now. The rotation is authorized as the "rotate" action
+// before any key material is drawn, published to the transparency log and
+// audited; the new version only takes over once both succeed. The replaced
+// version is deactivated if it was active.
+func (s *namedKeyService) RotateKey(ctx context.Context, name string) (meta *keystore.Metadata, err error) {
+	ctx, span := startSpan(ctx, "KeyService.RotateKey", name)
+	defer func() { endSpan(span, err) }()
+
+	event := audit.Event{Operation: audit.OpRotateKey, KeyID: name}
+	defer func() { s.record(OperationRotate, event.Outcome) }()
+
+	current, err := s.keys.Get(name)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	cm := current.Metadata()
+	size := keystore.KeySize(cm.Algorithm)
+	event.KeyType, event.KeyLength = cm.Algorithm, size
+	span.SetAttributes(attribute.String("key.type", cm.Algorithm))
+	if err := authorize(ctx, s.policy, s.metrics, policy.Request{Action: policy.ActionRotate, KeyType: cm.Algorithm, KeyName: name, KeyOwner: cm.Owner}); err != nil {
+		return nil, s.hide(ctx, cm, s.fail(ctx, &event, err))
+	}
+	if cm.State == keystore.StatePreActive {
+		return nil, s.fail(ctx, &event, fmt.Errorf("%w: key %s is pre-active until %s and cannot be rotated", keystore.ErrState, name, cm.ActivatesAt.Format(time.RFC3339)))
+	}
+	meta, err = s.newMetadata(ctx, CreateKeyRequest{Name: name, Purpose: cm.Purpose, Algorithm: cm.Algorithm, Labels: cm.Labels}, size)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	meta.Owner = cm.Owner
+	material, err := s.keyGenerator.Generate(ctx, size)
+	if err != nil {
+		return nil, s.fail(ctx, &event, fmt.Errorf("failed to generate key material: %w", err))
+	}
+	key, err := keystore.NewKey(*meta, material)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	m := key.Metadata()
+	leaf := transparency.Leaf{Type: transparency.LeafNamedKey, PrincipalDigest: transparency.PrincipalDigest(principalName(ctx)), KeyType: m.Algorithm, KeyLength: size, KeyName: m.Name, Data: m.PublicKey}
+	if _, err := s.tlog.Append(leaf); err != nil {
+		s.logger.ErrorContext(ctx, "Error appending to transparency log, not rotating key", "key_name", name, "error", err)
+		return nil, s.fail(ctx, &event, fmt.Errorf("failed to publish key rotation: %w", err))
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Error recording audit entry, not rotating key", "key_name", name, "error", err)
+		event.Outcome = audit.OutcomeError
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	transitions, err := s.keys.Rotate(key, time.Now().UTC())
+	if err != nil {
+		event.Outcome = audit.OutcomeError
+		return nil, err
+	}
+	for _, t := range transitions {
+		if t.Reason == keystore.RotatedReason {
+			s.recordTransition(ctx, t, principalName(ctx), t.Reason)
+		} else {
+			s.recordTransition(ctx, t, schedulerPrincipal, scheduledReason(t))
+		}
+	}
+	m = key.Metadata()
+	return &m, nil
+}
+
+// newMetadata validates req and builds the metadata of the key to create.
+func (s *namedKeyService) newMetadata(ctx context.Context, req CreateKeyRequest, size int) (*keystore.Metadata, error) {
+	if size == 0 {
+		if keystore.DefaultAlgorithm(req.Purpose) == "" {
+			return nil, fmt.Errorf("%w: unknown purpose %q", keystore.ErrInvalid, req.Purpose)
+		}
+		return nil, fmt.Errorf("%w: unknown algorithm %q", keystore.ErrInvalid, req.Algorithm)
+	}
+	meta := keystore.Metadata{
+		Name:        req.Name,
+		Labels:      req.Labels,
+		Owner:       principalName(ctx),
+		Purpose:     req.Purpose,
+		Algorithm:   req.Algorithm,
+		CreatedAt:   time.Now().UTC(),
+		ActivatesAt: req.ActivatesAt.UTC(),
+	}
+	if meta.ActivatesAt.IsZero() {
+		meta.ActivatesAt = meta.CreatedAt
+	}
+	if !req.ExpiresAt.IsZero() {
+		expires := req.ExpiresAt.UTC()
+		meta.ExpiresAt = &expires
+	}
+	cfg := s.config.Current()
+	if err := applyCryptoPeriod(&meta, cfg.CryptoPeriod(req.Algorithm)); err != nil {
+		return nil, err
+	}
+	if l := cfg.UsageLimit(req.Algorithm); l.MaxOperations > 0 || l.MaxBytes > 0 {
+		meta.UsageLimit = &keystore.UsageLimit{MaxOperations: l.MaxOperations, MaxBytes: l.MaxBytes, OnLimit: l.OnLimit}
+	}
+	// Validate before drawing key material.
+	if _, err := keystore.NewKey(meta, make([]byte, size)); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// applyCryptoPeriod fits meta's schedule into period. A key without an
+// expiry time is deactivated at the end of the active period (or of the
+// usage period, if only that is set); asking for a later expiry fails. The
+// key is destroyed at the end of the usage period.
+func applyCryptoPeriod(meta *keystore.Metadata, period config.CryptoPeriod) error {
+	if period.MaxActivePeriod > 0 {
+		limit := meta.ActivatesAt.Add(period.MaxActivePeriod)
+		if meta.ExpiresAt == nil {
+			meta.ExpiresAt = &limit
+		} else if meta.ExpiresAt.After(limit) {
+			return fmt.Errorf("%w: expiry time is beyond the maximum active period of %s for %s keys", keystore.ErrInvalid, period.MaxActivePeriod, meta.Algorithm)
+		}
+	}
+	if period.MaxUsagePeriod > 0 {
+		destroys := meta.ActivatesAt.Add(period.MaxUsagePeriod)
+		meta.DestroysAt = &destroys
+		if meta.ExpiresAt == nil {
+			meta.ExpiresAt = &destroys
+		} else if meta.ExpiresAt.After(destroys) {
+			return fmt.Errorf("%w: expiry time is beyond the maximum usage period of %s for %s keys", keystore.ErrInvalid, period.MaxUsagePeriod, meta.Algorithm)
+		}
+	}
+	return nil
+}
+
+// GetKey returns the metadata of a named key. The read is authorized as the
+// "read" action on the key and audited.
+func (s *namedKeyService) GetKey(ctx context.Context, name string) (meta *keystore.Metadata, err error) {
+	ctx, span := startSpan(ctx, "KeyService.GetKey", name)
+	defer func() { endSpan(span, err) }()
+
+	event := audit.Event{Operation: audit.OpReadKey, KeyID: name}
+	defer func() { s.record(OperationRead, event.Outcome) }()
+
+	key, err := s.keys.Get(name)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	m := key.Metadata()
+	event.KeyType = m.Algorithm
+	if err := authorize(ctx, s.policy, s.metrics, readRequest(m)); err != nil {
+		return nil, s.hide(ctx, m, s.fail(ctx, &event, err))
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Error recording audit entry, withholding result", "key_name", name, "operation", OperationRead, "error", err)
+		event.Outcome = audit.OutcomeError
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return &m, nil
+}
+
+// ListKeys returns the metadata of the keys matching selector that the
+// principal in ctx may read; other keys are left out rather than denied. The
+// listing is audited.
+func (s *namedKeyService) ListKeys(ctx context.Context, selector keystore.Selector) (keys []keystore.Metadata, err error) {
+	ctx, span := startSpan(ctx, "KeyService.ListKeys", "")
+	defer func() { endSpan(span, err) }()
+
+	event := audit.Event{Operation: audit.OpListKeys}
+	defer func() { s.record(OperationList, event.Outcome) }()
+
+	principal, _ := auth.PrincipalFromContext(ctx)
+	keys = []keystore.Metadata{}
+	for _, m := range s.keys.List(selector) {
+		req := readRequest(m)
+		req.Principal = principal
+		if s.policy.Evaluate(req).Allowed {
+			keys = append(keys, m)
+		}
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Error recording audit entry, withholding result", "operation", OperationList, "error", err)
+		event.Outcome = audit.OutcomeError
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return keys, nil
+}
+
+// hide returns the error a caller denied access to the key m by err gets.
+// Unless the caller may read the key, that is the error for a missing key,
+// so that key names cannot be probed; the audit entry keeps the denial.
+func (s *namedKeyService) hide(ctx context.Context, m keystore.Metadata, err error) error {
+	if !errors.Is(err, ErrPermissionDenied) {
+		return err
+	}
+	req := readRequest(m)
+	req.Principal, _ = auth.PrincipalFromContext(ctx)
+	if s.policy.Evaluate(req).Allowed {
+		return err
+	}
+	return fmt.Errorf("%w: %s", keystore.ErrNotFound, m.Name)
+}
+
+// readRequest is the policy request for reading m.
+func readRequest(m keystore.Metadata) policy.Request {
+	return policy.Request{Action: policy.ActionRead, KeyType: m.Algorithm, KeyName: m.Name, KeyOwner: m.Owner}
+}
+
+// Encrypt encrypts plaintext with a named encryption key.
+func (s *namedKeyService) Encrypt(ctx context.Context, name string, plaintext, aad []byte) ([]byte, error) {
+	var ciphertext []byte
+	err := s.use(ctx, OperationEncrypt, name, func(k *keystore.Key) (err error) {
+		ciphertext, err = k.Encrypt(plaintext, aad)
+		return err
+	})
+	return ciphertext, err
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt with the same key.
+func (s *namedKeyService) Decrypt(ctx context.Context, name string, ciphertext, aad []byte) ([]byte, error) {
+	var plaintext []byte
+	err := s.use(ctx, OperationDecrypt, name, func(k *keystore.Key) (err error) {
+		plaintext, err = k.Decrypt(ciphertext, aad)
+		return err
+	})
+	return plaintext, err
+}
+
+// Sign signs message with a named signing key, or computes its MAC with a
+// named MAC key.
+func (s *namedKeyService) Sign(ctx context.Context, name string, message []byte) ([]byte, error) {
+	var signature []byte
+	err := s.use(ctx, OperationSign, name, func(k *keystore.Key) (err error) {
+		signature, err = k.Sign(message)
+		return err
+	})
+	return signature, err
+}
+
+// Verify checks a signature or MAC produced by Sign with the same key. An
+// invalid signature is not an error.
+func (s *namedKeyService) Verify(ctx context.Context, name string, message, signature []byte) (bool, error) {
+	var valid bool
+	err := s.use(ctx, OperationVerify, name, func(k *keystore.Key) (err error) {
+		valid, err = k.Verify(message, signature)
+		return err
+	})
+	return valid, err
+}
+
+// SetState moves a named key to state for reason. The change is authorized
+// as the "manage" action and each transition it makes is audited.
+func (s *namedKeyService) SetState(ctx context.Context, name, state, reason string) (meta *keystore.Metadata, err error) {
+	ctx, span := startSpan(ctx, "KeyService.SetState", name)
+	span.SetAttributes(attribute.String("key.state", state))
+	defer func() { endSpan(span, err) }()
+
+	event := audit.Event{Operation: audit.OpKeyState, KeyID: name, KeyState: state}
+	defer func() { s.record(OperationState, event.Outcome) }()
+
+	key, err := s.keys.Get(name)
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	km := key.Metadata()
+	event.KeyType = km.Algorithm
+	if err := authorize(ctx, s.policy, s.metrics, policy.Request{Action: policy.ActionManage, KeyType: event.KeyType, KeyName: name, KeyOwner: km.Owner}); err != nil {
+		return nil, s.hide(ctx, km, s.fail(ctx, &event, err))
+	}
+	transitions, err := s.keys.SetState(name, state, reason, time.Now().UTC())
+	if err != nil {
+		return nil, s.fail(ctx, &event, err)
+	}
+	// The change has been made, so a failure to audit it is logged rather
+	// than undoing it. Scheduled transitions that were due first are
+	// recorded as the scheduler's.
+	event.Outcome = audit.OutcomeSuccess
+	for _, t := range transitions {
+		if t.Scheduled {
+			s.recordTransition(ctx, t, schedulerPrincipal, scheduledReason(t))
+		} else {
+			s.recordTransition(ctx, t, principalName(ctx), t.Reason)
+		}
+	}
+	m := key.Metadata()
+	return &m, nil
+}
+
+// UpdateStates records the transitions that are due on every key.
+func (s *namedKeyService) UpdateStates(ctx context.Context) int {
+	transitions := s.keys.Advance(time.Now().UTC())
+	for _, t := range transitions {
+		s.recordTransition(ctx, t, schedulerPrincipal, scheduledReason(t))
+	}
+	counts := make(map[string]int)
+	for _, m := range s.keys.List(nil) {
+		counts[m.State]++
+	}
+	for _, state := range []string{keystore.StatePreActive, keystore.StateActive, keystore.StateDeactivated, keystore.StateCompromised, keystore.StateDestroyed} {
+		s.metrics.SetNamedKeys(state, counts[state])
+	}
+	return len(transitions)
+}
+
+// scheduledReason returns the reason recorded for a scheduled transition.
+func scheduledReason(t keystore.Transition) string {
+	if t.Reason != "" {
+		return t.Reason
+	}
+	return "scheduled"
+}
+
+// recordTransition audits, logs and counts a state transition made on
+// behalf of principal.
+func (s *namedKeyService) recordTransition(ctx context.Context, t keystore.Transition, principal, reason string) {
+	s.audit.RecordOrLog(ctx, audit.Event{
+		Principal: principal,
+		Operation: audit.OpKeyState,
+		KeyID:     t.Name,
+		KeyType:   t.Algorithm,
+		KeyState:  t.To,
+		Outcome:   audit.OutcomeSuccess,
+		Reason:    reason,
+	})
+	s.logger.InfoContext(ctx, "Named key state changed", "key_name", t.Name, "version", t.Version, "from", t.From, "to", t.To, "at", t.At, "reason", reason)
+	s.metrics.RecordKeyStateTransition(t.From, t.To)
+}
+
+// keyOperations maps each operation on an existing key to its audit
+// operation, policy action and span name.
+var keyOperations = map[string]struct{ audit, action, span string }{
+	OperationEncrypt: {audit.OpEncrypt, policy.ActionEncrypt, "KeyService.Encrypt"},
+	OperationDecrypt: {audit.OpDecrypt, policy.ActionDecrypt, "KeyService.Decrypt"},
+	OperationSign:    {audit.OpSign, policy.ActionSign, "KeyService.Sign"},
+	OperationVerify:  {audit.OpVerify, policy.ActionVerify, "KeyService.Verify"},
+}
+
+// use runs op with the key called name once the principal in ctx is allowed
+// to perform operation on it, auditing the attempt. The result of a
+// successful operation is only returned once its audit entry is written.
+func (s *namedKeyService) use(ctx context.Context, operation, name string, op func(*keystore.Key) error) (err error) {
+	o := keyOperations[operation]
+	ctx, span := startSpan(ctx, o.span, name)
+	defer func() { endSpan(span, err) }()
+
+	event := audit.Event{Operation: o.audit, KeyID: name}
+	defer func() { s.record(operation, event.Outcome) }()
+
+	key, err := s.keys.Get(name)
+	if err != nil {
+		return s.fail(ctx, &event, err)
+	}
+	km := key.Metadata()
+	event.KeyType = km.Algorithm
+	span.SetAttributes(attribute.String("key.type", event.KeyType))
+	if err := authorize(ctx, s.policy, s.metrics, policy.Request{Action: o.action, KeyType: event.KeyType, KeyName: name, KeyOwner: km.Owner}); err != nil {
+		return s.hide(ctx, km, s.fail(ctx, &event, err))
+	}
+	if err := op(key); err != nil {
+		return s.fail(ctx, &event, err)
+	}
+
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.logger.ErrorContext(ctx, "Error recording audit entry, withholding result", "key_name", name, "operation", operation, "error", err)
+		event.Outcome = audit.OutcomeError
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// fail audits a failed operation and returns err. Rejections by validation,
+// policy or the key's purpose are recorded as denied; anything else as an
+// error.
+func (s *namedKeyService) fail(ctx context.Context, event *audit.Event, err error) error {
+	event.Outcome, event.Reason = audit.OutcomeError, err.Error()
+	if IsRejection(err) {
+		event.Outcome = audit.OutcomeDenied
+	}
+	s.audit.RecordOrLog(ctx, *event)
+	return err
+}
+
+// IsRejection reports whether err rejects a request, as opposed to a failure
+// inside the server.
+func IsRejection(err error) bool {
+	for _, target := range []error{
+		ErrPermissionDenied,
+		keystore.ErrNotFound,
+		keystore.ErrExists,
+		keystore.ErrInvalid,
+		keystore.ErrWrongPurpose,
+		keystore.ErrExpired,
+		keystore.ErrState,
+		keystore.ErrUsageLimit,
+		keystore.ErrDecrypt,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts an operation by its audit outcome.
+func (s *namedKeyService) record(operation, outcome string) {
+	if outcome == "" {
+		outcome = audit.OutcomeError
+	}
+	s.metrics.RecordKeyOperation(operation, outcome)
+}
+
+func principalName(ctx context.Context) string {
+	if p, ok := auth.PrincipalFromContext(ctx); ok {
+		return p.Name
+	}
+	return policy.AnonymousPrincipal
+}
+
+func startSpan(ctx context.Context, name, keyName string) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("github.com/bajhalshrey/Key-Server-Application/internal/keyservice").Start(ctx, name)
+	span.SetAttributes(attribute.String("key.name", keyName))
+	return ctx, span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+	span.End()
+}
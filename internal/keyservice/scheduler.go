@@ -0,0 +1,63 @@
+package keyservice
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// StateScheduler periodically records the state transitions that named
+// keys' schedules call for: activation, deactivation at the end of the
+// active period and destruction at the end of the usage period. Operations
+// follow the schedule whether or not the scheduler has run; the scheduler
+// erases destroyed key material and makes the transitions visible in the
+// audit log and metrics.
+type StateScheduler struct {
+	keys     NamedKeyService
+	interval time.Duration
+	logger   *slog.Logger
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewStateScheduler creates a StateScheduler that updates the states of the
+// keys in ks every interval.
+func NewStateScheduler(ks NamedKeyService, interval time.Duration, logger *slog.Logger) *StateScheduler {
+	return &StateScheduler{
+		keys:     ks,
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler in the background until Stop is called.
+func (s *StateScheduler) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if n := s.keys.UpdateStates(context.Background()); n > 0 {
+					s.logger.Debug("Updated named key states", "transitions", n)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler and waits for a running update to finish.
+func (s *StateScheduler) Stop(ctx context.Context) error {
+	close(s.stop)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,72 +1,163 @@
 package keyservice
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
 	"github.com/bajhalshrey/Key-Server-Application/internal/config"
 	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
 	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/tracing"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
 )
 
+// KeyTypeSymmetric is the key type produced by GenerateKey: raw random bytes.
+const KeyTypeSymmetric = "symmetric"
+
+// ErrPermissionDenied is returned (wrapped) when the policy engine rejects an operation.
+var ErrPermissionDenied = errors.New("permission denied")
+
 // KeyService defines the interface for key-related operations.
 type KeyService interface {
-	GenerateKey(length int) (string, error)
+	GenerateKey(ctx context.Context, length int) (string, error)
 }
 
 // concreteKeyService implements the KeyService interface.
 type concreteKeyService struct {
 	keyGenerator keygenerator.CryptoKeyGenerator
-	config       *config.Config
+	config       *config.Store
 	metrics      *metrics.PrometheusMetrics // Use the concrete struct pointer
+	policy       *policy.Engine
+	audit        *audit.Logger
+	tlog         *transparency.Log
+	logger       *slog.Logger
 }
 
 // NewKeyService creates and returns a new KeyService instance.
-// It returns the interface type.
+// It returns the interface type. Limits are read from the current snapshot in
+// cfg on every call, so reloaded settings apply to the next request.
 func NewKeyService(
 	kg keygenerator.CryptoKeyGenerator,
-	cfg *config.Config,
+	cfg *config.Store,
 	m *metrics.PrometheusMetrics,
+	pe *policy.Engine,
+	al *audit.Logger,
+	tl *transparency.Log,
+	logger *slog.Logger,
 ) KeyService {
 	return &concreteKeyService{
 		keyGenerator: kg,
 		config:       cfg,
 		metrics:      m,
+		policy:       pe,
+		audit:        al,
+		tlog:         tl,
+		logger:       logger,
 	}
 }
 
 // GenerateKey generates a new key of the specified length.
 // It returns the Base64 URL-encoded string of the key.
-func (s *concreteKeyService) GenerateKey(length int) (string, error) {
+// The principal in ctx (if any) must be allowed to generate a key of this size by policy.
+// Every attempt is audited; a key is only returned once its issuance is in the
+// transparency log and its audit entry is written.
+func (s *concreteKeyService) GenerateKey(ctx context.Context, length int) (key string, err error) {
+	ctx, span := otel.Tracer("github.com/bajhalshrey/Key-Server-Application/internal/keyservice").
+		Start(ctx, "KeyService.GenerateKey")
+	span.SetAttributes(tracing.KeyLength(length), attribute.String("key.type", KeyTypeSymmetric))
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
+
 	s.metrics.IncrementKeyGenerationRequests()
+	event := audit.Event{Operation: audit.OpGenerateKey, KeyType: KeyTypeSymmetric, KeyLength: length}
 
-	if length <= 0 || length > s.config.MaxSize {
+	maxSize := s.config.Current().MaxSize
+	if length <= 0 || length > maxSize {
 		s.metrics.IncrementInvalidKeyLengthErrors()
 		// It's generally better to define specific error types for known error conditions
 		// rather than relying on string comparison in the caller (handler.go).
 		// For now, given the current handler, this is acceptable, but something to consider.
-		return "", fmt.Errorf("key length %d is out of allowed range (1-%d)", length, s.config.MaxSize)
+		err := fmt.Errorf("key length %d is out of allowed range (1-%d)", length, maxSize)
+		event.Outcome, event.Reason = audit.OutcomeDenied, err.Error()
+		s.audit.RecordOrLog(ctx, event)
+		return "", err
+	}
+
+	if err := s.authorize(ctx, policy.Request{Action: policy.ActionGenerate, KeyType: KeyTypeSymmetric, KeySize: length}); err != nil {
+		event.Outcome, event.Reason = audit.OutcomeDenied, err.Error()
+		s.audit.RecordOrLog(ctx, event)
+		return "", err
 	}
 
 	start := time.Now()
-	keyBytes, err := s.keyGenerator.Generate(length) // keyGenerator.Generate returns []byte
+	keyBytes, err := s.keyGenerator.Generate(ctx, length) // keyGenerator.Generate returns []byte
 	duration := time.Since(start).Seconds()
 
-	s.metrics.ObserveKeyGenerationDuration(duration, length)
+	s.metrics.ObserveKeyGenerationDurationContext(ctx, duration, length)
 
 	if err != nil {
 		s.metrics.IncrementKeyGenerationErrors()
-		log.Printf("Error generating key: %v", err)
+		s.logger.ErrorContext(ctx, "Error generating key", "key_length", length, "error", err)
+		event.Outcome, event.Reason = audit.OutcomeError, err.Error()
+		s.audit.RecordOrLog(ctx, event)
 		return "", fmt.Errorf("failed to generate key: %w", err)
 	}
 
+	// Only issuance metadata is published; symmetric keys have no public part.
+	leaf := transparency.Leaf{Type: transparency.LeafSymmetricKey, KeyType: KeyTypeSymmetric, KeyLength: length}
+	if p, ok := auth.PrincipalFromContext(ctx); ok {
+		leaf.PrincipalDigest = transparency.PrincipalDigest(p.Name)
+	}
+	if _, err := s.tlog.Append(leaf); err != nil {
+		s.metrics.IncrementKeyGenerationErrors()
+		s.logger.ErrorContext(ctx, "Error appending to transparency log, withholding key", "key_length", length, "error", err)
+		event.Outcome, event.Reason = audit.OutcomeError, err.Error()
+		s.audit.RecordOrLog(ctx, event)
+		return "", fmt.Errorf("failed to publish key issuance: %w", err)
+	}
+
+	event.Outcome = audit.OutcomeSuccess
+	if err := s.audit.Record(ctx, event); err != nil {
+		s.metrics.IncrementKeyGenerationErrors()
+		s.logger.ErrorContext(ctx, "Error recording audit entry, withholding key", "key_length", length, "error", err)
+		return "", fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
 	s.metrics.ObserveKeyLength(float64(length))
 
 	return EncodeKey(keyBytes), nil // Encode the generated byte slice to a Base64 string
 }
 
+// authorize evaluates req for the principal in ctx, returning a wrapped
+// ErrPermissionDenied if the policy engine rejects it.
+func (s *concreteKeyService) authorize(ctx context.Context, req policy.Request) error {
+	return authorize(ctx, s.policy, s.metrics, req)
+}
+
+func authorize(ctx context.Context, pe *policy.Engine, m *metrics.PrometheusMetrics, req policy.Request) error {
+	req.Principal, _ = auth.PrincipalFromContext(ctx)
+	decision := pe.Evaluate(req)
+	if decision.Allowed {
+		return nil
+	}
+	m.RecordPolicyDenial(req.Action)
+	return fmt.Errorf("%w: %s", ErrPermissionDenied, decision.Reason)
+}
+
 // EncodeKey encodes a byte slice into a Base64 URL-safe string.
 // This function is EXPORTED (capital 'E').
 func EncodeKey(key []byte) string {
@@ -1,14 +1,25 @@
 package keyservice_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64" // <--- MOVED TO TOP
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings" // <--- MOVED TO TOP
 	"testing"
+	"time"
 
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
 	"github.com/bajhalshrey/Key-Server-Application/internal/config"
 	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
 	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -18,16 +29,24 @@ type MockKeyGenerator struct {
 }
 
 // Generate implements the CryptoKeyGenerator interface.
-func (m *MockKeyGenerator) Generate(length int) ([]byte, error) {
+func (m *MockKeyGenerator) Generate(ctx context.Context, length int) ([]byte, error) {
 	if m.GenerateFunc != nil {
 		return m.GenerateFunc(length)
 	}
 	return make([]byte, length), nil
 }
 
+func newTransparencyLog(t *testing.T) *transparency.Log {
+	tlog, err := transparency.Open(transparency.Options{})
+	if err != nil {
+		t.Fatalf("Could not open transparency log: %v", err)
+	}
+	return tlog
+}
+
 func TestKeyService_GenerateKey(t *testing.T) {
 	// Setup common mocks and configurations
-	dummyConfig := &config.Config{MaxSize: 64}
+	dummyConfig := config.NewStore(&config.Config{MaxSize: 64})
 	// Removed: registry := prometheus.NewRegistry() and mockMetrics := metrics.NewPrometheusMetricsWithRegistry(...)
 	// These are now correctly initialized within each t.Run to ensure isolation.
 
@@ -93,10 +112,10 @@ func TestKeyService_GenerateKey(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Ensure a new KeyService and metrics are created for each test run to avoid state leakage
 			currentRegistry := prometheus.NewRegistry()
-			currentMetrics := metrics.NewPrometheusMetricsWithRegistry(currentRegistry, dummyConfig.MaxSize)
-			service := keyservice.NewKeyService(tt.mockGen, dummyConfig, currentMetrics) // Pass mockMetrics here
+			currentMetrics := metrics.NewPrometheusMetricsWithRegistry(currentRegistry, dummyConfig.Current().MaxSize)
+			service := keyservice.NewKeyService(tt.mockGen, dummyConfig, currentMetrics, policy.Disabled(), audit.Disabled(), newTransparencyLog(t), logging.Discard()) // Pass mockMetrics here
 
-			key, err := service.GenerateKey(tt.keyLength)
+			key, err := service.GenerateKey(context.Background(), tt.keyLength)
 
 			if (err != nil) != tt.expectedErr {
 				t.Errorf("GenerateKey() error = %v, expectedErr %v", err, tt.expectedErr)
@@ -125,3 +144,485 @@ func TestKeyService_GenerateKey(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyService_GenerateKeyPolicy(t *testing.T) {
+	dummyConfig := config.NewStore(&config.Config{MaxSize: 64})
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "small-keys", Roles: []string{"generator"}, Actions: []string{policy.ActionGenerate}, KeyTypes: []string{keyservice.KeyTypeSymmetric}, MaxKeySize: 32},
+		{Name: "admins", Principals: []string{"admin"}, Actions: []string{policy.ActionGenerate}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		keyLength int
+		wantErr   bool
+	}{
+		{"Role within size limit", &auth.Principal{Name: "job", Roles: []string{"generator"}}, 32, false},
+		{"Role above size limit", &auth.Principal{Name: "job", Roles: []string{"generator"}}, 48, true},
+		{"Unlimited principal", &auth.Principal{Name: "admin"}, 64, false},
+		{"Unknown principal", &auth.Principal{Name: "intruder"}, 16, true},
+		{"Anonymous caller", nil, 16, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentMetrics := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), dummyConfig.Current().MaxSize)
+			service := keyservice.NewKeyService(&MockKeyGenerator{}, dummyConfig, currentMetrics, engine, audit.Disabled(), newTransparencyLog(t), logging.Discard())
+
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = auth.WithPrincipal(ctx, tt.principal)
+			}
+			_, err := service.GenerateKey(ctx, tt.keyLength)
+			if tt.wantErr {
+				if !errors.Is(err, keyservice.ErrPermissionDenied) {
+					t.Errorf("GenerateKey() error = %v, want ErrPermissionDenied", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GenerateKey() returned unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// failingSink rejects every audit entry.
+type failingSink struct{}
+
+func (failingSink) Write([]byte) error { return errors.New("disk full") }
+func (failingSink) Close() error       { return nil }
+
+func TestKeyService_GenerateKeyAudit(t *testing.T) {
+	dummyConfig := config.NewStore(&config.Config{MaxSize: 64})
+	newService := func(al *audit.Logger) keyservice.KeyService {
+		m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), dummyConfig.Current().MaxSize)
+		return keyservice.NewKeyService(&MockKeyGenerator{}, dummyConfig, m, policy.Disabled(), al, newTransparencyLog(t), logging.Discard())
+	}
+
+	t.Run("Entries never contain key material", func(t *testing.T) {
+		var buf bytes.Buffer
+		service := newService(audit.NewLogger(audit.NewWriterSink(&buf)))
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "job"})
+
+		key, err := service.GenerateKey(ctx, 32)
+		if err != nil {
+			t.Fatalf("GenerateKey() returned unexpected error: %v", err)
+		}
+		service.GenerateKey(ctx, 100)
+
+		log := buf.String()
+		if strings.Contains(log, key) {
+			t.Error("audit log contains the generated key")
+		}
+		for _, want := range []string{`"principal":"job"`, `"operation":"key.generate"`, `"outcome":"success"`, `"outcome":"denied"`} {
+			if !strings.Contains(log, want) {
+				t.Errorf("audit log missing %s:\n%s", want, log)
+			}
+		}
+		if sum, err := audit.Verify(&buf, ""); err != nil || sum.Entries != 2 {
+			t.Errorf("Verify() = %+v, %v; want 2 valid entries", sum, err)
+		}
+	})
+
+	t.Run("Key withheld when audit fails", func(t *testing.T) {
+		service := newService(audit.NewLogger(failingSink{}))
+		key, err := service.GenerateKey(context.Background(), 32)
+		if err == nil || key != "" {
+			t.Errorf("GenerateKey() = %q, %v; want no key and an error", key, err)
+		}
+	})
+}
+
+func TestKeyService_GenerateKeyTransparency(t *testing.T) {
+	dummyConfig := config.NewStore(&config.Config{MaxSize: 64})
+	tlog := newTransparencyLog(t)
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), dummyConfig.Current().MaxSize)
+	service := keyservice.NewKeyService(&MockKeyGenerator{}, dummyConfig, m, policy.Disabled(), audit.Disabled(), tlog, logging.Discard())
+
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "job"})
+	key, err := service.GenerateKey(ctx, 32)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned unexpected error: %v", err)
+	}
+	service.GenerateKey(ctx, 100) // Rejected requests are not published
+
+	if tlog.Size() != 1 {
+		t.Fatalf("transparency log has %d entries, want 1", tlog.Size())
+	}
+	entries, _ := tlog.Entries(0, 0)
+	var leaf transparency.Leaf
+	if err := json.Unmarshal(entries[0], &leaf); err != nil {
+		t.Fatalf("transparency log leaf is not valid JSON: %v", err)
+	}
+	if strings.Contains(string(entries[0]), key) || strings.Contains(string(entries[0]), "job") || !bytes.Equal(leaf.PrincipalDigest, transparency.PrincipalDigest("job")) {
+		t.Errorf("unexpected transparency log leaf: %s", entries[0])
+	}
+}
+
+func TestKeyService_ReloadedMaxSize(t *testing.T) {
+	store := config.NewStore(&config.Config{MaxSize: 64})
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 128)
+	service := keyservice.NewKeyService(&MockKeyGenerator{}, store, m, policy.Disabled(), audit.Disabled(), newTransparencyLog(t), logging.Discard())
+
+	if _, err := service.GenerateKey(context.Background(), 100); err == nil {
+		t.Fatal("expected an error for a key above the initial MaxSize")
+	}
+	store.Swap(&config.Config{MaxSize: 128})
+	if _, err := service.GenerateKey(context.Background(), 100); err != nil {
+		t.Errorf("GenerateKey() after raising MaxSize returned an error: %v", err)
+	}
+}
+
+func newNamedKeyService(t *testing.T, pe *policy.Engine, al *audit.Logger, tlog *transparency.Log) keyservice.NamedKeyService {
+	t.Helper()
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	return keyservice.NewNamedKeyService(keystore.NewStore(), config.NewStore(config.Defaults()), &MockKeyGenerator{}, m, pe, al, tlog, logging.Discard())
+}
+
+func TestNamedKeyService_CreateKey(t *testing.T) {
+	var buf bytes.Buffer
+	tlog := newTransparencyLog(t)
+	service := newNamedKeyService(t, policy.Disabled(), audit.NewLogger(audit.NewWriterSink(&buf)), tlog)
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "job"})
+
+	meta, err := service.CreateKey(ctx, keyservice.CreateKeyRequest{Name: "signer", Purpose: keystore.PurposeSign, Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatalf("CreateKey() returned unexpected error: %v", err)
+	}
+	if meta.Owner != "job" || meta.Algorithm != keystore.AlgorithmEd25519 || len(meta.PublicKey) == 0 {
+		t.Errorf("CreateKey() = %+v; want an Ed25519 key owned by job with a public key", meta)
+	}
+
+	tests := []struct {
+		name    string
+		req     keyservice.CreateKeyRequest
+		wantErr error
+	}{
+		{"Taken name", keyservice.CreateKeyRequest{Name: "signer", Purpose: keystore.PurposeSign}, keystore.ErrExists},
+		{"Unknown purpose", keyservice.CreateKeyRequest{Name: "k", Purpose: "wrap"}, keystore.ErrInvalid},
+		{"Mismatched algorithm", keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeMAC, Algorithm: keystore.AlgorithmAES128GCM}, keystore.ErrInvalid},
+		{"Past expiry", keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeMAC, ExpiresAt: time.Now().Add(-time.Hour)}, keystore.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.CreateKey(ctx, tt.req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateKey() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if tlog.Size() != 1 {
+		t.Errorf("transparency log has %d entries, want 1", tlog.Size())
+	}
+	for _, want := range []string{`"operation":"key.create"`, `"key_id":"signer"`, `"outcome":"success"`, `"outcome":"denied"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("audit log missing %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestNamedKeyService_Operations(t *testing.T) {
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "create", Principals: []string{"*"}, Actions: []string{policy.ActionGenerate}},
+		{Name: "payments", Principals: []string{"payments"}, Actions: []string{policy.ActionEncrypt, policy.ActionDecrypt}, Keys: []string{"payments-*"}},
+		{Name: "verifiers", Principals: []string{"*"}, Actions: []string{policy.ActionVerify}},
+		{Name: "own-keys", Principals: []string{"*"}, Actions: []string{policy.ActionRead}, Owners: []string{policy.PrincipalVariable}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+	service := newNamedKeyService(t, engine, audit.Disabled(), newTransparencyLog(t))
+	as := func(name string) context.Context {
+		return auth.WithPrincipal(context.Background(), &auth.Principal{Name: name})
+	}
+	for _, req := range []keyservice.CreateKeyRequest{
+		{Name: "payments-enc", Purpose: keystore.PurposeEncrypt},
+		{Name: "other-enc", Purpose: keystore.PurposeEncrypt},
+		{Name: "mac", Purpose: keystore.PurposeMAC},
+	} {
+		if _, err := service.CreateKey(as("admin"), req); err != nil {
+			t.Fatalf("CreateKey(%s) returned an error: %v", req.Name, err)
+		}
+	}
+
+	ciphertext, err := service.Encrypt(as("payments"), "payments-enc", []byte("hello"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() returned unexpected error: %v", err)
+	}
+	if plaintext, err := service.Decrypt(as("payments"), "payments-enc", ciphertext, nil); err != nil || string(plaintext) != "hello" {
+		t.Errorf("Decrypt() = %q, %v; want hello", plaintext, err)
+	}
+
+	tests := []struct {
+		name    string
+		op      func() error
+		wantErr error
+	}{
+		// Callers that may not read a key cannot tell it from a missing one.
+		{"Key outside the rule's globs", func() error {
+			_, err := service.Encrypt(as("payments"), "other-enc", []byte("hello"), nil)
+			return err
+		}, keystore.ErrNotFound},
+		{"Principal without the action", func() error {
+			_, err := service.Decrypt(as("intruder"), "payments-enc", ciphertext, nil)
+			return err
+		}, keystore.ErrNotFound},
+		{"Reader without the action", func() error {
+			_, err := service.Encrypt(as("admin"), "payments-enc", []byte("hello"), nil)
+			return err
+		}, keyservice.ErrPermissionDenied},
+		{"Missing key", func() error {
+			_, err := service.Encrypt(as("payments"), "payments-missing", []byte("hello"), nil)
+			return err
+		}, keystore.ErrNotFound},
+		{"Wrong purpose", func() error {
+			_, err := service.Verify(as("payments"), "payments-enc", []byte("hello"), nil)
+			return err
+		}, keystore.ErrWrongPurpose},
+		{"Owner reads key", func() error {
+			_, err := service.GetKey(as("admin"), "payments-enc")
+			return err
+		}, nil},
+		{"Other principal reads key", func() error {
+			_, err := service.GetKey(as("payments"), "payments-enc")
+			return err
+		}, keystore.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	sel, _ := keystore.ParseSelector("")
+	if keys, _ := service.ListKeys(as("admin"), sel); len(keys) != 3 {
+		t.Errorf("ListKeys() returned %d keys to their owner, want 3", len(keys))
+	}
+	if keys, _ := service.ListKeys(as("payments"), sel); len(keys) != 0 {
+		t.Errorf("ListKeys() returned %d keys to another principal, want 0", len(keys))
+	}
+}
+
+func TestNamedKeyService_RotateKey(t *testing.T) {
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "own-keys", Principals: []string{"*"}, Actions: []string{policy.ActionGenerate, policy.ActionRotate, policy.ActionEncrypt, policy.ActionDecrypt}, Owners: []string{policy.PrincipalVariable}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+	var buf bytes.Buffer
+	tlog := newTransparencyLog(t)
+	var fill byte
+	gen := &MockKeyGenerator{GenerateFunc: func(length int) ([]byte, error) {
+		fill++
+		return bytes.Repeat([]byte{fill}, length), nil
+	}}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	service := keyservice.NewNamedKeyService(keystore.NewStore(), config.NewStore(config.Defaults()), gen, m, engine, audit.NewLogger(audit.NewWriterSink(&buf)), tlog, logging.Discard())
+	owner := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "job"})
+
+	if _, err := service.CreateKey(owner, keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeEncrypt, Labels: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatalf("CreateKey() returned an error: %v", err)
+	}
+	old, err := service.Encrypt(owner, "k", []byte("hello"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() returned an error: %v", err)
+	}
+
+	intruder := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "intruder"})
+	if _, err := service.RotateKey(intruder, "k"); !errors.Is(err, keystore.ErrNotFound) {
+		t.Errorf("RotateKey() by another principal error = %v, want %v", err, keystore.ErrNotFound)
+	}
+	meta, err := service.RotateKey(owner, "k")
+	if err != nil {
+		t.Fatalf("RotateKey() returned an error: %v", err)
+	}
+	if meta.Version != 2 || meta.Owner != "job" || meta.Labels["env"] != "prod" || meta.State != keystore.StateActive {
+		t.Errorf("RotateKey() = %+v; want active version 2 owned by job with the same labels", meta)
+	}
+	if plaintext, err := service.Decrypt(owner, "k", old, nil); err != nil || string(plaintext) != "hello" {
+		t.Errorf("Decrypt() of data from version 1 = %q, %v; want hello", plaintext, err)
+	}
+	if fill != 2 {
+		t.Errorf("key material was drawn %d times, want 2", fill)
+	}
+	if tlog.Size() != 2 {
+		t.Errorf("transparency log has %d entries, want 2", tlog.Size())
+	}
+	for _, want := range []string{`"operation":"key.rotate"`, `"reason":"rotated"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("audit log missing %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestNamedKeyService_CreateKeyDenied(t *testing.T) {
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "payments", Principals: []string{"payments"}, Actions: []string{policy.ActionGenerate}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+	gen := &MockKeyGenerator{GenerateFunc: func(length int) ([]byte, error) {
+		t.Error("key material was drawn for a denied request")
+		return make([]byte, length), nil
+	}}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	service := keyservice.NewNamedKeyService(keystore.NewStore(), config.NewStore(config.Defaults()), gen, m, engine, audit.Disabled(), newTransparencyLog(t), logging.Discard())
+
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "intruder"})
+	if _, err := service.CreateKey(ctx, keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeMAC}); !errors.Is(err, keyservice.ErrPermissionDenied) {
+		t.Errorf("CreateKey() error = %v, want %v", err, keyservice.ErrPermissionDenied)
+	}
+}
+
+func TestNamedKeyService_AuditFailure(t *testing.T) {
+	service := newNamedKeyService(t, policy.Disabled(), audit.NewLogger(failingSink{}), newTransparencyLog(t))
+	if _, err := service.CreateKey(context.Background(), keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeMAC}); err == nil {
+		t.Fatal("CreateKey() succeeded without an audit entry")
+	}
+	if _, err := service.GetKey(context.Background(), "k"); !errors.Is(err, keystore.ErrNotFound) {
+		t.Errorf("GetKey() error = %v; the key must not be stored when auditing fails", err)
+	}
+}
+
+func TestNamedKeyService_CryptoPeriods(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.CryptoPeriods = []config.CryptoPeriod{
+		{KeyType: keystore.AlgorithmAES256GCM, MaxActivePeriod: 90 * 24 * time.Hour, MaxUsagePeriod: 365 * 24 * time.Hour},
+		{KeyType: "*", MaxUsagePeriod: 30 * 24 * time.Hour},
+	}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	service := keyservice.NewNamedKeyService(keystore.NewStore(), config.NewStore(cfg), &MockKeyGenerator{}, m, policy.Disabled(), audit.Disabled(), newTransparencyLog(t), logging.Discard())
+	activates := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		req         keyservice.CreateKeyRequest
+		wantExpires time.Duration // After activation
+		wantDestroy time.Duration
+		wantErr     bool
+	}{
+		{"Defaults to the active period", keyservice.CreateKeyRequest{Purpose: keystore.PurposeEncrypt}, 90 * 24 * time.Hour, 365 * 24 * time.Hour, false},
+		{"Shorter expiry kept", keyservice.CreateKeyRequest{Purpose: keystore.PurposeEncrypt, ExpiresAt: activates.Add(time.Hour)}, time.Hour, 365 * 24 * time.Hour, false},
+		{"Expiry beyond the active period", keyservice.CreateKeyRequest{Purpose: keystore.PurposeEncrypt, ExpiresAt: activates.Add(91 * 24 * time.Hour)}, 0, 0, true},
+		{"Fallback usage period", keyservice.CreateKeyRequest{Purpose: keystore.PurposeSign}, 30 * 24 * time.Hour, 30 * 24 * time.Hour, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Name = fmt.Sprintf("key-%d", i)
+			tt.req.ActivatesAt = activates
+			meta, err := service.CreateKey(context.Background(), tt.req)
+			if tt.wantErr {
+				if !errors.Is(err, keystore.ErrInvalid) {
+					t.Errorf("CreateKey() error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateKey() returned unexpected error: %v", err)
+			}
+			if meta.State != keystore.StatePreActive {
+				t.Errorf("State = %s, want %s", meta.State, keystore.StatePreActive)
+			}
+			if meta.ExpiresAt == nil || meta.ExpiresAt.Sub(activates) != tt.wantExpires {
+				t.Errorf("ExpiresAt = %v, want activation + %s", meta.ExpiresAt, tt.wantExpires)
+			}
+			if meta.DestroysAt == nil || meta.DestroysAt.Sub(activates) != tt.wantDestroy {
+				t.Errorf("DestroysAt = %v, want activation + %s", meta.DestroysAt, tt.wantDestroy)
+			}
+		})
+	}
+}
+
+func TestNamedKeyService_UsageLimits(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.Defaults()
+	cfg.UsageLimits = []config.UsageLimit{
+		{KeyType: keystore.AlgorithmHMACSHA256, MaxOperations: 1},
+		{KeyType: "*", MaxBytes: 5, OnLimit: keystore.OnLimitRefuse},
+	}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	service := keyservice.NewNamedKeyService(keystore.NewStore(), config.NewStore(cfg), &MockKeyGenerator{}, m, policy.Disabled(), audit.NewLogger(audit.NewWriterSink(&buf)), newTransparencyLog(t), logging.Discard())
+	ctx := context.Background()
+
+	for _, req := range []keyservice.CreateKeyRequest{{Name: "mac", Purpose: keystore.PurposeMAC}, {Name: "signer", Purpose: keystore.PurposeSign}} {
+		if _, err := service.CreateKey(ctx, req); err != nil {
+			t.Fatalf("CreateKey(%s) returned unexpected error: %v", req.Name, err)
+		}
+	}
+
+	if _, err := service.Sign(ctx, "mac", []byte("hello")); err != nil {
+		t.Fatalf("first Sign() returned unexpected error: %v", err)
+	}
+	if _, err := service.Sign(ctx, "mac", []byte("hello")); !errors.Is(err, keystore.ErrExpired) {
+		t.Errorf("Sign() past the limit error = %v, want ErrExpired", err)
+	}
+	if n := service.UpdateStates(ctx); n != 1 {
+		t.Errorf("UpdateStates() = %d, want 1", n)
+	}
+	if !strings.Contains(buf.String(), `"reason":"usage limit reached"`) {
+		t.Errorf("audit log does not record the usage limit as the reason:\n%s", buf.String())
+	}
+
+	_, err := service.Sign(ctx, "signer", []byte("hello!"))
+	if !errors.Is(err, keystore.ErrUsageLimit) || !keyservice.IsRejection(err) {
+		t.Errorf("Sign() over the byte limit error = %v, want a rejection with ErrUsageLimit", err)
+	}
+	meta, err := service.GetKey(ctx, "signer")
+	if err != nil {
+		t.Fatalf("GetKey() returned unexpected error: %v", err)
+	}
+	if meta.State != keystore.StateActive || meta.Usage.Operations[keystore.OpSign] != 0 {
+		t.Errorf("GetKey() = state %s, %d signatures; want an active key with none", meta.State, meta.Usage.Operations[keystore.OpSign])
+	}
+}
+
+func TestNamedKeyService_States(t *testing.T) {
+	var buf bytes.Buffer
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "all", Principals: []string{"*"}, Actions: []string{policy.ActionGenerate, policy.ActionRead, policy.ActionEncrypt, policy.ActionDecrypt}},
+		{Name: "security", Principals: []string{"security"}, Actions: []string{policy.ActionManage}},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+	service := newNamedKeyService(t, engine, audit.NewLogger(audit.NewWriterSink(&buf)), newTransparencyLog(t))
+	as := func(name string) context.Context {
+		return auth.WithPrincipal(context.Background(), &auth.Principal{Name: name})
+	}
+	if _, err := service.CreateKey(as("app"), keyservice.CreateKeyRequest{Name: "k", Purpose: keystore.PurposeEncrypt}); err != nil {
+		t.Fatalf("CreateKey() returned an error: %v", err)
+	}
+	if _, err := service.CreateKey(as("app"), keyservice.CreateKeyRequest{Name: "short", Purpose: keystore.PurposeEncrypt, ExpiresAt: time.Now().Add(20 * time.Millisecond)}); err != nil {
+		t.Fatalf("CreateKey() returned an error: %v", err)
+	}
+
+	if _, err := service.SetState(as("app"), "k", keystore.StateCompromised, "leaked"); !errors.Is(err, keyservice.ErrPermissionDenied) {
+		t.Errorf("SetState() without the manage action error = %v, want ErrPermissionDenied", err)
+	}
+	meta, err := service.SetState(as("security"), "k", keystore.StateCompromised, "leaked")
+	if err != nil || meta.State != keystore.StateCompromised {
+		t.Fatalf("SetState() = %+v, %v; want a compromised key", meta, err)
+	}
+	if _, err := service.Encrypt(as("app"), "k", []byte("hello"), nil); !errors.Is(err, keystore.ErrState) {
+		t.Errorf("Encrypt() with a compromised key error = %v, want ErrState", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n := service.UpdateStates(context.Background()); n != 1 {
+		t.Errorf("UpdateStates() made %d transitions, want 1", n)
+	}
+	for _, want := range []string{`"operation":"key.state"`, `"key_state":"compromised"`, `"reason":"leaked"`, `"principal":"key-scheduler"`, `"key_state":"deactivated"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("audit log missing %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// Package keystore holds named keys together with their metadata: what a
+// key is for, who owns it, how it is labeled and where it is in its life
+// cycle. A key can only be used for the operations of its purpose, so a
+// signing key cannot encrypt and an encryption key cannot sign, and only
+// for the operations its state allows.
+//
+// A key can be rotated: a new version with fresh material takes over its
+// name, while the versions it replaced still decrypt and verify the data
+// they protected until they are destroyed. Compromising or destroying a key
+// does the same to every version it replaced.
+//
+// Keys are kept in memory and are lost on restart.
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Purposes a key can be created for.
+const (
+	PurposeEncrypt = "encrypt" // Encrypt and decrypt
+	PurposeSign    = "sign"    // Sign and verify with a key pair
+	PurposeMAC     = "mac"     // Compute and verify message authentication codes
+)
+
+// Supported algorithms.
+const (
+	AlgorithmAES256GCM  = "aes-256-gcm"
+	AlgorithmAES128GCM  = "aes-128-gcm"
+	AlgorithmEd25519    = "ed25519"
+	AlgorithmHMACSHA256 = "hmac-sha256"
+)
+
+// algorithm describes what an algorithm is used for and how much key
+// material it takes.
+type algorithm struct {
+	purpose string
+	size    int // Bytes of key material (the seed for Ed25519)
+}
+
+var algorithms = map[string]algorithm{
+	AlgorithmAES256GCM:  {PurposeEncrypt, 32},
+	AlgorithmAES128GCM:  {PurposeEncrypt, 16},
+	AlgorithmEd25519:    {PurposeSign, ed25519.SeedSize},
+	AlgorithmHMACSHA256: {PurposeMAC, 32},
+}
+
+// defaultAlgorithms are used when a key is created without an algorithm.
+var defaultAlgorithms = map[string]string{
+	PurposeEncrypt: AlgorithmAES256GCM,
+	PurposeSign:    AlgorithmEd25519,
+	PurposeMAC:     AlgorithmHMACSHA256,
+}
+
+// Errors returned by the store and by key operations.
+var (
+	ErrNotFound     = errors.New("key not found")
+	ErrExists       = errors.New("key already exists")
+	ErrInvalid      = errors.New("invalid key metadata")
+	ErrWrongPurpose = errors.New("operation not allowed by the key's purpose")
+	ErrExpired      = errors.New("key has expired")
+	ErrState        = errors.New("operation not allowed in the key's state")
+	ErrDecrypt      = errors.New("ciphertext could not be decrypted")
+)
+
+const (
+	maxNameLength = 128
+	maxLabels     = 64
+)
+
+// Metadata describes a named key. It never includes secret key material.
+type Metadata struct {
+	Name      string            `json:"name"`
+	Version   int               `json:"version"` // 1 when created, incremented by each rotation
+	Labels    map[string]string `json:"labels,omitempty"`
+	Owner     string            `json:"owner"` // Principal that created the key
+	Purpose   string            `json:"purpose"`
+	Algorithm string            `json:"algorithm"`
+	CreatedAt time.Time         `json:"created_at"`
+	PublicKey []byte            `json:"public_key,omitempty"` // PKIX DER public key of signing keys
+
+	State          string     `json:"state"`
+	StateChangedAt time.Time  `json:"state_changed_at"`
+	StateReason    string     `json:"state_reason,omitempty"` // Given when the state was changed by hand
+	ActivatesAt    time.Time  `json:"activates_at"`           // End of the pre-active state; defaults to CreatedAt
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`   // Deactivation time; nil means the key stays active
+	DestroysAt     *time.Time `json:"destroys_at,omitempty"`  // When the key material is destroyed; nil means never
+
+	Usage      Usage       `json:"usage"`                 // Filled in by Key.Metadata
+	UsageLimit *UsageLimit `json:"usage_limit,omitempty"` // nil means unlimited
+}
+
+// KeySize returns the number of bytes of key material the algorithm takes,
+// or 0 for an unknown algorithm.
+func KeySize(alg string) int {
+	return algorithms[alg].size
+}
+
+// DefaultAlgorithm returns the algorithm used for purpose when none is
+// given, or "" for an unknown purpose.
+func DefaultAlgorithm(purpose string) string {
+	return defaultAlgorithms[purpose]
+}
+
+// validate checks the metadata set by the caller.
+func (m *Metadata) validate() error {
+	if err := validateName(m.Name); err != nil {
+		return err
+	}
+	alg, ok := algorithms[m.Algorithm]
+	if !ok {
+		return fmt.Errorf("%w: unknown algorithm %q", ErrInvalid, m.Algorithm)
+	}
+	if alg.purpose != m.Purpose {
+		return fmt.Errorf("%w: algorithm %s cannot be used for purpose %q", ErrInvalid, m.Algorithm, m.Purpose)
+	}
+	if m.ActivatesAt.Before(m.CreatedAt) {
+		return fmt.Errorf("%w: activation time must not be before the creation time", ErrInvalid)
+	}
+	if m.ExpiresAt != nil && !m.ExpiresAt.After(m.ActivatesAt) {
+		return fmt.Errorf("%w: expiry time must be after the activation time", ErrInvalid)
+	}
+	if m.DestroysAt != nil && (m.ExpiresAt == nil || m.DestroysAt.Before(*m.ExpiresAt)) {
+		return fmt.Errorf("%w: destruction time must not be before the expiry time", ErrInvalid)
+	}
+	return validateLabels(m.Labels)
+}
+
+// validateName accepts 1 to 128 letters, digits, '.', '_' and '-', starting
+// with a letter or digit, so names are safe in URL paths and policy globs.
+func validateName(name string) error {
+	if name == "" || len(name) > maxNameLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxNameLength)
+	}
+	for i, c := range name {
+		if isAlphanumeric(c) || (i > 0 && (c == '.' || c == '_' || c == '-')) {
+			continue
+		}
+		return fmt.Errorf("%w: name %q must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", ErrInvalid, name)
+	}
+	return nil
+}
+
+// Key is a named key: its metadata and its secret material.
+type Key struct {
+	mu       sync.RWMutex // Guards the state and the material, which is erased on destruction
+	meta     Metadata
+	material []byte
+	signer   ed25519.PrivateKey // For signing keys
+	usage    usage
+	previous *Key // Version this one replaced; set before the key is stored
+}
+
+// NewKey validates meta and creates a key from material, which must be
+// KeySize(meta.Algorithm) random bytes. The creation time defaults to now
+// and the activation time to the creation time. The key starts out
+// pre-active if it activates later and active otherwise.
+func NewKey(meta Metadata, material []byte) (*Key, error) {
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now().UTC()
+	}
+	if meta.ActivatesAt.IsZero() {
+		meta.ActivatesAt = meta.CreatedAt
+	}
+	meta.State, meta.StateChangedAt, meta.StateReason = StateActive, meta.ActivatesAt, ""
+	if meta.ActivatesAt.After(meta.CreatedAt) {
+		meta.State, meta.StateChangedAt = StatePreActive, meta.CreatedAt
+	}
+	meta.Version, meta.PublicKey, meta.Usage = 1, nil, Usage{}
+	if err := meta.validate(); err != nil {
+		return nil, err
+	}
+	if err := applyUsageLimit(&meta); err != nil {
+		return nil, err
+	}
+	if len(material) != algorithms[meta.Algorithm].size {
+		return nil, fmt.Errorf("%w: %s takes %d bytes of key material, got %d", ErrInvalid, meta.Algorithm, algorithms[meta.Algorithm].size, len(material))
+	}
+	meta.Labels = cloneLabels(meta.Labels)
+
+	k := &Key{meta: meta, material: append([]byte(nil), material...)}
+	if meta.Algorithm == AlgorithmEd25519 {
+		k.signer = ed25519.NewKeyFromSeed(material)
+		pub, err := x509.MarshalPKIXPublicKey(k.signer.Public())
+		if err != nil {
+			return nil, err
+		}
+		k.meta.PublicKey = pub
+	}
+	return k, nil
+}
+
+// Metadata returns a copy of the key's metadata. The state is the one the
+// key is in now, even if the scheduler has not recorded it yet.
+func (k *Key) Metadata() Metadata {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	m := k.meta
+	m.Labels = cloneLabels(m.Labels)
+	m.State, m.StateChangedAt = k.stateAt(time.Now())
+	m.Usage = k.usage.snapshot(m.Purpose)
+	if m.UsageLimit != nil {
+		l := *m.UsageLimit
+		m.UsageLimit = &l
+	}
+	return m
+}
+
+// State returns the key's state at now.
+func (k *Key) State(now time.Time) string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	state, _ := k.stateAt(now)
+	return state
+}
+
+// Encrypt seals plaintext with AES-GCM, authenticating aad as well. The
+// result is the random 12-byte nonce followed by the ciphertext and tag.
+func (k *Key) Encrypt(plaintext, aad []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if err := k.checkUse(PurposeEncrypt, true, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := k.reserve(OpEncrypt, len(plaintext)); err != nil {
+		return nil, err
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with the same aad, by this
+// version of the key or one it replaced. Earlier versions are only tried
+// when this one is usable but cannot open the ciphertext. The decryption is
+// counted against the version that opens it, or this one if none does.
+func (k *Key) Decrypt(ciphertext, aad []byte) ([]byte, error) {
+	plaintext, err := k.decrypt(ciphertext, aad)
+	if errors.Is(err, ErrDecrypt) {
+		for v := k.previous; v != nil; v = v.previous {
+			if p, verr := v.decrypt(ciphertext, aad); verr == nil {
+				v.count(OpDecrypt, len(ciphertext))
+				return p, nil
+			}
+		}
+	}
+	if err == nil || errors.Is(err, ErrDecrypt) {
+		k.count(OpDecrypt, len(ciphertext))
+	}
+	return plaintext, err
+}
+
+// decrypt opens ciphertext with this version alone, without counting it.
+func (k *Key) decrypt(ciphertext, aad []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if err := k.checkUse(PurposeEncrypt, false, time.Now()); err != nil {
+		return nil, err
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(ciphertext) < n+aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:n], ciphertext[n:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func (k *Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Sign signs message with a signing key (Ed25519) or computes its MAC with
+// a MAC key (HMAC-SHA256).
+func (k *Key) Sign(message []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	switch k.meta.Purpose {
+	case PurposeSign:
+		if err := k.checkUse(PurposeSign, true, time.Now()); err != nil {
+			return nil, err
+		}
+		if err := k.reserve(OpSign, len(message)); err != nil {
+			return nil, err
+		}
+		return ed25519.Sign(k.signer, message), nil
+	case PurposeMAC:
+		if err := k.checkUse(PurposeMAC, true, time.Now()); err != nil {
+			return nil, err
+		}
+		if err := k.reserve(OpSign, len(message)); err != nil {
+			return nil, err
+		}
+		return k.mac(message), nil
+	}
+	return nil, fmt.Errorf("%w: key %s is for %s", ErrWrongPurpose, k.meta.Name, k.meta.Purpose)
+}
+
+// Verify checks a signature or MAC produced by Sign with this version of
+// the key or one it replaced. Earlier versions are only tried when this one
+// is usable but rejects the signature. The verification is counted against
+// the version that accepts it, or this one if none does.
+func (k *Key) Verify(message, signature []byte) (bool, error) {
+	valid, err := k.verify(message, signature)
+	if err != nil {
+		return false, err
+	}
+	if !valid {
+		for v := k.previous; v != nil; v = v.previous {
+			if ok, verr := v.verify(message, signature); verr == nil && ok {
+				v.count(OpVerify, len(message))
+				return true, nil
+			}
+		}
+	}
+	k.count(OpVerify, len(message))
+	return valid, nil
+}
+
+// verify checks signature with this version alone, without counting it.
+func (k *Key) verify(message, signature []byte) (bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	switch k.meta.Purpose {
+	case PurposeSign:
+		if err := k.checkUse(PurposeSign, false, time.Now()); err != nil {
+			return false, err
+		}
+		return ed25519.Verify(k.signer.Public().(ed25519.PublicKey), message, signature), nil
+	case PurposeMAC:
+		if err := k.checkUse(PurposeMAC, false, time.Now()); err != nil {
+			return false, err
+		}
+		return hmac.Equal(k.mac(message), signature), nil
+	}
+	return false, fmt.Errorf("%w: key %s is for %s", ErrWrongPurpose, k.meta.Name, k.meta.Purpose)
+}
+
+func (k *Key) mac(message []byte) []byte {
+	h := hmac.New(sha256.New, k.material)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// Store holds named keys in memory. It is safe for concurrent use.
+type Store struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{keys: make(map[string]*Key)}
+}
+
+// Add stores k, failing with ErrExists if its name is taken.
+func (s *Store) Add(k *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.meta.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, k.meta.Name)
+	}
+	s.keys[k.meta.Name] = k
+	return nil
+}
+
+// Rotate makes k the new version of the key with its name, deactivating the
+// version it replaces if that is still active. It returns the transitions
+// made on the replaced version.
+func (s *Store) Rotate(k *Key, now time.Time) ([]Transition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.keys[k.meta.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, k.meta.Name)
+	}
+	if k.meta.Purpose != current.meta.Purpose {
+		return nil, fmt.Errorf("%w: key %s is for %s, not %s", ErrInvalid, k.meta.Name, current.meta.Purpose, k.meta.Purpose)
+	}
+	transitions, err := current.retire(now)
+	if err != nil {
+		return nil, err
+	}
+	k.meta.Version = current.meta.Version + 1
+	k.previous = current
+	s.keys[k.meta.Name] = k
+	return transitions, nil
+}
+
+// SelfTest checks that the store can be read and that keys of every purpose
+// work: a throwaway key of each purpose's default algorithm must round-trip
+// an encryption or a signature. The throwaway keys are not stored.
+func (s *Store) SelfTest() error {
+	// A store wedged by a held lock fails the check by timing out.
+	s.mu.RLock()
+	initialized := s.keys != nil
+	s.mu.RUnlock()
+	if !initialized {
+		return errors.New("key store is not initialized")
+	}
+
+	message := []byte("key store self-test")
+	for _, purpose := range []string{PurposeEncrypt, PurposeSign, PurposeMAC} {
+		alg := DefaultAlgorithm(purpose)
+		k, err := NewKey(Metadata{Name: "self-test", Purpose: purpose, Algorithm: alg}, make([]byte, KeySize(alg)))
+		if err != nil {
+			return fmt.Errorf("%s key: %w", alg, err)
+		}
+		if purpose == PurposeEncrypt {
+			ciphertext, err := k.Encrypt(message, nil)
+			if err != nil {
+				return fmt.Errorf("%s encrypt: %w", alg, err)
+			}
+			plaintext, err := k.Decrypt(ciphertext, nil)
+			if err != nil || !bytes.Equal(plaintext, message) {
+				return fmt.Errorf("%s does not decrypt what it encrypted: %v", alg, err)
+			}
+			continue
+		}
+		signature, err := k.Sign(message)
+		if err != nil {
+			return fmt.Errorf("%s sign: %w", alg, err)
+		}
+		if valid, err := k.Verify(message, signature); err != nil || !valid {
+			return fmt.Errorf("%s does not verify what it signed: %v", alg, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the current version of the key called name.
+func (s *Store) Get(name string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return k, nil
+}
+
+// List returns the metadata of the current versions of the keys whose
+// labels match sel, sorted by name.
+func (s *Store) List(sel Selector) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Metadata, 0, len(s.keys))
+	for _, k := range s.keys {
+		if sel.Matches(k.meta.Labels) {
+			list = append(list, k.Metadata())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Advance records the state transitions that are due by now on every
+// version of every key and returns them.
+func (s *Store) Advance(now time.Time) []Transition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var all []Transition
+	for _, k := range s.keys {
+		for v := k; v != nil; v = v.previous {
+			all = append(all, v.advance(now)...)
+		}
+	}
+	// Stable, so each key's transitions stay in order.
+	sort.SliceStable(all, func(i, j int) bool { return all[i].At.Before(all[j].At) })
+	return all
+}
+
+// SetState moves the key called name to state (deactivated, compromised or
+// destroyed) at now, recording reason. Compromise and destruction also
+// apply to the versions the key replaced, which still hold material that
+// decrypts and verifies; versions already in that state or past it are
+// left as they are. It returns every transition made, including scheduled
+// ones that were due first.
+func (s *Store) SetState(name, state, reason string, now time.Time) ([]Transition, error) {
+	// Held throughout so that a rotation cannot slip a version in between.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	transitions, err := k.setState(state, reason, now)
+	if err != nil {
+		return nil, err
+	}
+	if state == StateCompromised || state == StateDestroyed {
+		for v := k.previous; v != nil; v = v.previous {
+			if t, err := v.setState(state, reason, now); err == nil {
+				transitions = append(transitions, t...)
+			}
+		}
+	}
+	return transitions, nil
+}
+
+func cloneLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
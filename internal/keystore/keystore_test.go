@@ -0,0 +1,539 @@
+package keystore_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+)
+
+func newKey(t *testing.T, name, purpose string, labels map[string]string) *keystore.Key {
+	t.Helper()
+	alg := keystore.DefaultAlgorithm(purpose)
+	k, err := keystore.NewKey(keystore.Metadata{Name: name, Purpose: purpose, Algorithm: alg, Labels: labels}, bytes.Repeat([]byte{7}, keystore.KeySize(alg)))
+	if err != nil {
+		t.Fatalf("NewKey(%s) returned an error: %v", name, err)
+	}
+	return k
+}
+
+func TestNewKey_Validation(t *testing.T) {
+	valid := keystore.Metadata{Name: "payments-2026", Purpose: keystore.PurposeEncrypt, Algorithm: keystore.AlgorithmAES256GCM}
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		modify   func(m *keystore.Metadata)
+		material int // Bytes of key material; 0 means the algorithm's size
+		wantErr  bool
+	}{
+		{"Valid", func(m *keystore.Metadata) {}, 0, false},
+		{"Labels", func(m *keystore.Metadata) { m.Labels = map[string]string{"team": "payments", "app.io/tier": "gold"} }, 0, false},
+		{"Empty name", func(m *keystore.Metadata) { m.Name = "" }, 0, true},
+		{"Name with slash", func(m *keystore.Metadata) { m.Name = "a/b" }, 0, true},
+		{"Name starting with dot", func(m *keystore.Metadata) { m.Name = ".hidden" }, 0, true},
+		{"Name too long", func(m *keystore.Metadata) { m.Name = strings.Repeat("k", 129) }, 0, true},
+		{"Unknown algorithm", func(m *keystore.Metadata) { m.Algorithm = "des" }, 0, true},
+		{"Algorithm of another purpose", func(m *keystore.Metadata) { m.Algorithm = keystore.AlgorithmEd25519 }, 0, true},
+		{"Expiry before creation", func(m *keystore.Metadata) { m.ExpiresAt = &past }, 0, true},
+		{"Invalid label key", func(m *keystore.Metadata) { m.Labels = map[string]string{"-team": "x"} }, 0, true},
+		{"Invalid label value", func(m *keystore.Metadata) { m.Labels = map[string]string{"team": "a b"} }, 0, true},
+		{"Usage limit", func(m *keystore.Metadata) { m.UsageLimit = &keystore.UsageLimit{MaxBytes: 1 << 30} }, 0, false},
+		{"Unknown on_limit", func(m *keystore.Metadata) { m.UsageLimit = &keystore.UsageLimit{MaxBytes: 1, OnLimit: "rotate"} }, 0, true},
+		{"Wrong material size", func(m *keystore.Metadata) {}, 16, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid
+			tt.modify(&m)
+			size := tt.material
+			if size == 0 {
+				size = keystore.KeySize(m.Algorithm)
+			}
+			_, err := keystore.NewKey(m, make([]byte, size))
+			if tt.wantErr {
+				if !errors.Is(err, keystore.ErrInvalid) {
+					t.Errorf("NewKey() error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewKey() returned unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestKey_Purpose(t *testing.T) {
+	enc := newKey(t, "enc", keystore.PurposeEncrypt, nil)
+	sig := newKey(t, "sig", keystore.PurposeSign, nil)
+	mac := newKey(t, "mac", keystore.PurposeMAC, nil)
+	msg := []byte("hello")
+
+	ciphertext, err := enc.Encrypt(msg, []byte("aad"))
+	if err != nil {
+		t.Fatalf("Encrypt() returned an error: %v", err)
+	}
+	if got, err := enc.Decrypt(ciphertext, []byte("aad")); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("Decrypt() = %q, %v; want %q", got, err, msg)
+	}
+	if _, err := enc.Decrypt(ciphertext, []byte("other")); !errors.Is(err, keystore.ErrDecrypt) {
+		t.Errorf("Decrypt() with other aad error = %v, want ErrDecrypt", err)
+	}
+
+	for _, k := range []*keystore.Key{sig, mac} {
+		signature, err := k.Sign(msg)
+		if err != nil {
+			t.Fatalf("Sign() with %s returned an error: %v", k.Metadata().Name, err)
+		}
+		if ok, err := k.Verify(msg, signature); !ok || err != nil {
+			t.Errorf("Verify() with %s = %v, %v; want true", k.Metadata().Name, ok, err)
+		}
+		if ok, _ := k.Verify([]byte("tampered"), signature); ok {
+			t.Errorf("Verify() with %s accepted a tampered message", k.Metadata().Name)
+		}
+	}
+	if len(sig.Metadata().PublicKey) == 0 {
+		t.Error("signing key has no public key")
+	}
+
+	wrong := []struct {
+		name string
+		op   func() error
+	}{
+		{"Sign with encryption key", func() error { _, err := enc.Sign(msg); return err }},
+		{"Verify with encryption key", func() error { _, err := enc.Verify(msg, nil); return err }},
+		{"Encrypt with signing key", func() error { _, err := sig.Encrypt(msg, nil); return err }},
+		{"Decrypt with MAC key", func() error { _, err := mac.Decrypt(ciphertext, nil); return err }},
+	}
+	for _, tt := range wrong {
+		if err := tt.op(); !errors.Is(err, keystore.ErrWrongPurpose) {
+			t.Errorf("%s: error = %v, want ErrWrongPurpose", tt.name, err)
+		}
+	}
+}
+
+func TestKey_States(t *testing.T) {
+	// Each key activates 10 minutes after creation, is deactivated after 20
+	// and destroyed after 30. Creation is moved back by age, so operations,
+	// which use the current time, see the key in the state under test before
+	// any transition is recorded.
+	tests := []struct {
+		age       time.Duration
+		wantState string
+		signErr   error
+		verifyErr error
+	}{
+		{5 * time.Minute, keystore.StatePreActive, keystore.ErrState, keystore.ErrState},
+		{15 * time.Minute, keystore.StateActive, nil, nil},
+		{25 * time.Minute, keystore.StateDeactivated, keystore.ErrExpired, nil},
+		{35 * time.Minute, keystore.StateDestroyed, keystore.ErrState, keystore.ErrState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantState, func(t *testing.T) {
+			created := time.Now().Add(-tt.age)
+			at := func(d time.Duration) *time.Time { ts := created.Add(d); return &ts }
+			k, err := keystore.NewKey(keystore.Metadata{
+				Name: "scheduled", Purpose: keystore.PurposeSign, Algorithm: keystore.AlgorithmEd25519,
+				CreatedAt: created, ActivatesAt: *at(10 * time.Minute), ExpiresAt: at(20 * time.Minute), DestroysAt: at(30 * time.Minute),
+			}, make([]byte, keystore.KeySize(keystore.AlgorithmEd25519)))
+			if err != nil {
+				t.Fatalf("NewKey() returned an error: %v", err)
+			}
+
+			if got := k.Metadata().State; got != tt.wantState {
+				t.Errorf("Metadata().State = %s, want %s", got, tt.wantState)
+			}
+			if _, err := k.Sign([]byte("hello")); !errors.Is(err, tt.signErr) {
+				t.Errorf("Sign() error = %v, want %v", err, tt.signErr)
+			}
+			if _, err := k.Verify([]byte("hello"), make([]byte, 64)); !errors.Is(err, tt.verifyErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.verifyErr)
+			}
+		})
+	}
+}
+
+func TestKey_Usage(t *testing.T) {
+	tests := []struct {
+		name       string
+		purpose    string
+		limit      *keystore.UsageLimit
+		wantLimit  *keystore.UsageLimit // Limit reported in the metadata
+		wantRefuse int                  // Protect operation refused with ErrUsageLimit; 0 = none of three
+		wantState  string               // State after the operations
+	}{
+		{"AES-GCM cap", keystore.PurposeEncrypt, nil, &keystore.UsageLimit{MaxOperations: keystore.MaxGCMEncryptions, OnLimit: keystore.OnLimitDeactivate}, 0, keystore.StateActive},
+		{"AES-GCM cap is a ceiling", keystore.PurposeEncrypt, &keystore.UsageLimit{MaxOperations: 1 << 40}, &keystore.UsageLimit{MaxOperations: keystore.MaxGCMEncryptions, OnLimit: keystore.OnLimitDeactivate}, 0, keystore.StateActive},
+		{"Unlimited", keystore.PurposeMAC, nil, nil, 0, keystore.StateActive},
+		{"Refuse", keystore.PurposeMAC, &keystore.UsageLimit{MaxOperations: 2, OnLimit: keystore.OnLimitRefuse}, &keystore.UsageLimit{MaxOperations: 2, OnLimit: keystore.OnLimitRefuse}, 3, keystore.StateActive},
+		{"Refuse by bytes", keystore.PurposeSign, &keystore.UsageLimit{MaxBytes: 12, OnLimit: keystore.OnLimitRefuse}, &keystore.UsageLimit{MaxBytes: 12, OnLimit: keystore.OnLimitRefuse}, 3, keystore.StateActive},
+		{"Deactivate", keystore.PurposeEncrypt, &keystore.UsageLimit{MaxOperations: 2}, &keystore.UsageLimit{MaxOperations: 2, OnLimit: keystore.OnLimitDeactivate}, 0, keystore.StateDeactivated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg := keystore.DefaultAlgorithm(tt.purpose)
+			k, err := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: tt.purpose, Algorithm: alg, UsageLimit: tt.limit}, bytes.Repeat([]byte{7}, keystore.KeySize(alg)))
+			if err != nil {
+				t.Fatalf("NewKey() returned an error: %v", err)
+			}
+			protect := func() ([]byte, error) {
+				if tt.purpose == keystore.PurposeEncrypt {
+					return k.Encrypt([]byte("hello!"), nil)
+				}
+				return k.Sign([]byte("hello!"))
+			}
+			var out []byte
+			for i := 1; i <= 3; i++ {
+				res, err := protect()
+				switch {
+				case i == tt.wantRefuse && !errors.Is(err, keystore.ErrUsageLimit):
+					t.Fatalf("operation %d error = %v, want ErrUsageLimit", i, err)
+				case i == 3 && tt.wantState == keystore.StateDeactivated && !errors.Is(err, keystore.ErrExpired):
+					t.Fatalf("operation %d error = %v, want ErrExpired", i, err)
+				case i < 3 && err != nil:
+					t.Fatalf("operation %d returned an error: %v", i, err)
+				}
+				if err == nil {
+					out = res
+				}
+			}
+			if tt.purpose == keystore.PurposeEncrypt {
+				_, err = k.Decrypt(out, nil)
+			} else {
+				_, err = k.Verify([]byte("hello!"), out)
+			}
+			if err != nil {
+				t.Fatalf("processing existing data returned an error: %v", err)
+			}
+
+			m := k.Metadata()
+			if (m.UsageLimit == nil) != (tt.wantLimit == nil) || m.UsageLimit != nil && *m.UsageLimit != *tt.wantLimit {
+				t.Errorf("UsageLimit = %+v, want %+v", m.UsageLimit, tt.wantLimit)
+			}
+			if m.State != tt.wantState {
+				t.Errorf("State = %s, want %s", m.State, tt.wantState)
+			}
+			protectOp, processOp := keystore.OpSign, keystore.OpVerify
+			if tt.purpose == keystore.PurposeEncrypt {
+				protectOp, processOp = keystore.OpEncrypt, keystore.OpDecrypt
+			}
+			wantOps := uint64(3)
+			if tt.wantRefuse > 0 || tt.wantState == keystore.StateDeactivated {
+				wantOps = 2
+			}
+			if got := m.Usage.Operations[protectOp]; got != wantOps {
+				t.Errorf("Usage.Operations[%s] = %d, want %d", protectOp, got, wantOps)
+			}
+			if got := m.Usage.Bytes[protectOp]; got != 6*wantOps {
+				t.Errorf("Usage.Bytes[%s] = %d, want %d", protectOp, got, 6*wantOps)
+			}
+			if got := m.Usage.Operations[processOp]; got != 1 {
+				t.Errorf("Usage.Operations[%s] = %d, want 1", processOp, got)
+			}
+		})
+	}
+}
+
+func TestKey_Usage_Concurrent(t *testing.T) {
+	const limit = 50
+	k, err := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: keystore.PurposeMAC, Algorithm: keystore.AlgorithmHMACSHA256, UsageLimit: &keystore.UsageLimit{MaxOperations: limit, OnLimit: keystore.OnLimitRefuse}}, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewKey() returned an error: %v", err)
+	}
+	var wg sync.WaitGroup
+	var signed atomic.Int64
+	for i := 0; i < 3*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := k.Sign([]byte("hello!")); err == nil {
+				signed.Add(1)
+			} else if !errors.Is(err, keystore.ErrUsageLimit) {
+				t.Errorf("Sign() returned an error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m := k.Metadata()
+	if signed.Load() != limit || m.Usage.Operations[keystore.OpSign] != limit || m.Usage.Bytes[keystore.OpSign] != 6*limit {
+		t.Errorf("signed %d times, usage %+v; want exactly %d operations", signed.Load(), m.Usage, limit)
+	}
+	if m.State != keystore.StateActive {
+		t.Errorf("State = %s, want %s", m.State, keystore.StateActive)
+	}
+}
+
+func TestStore_Advance_UsageLimit(t *testing.T) {
+	s := keystore.NewStore()
+	k, err := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: keystore.PurposeMAC, Algorithm: keystore.AlgorithmHMACSHA256, UsageLimit: &keystore.UsageLimit{MaxOperations: 1}}, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewKey() returned an error: %v", err)
+	}
+	s.Add(k)
+	if _, err := k.Sign([]byte("hello")); err != nil {
+		t.Fatalf("Sign() returned an error: %v", err)
+	}
+	got := s.Advance(time.Now())
+	if len(got) != 1 || got[0].To != keystore.StateDeactivated || got[0].Reason != "usage limit reached" {
+		t.Fatalf("Advance() = %+v, want one deactivation for the usage limit", got)
+	}
+	if m := k.Metadata(); m.StateReason != "usage limit reached" {
+		t.Errorf("StateReason = %q, want %q", m.StateReason, "usage limit reached")
+	}
+}
+
+func TestStore_Advance(t *testing.T) {
+	now := time.Now()
+	created := now.Add(-time.Hour)
+	at := func(d time.Duration) *time.Time { ts := created.Add(d); return &ts }
+	s := keystore.NewStore()
+	for _, m := range []keystore.Metadata{
+		{Name: "future", ActivatesAt: now.Add(time.Hour)},
+		{Name: "expired", ExpiresAt: at(30 * time.Minute)},
+		{Name: "retired", ExpiresAt: at(10 * time.Minute), DestroysAt: at(20 * time.Minute)},
+		{Name: "forever"},
+	} {
+		m.Purpose, m.Algorithm, m.CreatedAt = keystore.PurposeEncrypt, keystore.AlgorithmAES256GCM, created
+		k, err := keystore.NewKey(m, make([]byte, 32))
+		if err != nil {
+			t.Fatalf("NewKey(%s) returned an error: %v", m.Name, err)
+		}
+		s.Add(k)
+	}
+
+	var got []string
+	for _, tr := range s.Advance(now) {
+		got = append(got, tr.Name+":"+tr.From+">"+tr.To)
+	}
+	want := "retired:active>deactivated,retired:deactivated>destroyed,expired:active>deactivated"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Advance() = %s, want %s", strings.Join(got, ","), want)
+	}
+	if again := s.Advance(now); len(again) != 0 {
+		t.Errorf("second Advance() made %d transitions, want none", len(again))
+	}
+
+	k, _ := s.Get("expired")
+	ciphertext := make([]byte, 64)
+	if _, err := k.Encrypt([]byte("hello"), nil); !errors.Is(err, keystore.ErrExpired) {
+		t.Errorf("Encrypt() with a deactivated key error = %v, want ErrExpired", err)
+	}
+	if _, err := k.Decrypt(ciphertext, nil); !errors.Is(err, keystore.ErrDecrypt) {
+		t.Errorf("Decrypt() with a deactivated key error = %v, want it to reach decryption", err)
+	}
+}
+
+func TestStore_SetState(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []string // States set in order; the last one is checked
+		wantErr error
+	}{
+		{"Deactivate", []string{keystore.StateDeactivated}, nil},
+		{"Compromise active key", []string{keystore.StateCompromised}, nil},
+		{"Destroy compromised key", []string{keystore.StateCompromised, keystore.StateDestroyed}, nil},
+		{"Destroy active key", []string{keystore.StateDestroyed}, keystore.ErrState},
+		{"Reactivate", []string{keystore.StateDeactivated, keystore.StateActive}, keystore.ErrInvalid},
+		{"Compromise destroyed key", []string{keystore.StateDeactivated, keystore.StateDestroyed, keystore.StateCompromised}, keystore.ErrState},
+		{"Unknown state", []string{"lost"}, keystore.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := keystore.NewStore()
+			s.Add(newKey(t, "k", keystore.PurposeEncrypt, nil))
+			var err error
+			for _, state := range tt.steps {
+				_, err = s.SetState("k", state, "test", time.Now())
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("SetState() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetState() returned unexpected error: %v", err)
+			}
+			k, _ := s.Get("k")
+			if m := k.Metadata(); m.State != tt.steps[len(tt.steps)-1] || m.StateReason != "test" {
+				t.Errorf("Metadata() = %s (%q), want %s", m.State, m.StateReason, tt.steps[len(tt.steps)-1])
+			}
+		})
+	}
+
+	s := keystore.NewStore()
+	s.Add(newKey(t, "k", keystore.PurposeEncrypt, nil))
+	s.SetState("k", keystore.StateCompromised, "leaked", time.Now())
+	k, _ := s.Get("k")
+	if _, err := k.Decrypt(make([]byte, 64), nil); !errors.Is(err, keystore.ErrState) {
+		t.Errorf("Decrypt() with a compromised key error = %v, want ErrState", err)
+	}
+}
+
+func TestStore_Rotate(t *testing.T) {
+	version := func(t *testing.T, purpose string, fill byte) *keystore.Key {
+		t.Helper()
+		alg := keystore.DefaultAlgorithm(purpose)
+		k, err := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: purpose, Algorithm: alg}, bytes.Repeat([]byte{fill}, keystore.KeySize(alg)))
+		if err != nil {
+			t.Fatalf("NewKey() returned an error: %v", err)
+		}
+		return k
+	}
+	s := keystore.NewStore()
+	v1 := version(t, keystore.PurposeEncrypt, 1)
+	s.Add(v1)
+	old, err := v1.Encrypt([]byte("hello"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() returned an error: %v", err)
+	}
+
+	transitions, err := s.Rotate(version(t, keystore.PurposeEncrypt, 2), time.Now())
+	if err != nil {
+		t.Fatalf("Rotate() returned an error: %v", err)
+	}
+	if len(transitions) != 1 || transitions[0].Version != 1 || transitions[0].To != keystore.StateDeactivated || transitions[0].Reason != keystore.RotatedReason {
+		t.Errorf("Rotate() transitions = %+v, want version 1 deactivated as rotated", transitions)
+	}
+	if m := v1.Metadata(); m.State != keystore.StateDeactivated {
+		t.Errorf("replaced version is %s, want %s", m.State, keystore.StateDeactivated)
+	}
+	k, _ := s.Get("k")
+	if m := k.Metadata(); m.Version != 2 || m.State != keystore.StateActive {
+		t.Errorf("current version = %d (%s), want 2 (active)", m.Version, m.State)
+	}
+	if plaintext, err := k.Decrypt(old, nil); err != nil || string(plaintext) != "hello" {
+		t.Errorf("Decrypt() of data from the replaced version = %q, %v; want hello", plaintext, err)
+	}
+	if _, err := k.Decrypt(make([]byte, 64), nil); !errors.Is(err, keystore.ErrDecrypt) {
+		t.Errorf("Decrypt() of garbage error = %v, want ErrDecrypt", err)
+	}
+	if list := s.List(nil); len(list) != 1 || list[0].Version != 2 {
+		t.Errorf("List() = %+v, want only version 2", list)
+	}
+	if totals := s.UsageTotals(); len(totals) != 1 || totals[0].Usage.Operations[keystore.OpEncrypt] != 1 {
+		t.Errorf("UsageTotals() = %+v, want the encryption by version 1", totals)
+	}
+
+	if _, err := s.Rotate(version(t, keystore.PurposeMAC, 3), time.Now()); !errors.Is(err, keystore.ErrInvalid) {
+		t.Errorf("Rotate() to another purpose error = %v, want ErrInvalid", err)
+	}
+	alg := keystore.AlgorithmAES256GCM
+	missing, _ := keystore.NewKey(keystore.Metadata{Name: "missing", Purpose: keystore.PurposeEncrypt, Algorithm: alg}, make([]byte, 32))
+	if _, err := s.Rotate(missing, time.Now()); !errors.Is(err, keystore.ErrNotFound) {
+		t.Errorf("Rotate() of a missing key error = %v, want ErrNotFound", err)
+	}
+	future, _ := keystore.NewKey(keystore.Metadata{Name: "future", Purpose: keystore.PurposeEncrypt, Algorithm: alg, ActivatesAt: time.Now().Add(time.Hour)}, make([]byte, 32))
+	s.Add(future)
+	next, _ := keystore.NewKey(keystore.Metadata{Name: "future", Purpose: keystore.PurposeEncrypt, Algorithm: alg}, make([]byte, 32))
+	if _, err := s.Rotate(next, time.Now()); !errors.Is(err, keystore.ErrState) {
+		t.Errorf("Rotate() of a pre-active key error = %v, want ErrState", err)
+	}
+}
+
+func TestStore_RotateThenCompromise(t *testing.T) {
+	alg := keystore.AlgorithmAES256GCM
+	s := keystore.NewStore()
+	v1, _ := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: keystore.PurposeEncrypt, Algorithm: alg}, bytes.Repeat([]byte{1}, 32))
+	s.Add(v1)
+	old, err := v1.Encrypt([]byte("hello"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() returned an error: %v", err)
+	}
+	v2, _ := keystore.NewKey(keystore.Metadata{Name: "k", Purpose: keystore.PurposeEncrypt, Algorithm: alg}, bytes.Repeat([]byte{2}, 32))
+	if _, err := s.Rotate(v2, time.Now()); err != nil {
+		t.Fatalf("Rotate() returned an error: %v", err)
+	}
+
+	// Only the version that opens the ciphertext counts the decryption.
+	if _, err := v2.Decrypt(old, nil); err != nil {
+		t.Fatalf("Decrypt() of data from the replaced version returned an error: %v", err)
+	}
+	if n := v1.Metadata().Usage.Operations[keystore.OpDecrypt]; n != 1 {
+		t.Errorf("replaced version decryptions = %d, want 1", n)
+	}
+	if n := v2.Metadata().Usage.Operations[keystore.OpDecrypt]; n != 0 {
+		t.Errorf("current version decryptions = %d, want 0", n)
+	}
+
+	transitions, err := s.SetState("k", keystore.StateCompromised, "leaked", time.Now())
+	if err != nil {
+		t.Fatalf("SetState() returned an error: %v", err)
+	}
+	if len(transitions) != 2 || transitions[0].Version != 2 || transitions[1].Version != 1 {
+		t.Errorf("SetState() transitions = %+v, want versions 2 and 1 compromised", transitions)
+	}
+	if m := v1.Metadata(); m.State != keystore.StateCompromised || m.StateReason != "leaked" {
+		t.Errorf("replaced version is %s (%q), want compromised (leaked)", m.State, m.StateReason)
+	}
+	if _, err := v2.Decrypt(old, nil); !errors.Is(err, keystore.ErrState) {
+		t.Errorf("Decrypt() after compromise error = %v, want ErrState", err)
+	}
+
+	if _, err := s.SetState("k", keystore.StateDestroyed, "", time.Now()); err != nil {
+		t.Fatalf("SetState() returned an error: %v", err)
+	}
+	if m := v1.Metadata(); m.State != keystore.StateDestroyed {
+		t.Errorf("replaced version is %s, want destroyed", m.State)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := keystore.NewStore()
+	for _, k := range []*keystore.Key{
+		newKey(t, "c-dev", keystore.PurposeEncrypt, map[string]string{"env": "dev", "team": "payments"}),
+		newKey(t, "a-prod", keystore.PurposeEncrypt, map[string]string{"env": "prod", "team": "payments"}),
+		newKey(t, "b-prod", keystore.PurposeSign, map[string]string{"env": "prod"}),
+	} {
+		if err := s.Add(k); err != nil {
+			t.Fatalf("Add() returned an error: %v", err)
+		}
+	}
+	if err := s.Add(newKey(t, "a-prod", keystore.PurposeMAC, nil)); !errors.Is(err, keystore.ErrExists) {
+		t.Errorf("Add() of a taken name error = %v, want ErrExists", err)
+	}
+	if _, err := s.Get("missing"); !errors.Is(err, keystore.ErrNotFound) {
+		t.Errorf("Get() of a missing key error = %v, want ErrNotFound", err)
+	}
+
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"", "a-prod,b-prod,c-dev"},
+		{"env=prod", "a-prod,b-prod"},
+		{"env==prod, team=payments", "a-prod"},
+		{"env!=prod", "c-dev"},
+		{"team", "a-prod,c-dev"},
+		{"!team", "b-prod"},
+		{"env=staging", ""},
+	}
+	for _, tt := range tests {
+		sel, err := keystore.ParseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q) returned an error: %v", tt.selector, err)
+		}
+		var names []string
+		for _, m := range s.List(sel) {
+			names = append(names, m.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("List(%q) = %s, want %s", tt.selector, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"=prod", "env=a b", "env,,team", "!"} {
+		if _, err := keystore.ParseSelector(bad); err == nil {
+			t.Errorf("ParseSelector(%q) accepted an invalid selector", bad)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package keystore
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxLabelKeyLength   = 63
+	maxLabelValueLength = 63
+)
+
+// validateLabels checks label keys and values. Keys are 1 to 63 letters,
+// digits, '.', '_', '-' and '/', starting with a letter or digit; values are
+// up to 63 letters, digits, '.', '_' and '-'.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("%w: at most %d labels are allowed, got %d", ErrInvalid, maxLabels, len(labels))
+	}
+	for k, v := range labels {
+		if err := validateLabelKey(k); err != nil {
+			return err
+		}
+		if err := validateLabelValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLabelKey(k string) error {
+	if k == "" || len(k) > maxLabelKeyLength {
+		return fmt.Errorf("%w: label key %q must be 1 to %d characters", ErrInvalid, k, maxLabelKeyLength)
+	}
+	for i, c := range k {
+		if isAlphanumeric(c) || (i > 0 && strings.ContainsRune("._-/", c)) {
+			continue
+		}
+		return fmt.Errorf("%w: label key %q must start with a letter or digit and contain only letters, digits, '.', '_', '-' and '/'", ErrInvalid, k)
+	}
+	return nil
+}
+
+func validateLabelValue(v string) error {
+	if len(v) > maxLabelValueLength {
+		return fmt.Errorf("%w: label value %q must be at most %d characters", ErrInvalid, v, maxLabelValueLength)
+	}
+	for _, c := range v {
+		if !isAlphanumeric(c) && !strings.ContainsRune("._-", c) {
+			return fmt.Errorf("%w: label value %q must contain only letters, digits, '.', '_' and '-'", ErrInvalid, v)
+		}
+	}
+	return nil
+}
+
+func isAlphanumeric(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Selector operators.
+const (
+	opEquals    = "="
+	opNotEquals = "!="
+	opExists    = "exists"
+	opNotExists = "!exists"
+)
+
+// requirement is one term of a Selector.
+type requirement struct {
+	key   string
+	op    string
+	value string
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case opEquals:
+		return ok && v == r.value
+	case opNotEquals:
+		return !ok || v != r.value
+	case opExists:
+		return ok
+	default: // opNotExists
+		return !ok
+	}
+}
+
+// Selector filters keys by their labels. The zero Selector matches every
+// key.
+type Selector []requirement
+
+// ParseSelector parses a comma-separated list of label requirements, all of
+// which must hold: "key=value" (or "key==value"), "key!=value" (also true
+// when the label is absent), "key" (the label is set) and "!key" (it is
+// not).
+func ParseSelector(s string) (Selector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var r requirement
+		switch {
+		case strings.Contains(term, "!="):
+			r.key, r.value, _ = strings.Cut(term, "!=")
+			r.op = opNotEquals
+		case strings.Contains(term, "=="):
+			r.key, r.value, _ = strings.Cut(term, "==")
+			r.op = opEquals
+		case strings.Contains(term, "="):
+			r.key, r.value, _ = strings.Cut(term, "=")
+			r.op = opEquals
+		case strings.HasPrefix(term, "!"):
+			r.key, r.op = term[1:], opNotExists
+		default:
+			r.key, r.op = term, opExists
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if err := validateLabelKey(r.key); err != nil {
+			return nil, fmt.Errorf("invalid selector term %q: %w", term, err)
+		}
+		if err := validateLabelValue(r.value); err != nil {
+			return nil, fmt.Errorf("invalid selector term %q: %w", term, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy every requirement of s.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package keystore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Key states, following the life cycle of NIST SP 800-57 Part 1 section
+// 7. A key moves forward through them and never returns to an earlier one.
+const (
+	StatePreActive   = "pre-active"  // Not yet usable; becomes active at ActivatesAt
+	StateActive      = "active"      // Usable for every operation of its purpose
+	StateDeactivated = "deactivated" // Only decrypts and verifies existing data
+	StateCompromised = "compromised" // Refuses every operation
+	StateDestroyed   = "destroyed"   // Key material erased; only the metadata remains
+)
+
+// manualTransitions lists the states a caller may move a key to, and the
+// states it may be moved from. Activation, and destruction of an active
+// key, only happen on schedule.
+var manualTransitions = map[string][]string{
+	StateDeactivated: {StateActive},
+	StateCompromised: {StatePreActive, StateActive, StateDeactivated},
+	StateDestroyed:   {StatePreActive, StateDeactivated, StateCompromised},
+}
+
+// Transition records a key moving from one state to another.
+type Transition struct {
+	Name      string
+	Version   int
+	Algorithm string
+	From      string
+	To        string
+	At        time.Time
+	Reason    string // Why the state changed; empty for transitions on schedule
+	Scheduled bool   // Made by the key's schedule rather than by a caller
+}
+
+// usageLimitReason is the reason recorded when a key is deactivated for
+// reaching its usage limit.
+const usageLimitReason = "usage limit reached"
+
+// RotatedReason is the reason recorded when a key version is deactivated
+// because a new version replaced it.
+const RotatedReason = "rotated"
+
+// nextState returns the state the key's schedule moves it to from state,
+// when and why, or "" if the schedule has nothing more for it. An active key
+// is deactivated at its expiry time or when it reaches a deactivating usage
+// limit, whichever comes first.
+func (k *Key) nextState(state string) (string, time.Time, string) {
+	m := &k.meta
+	switch state {
+	case StatePreActive:
+		return StateActive, m.ActivatesAt, ""
+	case StateActive:
+		exhaustedAt, exhausted := k.exhausted()
+		switch {
+		case exhausted && (m.ExpiresAt == nil || exhaustedAt.Before(*m.ExpiresAt)):
+			return StateDeactivated, exhaustedAt, usageLimitReason
+		case m.ExpiresAt != nil:
+			return StateDeactivated, *m.ExpiresAt, ""
+		}
+	case StateDeactivated, StateCompromised:
+		if m.DestroysAt != nil {
+			return StateDestroyed, *m.DestroysAt, ""
+		}
+	}
+	return "", time.Time{}, ""
+}
+
+// scheduled returns the transitions the key's schedule has made due by now
+// that are not recorded yet, in order.
+func (k *Key) scheduled(now time.Time) []Transition {
+	var due []Transition
+	state := k.meta.State
+	for {
+		next, at, reason := k.nextState(state)
+		if next == "" || now.Before(at) {
+			return due
+		}
+		due = append(due, Transition{Name: k.meta.Name, Version: k.meta.Version, Algorithm: k.meta.Algorithm, From: state, To: next, At: at, Reason: reason, Scheduled: true})
+		state = next
+	}
+}
+
+// stateAt returns the key's state at now and when it was entered, taking
+// scheduled transitions that are not recorded yet into account. Operations
+// follow the schedule exactly, however late the scheduler runs.
+func (k *Key) stateAt(now time.Time) (string, time.Time) {
+	state, at := k.meta.State, k.meta.StateChangedAt
+	if due := k.scheduled(now); len(due) > 0 {
+		last := due[len(due)-1]
+		state, at = last.To, last.At
+	}
+	return state, at
+}
+
+// apply records t. Destroying a key erases its material.
+func (k *Key) apply(t Transition) {
+	k.meta.State, k.meta.StateChangedAt, k.meta.StateReason = t.To, t.At, t.Reason
+	if t.To == StateDestroyed {
+		for i := range k.material {
+			k.material[i] = 0
+		}
+		for i := range k.signer {
+			k.signer[i] = 0
+		}
+		k.material, k.signer = nil, nil
+	}
+}
+
+// advance records the transitions that are due by now and returns them.
+func (k *Key) advance(now time.Time) []Transition {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	due := k.scheduled(now)
+	for _, t := range due {
+		k.apply(t)
+	}
+	return due
+}
+
+// setState moves the key to state at now for reason, after recording any
+// scheduled transitions that are due. It returns every transition made.
+func (k *Key) setState(state, reason string, now time.Time) ([]Transition, error) {
+	from, ok := manualTransitions[state]
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot move a key to state %q; use %s, %s or %s", ErrInvalid, state, StateDeactivated, StateCompromised, StateDestroyed)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	due := k.scheduled(now)
+	current := k.meta.State
+	if len(due) > 0 {
+		current = due[len(due)-1].To
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || s == current
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: key %s is %s and cannot become %s", ErrState, k.meta.Name, current, state)
+	}
+	for _, t := range due {
+		k.apply(t)
+	}
+	t := Transition{Name: k.meta.Name, Version: k.meta.Version, Algorithm: k.meta.Algorithm, From: current, To: state, At: now, Reason: reason}
+	k.apply(t)
+	return append(due, t), nil
+}
+
+// retire deactivates the key at now because a new version replaces it,
+// after recording any scheduled transitions that are due. A key that no
+// longer protects data is left as it is; a pre-active key cannot be
+// replaced. It returns every transition made.
+func (k *Key) retire(now time.Time) ([]Transition, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	due := k.scheduled(now)
+	current := k.meta.State
+	if len(due) > 0 {
+		current = due[len(due)-1].To
+	}
+	if current == StatePreActive {
+		return nil, fmt.Errorf("%w: key %s is pre-active until %s and cannot be rotated", ErrState, k.meta.Name, k.meta.ActivatesAt.Format(time.RFC3339))
+	}
+	for _, t := range due {
+		k.apply(t)
+	}
+	if current == StateActive {
+		t := Transition{Name: k.meta.Name, Version: k.meta.Version, Algorithm: k.meta.Algorithm, From: current, To: StateDeactivated, At: now, Reason: RotatedReason}
+		k.apply(t)
+		due = append(due, t)
+	}
+	return due, nil
+}
+
+// checkUse returns an error unless the key may be used for an operation of
+// purpose at now. Only active keys protect new data (encrypt or sign);
+// deactivated keys still process existing data (decrypt or verify), and
+// keys in any other state refuse every operation. The caller holds k.mu.
+func (k *Key) checkUse(purpose string, protect bool, now time.Time) error {
+	if k.meta.Purpose != purpose {
+		return fmt.Errorf("%w: key %s is for %s", ErrWrongPurpose, k.meta.Name, k.meta.Purpose)
+	}
+	state, since := k.stateAt(now)
+	switch {
+	case state == StateActive:
+		return nil
+	case state == StateDeactivated && !protect:
+		return nil
+	case state == StateDeactivated:
+		return fmt.Errorf("%w: key %s was deactivated at %s", ErrExpired, k.meta.Name, since.Format(time.RFC3339))
+	case state == StatePreActive:
+		return fmt.Errorf("%w: key %s is pre-active until %s", ErrState, k.meta.Name, k.meta.ActivatesAt.Format(time.RFC3339))
+	}
+	return fmt.Errorf("%w: key %s is %s", ErrState, k.meta.Name, state)
+}
@@ -0,0 +1,212 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operations counted in a key's Usage.
+const (
+	OpEncrypt = "encrypt"
+	OpDecrypt = "decrypt"
+	OpSign    = "sign"
+	OpVerify  = "verify"
+)
+
+var usageOps = [...]string{OpEncrypt, OpDecrypt, OpSign, OpVerify}
+
+// What happens when a key reaches its usage limit.
+const (
+	OnLimitDeactivate = "deactivate" // The key is deactivated, so it has to be replaced by a new one
+	OnLimitRefuse     = "refuse"     // The key stays active but refuses to protect more data
+)
+
+// MaxGCMEncryptions is the most messages an AES-GCM key encrypts with
+// random 96-bit nonces before the chance of a nonce collision becomes
+// unacceptable (NIST SP 800-38D section 8.3). Every AES-GCM key is limited
+// to it, whatever its configured limit.
+const MaxGCMEncryptions = 1 << 32
+
+// ErrUsageLimit is returned when a key has protected as much data as its
+// usage limit allows.
+var ErrUsageLimit = errors.New("key usage limit reached")
+
+// Usage counts a key's operations and the bytes they processed (plaintext
+// for encrypt, ciphertext for decrypt, the message for sign and verify).
+type Usage struct {
+	Operations map[string]uint64 `json:"operations"`
+	Bytes      map[string]uint64 `json:"bytes"`
+}
+
+// UsageLimit caps how much data a key protects: the number of encrypt or
+// sign operations and the bytes they process. Decryption and verification
+// are counted but not limited.
+type UsageLimit struct {
+	MaxOperations uint64 `json:"max_operations,omitempty"` // 0 = unlimited
+	MaxBytes      uint64 `json:"max_bytes,omitempty"`      // 0 = unlimited
+	OnLimit       string `json:"on_limit"`                 // OnLimitDeactivate (default) or OnLimitRefuse
+}
+
+// usage holds a key's counters. They are updated without the key's lock;
+// reservations against a limit are serialized by reserveMu instead.
+type usage struct {
+	reserveMu   sync.Mutex
+	operations  [len(usageOps)]atomic.Uint64
+	bytes       [len(usageOps)]atomic.Uint64
+	exhaustedAt atomic.Int64 // Unix nanoseconds at which the limit was reached; 0 = not reached
+}
+
+func opIndex(op string) int {
+	for i, o := range usageOps {
+		if o == op {
+			return i
+		}
+	}
+	panic("keystore: unknown operation " + op)
+}
+
+// snapshot returns the counters of the operations a key of purpose
+// performs as a Usage.
+func (u *usage) snapshot(purpose string) Usage {
+	ops := []string{OpSign, OpVerify}
+	if purpose == PurposeEncrypt {
+		ops = []string{OpEncrypt, OpDecrypt}
+	}
+	s := Usage{Operations: make(map[string]uint64, len(ops)), Bytes: make(map[string]uint64, len(ops))}
+	for _, op := range ops {
+		i := opIndex(op)
+		s.Operations[op] = u.operations[i].Load()
+		s.Bytes[op] = u.bytes[i].Load()
+	}
+	return s
+}
+
+// UsageTotal is the combined usage of the keys of one algorithm.
+type UsageTotal struct {
+	Algorithm string
+	Usage     Usage
+}
+
+// UsageTotals adds up the usage of every version of every key by
+// algorithm, sorted by algorithm. Keys and versions are never removed, so
+// the totals only grow.
+func (s *Store) UsageTotals() []UsageTotal {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byAlgorithm := make(map[string]*UsageTotal)
+	for _, k := range s.keys {
+		for v := k; v != nil; v = v.previous {
+			t, ok := byAlgorithm[v.meta.Algorithm]
+			if !ok {
+				t = &UsageTotal{Algorithm: v.meta.Algorithm, Usage: Usage{Operations: map[string]uint64{}, Bytes: map[string]uint64{}}}
+				byAlgorithm[v.meta.Algorithm] = t
+			}
+			u := v.usage.snapshot(v.meta.Purpose)
+			for op, n := range u.Operations {
+				t.Usage.Operations[op] += n
+			}
+			for op, n := range u.Bytes {
+				t.Usage.Bytes[op] += n
+			}
+		}
+	}
+	totals := make([]UsageTotal, 0, len(byAlgorithm))
+	for _, t := range byAlgorithm {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Algorithm < totals[j].Algorithm })
+	return totals
+}
+
+// exhausted returns when a deactivating limit was reached, if it was.
+func (k *Key) exhausted() (time.Time, bool) {
+	if k.meta.UsageLimit == nil || k.meta.UsageLimit.OnLimit != OnLimitDeactivate {
+		return time.Time{}, false
+	}
+	ns := k.usage.exhaustedAt.Load()
+	if ns == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns).UTC(), true
+}
+
+// count records an operation that does not protect data.
+func (k *Key) count(op string, n int) {
+	i := opIndex(op)
+	k.usage.operations[i].Add(1)
+	k.usage.bytes[i].Add(uint64(n))
+}
+
+// reserve counts a protect operation of n bytes, refusing it if it would
+// take the key past its usage limit. A refused operation is not counted.
+// Under OnLimitDeactivate, reaching the limit (or being refused) deactivates
+// the key.
+func (k *Key) reserve(op string, n int) error {
+	l := k.meta.UsageLimit
+	if l == nil {
+		k.count(op, n)
+		return nil
+	}
+	// Checking and counting are one step, so concurrent operations cannot
+	// push the counters past the limit and refuse each other.
+	k.usage.reserveMu.Lock()
+	defer k.usage.reserveMu.Unlock()
+	i := opIndex(op)
+	ops := k.usage.operations[i].Load() + 1
+	total := k.usage.bytes[i].Load() + uint64(n)
+	over := (l.MaxOperations > 0 && ops > l.MaxOperations) || (l.MaxBytes > 0 && total > l.MaxBytes)
+	if !over {
+		k.usage.operations[i].Add(1)
+		k.usage.bytes[i].Add(uint64(n))
+	}
+	reached := over || (l.MaxOperations > 0 && ops == l.MaxOperations) || (l.MaxBytes > 0 && total == l.MaxBytes)
+	if reached && l.OnLimit == OnLimitDeactivate {
+		k.usage.exhaustedAt.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	if over {
+		return fmt.Errorf("%w: key %s may protect at most %s", ErrUsageLimit, k.meta.Name, l)
+	}
+	return nil
+}
+
+// String describes the limit, as in "4294967296 operations".
+func (l *UsageLimit) String() string {
+	switch {
+	case l.MaxOperations > 0 && l.MaxBytes > 0:
+		return fmt.Sprintf("%d operations and %d bytes", l.MaxOperations, l.MaxBytes)
+	case l.MaxOperations > 0:
+		return fmt.Sprintf("%d operations", l.MaxOperations)
+	}
+	return fmt.Sprintf("%d bytes", l.MaxBytes)
+}
+
+// applyUsageLimit validates meta's usage limit, filling in the default
+// action and capping AES-GCM keys at MaxGCMEncryptions.
+func applyUsageLimit(meta *Metadata) error {
+	limit := UsageLimit{}
+	if meta.UsageLimit != nil {
+		limit = *meta.UsageLimit
+	}
+	meta.UsageLimit = &limit
+	if meta.Purpose == PurposeEncrypt {
+		if meta.UsageLimit.MaxOperations == 0 || meta.UsageLimit.MaxOperations > MaxGCMEncryptions {
+			meta.UsageLimit.MaxOperations = MaxGCMEncryptions
+		}
+	}
+	l := meta.UsageLimit
+	switch l.OnLimit {
+	case "":
+		l.OnLimit = OnLimitDeactivate
+	case OnLimitDeactivate, OnLimitRefuse:
+	default:
+		return fmt.Errorf("%w: on_limit must be %q or %q, got %q", ErrInvalid, OnLimitDeactivate, OnLimitRefuse, l.OnLimit)
+	}
+	if l.MaxOperations == 0 && l.MaxBytes == 0 {
+		meta.UsageLimit = nil
+	}
+	return nil
+}
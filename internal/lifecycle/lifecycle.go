@@ -0,0 +1,142 @@
+// Package lifecycle runs the server's graceful shutdown: it marks the
+// instance unready, gives load balancers time to notice, drains in-flight
+// requests and stops background workers in order.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/health"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+// Options configures a Manager.
+type Options struct {
+	Health  *health.Registry           // Marked draining when shutdown starts
+	Metrics *metrics.PrometheusMetrics // Receives the in-flight request gauge
+	Logger  *slog.Logger
+
+	// UnreadyDelay is how long to keep serving after reporting unready, so
+	// load balancers and endpoints controllers stop routing new requests
+	// before listeners close.
+	UnreadyDelay time.Duration
+}
+
+// Manager tracks in-flight requests and runs the shutdown sequence.
+type Manager struct {
+	opts Options
+
+	mu       sync.Mutex
+	hooks    []hook
+	inFlight int
+	drained  chan struct{} // Closed when inFlight drops to zero while draining
+	draining bool
+}
+
+// hook is one step of the shutdown sequence.
+type hook struct {
+	name string
+	stop func(context.Context) error
+}
+
+// New returns a Manager.
+func New(opts Options) *Manager {
+	return &Manager{opts: opts}
+}
+
+// OnShutdown registers a step of the shutdown sequence. Steps run one after
+// another in registration order, so register the listeners first, then Drain,
+// then the workers requests depend on.
+func (m *Manager) OnShutdown(name string, stop func(context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// Track counts requests served by next as in flight until they complete.
+func (m *Manager) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.add(1)
+		defer m.add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlight returns the number of tracked requests in progress.
+func (m *Manager) InFlight() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inFlight
+}
+
+func (m *Manager) add(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight += delta
+	m.opts.Metrics.AddKeyRequestsInFlight(float64(delta))
+	if m.draining && m.inFlight == 0 && m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+// Shutdown runs the shutdown sequence: it reports the instance unready on
+// /ready, keeps serving for UnreadyDelay, then runs the registered steps in
+// order. Steps share ctx's deadline. A failed step is logged and the sequence
+// continues so every worker gets the chance to stop; the returned error joins
+// every failure.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.opts.Health.SetDraining(true)
+	if d := m.opts.UnreadyDelay; d > 0 {
+		m.opts.Logger.Info("Reporting unready before closing listeners", "delay", d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+
+	m.mu.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, h := range hooks {
+		start := time.Now()
+		if err := h.stop(ctx); err != nil {
+			m.opts.Logger.Error("Shutdown step failed", "step", h.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		m.opts.Logger.Info("Shutdown step complete", "step", h.name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// Drain waits for tracked requests to finish. Register it as a shutdown step
+// after the listeners are closed, when no new requests can arrive.
+func (m *Manager) Drain(ctx context.Context) error {
+	m.mu.Lock()
+	m.draining = true
+	if m.inFlight == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	n := m.inFlight
+	drained := make(chan struct{})
+	m.drained = drained
+	m.mu.Unlock()
+
+	m.opts.Logger.Info("Waiting for in-flight requests", "in_flight", n)
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d requests still in flight: %w", m.InFlight(), ctx.Err())
+	}
+}
@@ -0,0 +1,132 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/health"
+	"github.com/bajhalshrey/Key-Server-Application/internal/lifecycle"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+func newManager(t *testing.T, delay time.Duration) (*lifecycle.Manager, *health.Registry, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	healthRegistry := health.NewRegistry()
+	m := lifecycle.New(lifecycle.Options{
+		Health:       healthRegistry,
+		Metrics:      metrics.NewPrometheusMetricsWithRegistry(registry, 64),
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		UnreadyDelay: delay,
+	})
+	return m, healthRegistry, registry
+}
+
+func TestManager_Shutdown(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	m, healthRegistry, _ := newManager(t, delay)
+
+	var steps []string
+	start := time.Now()
+	m.OnShutdown("listeners", func(context.Context) error {
+		if !healthRegistry.Draining() {
+			t.Error("listeners closed before the instance reported unready")
+		}
+		if elapsed := time.Since(start); elapsed < delay {
+			t.Errorf("listeners closed after %s, before the %s unready delay", elapsed, delay)
+		}
+		steps = append(steps, "listeners")
+		return nil
+	})
+	m.OnShutdown("pool", func(context.Context) error {
+		steps = append(steps, "pool")
+		return errors.New("stuck")
+	})
+	m.OnShutdown("rotator", func(context.Context) error {
+		steps = append(steps, "rotator")
+		return nil
+	})
+
+	err := m.Shutdown(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "pool: stuck") {
+		t.Errorf("Shutdown error = %v, want the failed step", err)
+	}
+	if got := strings.Join(steps, ","); got != "listeners,pool,rotator" {
+		t.Errorf("steps ran as %s, want every step in registration order", got)
+	}
+}
+
+func TestManager_Drain(t *testing.T) {
+	m, _, registry := newManager(t, 0)
+
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	handler := m.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+	finished := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/key/32", nil))
+		close(finished)
+	}()
+	<-entered
+
+	if got := m.InFlight(); got != 1 {
+		t.Errorf("InFlight() = %d, want 1", got)
+	}
+	if got := inFlightGauge(t, registry); got != 1 {
+		t.Errorf("in-flight gauge = %v, want 1", got)
+	}
+
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := m.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Drain error = %v, want DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("Completes", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- m.Drain(context.Background()) }()
+		close(release)
+		<-finished
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Drain returned an error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Drain did not return after the request finished")
+		}
+		if got := inFlightGauge(t, registry); got != 0 {
+			t.Errorf("in-flight gauge = %v, want 0", got)
+		}
+	})
+}
+
+// inFlightGauge returns the value of the in-flight key request gauge.
+func inFlightGauge(t *testing.T, registry *prometheus.Registry) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "key_server_key_requests_in_flight" {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatal("key_server_key_requests_in_flight not registered")
+	return 0
+}
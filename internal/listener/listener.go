@@ -0,0 +1,213 @@
+// Package listener opens the sockets the server accepts connections on: TCP
+// addresses, Unix domain sockets and sockets passed in by systemd socket
+// activation.
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Networks a listener can be opened on.
+const (
+	NetworkTCP     = "tcp"
+	NetworkUnix    = "unix"
+	NetworkSystemd = "systemd"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Spec describes one listener.
+type Spec struct {
+	Network string // NetworkTCP, NetworkUnix or NetworkSystemd
+	Address string // host:port, socket path, or systemd socket name (empty = every passed socket)
+}
+
+// String returns the address in the form accepted by Parse.
+func (s Spec) String() string {
+	switch s.Network {
+	case NetworkSystemd:
+		if s.Address == "" {
+			return NetworkSystemd
+		}
+		return NetworkSystemd + ":" + s.Address
+	default:
+		return s.Network + "://" + s.Address
+	}
+}
+
+// Parse parses a listener address:
+//
+//	tcp://host:port or host:port   TCP (host may be empty to listen on all interfaces)
+//	unix:///path/to/socket         Unix domain socket
+//	systemd                        every socket passed by systemd socket activation
+//	systemd:NAME                   the sockets systemd passed with FileDescriptorName=NAME
+func Parse(s string) (Spec, error) {
+	switch {
+	case s == NetworkSystemd:
+		return Spec{Network: NetworkSystemd}, nil
+	case strings.HasPrefix(s, NetworkSystemd+":"):
+		name := strings.TrimPrefix(s, NetworkSystemd+":")
+		if name == "" || strings.Contains(name, ":") {
+			return Spec{}, fmt.Errorf("invalid systemd socket name in %q", s)
+		}
+		return Spec{Network: NetworkSystemd, Address: name}, nil
+	case strings.HasPrefix(s, NetworkUnix+"://"):
+		path := strings.TrimPrefix(s, NetworkUnix+"://")
+		if path == "" {
+			return Spec{}, fmt.Errorf("missing socket path in %q", s)
+		}
+		return Spec{Network: NetworkUnix, Address: path}, nil
+	}
+
+	addr := strings.TrimPrefix(s, NetworkTCP+"://")
+	if strings.Contains(addr, "://") {
+		return Spec{}, fmt.Errorf("unsupported listener scheme in %q", s)
+	}
+	if _, port, err := net.SplitHostPort(addr); err != nil {
+		return Spec{}, fmt.Errorf("invalid TCP address %q: %w", s, err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return Spec{}, fmt.Errorf("invalid port in %q", s)
+	}
+	return Spec{Network: NetworkTCP, Address: addr}, nil
+}
+
+// Options configures Open.
+type Options struct {
+	UnixSocketMode os.FileMode // Permissions of created Unix sockets
+}
+
+// Open opens a listener for every spec. A stale Unix socket left behind by a
+// previous run is replaced. If any listener fails, those already opened are
+// closed.
+func Open(specs []Spec, opts Options) ([]net.Listener, error) {
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	var activated []activatedSocket
+	for _, spec := range specs {
+		if spec.Network == NetworkSystemd && activated == nil {
+			var err error
+			if activated, err = systemdSockets(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	for _, spec := range specs {
+		switch spec.Network {
+		case NetworkTCP:
+			l, err := net.Listen("tcp", spec.Address)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, l)
+		case NetworkUnix:
+			l, err := listenUnix(spec.Address, opts.UnixSocketMode)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, l)
+		case NetworkSystemd:
+			found := false
+			for _, s := range activated {
+				if spec.Address == "" || s.name == spec.Address {
+					listeners = append(listeners, s.listener)
+					found = true
+				}
+			}
+			if !found {
+				return fail(fmt.Errorf("no socket named %q was passed by systemd", spec.Address))
+			}
+		default:
+			return fail(fmt.Errorf("unsupported listener network %q", spec.Network))
+		}
+	}
+	return listeners, nil
+}
+
+// IsUnix reports whether l accepts connections on a Unix domain socket.
+// Such connections are local, so the server does not require TLS on them.
+func IsUnix(l net.Listener) bool {
+	return l.Addr().Network() == NetworkUnix
+}
+
+// listenUnix listens on a Unix socket at path, removing a stale socket first.
+// The socket file is removed again when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// activatedSocket is a listening socket passed in by systemd.
+type activatedSocket struct {
+	name     string
+	listener net.Listener
+}
+
+// systemdSockets returns the listening sockets passed by systemd socket
+// activation (sd_listen_fds). The environment variables are cleared so that
+// child processes do not inherit them.
+func systemdSockets() ([]activatedSocket, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_PID is not set to this process)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_FDS is not set)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	sockets := make([]activatedSocket, 0, n)
+	for i := 0; i < n; i++ {
+		name := "unknown" // systemd's name for sockets without FileDescriptorName
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener duplicates the descriptor
+		if err != nil {
+			for _, s := range sockets {
+				s.listener.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %d (%s) is not a listening socket: %w", i, name, err)
+		}
+		sockets = append(sockets, activatedSocket{name: name, listener: l})
+	}
+	return sockets, nil
+}
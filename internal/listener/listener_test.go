@@ -0,0 +1,123 @@
+package listener_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/listener"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    listener.Spec
+		wantErr bool
+	}{
+		{in: ":8443", want: listener.Spec{Network: listener.NetworkTCP, Address: ":8443"}},
+		{in: "tcp://127.0.0.1:8443", want: listener.Spec{Network: listener.NetworkTCP, Address: "127.0.0.1:8443"}},
+		{in: "tcp://[::1]:0", want: listener.Spec{Network: listener.NetworkTCP, Address: "[::1]:0"}},
+		{in: "unix:///run/key-server/api.sock", want: listener.Spec{Network: listener.NetworkUnix, Address: "/run/key-server/api.sock"}},
+		{in: "systemd", want: listener.Spec{Network: listener.NetworkSystemd}},
+		{in: "systemd:api", want: listener.Spec{Network: listener.NetworkSystemd, Address: "api"}},
+		{in: "8443", wantErr: true},
+		{in: "tcp://:99999", wantErr: true},
+		{in: "unix://", wantErr: true},
+		{in: "systemd:", wantErr: true},
+		{in: "udp://:53", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := listener.Parse(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Parse(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+			}
+			if again, err := listener.Parse(got.String()); err != nil || again != got {
+				t.Errorf("Parse(%q.String()) = %+v, %v", got, again, err)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	// A socket left behind by a previous run is replaced.
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := listener.Open([]listener.Spec{
+		{Network: listener.NetworkTCP, Address: "127.0.0.1:0"},
+		{Network: listener.NetworkUnix, Address: socket},
+	}, listener.Options{UnixSocketMode: 0o600})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	if len(listeners) != 2 || listener.IsUnix(listeners[0]) || !listener.IsUnix(listeners[1]) {
+		t.Fatalf("Open returned %v", listeners)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	for _, l := range listeners {
+		go server.Serve(l)
+	}
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://key-server/health")
+	if err != nil {
+		t.Fatalf("request over the Unix socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status over the Unix socket = %d", resp.StatusCode)
+	}
+}
+
+func TestOpen_Errors(t *testing.T) {
+	notSocket := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notSocket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		spec    listener.Spec
+		wantErr string
+	}{
+		{"Existing file", listener.Spec{Network: listener.NetworkUnix, Address: notSocket}, "not a socket"},
+		{"No systemd sockets", listener.Spec{Network: listener.NetworkSystemd}, "LISTEN_PID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := listener.Open([]listener.Spec{{Network: listener.NetworkTCP, Address: "127.0.0.1:0"}, tt.spec}, listener.Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Open() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
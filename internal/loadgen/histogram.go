@@ -0,0 +1,78 @@
+package loadgen
+
+import (
+	"math"
+	"time"
+)
+
+// Histogram bounds: latencies are recorded from 1µs to 100s with
+// bucketsPerDecade logarithmic buckets per power of ten, so quantiles are
+// accurate to about 1% while memory stays fixed however long a run lasts.
+const (
+	minLatency       = time.Microsecond
+	decades          = 8
+	bucketsPerDecade = 100
+	numBuckets       = decades*bucketsPerDecade + 1
+)
+
+// histogram records latencies in logarithmic buckets.
+type histogram struct {
+	counts [numBuckets]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func bucketFor(d time.Duration) int {
+	if d <= minLatency {
+		return 0
+	}
+	i := int(math.Ceil(math.Log10(float64(d)/float64(minLatency)) * bucketsPerDecade))
+	return min(i, numBuckets-1)
+}
+
+// upperBound returns the largest latency recorded in bucket i.
+func upperBound(i int) time.Duration {
+	return time.Duration(float64(minLatency) * math.Pow(10, float64(i)/bucketsPerDecade))
+}
+
+func (h *histogram) record(d time.Duration) {
+	h.counts[bucketFor(d)]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(other *histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.max = max(h.max, other.max)
+}
+
+// quantile returns the latency at or below which a fraction q of the
+// recorded latencies fall, never more than the maximum recorded.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	rank = max(rank, 1)
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(upperBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
@@ -0,0 +1,339 @@
+// Package loadgen drives the key API with a configurable concurrency, rate
+// and mix of key lengths, and reports latency percentiles, throughput and a
+// breakdown of errors.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// Generator is the API under load. *keyclient.Client implements it; give it
+// a RetryPolicy with MaxAttempts 1 so every attempt is measured.
+type Generator interface {
+	GenerateKey(ctx context.Context, length int) ([]byte, error)
+}
+
+// Weighted is a key length and its relative share of requests.
+type Weighted struct {
+	Length int
+	Weight int
+}
+
+// ParseMix parses a key length mix such as "32" or "16:1,32:3,64:1"
+// (length:weight; the weight defaults to 1).
+func ParseMix(s string) ([]Weighted, error) {
+	var mix []Weighted
+	for _, part := range strings.Split(s, ",") {
+		lengthStr, weightStr, hasWeight := strings.Cut(strings.TrimSpace(part), ":")
+		w := Weighted{Weight: 1}
+		var err error
+		if w.Length, err = strconv.Atoi(lengthStr); err != nil || w.Length < 1 {
+			return nil, fmt.Errorf("invalid key length %q in mix", lengthStr)
+		}
+		if hasWeight {
+			if w.Weight, err = strconv.Atoi(weightStr); err != nil || w.Weight < 1 {
+				return nil, fmt.Errorf("invalid weight %q in mix", weightStr)
+			}
+		}
+		mix = append(mix, w)
+	}
+	return mix, nil
+}
+
+// Options configures a run. The run stops after Duration or after Requests
+// requests, whichever comes first; at least one must be set.
+type Options struct {
+	Concurrency int           // Concurrent requests
+	Rate        float64       // Total requests per second; 0 sends as fast as Concurrency allows
+	Duration    time.Duration // 0 means no time limit
+	Requests    int           // 0 means no request limit
+	Mix         []Weighted    // Key lengths to request
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case o.Rate < 0:
+		return errors.New("rate must not be negative")
+	case o.Duration < 0 || o.Requests < 0:
+		return errors.New("duration and requests must not be negative")
+	case o.Duration == 0 && o.Requests == 0:
+		return errors.New("a duration or a number of requests is required")
+	case len(o.Mix) == 0:
+		return errors.New("at least one key length is required")
+	}
+	return nil
+}
+
+// Latency summarizes the latencies of successful requests.
+type Latency struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P95  time.Duration `json:"p95_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// LengthReport breaks the results down for one key length.
+type LengthReport struct {
+	Length   int     `json:"length"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	Latency  Latency `json:"latency"`
+}
+
+// Report is the result of a run.
+type Report struct {
+	Requests       int64            `json:"requests"`
+	Successes      int64            `json:"successes"`
+	Errors         map[string]int64 `json:"errors"` // By class: "HTTP 429", "timeout", "transport", ...
+	Elapsed        time.Duration    `json:"elapsed_ns"`
+	TargetRate     float64          `json:"target_rate,omitempty"`
+	Throughput     float64          `json:"throughput_rps"`       // Successful requests per second
+	KeyBytesPerSec float64          `json:"key_bytes_per_second"` // Key material received per second
+	Latency        Latency          `json:"latency"`
+	ByLength       []LengthReport   `json:"by_length"`
+}
+
+// Run generates load against g until the run's limits are reached or ctx
+// is canceled. Requests still in flight when the time limit passes are
+// allowed to finish and are counted.
+func Run(ctx context.Context, g Generator, opts Options) (*Report, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	produceCtx := ctx
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		produceCtx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	work := make(chan int)
+	go produce(produceCtx, work, opts)
+
+	var mu sync.Mutex
+	byLength := make(map[int]*stats)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[int]*stats)
+			for length := range work {
+				st := local[length]
+				if st == nil {
+					st = newStats()
+					local[length] = st
+				}
+				t := time.Now()
+				_, err := g.GenerateKey(ctx, length)
+				st.observe(time.Since(t), err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for length, st := range local {
+				if byLength[length] == nil {
+					byLength[length] = newStats()
+				}
+				byLength[length].merge(st)
+			}
+		}()
+	}
+	wg.Wait()
+	return buildReport(byLength, time.Since(start), opts.Rate), nil
+}
+
+// produce sends the length of each request to work, pacing them at
+// opts.Rate, and closes work when the run is over.
+func produce(ctx context.Context, work chan<- int, opts Options) {
+	defer close(work)
+	totalWeight := 0
+	for _, w := range opts.Mix {
+		totalWeight += w.Weight
+	}
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+	start := time.Now()
+	for i := 0; opts.Requests == 0 || i < opts.Requests; i++ {
+		if interval > 0 {
+			// Requests are scheduled from the start so a slow server makes
+			// the generator catch up rather than lower the offered rate.
+			if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case work <- pick(opts.Mix, totalWeight):
+		}
+	}
+}
+
+func pick(mix []Weighted, totalWeight int) int {
+	n := rand.IntN(totalWeight)
+	for _, w := range mix {
+		if n < w.Weight {
+			return w.Length
+		}
+		n -= w.Weight
+	}
+	return mix[len(mix)-1].Length
+}
+
+// stats accumulates the results for one key length.
+type stats struct {
+	histogram histogram
+	requests  int64
+	errors    map[string]int64
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int64)}
+}
+
+func (s *stats) observe(d time.Duration, err error) {
+	s.requests++
+	if err != nil {
+		s.errors[classify(err)]++
+		return
+	}
+	s.histogram.record(d)
+}
+
+func (s *stats) merge(other *stats) {
+	s.histogram.merge(&other.histogram)
+	s.requests += other.requests
+	for class, n := range other.errors {
+		s.errors[class] += n
+	}
+}
+
+// classify names the kind of failure for the error breakdown.
+func classify(err error) string {
+	var apiErr *keyclient.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("HTTP %d", apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "transport"
+}
+
+func buildReport(byLength map[int]*stats, elapsed time.Duration, rate float64) *Report {
+	r := &Report{Errors: make(map[string]int64), Elapsed: elapsed, TargetRate: rate}
+	var all histogram
+	var keyBytes int64
+	for length, st := range byLength {
+		all.merge(&st.histogram)
+		keyBytes += int64(length) * st.histogram.count
+		r.Requests += st.requests
+		var errs int64
+		for class, n := range st.errors {
+			r.Errors[class] += n
+			errs += n
+		}
+		r.ByLength = append(r.ByLength, LengthReport{
+			Length:   length,
+			Requests: st.requests,
+			Errors:   errs,
+			Latency:  summarize(&st.histogram),
+		})
+	}
+	sort.Slice(r.ByLength, func(i, j int) bool { return r.ByLength[i].Length < r.ByLength[j].Length })
+	r.Successes = all.count
+	r.Latency = summarize(&all)
+	if secs := elapsed.Seconds(); secs > 0 {
+		r.Throughput = float64(r.Successes) / secs
+		r.KeyBytesPerSec = float64(keyBytes) / secs
+	}
+	return r
+}
+
+func summarize(h *histogram) Latency {
+	return Latency{
+		Mean: h.mean(),
+		P50:  h.quantile(0.50),
+		P90:  h.quantile(0.90),
+		P95:  h.quantile(0.95),
+		P99:  h.quantile(0.99),
+		P999: h.quantile(0.999),
+		Max:  h.max,
+	}
+}
+
+// WriteText writes the report in a human-readable form.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Requests:\t%d (%d succeeded, %d failed)\n", r.Requests, r.Successes, r.Requests-r.Successes)
+	fmt.Fprintf(tw, "Elapsed:\t%s\n", r.Elapsed.Round(time.Millisecond))
+	throughput := fmt.Sprintf("%.1f req/s", r.Throughput)
+	if r.TargetRate > 0 {
+		throughput += fmt.Sprintf(" (target %.1f req/s)", r.TargetRate)
+	}
+	fmt.Fprintf(tw, "Throughput:\t%s, %.1f KB/s of key material\n", throughput, r.KeyBytesPerSec/1e3)
+	l := r.Latency
+	fmt.Fprintf(tw, "Latency:\tmean %s, p50 %s, p90 %s, p95 %s, p99 %s, p99.9 %s, max %s\n",
+		round(l.Mean), round(l.P50), round(l.P90), round(l.P95), round(l.P99), round(l.P999), round(l.Max))
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(tw, "Errors:\n")
+		classes := make([]string, 0, len(r.Errors))
+		for class := range r.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(tw, "  %s\t%d\n", class, r.Errors[class])
+		}
+	}
+
+	fmt.Fprintf(tw, "\nLENGTH\tREQUESTS\tERRORS\tP50\tP99\tMAX\n")
+	for _, lr := range r.ByLength {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t%s\n", lr.Length, lr.Requests, lr.Errors,
+			round(lr.Latency.P50), round(lr.Latency.P99), round(lr.Latency.Max))
+	}
+	return tw.Flush()
+}
+
+// round shortens a latency for display.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond / 10)
+	}
+}
@@ -0,0 +1,142 @@
+package loadgen_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/loadgen"
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// fakeGenerator takes delay per request and fails every failEvery-th one
+// with a 429.
+type fakeGenerator struct {
+	delay     time.Duration
+	failEvery int64
+	calls     atomic.Int64
+}
+
+func (g *fakeGenerator) GenerateKey(ctx context.Context, length int) ([]byte, error) {
+	n := g.calls.Add(1)
+	time.Sleep(g.delay)
+	if g.failEvery > 0 && n%g.failEvery == 0 {
+		return nil, &keyclient.Error{StatusCode: http.StatusTooManyRequests}
+	}
+	if length > 1024 {
+		return nil, errors.New("connection reset by peer")
+	}
+	return make([]byte, length), nil
+}
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []loadgen.Weighted
+		wantErr bool
+	}{
+		{"32", []loadgen.Weighted{{Length: 32, Weight: 1}}, false},
+		{"16:1, 32:3,64", []loadgen.Weighted{{16, 1}, {32, 3}, {64, 1}}, false},
+		{"", nil, true},
+		{"0", nil, true},
+		{"32:0", nil, true},
+		{"32:x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := loadgen.ParseMix(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMix(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	g := &fakeGenerator{delay: 2 * time.Millisecond, failEvery: 10}
+	mix := []loadgen.Weighted{{Length: 16, Weight: 3}, {Length: 2048, Weight: 1}}
+	report, err := loadgen.Run(context.Background(), g, loadgen.Options{Concurrency: 8, Requests: 400, Mix: mix})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	if report.Requests != 400 || g.calls.Load() != 400 {
+		t.Fatalf("Requests = %d (generator saw %d), want 400", report.Requests, g.calls.Load())
+	}
+	if got := report.Errors["HTTP 429"]; got != 40 {
+		t.Errorf("Errors[HTTP 429] = %d, want 40", got)
+	}
+	var failed int64
+	for _, n := range report.Errors {
+		failed += n
+	}
+	if report.Successes+failed != report.Requests {
+		t.Errorf("%d successes and %d errors do not add up to %d requests", report.Successes, failed, report.Requests)
+	}
+	if len(report.ByLength) != 2 || report.ByLength[0].Length != 16 || report.ByLength[1].Length != 2048 {
+		t.Fatalf("ByLength = %+v, want 16 and 2048", report.ByLength)
+	}
+	// 3:1 mix; the bounds leave room for randomness.
+	if n := report.ByLength[0].Requests; n < 240 || n > 350 {
+		t.Errorf("%d requests for 16-byte keys, want about 300", n)
+	}
+	if report.ByLength[1].Errors != report.ByLength[1].Requests || report.Errors["transport"] == 0 {
+		t.Errorf("transport failures not broken down: %+v, %v", report.ByLength[1], report.Errors)
+	}
+
+	l := report.Latency
+	if l.P50 < g.delay || l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+		t.Errorf("latency percentiles are not ordered or below the %s delay: %+v", g.delay, l)
+	}
+	if report.Throughput <= 0 || report.KeyBytesPerSec <= 0 {
+		t.Errorf("throughput not computed: %+v", report)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Requests:", "400 (", "p99.9", "HTTP 429", "transport", "LENGTH"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("text report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRun_Rate(t *testing.T) {
+	g := &fakeGenerator{}
+	report, err := loadgen.Run(context.Background(), g, loadgen.Options{
+		Concurrency: 4,
+		Rate:        200,
+		Duration:    300 * time.Millisecond,
+		Mix:         []loadgen.Weighted{{Length: 32, Weight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// 200 req/s for 300ms is 60 requests.
+	if report.Requests < 40 || report.Requests > 62 {
+		t.Errorf("Requests = %d, want about 60 at 200 req/s for 300ms", report.Requests)
+	}
+	if report.TargetRate != 200 {
+		t.Errorf("TargetRate = %v, want 200", report.TargetRate)
+	}
+}
+
+func TestRun_InvalidOptions(t *testing.T) {
+	mix := []loadgen.Weighted{{Length: 32, Weight: 1}}
+	for name, opts := range map[string]loadgen.Options{
+		"No concurrency": {Requests: 1, Mix: mix},
+		"No limit":       {Concurrency: 1, Mix: mix},
+		"No mix":         {Concurrency: 1, Requests: 1},
+		"Negative rate":  {Concurrency: 1, Requests: 1, Rate: -1, Mix: mix},
+	} {
+		if _, err := loadgen.Run(context.Background(), &fakeGenerator{}, opts); err == nil {
+			t.Errorf("%s: Run succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+)
+
+// Supported output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the value of any attribute that could carry key material.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute names whose values are never written, at any
+// level or nesting depth. Callers should not log secrets in the first place;
+// this is the backstop.
+var sensitiveKeys = map[string]bool{
+	"key":           true,
+	"keys":          true,
+	"private_key":   true,
+	"secret":        true,
+	"password":      true,
+	"token":         true,
+	"api_key":       true,
+	"authorization": true,
+	"jwt":           true,
+}
+
+// Secret wraps a value that must never appear in logs. It renders as
+// Redacted wherever it is logged, under any attribute name.
+type Secret string
+
+// LogValue implements slog.LogValuer.
+func (Secret) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// String implements fmt.Stringer so Secret is also redacted by %s and %v.
+func (Secret) String() string {
+	return Redacted
+}
+
+// New returns a logger writing format ("text" or "json") records at or above
+// level to w. Pass a *slog.LevelVar to change the level at runtime. Records
+// logged with a context carry its request ID, and sensitive attributes are
+// redacted.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Discard returns a logger that drops every record, for tests and callers
+// that do not want logs.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// contextHandler adds the request ID, principal and trace from the record's
+// context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := requestid.FromContext(ctx); id != "" {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if p, ok := auth.PrincipalFromContext(ctx); ok {
+			r.AddAttrs(slog.String("principal", p.Name))
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Middleware logs one line per request with its method, route, status,
+// response size and duration. It must run after requestid.Middleware so the
+// line carries the request ID. Request and response bodies are never logged.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			route := r.URL.Path
+			if cr := mux.CurrentRoute(r); cr != nil {
+				if tmpl, err := cr.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+			level := slog.LevelInfo
+			if rw.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(r.Context(), level, "HTTP request",
+				slog.String("method", r.Method),
+				slog.String("route", route),
+				slog.Int("status", rw.status),
+				slog.Int("bytes", rw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// responseWriter records the status code and body size written by the
+// wrapped handler.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
@@ -0,0 +1,122 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+)
+
+const keyMaterial = "c2VjcmV0LWtleS1tYXRlcmlhbA=="
+
+func TestNew_Redaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	logger.Info("plain attribute", "key", keyMaterial)
+	logger.Info("nested attribute", slog.Group("response", slog.String("Key", keyMaterial)))
+	logger.Info("secret value", "value", logging.Secret(keyMaterial))
+	logger.Info("formatted secret", "detail", fmt.Sprintf("%v", logging.Secret(keyMaterial)))
+	logger.With("private_key", keyMaterial).Info("derived logger")
+
+	if strings.Contains(buf.String(), keyMaterial) {
+		t.Fatalf("log output contains key material:\n%s", buf.String())
+	}
+	if n := strings.Count(buf.String(), logging.Redacted); n != 5 {
+		t.Errorf("found %d redacted values, want 5:\n%s", n, buf.String())
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatText, slog.LevelWarn)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+	if out := buf.String(); strings.Contains(out, "dropped") || !strings.Contains(out, "level=WARN msg=kept") {
+		t.Errorf("unexpected text output: %q", out)
+	}
+
+	if _, err := logging.New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestMiddleware_RequestCorrelation(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+
+	router := mux.NewRouter()
+	router.Use(requestid.Middleware, logging.Middleware(logger))
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(r.Context(), &auth.Principal{Name: "alice"})
+		logger.InfoContext(ctx, "generating key")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"key":%q}`, keyMaterial)
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+	}{
+		{"Incoming ID honored", "trace-abc-123"},
+		{"Invalid ID replaced", "has spaces"},
+		{"Missing ID generated", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/key/16", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestid.Header, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(requestid.Header)
+			if id == "" || (tt.incoming == "trace-abc-123" && id != tt.incoming) || id == "has spaces" {
+				t.Fatalf("response request ID = %q", id)
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("expected 2 log lines, got %d:\n%s", len(lines), buf.String())
+			}
+			var app, access map[string]interface{}
+			json.Unmarshal([]byte(lines[0]), &app)
+			json.Unmarshal([]byte(lines[1]), &access)
+			if app["request_id"] != id || app["principal"] != "alice" {
+				t.Errorf("handler log line = %v, want request_id %q and principal alice", app, id)
+			}
+			if access["request_id"] != id || access["route"] != "/key/{length}" || access["status"] != float64(200) {
+				t.Errorf("access log line = %v", access)
+			}
+			if strings.Contains(buf.String(), keyMaterial) {
+				t.Errorf("log output contains the response body:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	if logging.Discard().Enabled(context.Background(), slog.LevelError) {
+		t.Error("Discard logger should not be enabled at any level")
+	}
+}
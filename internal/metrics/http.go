@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// UnmatchedRoute is the route label for requests no route matched (404s and
+// 405s from the router), so arbitrary paths never become label values.
+const UnmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// InstrumentHandler wraps the whole router, recording every request's count,
+// latency and body sizes by route template, method and status code, plus the
+// number of requests in flight. Wrapping the router (rather than adding a
+// router middleware) means requests that match no route are counted too.
+// Register RouteMiddleware on the router so matched requests are labeled with
+// their route template.
+func (m *PrometheusMetrics) InstrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.httpRequestsInFlight.Inc()
+		defer m.httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		route := UnmatchedRoute
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+
+		labels := []string{route, method(r.Method), strconv.Itoa(rw.status)}
+		m.httpRequestsTotal.WithLabelValues(labels...).Inc()
+		m.httpRequestDurationSeconds.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		reqSize := body.n
+		if r.ContentLength > reqSize {
+			reqSize = r.ContentLength
+		}
+		m.httpRequestSizeBytes.WithLabelValues(labels...).Observe(float64(reqSize))
+		m.httpResponseSizeBytes.WithLabelValues(labels...).Observe(float64(rw.bytes))
+	})
+}
+
+// RouteMiddleware records the matched route template for InstrumentHandler.
+// It must be registered on the router with Use.
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if cr := mux.CurrentRoute(r); cr != nil {
+				if tmpl, err := cr.GetPathTemplate(); err == nil {
+					*route = tmpl
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// method bounds the method label to the standard HTTP methods.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "other"
+}
+
+// countingReader counts the request body bytes read by the handler.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status code and body size written by the
+// wrapped handler.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+)
+
+var (
+	namedKeyOperationsDesc = prometheus.NewDesc(
+		"key_server_named_key_operations_total",
+		"Total number of operations performed with named keys, by algorithm and operation.",
+		[]string{"algorithm", "operation"}, nil,
+	)
+	namedKeyBytesDesc = prometheus.NewDesc(
+		"key_server_named_key_bytes_total",
+		"Total number of bytes processed with named keys, by algorithm and operation.",
+		[]string{"algorithm", "operation"}, nil,
+	)
+)
+
+// keyUsageCollector reports the usage counters of named keys at scrape
+// time, so they stay exact without the key store depending on metrics.
+// They are aggregated by algorithm: a label per key would grow with every
+// key ever created.
+type keyUsageCollector struct {
+	totals func() []keystore.UsageTotal
+}
+
+func (c keyUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- namedKeyOperationsDesc
+	ch <- namedKeyBytesDesc
+}
+
+func (c keyUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.totals() {
+		for op, n := range t.Usage.Operations {
+			ch <- prometheus.MustNewConstMetric(namedKeyOperationsDesc, prometheus.CounterValue, float64(n), t.Algorithm, op)
+		}
+		for op, n := range t.Usage.Bytes {
+			ch <- prometheus.MustNewConstMetric(namedKeyBytesDesc, prometheus.CounterValue, float64(n), t.Algorithm, op)
+		}
+	}
+}
+
+// RegisterKeyUsage exports the named key usage totals returned by totals.
+func (m *PrometheusMetrics) RegisterKeyUsage(totals func() []keystore.UsageTotal) {
+	m.registry.MustRegister(keyUsageCollector{totals: totals})
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+)
+
+// InvalidLengthClass labels requests for zero or negative key lengths.
+const InvalidLengthClass = "invalid"
+
+// DefaultLengthClasses returns power-of-two class bounds from 16 bytes up to
+// the first bound that covers maxKeySize.
+func DefaultLengthClasses(maxKeySize int) []int {
+	bounds := []int{16}
+	for bounds[len(bounds)-1] < maxKeySize {
+		bounds = append(bounds, bounds[len(bounds)-1]*2)
+	}
+	return bounds
+}
+
+// lengthClasses maps key lengths onto a fixed set of label values so the
+// number of series does not grow with the lengths clients ask for.
+type lengthClasses struct {
+	bounds []int    // Inclusive upper bounds, strictly increasing
+	labels []string // labels[i] covers (bounds[i-1], bounds[i]]; the last entry covers everything above
+}
+
+func newLengthClasses(bounds []int) lengthClasses {
+	c := lengthClasses{bounds: bounds}
+	lower := 1
+	for _, b := range bounds {
+		c.labels = append(c.labels, strconv.Itoa(lower)+"-"+strconv.Itoa(b))
+		lower = b + 1
+	}
+	c.labels = append(c.labels, ">"+strconv.Itoa(bounds[len(bounds)-1]))
+	return c
+}
+
+// label returns the class label for length.
+func (c lengthClasses) label(length int) string {
+	if length <= 0 {
+		return InvalidLengthClass
+	}
+	return c.labels[sort.SearchInts(c.bounds, length)]
+}
+
+// buckets returns the class bounds as histogram buckets.
+func (c lengthClasses) buckets() []float64 {
+	buckets := make([]float64, len(c.bounds))
+	for i, b := range c.bounds {
+		buckets[i] = float64(b)
+	}
+	return buckets
+}
@@ -1,46 +1,128 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"net/http" // Required for http.Handler
-	"strconv"  // Required for strconv.Itoa
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MetricsService defines the interface for collecting application metrics.
 type MetricsService interface {
-	IncHTTPStatusCounter(statusCode int)
 	IncrementKeyGenerationRequests()
 	IncrementInvalidKeyLengthErrors()
 	IncrementKeyGenerationErrors()
 	ObserveKeyGenerationDuration(duration float64, length int)
 	ObserveKeyLength(length float64)
 	RecordKeyGeneration(length int, success bool) // <--- ADDED TO INTERFACE
-	MetricsHandler() http.Handler                 // Returns an http.Handler for the /metrics endpoint
+	RecordAuthentication(method, result string)
+	MetricsHandler() http.Handler // Returns an http.Handler for the /metrics endpoint
 }
 
 // PrometheusMetrics implements the MetricsService interface using Prometheus.
 type PrometheusMetrics struct {
 	httpRequestsTotal            *prometheus.CounterVec
+	httpRequestsInFlight         prometheus.Gauge
+	httpRequestDurationSeconds   *prometheus.HistogramVec
+	httpRequestSizeBytes         *prometheus.HistogramVec
+	httpResponseSizeBytes        *prometheus.HistogramVec
 	keyGenerationRequestsTotal   prometheus.Counter
 	invalidKeyLengthErrorsTotal  prometheus.Counter
 	keyGenerationErrorsTotal     prometheus.Counter
 	keyGenerationDurationSeconds *prometheus.HistogramVec
 	generatedKeyLengthBytes      prometheus.Histogram // <--- CHANGED: Removed '*' - now it's the interface type
 	keyGenerationsTotal          *prometheus.CounterVec
+	authenticationAttemptsTotal  *prometheus.CounterVec
+	policyDenialsTotal           *prometheus.CounterVec
+	throttledRequestsTotal       *prometheus.CounterVec
+	configReloadsTotal           *prometheus.CounterVec
+	configLastReloadSuccess      prometheus.Gauge
+	keyRequestsInFlight          prometheus.Gauge
+	idempotencyRequestsTotal     *prometheus.CounterVec
+	keyOperationsTotal           *prometheus.CounterVec
+	keyStateTransitionsTotal     *prometheus.CounterVec
+	namedKeys                    *prometheus.GaugeVec
 	registry                     *prometheus.Registry // Store the registry
+	lengths                      lengthClasses        // Bounds the "length" label values
 }
 
+// Options configures NewPrometheusMetricsWithOptions.
+type Options struct {
+	MaxKeySize    int   // Largest key length served, in bytes
+	LengthClasses []int // Increasing upper bounds of the key length label classes (empty = DefaultLengthClasses)
+}
+
+// Native histogram settings shared by the latency histograms. Scrapers that
+// negotiate the protobuf format get high-resolution native histograms; others
+// get the classic buckets.
+const (
+	nativeHistogramBucketFactor = 1.1
+	nativeHistogramMaxBuckets   = 160
+	nativeHistogramResetPeriod  = time.Hour
+)
+
 // NewPrometheusMetricsWithRegistry creates a new PrometheusMetrics instance with a custom registry.
 func NewPrometheusMetricsWithRegistry(registry *prometheus.Registry, maxKeySize int) *PrometheusMetrics {
+	return NewPrometheusMetricsWithOptions(registry, Options{MaxKeySize: maxKeySize})
+}
+
+// NewPrometheusMetricsWithOptions creates a new PrometheusMetrics instance with
+// a custom registry. Key lengths are reported by class rather than exact value
+// so the number of series stays bounded. Go runtime, process and build
+// information collectors are added to the registry unless already present.
+func NewPrometheusMetricsWithOptions(registry *prometheus.Registry, opts Options) *PrometheusMetrics {
+	bounds := opts.LengthClasses
+	if len(bounds) == 0 {
+		bounds = DefaultLengthClasses(opts.MaxKeySize)
+	}
+	lengths := newLengthClasses(bounds)
+
 	m := &PrometheusMetrics{
+		lengths: lengths,
 		httpRequestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "http_requests_total",
-				Help: "Total number of HTTP requests by status code.",
+				Help: "Total number of HTTP requests by route template, method and status code.",
+			},
+			[]string{"route", "method", "code"},
+		),
+		httpRequestsInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "Number of HTTP requests currently being served.",
+			},
+		),
+		httpRequestDurationSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:                            "http_request_duration_seconds",
+				Help:                            "HTTP request latency by route template, method and status code.",
+				Buckets:                         prometheus.DefBuckets,
+				NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+				NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+				NativeHistogramMinResetDuration: nativeHistogramResetPeriod,
+			},
+			[]string{"route", "method", "code"},
+		),
+		httpRequestSizeBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_size_bytes",
+				Help:    "HTTP request body size by route template, method and status code.",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 8), // 64B to 1MiB
+			},
+			[]string{"route", "method", "code"},
+		),
+		httpResponseSizeBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "HTTP response body size by route template, method and status code.",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 8), // 64B to 1MiB
 			},
-			[]string{"code"},
+			[]string{"route", "method", "code"},
 		),
 		keyGenerationRequestsTotal: prometheus.NewCounter(
 			prometheus.CounterOpts{
@@ -62,37 +144,135 @@ func NewPrometheusMetricsWithRegistry(registry *prometheus.Registry, maxKeySize
 		),
 		keyGenerationDurationSeconds: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:    "key_generation_duration_seconds",
-				Help:    "Time taken to generate a key.",
-				Buckets: prometheus.LinearBuckets(0, 3.2, 20), // Example buckets
+				Name:                            "key_generation_duration_seconds",
+				Help:                            "Time taken to generate a key, by key length class.",
+				Buckets:                         prometheus.ExponentialBuckets(1e-6, 4, 10), // 1µs to ~262ms
+				NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+				NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+				NativeHistogramMinResetDuration: nativeHistogramResetPeriod,
 			},
-			[]string{"length"}, // Label for key length
+			[]string{"length"}, // Key length class, e.g. "17-32"
 		),
 		generatedKeyLengthBytes: prometheus.NewHistogram( // <--- No '*' here
 			prometheus.HistogramOpts{
 				Name:    "key_server_generated_key_length_bytes",
 				Help:    "Histogram of generated key lengths in bytes.",
-				Buckets: prometheus.LinearBuckets(0, float64(maxKeySize/10), 10), // Example: 0, 10, 20...
+				Buckets: lengths.buckets(), // One bucket per length class
 			},
 		),
 		keyGenerationsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "key_generations_total",
-				Help: "Total number of key generation attempts by length and success status.",
+				Help: "Total number of key generation attempts by key length class and success status.",
+			},
+			[]string{"length", "status"}, // Labels for length class and success/failure
+		),
+		authenticationAttemptsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_authentication_attempts_total",
+				Help: "Total number of authentication attempts by method and result.",
+			},
+			[]string{"method", "result"},
+		),
+		policyDenialsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_policy_denials_total",
+				Help: "Total number of operations rejected by the authorization policy, by action.",
+			},
+			[]string{"action"},
+		),
+		throttledRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_throttled_requests_total",
+				Help: "Total number of requests rejected by rate limits or quotas, by route and reason.",
+			},
+			[]string{"route", "reason"},
+		),
+		configReloadsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_config_reloads_total",
+				Help: "Total number of configuration reload attempts, by result (success or failure).",
+			},
+			[]string{"result"},
+		),
+		configLastReloadSuccess: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "key_server_config_last_reload_success_timestamp_seconds",
+				Help: "Unix time of the last successful configuration reload.",
+			},
+		),
+		keyRequestsInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "key_server_key_requests_in_flight",
+				Help: "Number of key issuance requests in progress. Shutdown waits for these to drain.",
+			},
+		),
+		idempotencyRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_idempotency_requests_total",
+				Help: "Total number of requests carrying an Idempotency-Key, by outcome (stored, replayed, mismatch or in_progress).",
+			},
+			[]string{"outcome"},
+		),
+		keyOperationsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_key_operations_total",
+				Help: "Total number of named key operations, by operation (create, encrypt, decrypt, sign, verify or state) and outcome (success, denied or error).",
+			},
+			[]string{"operation", "outcome"},
+		),
+		keyStateTransitionsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "key_server_key_state_transitions_total",
+				Help: "Total number of named key state transitions, by previous and new state.",
+			},
+			[]string{"from", "to"},
+		),
+		namedKeys: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "key_server_named_keys",
+				Help: "Number of named keys in each state, as of the last state check.",
 			},
-			[]string{"length", "status"}, // Labels for length and success/failure
+			[]string{"state"},
 		),
 		registry: registry, // Store the provided registry
 	}
 
 	// Register all metrics with the provided registry
 	registry.MustRegister(m.httpRequestsTotal)
+	registry.MustRegister(m.httpRequestsInFlight)
+	registry.MustRegister(m.httpRequestDurationSeconds)
+	registry.MustRegister(m.httpRequestSizeBytes)
+	registry.MustRegister(m.httpResponseSizeBytes)
 	registry.MustRegister(m.keyGenerationRequestsTotal)
 	registry.MustRegister(m.invalidKeyLengthErrorsTotal)
 	registry.MustRegister(m.keyGenerationErrorsTotal)
 	registry.MustRegister(m.keyGenerationDurationSeconds)
 	registry.MustRegister(m.generatedKeyLengthBytes) // <--- Now correctly registered
 	registry.MustRegister(m.keyGenerationsTotal)
+	registry.MustRegister(m.authenticationAttemptsTotal)
+	registry.MustRegister(m.policyDenialsTotal)
+	registry.MustRegister(m.throttledRequestsTotal)
+	registry.MustRegister(m.configReloadsTotal)
+	registry.MustRegister(m.configLastReloadSuccess)
+	registry.MustRegister(m.keyRequestsInFlight)
+	registry.MustRegister(m.idempotencyRequestsTotal)
+	registry.MustRegister(m.keyOperationsTotal)
+	registry.MustRegister(m.keyStateTransitionsTotal)
+	registry.MustRegister(m.namedKeys)
+
+	for _, c := range []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewBuildInfoCollector(),
+	} {
+		if err := registry.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				panic(err)
+			}
+		}
+	}
 
 	return m
 }
@@ -102,11 +282,6 @@ func NewPrometheusMetrics() *PrometheusMetrics {
 	return NewPrometheusMetricsWithRegistry(prometheus.DefaultRegisterer.(*prometheus.Registry), 1024)
 }
 
-// IncHTTPStatusCounter increments the counter for HTTP requests by status code.
-func (m *PrometheusMetrics) IncHTTPStatusCounter(statusCode int) {
-	m.httpRequestsTotal.WithLabelValues(strconv.Itoa(statusCode)).Inc()
-}
-
 // IncrementKeyGenerationRequests increments the total count of key generation requests.
 func (m *PrometheusMetrics) IncrementKeyGenerationRequests() {
 	m.keyGenerationRequestsTotal.Inc()
@@ -124,7 +299,23 @@ func (m *PrometheusMetrics) IncrementKeyGenerationErrors() {
 
 // ObserveKeyGenerationDuration observes the duration of a key generation operation.
 func (m *PrometheusMetrics) ObserveKeyGenerationDuration(duration float64, length int) {
-	m.keyGenerationDurationSeconds.WithLabelValues(strconv.Itoa(length)).Observe(duration)
+	m.keyGenerationDurationSeconds.WithLabelValues(m.lengths.label(length)).Observe(duration)
+}
+
+// ObserveKeyGenerationDurationContext observes the duration of a key generation
+// operation, attaching the trace and span IDs from ctx as an exemplar when the
+// span is sampled. Exemplars are only exposed in the OpenMetrics format.
+func (m *PrometheusMetrics) ObserveKeyGenerationDurationContext(ctx context.Context, duration float64, length int) {
+	observer := m.keyGenerationDurationSeconds.WithLabelValues(m.lengths.label(length))
+	sc := trace.SpanContextFromContext(ctx)
+	if eo, ok := observer.(prometheus.ExemplarObserver); ok && sc.IsSampled() {
+		eo.ObserveWithExemplar(duration, prometheus.Labels{
+			"trace_id": sc.TraceID().String(),
+			"span_id":  sc.SpanID().String(),
+		})
+		return
+	}
+	observer.Observe(duration)
 }
 
 // ObserveKeyLength observes the length of a generated key.
@@ -138,10 +329,64 @@ func (m *PrometheusMetrics) RecordKeyGeneration(length int, success bool) { // <
 	if success {
 		status = "success"
 	}
-	m.keyGenerationsTotal.WithLabelValues(strconv.Itoa(length), status).Inc()
+	m.keyGenerationsTotal.WithLabelValues(m.lengths.label(length), status).Inc()
+}
+
+// RecordAuthentication records an authentication attempt by method (api_key, jwt, none)
+// and result (success, failure).
+func (m *PrometheusMetrics) RecordAuthentication(method, result string) {
+	m.authenticationAttemptsTotal.WithLabelValues(method, result).Inc()
+}
+
+// RecordPolicyDenial records an operation rejected by the authorization policy.
+func (m *PrometheusMetrics) RecordPolicyDenial(action string) {
+	m.policyDenialsTotal.WithLabelValues(action).Inc()
+}
+
+// RecordThrottled records a request rejected by a rate limit or quota. Reason is
+// one of rate, daily_keys or daily_bytes.
+func (m *PrometheusMetrics) RecordThrottled(route, reason string) {
+	m.throttledRequestsTotal.WithLabelValues(route, reason).Inc()
+}
+
+// RecordConfigReload records the result of a configuration reload.
+func (m *PrometheusMetrics) RecordConfigReload(success bool) {
+	if !success {
+		m.configReloadsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	m.configReloadsTotal.WithLabelValues("success").Inc()
+	m.configLastReloadSuccess.SetToCurrentTime()
+}
+
+// AddKeyRequestsInFlight adjusts the in-flight key request gauge by delta.
+func (m *PrometheusMetrics) AddKeyRequestsInFlight(delta float64) {
+	m.keyRequestsInFlight.Add(delta)
+}
+
+// RecordIdempotency records the outcome of a request carrying an
+// Idempotency-Key.
+func (m *PrometheusMetrics) RecordIdempotency(outcome string) {
+	m.idempotencyRequestsTotal.WithLabelValues(outcome).Inc()
+}
+
+// RecordKeyOperation records an operation on a named key and its outcome.
+func (m *PrometheusMetrics) RecordKeyOperation(operation, outcome string) {
+	m.keyOperationsTotal.WithLabelValues(operation, outcome).Inc()
+}
+
+// RecordKeyStateTransition records a named key moving from one state to
+// another.
+func (m *PrometheusMetrics) RecordKeyStateTransition(from, to string) {
+	m.keyStateTransitionsTotal.WithLabelValues(from, to).Inc()
+}
+
+// SetNamedKeys sets the number of named keys in state.
+func (m *PrometheusMetrics) SetNamedKeys(state string, n int) {
+	m.namedKeys.WithLabelValues(state).Set(float64(n))
 }
 
 // MetricsHandler returns an http.Handler for the /metrics endpoint.
 func (m *PrometheusMetrics) MetricsHandler() http.Handler {
-	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
 }
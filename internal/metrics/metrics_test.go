@@ -1,12 +1,22 @@
 package metrics_test
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
 	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
 )
 
@@ -17,9 +27,6 @@ func TestPrometheusMetrics_MetricsOutput(t *testing.T) {
 	metricsSvc := metrics.NewPrometheusMetricsWithRegistry(registry, testMaxKeySize)
 
 	// Simulate metric activity for ALL defined metrics
-	metricsSvc.IncHTTPStatusCounter(200)
-	metricsSvc.IncHTTPStatusCounter(404)
-
 	metricsSvc.IncrementKeyGenerationRequests()
 	metricsSvc.IncrementKeyGenerationRequests() // Two requests
 
@@ -58,74 +65,46 @@ func TestPrometheusMetrics_MetricsOutput(t *testing.T) {
 
 	// IMPORTANT: Paste the EXACT output you copy from the terminal (after running the temporary code)
 	// into this multiline string.
-	expected := `# HELP http_requests_total Total number of HTTP requests by status code.
-# TYPE http_requests_total counter
-http_requests_total{code="200"} 1
-http_requests_total{code="404"} 1
-# HELP key_generation_duration_seconds Time taken to generate a key.
+	expected := `# HELP http_requests_in_flight Number of HTTP requests currently being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 0
+# HELP key_generation_duration_seconds Time taken to generate a key, by key length class.
 # TYPE key_generation_duration_seconds histogram
-key_generation_duration_seconds_bucket{length="16",le="0"} 0
-key_generation_duration_seconds_bucket{length="16",le="3.2"} 1
-key_generation_duration_seconds_bucket{length="16",le="6.4"} 1
-key_generation_duration_seconds_bucket{length="16",le="9.600000000000001"} 1
-key_generation_duration_seconds_bucket{length="16",le="12.8"} 1
-key_generation_duration_seconds_bucket{length="16",le="16"} 1
-key_generation_duration_seconds_bucket{length="16",le="19.2"} 1
-key_generation_duration_seconds_bucket{length="16",le="22.4"} 1
-key_generation_duration_seconds_bucket{length="16",le="25.599999999999998"} 1
-key_generation_duration_seconds_bucket{length="16",le="28.799999999999997"} 1
-key_generation_duration_seconds_bucket{length="16",le="31.999999999999996"} 1
-key_generation_duration_seconds_bucket{length="16",le="35.199999999999996"} 1
-key_generation_duration_seconds_bucket{length="16",le="38.4"} 1
-key_generation_duration_seconds_bucket{length="16",le="41.6"} 1
-key_generation_duration_seconds_bucket{length="16",le="44.800000000000004"} 1
-key_generation_duration_seconds_bucket{length="16",le="48.00000000000001"} 1
-key_generation_duration_seconds_bucket{length="16",le="51.20000000000001"} 1
-key_generation_duration_seconds_bucket{length="16",le="54.40000000000001"} 1
-key_generation_duration_seconds_bucket{length="16",le="57.600000000000016"} 1
-key_generation_duration_seconds_bucket{length="16",le="60.80000000000002"} 1
-key_generation_duration_seconds_bucket{length="16",le="+Inf"} 1
-key_generation_duration_seconds_sum{length="16"} 0.01
-key_generation_duration_seconds_count{length="16"} 1
-key_generation_duration_seconds_bucket{length="32",le="0"} 0
-key_generation_duration_seconds_bucket{length="32",le="3.2"} 1
-key_generation_duration_seconds_bucket{length="32",le="6.4"} 1
-key_generation_duration_seconds_bucket{length="32",le="9.600000000000001"} 1
-key_generation_duration_seconds_bucket{length="32",le="12.8"} 1
-key_generation_duration_seconds_bucket{length="32",le="16"} 1
-key_generation_duration_seconds_bucket{length="32",le="19.2"} 1
-key_generation_duration_seconds_bucket{length="32",le="22.4"} 1
-key_generation_duration_seconds_bucket{length="32",le="25.599999999999998"} 1
-key_generation_duration_seconds_bucket{length="32",le="28.799999999999997"} 1
-key_generation_duration_seconds_bucket{length="32",le="31.999999999999996"} 1
-key_generation_duration_seconds_bucket{length="32",le="35.199999999999996"} 1
-key_generation_duration_seconds_bucket{length="32",le="38.4"} 1
-key_generation_duration_seconds_bucket{length="32",le="41.6"} 1
-key_generation_duration_seconds_bucket{length="32",le="44.800000000000004"} 1
-key_generation_duration_seconds_bucket{length="32",le="48.00000000000001"} 1
-key_generation_duration_seconds_bucket{length="32",le="51.20000000000001"} 1
-key_generation_duration_seconds_bucket{length="32",le="54.40000000000001"} 1
-key_generation_duration_seconds_bucket{length="32",le="57.600000000000016"} 1
-key_generation_duration_seconds_bucket{length="32",le="60.80000000000002"} 1
-key_generation_duration_seconds_bucket{length="32",le="+Inf"} 1
-key_generation_duration_seconds_sum{length="32"} 0.05
-key_generation_duration_seconds_count{length="32"} 1
-# HELP key_generations_total Total number of key generation attempts by length and success status.
+key_generation_duration_seconds_bucket{length="1-16",le="1e-06"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="4e-06"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="1.6e-05"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="6.4e-05"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="0.000256"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="0.001024"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="0.004096"} 0
+key_generation_duration_seconds_bucket{length="1-16",le="0.016384"} 1
+key_generation_duration_seconds_bucket{length="1-16",le="0.065536"} 1
+key_generation_duration_seconds_bucket{length="1-16",le="0.262144"} 1
+key_generation_duration_seconds_bucket{length="1-16",le="+Inf"} 1
+key_generation_duration_seconds_sum{length="1-16"} 0.01
+key_generation_duration_seconds_count{length="1-16"} 1
+key_generation_duration_seconds_bucket{length="17-32",le="1e-06"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="4e-06"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="1.6e-05"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="6.4e-05"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="0.000256"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="0.001024"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="0.004096"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="0.016384"} 0
+key_generation_duration_seconds_bucket{length="17-32",le="0.065536"} 1
+key_generation_duration_seconds_bucket{length="17-32",le="0.262144"} 1
+key_generation_duration_seconds_bucket{length="17-32",le="+Inf"} 1
+key_generation_duration_seconds_sum{length="17-32"} 0.05
+key_generation_duration_seconds_count{length="17-32"} 1
+# HELP key_generations_total Total number of key generation attempts by key length class and success status.
 # TYPE key_generations_total counter
-key_generations_total{length="16",status="success"} 1
-key_generations_total{length="32",status="failure"} 1
+key_generations_total{length="1-16",status="success"} 1
+key_generations_total{length="17-32",status="failure"} 1
 # HELP key_server_generated_key_length_bytes Histogram of generated key lengths in bytes.
 # TYPE key_server_generated_key_length_bytes histogram
-key_server_generated_key_length_bytes_bucket{le="0"} 0
-key_server_generated_key_length_bytes_bucket{le="6"} 0
-key_server_generated_key_length_bytes_bucket{le="12"} 0
-key_server_generated_key_length_bytes_bucket{le="18"} 1
-key_server_generated_key_length_bytes_bucket{le="24"} 1
-key_server_generated_key_length_bytes_bucket{le="30"} 1
-key_server_generated_key_length_bytes_bucket{le="36"} 2
-key_server_generated_key_length_bytes_bucket{le="42"} 2
-key_server_generated_key_length_bytes_bucket{le="48"} 2
-key_server_generated_key_length_bytes_bucket{le="54"} 2
+key_server_generated_key_length_bytes_bucket{le="16"} 1
+key_server_generated_key_length_bytes_bucket{le="32"} 2
+key_server_generated_key_length_bytes_bucket{le="64"} 2
 key_server_generated_key_length_bytes_bucket{le="+Inf"} 2
 key_server_generated_key_length_bytes_sum 48
 key_server_generated_key_length_bytes_count 2
@@ -139,7 +118,238 @@ key_server_key_generation_errors_total 1
 # TYPE key_server_key_generation_requests_total counter
 key_server_key_generation_requests_total 2
 ` + "\n" // Added the missing newline character here.
-	if err := testutil.CollectAndCompare(registry, strings.NewReader(expected)); err != nil {
+	// Runtime and process collectors are checked separately; their values vary.
+	names := []string{
+		"http_requests_in_flight",
+		"key_generation_duration_seconds",
+		"key_generations_total",
+		"key_server_generated_key_length_bytes",
+		"key_server_invalid_key_length_errors_total",
+		"key_server_key_generation_errors_total",
+		"key_server_key_generation_requests_total",
+	}
+	if err := testutil.CollectAndCompare(registry, strings.NewReader(expected), names...); err != nil {
 		t.Errorf("Unexpected metrics output:\n%s", err)
 	}
 }
+
+// TestPrometheusMetrics_LengthClasses tests that key lengths map onto a bounded
+// set of label values.
+func TestPrometheusMetrics_LengthClasses(t *testing.T) {
+	if got := metrics.DefaultLengthClasses(1024); fmt.Sprint(got) != "[16 32 64 128 256 512 1024]" {
+		t.Errorf("DefaultLengthClasses(1024) = %v", got)
+	}
+	if got := metrics.DefaultLengthClasses(100); fmt.Sprint(got) != "[16 32 64 128]" {
+		t.Errorf("DefaultLengthClasses(100) = %v", got)
+	}
+
+	registry := prometheus.NewRegistry()
+	metricsSvc := metrics.NewPrometheusMetricsWithOptions(registry, metrics.Options{MaxKeySize: 1024, LengthClasses: []int{32, 256}})
+	for length := -1; length <= 1024; length++ {
+		metricsSvc.RecordKeyGeneration(length, true)
+	}
+
+	expected := `# HELP key_generations_total Total number of key generation attempts by key length class and success status.
+# TYPE key_generations_total counter
+key_generations_total{length="1-32",status="success"} 32
+key_generations_total{length="33-256",status="success"} 224
+key_generations_total{length=">256",status="success"} 768
+key_generations_total{length="invalid",status="success"} 2
+`
+	if err := testutil.CollectAndCompare(registry, strings.NewReader(expected), "key_generations_total"); err != nil {
+		t.Errorf("Unexpected length classes:\n%s", err)
+	}
+}
+
+// TestPrometheusMetrics_ConfigReloads tests that reload results are counted
+// and only successes move the last-success timestamp.
+func TestPrometheusMetrics_ConfigReloads(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metricsSvc := metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+	metricsSvc.RecordConfigReload(false)
+	metricsSvc.RecordConfigReload(false)
+
+	expected := `# HELP key_server_config_last_reload_success_timestamp_seconds Unix time of the last successful configuration reload.
+# TYPE key_server_config_last_reload_success_timestamp_seconds gauge
+key_server_config_last_reload_success_timestamp_seconds 0
+# HELP key_server_config_reloads_total Total number of configuration reload attempts, by result (success or failure).
+# TYPE key_server_config_reloads_total counter
+key_server_config_reloads_total{result="failure"} 2
+`
+	if err := testutil.CollectAndCompare(registry, strings.NewReader(expected),
+		"key_server_config_reloads_total", "key_server_config_last_reload_success_timestamp_seconds"); err != nil {
+		t.Errorf("Unexpected reload metrics after failures:\n%s", err)
+	}
+
+	metricsSvc.RecordConfigReload(true)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned an error: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "key_server_config_last_reload_success_timestamp_seconds" && mf.GetMetric()[0].GetGauge().GetValue() == 0 {
+			t.Error("last reload success timestamp not set after a successful reload")
+		}
+	}
+}
+
+// TestPrometheusMetrics_KeyUsage tests that named key usage counters are
+// read from the usage totals at scrape time.
+func TestPrometheusMetrics_KeyUsage(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metricsSvc := metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+	totals := []keystore.UsageTotal{{Algorithm: keystore.AlgorithmAES256GCM, Usage: keystore.Usage{
+		Operations: map[string]uint64{keystore.OpEncrypt: 3, keystore.OpDecrypt: 1},
+		Bytes:      map[string]uint64{keystore.OpEncrypt: 300, keystore.OpDecrypt: 128},
+	}}}
+	metricsSvc.RegisterKeyUsage(func() []keystore.UsageTotal { return totals })
+
+	expected := `# HELP key_server_named_key_bytes_total Total number of bytes processed with named keys, by algorithm and operation.
+# TYPE key_server_named_key_bytes_total counter
+key_server_named_key_bytes_total{algorithm="aes-256-gcm",operation="decrypt"} 128
+key_server_named_key_bytes_total{algorithm="aes-256-gcm",operation="encrypt"} 300
+# HELP key_server_named_key_operations_total Total number of operations performed with named keys, by algorithm and operation.
+# TYPE key_server_named_key_operations_total counter
+key_server_named_key_operations_total{algorithm="aes-256-gcm",operation="decrypt"} 1
+key_server_named_key_operations_total{algorithm="aes-256-gcm",operation="encrypt"} 3
+`
+	if err := testutil.CollectAndCompare(registry, strings.NewReader(expected),
+		"key_server_named_key_operations_total", "key_server_named_key_bytes_total"); err != nil {
+		t.Errorf("Unexpected key usage metrics:\n%s", err)
+	}
+}
+
+// TestPrometheusMetrics_RuntimeCollectors tests that runtime, process and build
+// information is exported, including on a registry that already has it.
+func TestPrometheusMetrics_RuntimeCollectors(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned an error: %v", err)
+	}
+	found := map[string]bool{}
+	for _, mf := range families {
+		found[mf.GetName()] = true
+	}
+	names := []string{"go_goroutines", "go_build_info"}
+	if runtime.GOOS == "linux" {
+		// The process collector only reports on Linux and Windows.
+		names = append(names, "process_start_time_seconds")
+	}
+	for _, name := range names {
+		if !found[name] {
+			t.Errorf("metric %s not exported", name)
+		}
+	}
+}
+
+// TestObserveKeyGenerationDurationContext_Exemplar tests that sampled spans are
+// attached to the duration histogram as exemplars.
+func TestObserveKeyGenerationDurationContext_Exemplar(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metricsSvc := metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	unsampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	metricsSvc.ObserveKeyGenerationDurationContext(sampled, 0.5, 16)
+	metricsSvc.ObserveKeyGenerationDurationContext(unsampled, 0.5, 32)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned an error: %v", err)
+	}
+	exemplars := map[string]int{}
+	for _, mf := range families {
+		if mf.GetName() != "key_generation_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			length := m.GetLabel()[0].GetValue()
+			for _, b := range m.GetHistogram().GetBucket() {
+				if ex := b.GetExemplar(); ex != nil {
+					exemplars[length]++
+					for _, l := range ex.GetLabel() {
+						if l.GetName() == "trace_id" && l.GetValue() != traceID.String() {
+							t.Errorf("exemplar trace_id = %s, want %s", l.GetValue(), traceID)
+						}
+					}
+				}
+			}
+		}
+	}
+	if exemplars["1-16"] != 1 || exemplars["17-32"] != 0 {
+		t.Errorf("exemplars by length = %v, want one for 16 and none for 32", exemplars)
+	}
+}
+
+// TestInstrumentHandler tests that requests are labeled by route template,
+// including requests the router rejects.
+func TestInstrumentHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metricsSvc := metrics.NewPrometheusMetricsWithRegistry(registry, 64)
+
+	router := mux.NewRouter()
+	router.Use(metrics.RouteMiddleware)
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0123456789")
+	}).Methods("GET")
+	router.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}).Methods("POST")
+	handler := metricsSvc.InstrumentHandler(router)
+
+	requests := []struct{ method, path, body string }{
+		{"GET", "/key/16", ""},
+		{"GET", "/key/32", ""},
+		{"POST", "/echo", "hello"},
+		{"GET", "/no/such/path", ""},
+		{"DELETE", "/key/16", ""},
+		{"BREW", "/key/16", ""},
+	}
+	for _, req := range requests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+	}
+
+	expected := `# HELP http_requests_in_flight Number of HTTP requests currently being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 0
+# HELP http_requests_total Total number of HTTP requests by route template, method and status code.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="GET",route="/key/{length}"} 2
+http_requests_total{code="200",method="POST",route="/echo"} 1
+http_requests_total{code="404",method="GET",route="unmatched"} 1
+http_requests_total{code="405",method="DELETE",route="unmatched"} 1
+http_requests_total{code="405",method="other",route="unmatched"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_requests_total", "http_requests_in_flight"); err != nil {
+		t.Errorf("Unexpected request metrics:\n%s", err)
+	}
+
+	families, _ := registry.Gather()
+	for _, mf := range families {
+		if mf.GetName() != "http_request_size_bytes" && mf.GetName() != "http_response_size_bytes" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetLabel()[2].GetValue() == "/echo" && m.GetHistogram().GetSampleSum() != 5 {
+				t.Errorf("%s for /echo = %v, want 5", mf.GetName(), m.GetHistogram().GetSampleSum())
+			}
+		}
+	}
+	if n := testutil.CollectAndCount(registry, "http_request_duration_seconds"); n != 5 {
+		t.Errorf("http_request_duration_seconds has %d series, want 5", n)
+	}
+}
@@ -0,0 +1,123 @@
+package policy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+)
+
+// maxDryRunRequestBytes bounds the size of a dry-run request body.
+const maxDryRunRequestBytes = 16 * 1024
+
+// DryRunRequest asks what a principal would be allowed to do.
+// Principal and Roles default to the authenticated caller, and only callers
+// granted the admin action may evaluate anyone else. An empty Action
+// evaluates every action.
+type DryRunRequest struct {
+	Principal string   `json:"principal"`
+	Roles     []string `json:"roles"`
+	Action    string   `json:"action"`
+	KeyType   string   `json:"key_type"`
+	KeySize   int      `json:"key_size"`
+	KeyName   string   `json:"key_name"`
+	KeyOwner  string   `json:"key_owner"`
+
+	SSHPrincipals []string `json:"ssh_principals"`
+	CertType      string   `json:"cert_type"`
+	CertTTL       string   `json:"cert_ttl"` // Duration such as "8h"
+}
+
+// ActionDecision is a Decision for a specific action.
+type ActionDecision struct {
+	Action string `json:"action"`
+	Decision
+}
+
+// Handler exposes policy evaluation over HTTP.
+type Handler struct {
+	engine *Engine
+	logger *slog.Logger
+}
+
+// NewHandler creates a new Handler for engine.
+func NewHandler(engine *Engine, logger *slog.Logger) *Handler {
+	return &Handler{engine: engine, logger: logger}
+}
+
+// DryRun handles the /policy/dry-run endpoint. It evaluates the request
+// without performing any key operation.
+func (h *Handler) DryRun(w http.ResponseWriter, r *http.Request) {
+	var req DryRunRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxDryRunRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body. Must be a JSON dry-run request.", http.StatusBadRequest)
+		return
+	}
+	if req.Action != "" && !knownActions[req.Action] {
+		http.Error(w, "Unknown action: "+req.Action, http.StatusBadRequest)
+		return
+	}
+
+	var certTTL time.Duration
+	if req.CertTTL != "" {
+		ttl, err := time.ParseDuration(req.CertTTL)
+		if err != nil {
+			http.Error(w, "Invalid cert_ttl: "+req.CertTTL, http.StatusBadRequest)
+			return
+		}
+		certTTL = ttl
+	}
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if (req.Principal != "" && req.Principal != principalName(principal)) || req.Roles != nil {
+		// Evaluating other principals maps out the policy, so it is an
+		// administrative action.
+		if d := h.engine.Evaluate(Request{Principal: principal, Action: ActionAdmin}); !d.Allowed {
+			http.Error(w, "Forbidden: evaluating another principal requires the admin action: "+d.Reason, http.StatusForbidden)
+			return
+		}
+		name := req.Principal
+		if name == "" {
+			// Only the roles are replaced: evaluate the caller with them.
+			name = principalName(principal)
+		}
+		principal = &auth.Principal{Name: name, Roles: req.Roles}
+	}
+
+	actions := Actions
+	if req.Action != "" {
+		actions = []string{req.Action}
+	}
+	decisions := make([]ActionDecision, 0, len(actions))
+	for _, action := range actions {
+		decisions = append(decisions, ActionDecision{
+			Action: action,
+			Decision: h.engine.Evaluate(Request{
+				Principal: principal,
+				Action:    action,
+				KeyType:   req.KeyType,
+				KeySize:   req.KeySize,
+				KeyName:   req.KeyName,
+				KeyOwner:  req.KeyOwner,
+
+				SSHPrincipals: req.SSHPrincipals,
+				CertType:      req.CertType,
+				CertTTL:       certTTL,
+			}),
+		})
+	}
+
+	response := map[string]interface{}{
+		"principal": principalName(principal),
+		"enforced":  h.engine.Enabled(),
+		"decisions": decisions,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.ErrorContext(r.Context(), "Error encoding JSON response", "error", err)
+	}
+}
@@ -0,0 +1,369 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+)
+
+// Actions a policy rule can grant or deny.
+const (
+	ActionGenerate = "generate" // Generate a new key, or create a named key
+	ActionEncrypt  = "encrypt"  // Encrypt with a named key
+	ActionDecrypt  = "decrypt"  // Decrypt with a named key
+	ActionSign     = "sign"     // Sign or MAC with a named key
+	ActionVerify   = "verify"   // Verify a signature or MAC with a named key
+	ActionRead     = "read"     // Read or list the metadata of named keys
+	ActionRotate   = "rotate"   // Rotate a named key
+	ActionManage   = "manage"   // Deactivate, mark compromised or destroy a named key
+	ActionSignSSH  = "ssh_sign" // Issue an SSH certificate
+	ActionAdmin    = "admin"    // Evaluate the policy on behalf of other principals
+)
+
+// Actions lists every action, in the order the dry-run reports them.
+var Actions = []string{ActionGenerate, ActionEncrypt, ActionDecrypt, ActionSign, ActionVerify, ActionRead, ActionRotate, ActionManage, ActionSignSSH, ActionAdmin}
+
+// Rule effects. Deny rules take precedence over allow rules.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// AnonymousPrincipal is the name evaluated when a request carries no principal
+// (authentication disabled). It only matches rules listing it or "*".
+const AnonymousPrincipal = "anonymous"
+
+var knownActions = map[string]bool{
+	ActionGenerate: true,
+	ActionEncrypt:  true,
+	ActionDecrypt:  true,
+	ActionSign:     true,
+	ActionVerify:   true,
+	ActionRead:     true,
+	ActionRotate:   true,
+	ActionManage:   true,
+	ActionSignSSH:  true,
+	ActionAdmin:    true,
+}
+
+// PrincipalVariable in a rule's ssh_principals or owners stands for the name
+// of the caller, so one rule can let every caller sign certificates for
+// itself or use the keys it created.
+const PrincipalVariable = "${principal}"
+
+// Rule is one declarative policy statement. A rule applies to a request when
+// the principal matches (by name or role), the action is listed and every
+// constraint present on the rule is satisfied.
+type Rule struct {
+	Name       string   `json:"name"`
+	Effect     string   `json:"effect"`       // "allow" (default) or "deny"
+	Principals []string `json:"principals"`   // Principal names or globs; "*" matches everyone
+	Roles      []string `json:"roles"`        // Any of these roles matches
+	Actions    []string `json:"actions"`      // Actions the rule covers
+	KeyTypes   []string `json:"key_types"`    // For "generate": allowed key types or named key algorithms (empty = any)
+	MaxKeySize int      `json:"max_key_size"` // For "generate": largest size in bytes (0 = no limit)
+	Keys       []string `json:"keys"`         // Key name globs (empty = any); /key/{length} requests have no name and only match "*"
+	Owners     []string `json:"owners"`       // Named key owner globs (empty = any); /key/{length} requests have no owner and never match
+
+	SSHPrincipals []string `json:"ssh_principals"` // For "ssh_sign": globs every certificate principal must match (required on allow rules)
+	CertTypes     []string `json:"cert_types"`     // For "ssh_sign": "user" and/or "host" (empty = any)
+	MaxCertTTL    string   `json:"max_cert_ttl"`   // For "ssh_sign": longest validity, such as "8h" (empty = the CA's maximum)
+
+	maxCertTTL time.Duration
+}
+
+// File is the on-disk policy document.
+type File struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Request describes an operation to authorize.
+type Request struct {
+	Principal *auth.Principal
+	Action    string
+	KeyType   string
+	KeySize   int
+	KeyName   string // Named key operated on or created (empty for /key/{length})
+	KeyOwner  string // Owner of the named key; the caller when creating one
+
+	SSHPrincipals []string      // Principals an SSH certificate is issued for
+	CertType      string        // SSH certificate type, "user" or "host"
+	CertTTL       time.Duration // SSH certificate validity
+}
+
+// Decision is the outcome of evaluating a Request.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"` // Rule that decided the outcome, if any
+	Reason  string `json:"reason"`
+}
+
+// Engine evaluates requests against a set of rules. Requests are denied
+// unless an allow rule applies and no deny rule does.
+// The rules can be replaced at runtime with Replace.
+type Engine struct {
+	policy atomic.Pointer[ruleSet]
+}
+
+// ruleSet is one immutable version of an engine's policy.
+type ruleSet struct {
+	rules   []Rule
+	enabled bool
+}
+
+func newEngine(r *ruleSet) *Engine {
+	e := &Engine{}
+	e.policy.Store(r)
+	return e
+}
+
+// Disabled returns an engine that allows every request. It is used when no
+// policy file is configured.
+func Disabled() *Engine {
+	return newEngine(&ruleSet{})
+}
+
+// LoadFile reads and validates a JSON policy file.
+func LoadFile(filename string) (*Engine, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var file File
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	return NewEngine(file.Rules)
+}
+
+// NewEngine validates rules and returns an enforcing engine.
+func NewEngine(rules []Rule) (*Engine, error) {
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if r.Effect != "" && r.Effect != EffectAllow && r.Effect != EffectDeny {
+			return nil, fmt.Errorf("rule %s: effect must be %q or %q", name, EffectAllow, EffectDeny)
+		}
+		if len(r.Principals) == 0 && len(r.Roles) == 0 {
+			return nil, fmt.Errorf("rule %s: must list principals or roles", name)
+		}
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("rule %s: must list at least one action", name)
+		}
+		for _, a := range r.Actions {
+			if !knownActions[a] {
+				return nil, fmt.Errorf("rule %s: unknown action %q", name, a)
+			}
+		}
+		for _, pattern := range append(append([]string{}, r.Principals...), r.Keys...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid pattern %q", name, pattern)
+			}
+		}
+		for _, pattern := range r.Owners {
+			if _, err := path.Match(strings.ReplaceAll(pattern, PrincipalVariable, ""), ""); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid owners pattern %q", name, pattern)
+			}
+		}
+		if r.MaxKeySize < 0 {
+			return nil, fmt.Errorf("rule %s: max_key_size must not be negative", name)
+		}
+		if err := validateSSHConstraints(&rules[i]); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		rules[i].Name = name
+	}
+	return newEngine(&ruleSet{rules: rules, enabled: true}), nil
+}
+
+// Replace atomically switches e to other's policy, so everything holding e
+// enforces the new rules from its next evaluation on. It is used to reload
+// the policy file.
+func (e *Engine) Replace(other *Engine) {
+	e.policy.Store(other.policy.Load())
+}
+
+// Enabled reports whether the engine enforces rules.
+func (e *Engine) Enabled() bool {
+	return e.policy.Load().enabled
+}
+
+// Evaluate decides whether req is permitted.
+func (e *Engine) Evaluate(req Request) Decision {
+	p := e.policy.Load()
+	if !p.enabled {
+		return Decision{Allowed: true, Reason: "policy enforcement is disabled"}
+	}
+	if !knownActions[req.Action] {
+		return Decision{Reason: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+
+	var allowedBy *Rule
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !r.applies(req) {
+			continue
+		}
+		if r.Effect == EffectDeny {
+			return Decision{Rule: r.Name, Reason: fmt.Sprintf("denied by rule %s", r.Name)}
+		}
+		if allowedBy == nil {
+			allowedBy = r
+		}
+	}
+	if allowedBy == nil {
+		return Decision{Reason: fmt.Sprintf("no rule allows %s for %s", req.Action, principalName(req.Principal))}
+	}
+	return Decision{Allowed: true, Rule: allowedBy.Name, Reason: fmt.Sprintf("allowed by rule %s", allowedBy.Name)}
+}
+
+// applies reports whether every condition of the rule matches req.
+func (r *Rule) applies(req Request) bool {
+	if !r.matchesPrincipal(req.Principal) || !contains(r.Actions, req.Action) {
+		return false
+	}
+	if req.Action == ActionGenerate {
+		if len(r.KeyTypes) > 0 && !contains(r.KeyTypes, req.KeyType) {
+			return false
+		}
+		// Deny rules with a size limit target requests above it; allow
+		// rules with a size limit only cover requests within it.
+		if r.MaxKeySize > 0 {
+			if r.Effect == EffectDeny && req.KeySize <= r.MaxKeySize {
+				return false
+			}
+			if r.Effect != EffectDeny && req.KeySize > r.MaxKeySize {
+				return false
+			}
+		}
+	}
+	if req.Action == ActionSignSSH && !r.appliesToCertificate(req) {
+		return false
+	}
+	if len(r.Owners) > 0 && (req.KeyOwner == "" || !matchesAny(r.Owners, req.KeyOwner, principalName(req.Principal))) {
+		return false
+	}
+	if len(r.Keys) == 0 {
+		return true
+	}
+	for _, pattern := range r.Keys {
+		if ok, _ := path.Match(pattern, req.KeyName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSSHConstraints checks the "ssh_sign" constraints of r and parses
+// its max_cert_ttl. Allow rules must say which principals they sign for.
+func validateSSHConstraints(r *Rule) error {
+	if r.Effect != EffectDeny && contains(r.Actions, ActionSignSSH) && len(r.SSHPrincipals) == 0 {
+		return fmt.Errorf("must list ssh_principals to allow %s", ActionSignSSH)
+	}
+	for _, pattern := range r.SSHPrincipals {
+		if _, err := path.Match(strings.ReplaceAll(pattern, PrincipalVariable, ""), ""); err != nil {
+			return fmt.Errorf("invalid ssh_principals pattern %q", pattern)
+		}
+	}
+	for _, t := range r.CertTypes {
+		if t != "user" && t != "host" {
+			return fmt.Errorf("cert_types must be \"user\" or \"host\", got %q", t)
+		}
+	}
+	if r.MaxCertTTL != "" {
+		ttl, err := time.ParseDuration(r.MaxCertTTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("max_cert_ttl must be a positive duration, got %q", r.MaxCertTTL)
+		}
+		r.maxCertTTL = ttl
+	}
+	return nil
+}
+
+// appliesToCertificate reports whether the "ssh_sign" constraints of the
+// rule match req. Allow rules cover certificates whose principals all match
+// ssh_principals and whose validity is within max_cert_ttl; deny rules
+// target certificates naming any of ssh_principals or outliving max_cert_ttl.
+func (r *Rule) appliesToCertificate(req Request) bool {
+	if len(r.CertTypes) > 0 && !contains(r.CertTypes, req.CertType) {
+		return false
+	}
+	caller := principalName(req.Principal)
+	if r.Effect == EffectDeny {
+		if len(r.SSHPrincipals) > 0 {
+			named := false
+			for _, p := range req.SSHPrincipals {
+				named = named || matchesAny(r.SSHPrincipals, p, caller)
+			}
+			if !named {
+				return false
+			}
+		}
+		return r.maxCertTTL == 0 || req.CertTTL > r.maxCertTTL
+	}
+	if len(req.SSHPrincipals) == 0 {
+		return false
+	}
+	for _, p := range req.SSHPrincipals {
+		if !matchesAny(r.SSHPrincipals, p, caller) {
+			return false
+		}
+	}
+	return r.maxCertTTL == 0 || req.CertTTL <= r.maxCertTTL
+}
+
+// matchesAny reports whether name matches one of patterns, with
+// PrincipalVariable standing for caller taken literally.
+func matchesAny(patterns []string, name, caller string) bool {
+	literal := globEscaper.Replace(caller)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ReplaceAll(pattern, PrincipalVariable, literal), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globEscaper quotes the characters path.Match treats specially.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+func (r *Rule) matchesPrincipal(p *auth.Principal) bool {
+	name := principalName(p)
+	for _, pattern := range r.Principals {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	if p != nil {
+		for _, role := range p.Roles {
+			if contains(r.Roles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func principalName(p *auth.Principal) string {
+	if p == nil {
+		return AnonymousPrincipal
+	}
+	return p.Name
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+)
+
+const testPolicy = `{
+  "rules": [
+    {"name": "generators", "roles": ["generator"], "actions": ["generate"], "key_types": ["symmetric"], "max_key_size": 32},
+    {"name": "payments-crypto", "principals": ["payments-*"], "actions": ["encrypt", "sign"], "keys": ["payments/*"]},
+    {"name": "operators", "roles": ["operator"], "actions": ["rotate", "generate"]},
+    {"name": "payments-keys", "principals": ["payments-*"], "actions": ["generate"], "key_types": ["aes-256-gcm"], "keys": ["payments-*"]},
+    {"name": "no-huge-keys", "effect": "deny", "principals": ["*"], "actions": ["generate"], "max_key_size": 512},
+    {"name": "ssh-self", "principals": ["*"], "actions": ["ssh_sign"], "ssh_principals": ["${principal}"], "cert_types": ["user"], "max_cert_ttl": "8h"},
+    {"name": "ssh-hosts", "roles": ["operator"], "actions": ["ssh_sign"], "ssh_principals": ["*.internal"], "cert_types": ["host"]},
+    {"name": "no-root", "effect": "deny", "principals": ["*"], "actions": ["ssh_sign"], "ssh_principals": ["root"]},
+    {"name": "security", "principals": ["auditor"], "actions": ["admin"]},
+    {"name": "own-keys", "principals": ["*"], "actions": ["read"], "owners": ["${principal}"]}
+  ]
+}`
+
+func loadTestEngine(t *testing.T) *policy.Engine {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatalf("Could not write policy file: %v", err)
+	}
+	engine, err := policy.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile returned an error: %v", err)
+	}
+	return engine
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine := loadTestEngine(t)
+	generator := &auth.Principal{Name: "batch-job", Roles: []string{"generator"}}
+	operator := &auth.Principal{Name: "oncall", Roles: []string{"operator"}}
+	payments := &auth.Principal{Name: "payments-api"}
+
+	tests := []struct {
+		name     string
+		req      policy.Request
+		allowed  bool
+		wantRule string
+	}{
+		{"Generate within limit", policy.Request{Principal: generator, Action: policy.ActionGenerate, KeyType: "symmetric", KeySize: 32}, true, "generators"},
+		{"Generate above limit", policy.Request{Principal: generator, Action: policy.ActionGenerate, KeyType: "symmetric", KeySize: 33}, false, ""},
+		{"Generate wrong key type", policy.Request{Principal: generator, Action: policy.ActionGenerate, KeyType: "rsa", KeySize: 16}, false, ""},
+		{"Encrypt matching key", policy.Request{Principal: payments, Action: policy.ActionEncrypt, KeyName: "payments/card-data"}, true, "payments-crypto"},
+		{"Encrypt other key", policy.Request{Principal: payments, Action: policy.ActionEncrypt, KeyName: "hr/salaries"}, false, ""},
+		{"Create matching named key", policy.Request{Principal: payments, Action: policy.ActionGenerate, KeyType: "aes-256-gcm", KeySize: 32, KeyName: "payments-cards"}, true, "payments-keys"},
+		{"Create other named key", policy.Request{Principal: payments, Action: policy.ActionGenerate, KeyType: "aes-256-gcm", KeySize: 32, KeyName: "hr-salaries"}, false, ""},
+		{"Key rule does not cover unnamed keys", policy.Request{Principal: payments, Action: policy.ActionGenerate, KeyType: "aes-256-gcm", KeySize: 32}, false, ""},
+		{"Rotate not granted", policy.Request{Principal: payments, Action: policy.ActionRotate, KeyName: "payments/card-data"}, false, ""},
+		{"Operator rotates any key", policy.Request{Principal: operator, Action: policy.ActionRotate, KeyName: "hr/salaries"}, true, "operators"},
+		{"Deny rule overrides allow", policy.Request{Principal: operator, Action: policy.ActionGenerate, KeyType: "symmetric", KeySize: 1024}, false, "no-huge-keys"},
+		{"Anonymous denied", policy.Request{Action: policy.ActionGenerate, KeyType: "symmetric", KeySize: 16}, false, ""},
+		{"Unknown action", policy.Request{Principal: operator, Action: "delete"}, false, ""},
+		{"SSH certificate for self", policy.Request{Principal: payments, Action: policy.ActionSignSSH, SSHPrincipals: []string{"payments-api"}, CertType: "user", CertTTL: time.Hour}, true, "ssh-self"},
+		{"SSH certificate for someone else", policy.Request{Principal: payments, Action: policy.ActionSignSSH, SSHPrincipals: []string{"payments-api", "oncall"}, CertType: "user", CertTTL: time.Hour}, false, ""},
+		{"SSH certificate beyond max TTL", policy.Request{Principal: payments, Action: policy.ActionSignSSH, SSHPrincipals: []string{"payments-api"}, CertType: "user", CertTTL: 9 * time.Hour}, false, ""},
+		{"SSH host certificate", policy.Request{Principal: operator, Action: policy.ActionSignSSH, SSHPrincipals: []string{"db1.internal", "db2.internal"}, CertType: "host", CertTTL: time.Hour}, true, "ssh-hosts"},
+		{"SSH host certificate of wrong type", policy.Request{Principal: operator, Action: policy.ActionSignSSH, SSHPrincipals: []string{"db1.internal"}, CertType: "user", CertTTL: time.Hour}, false, ""},
+		{"SSH certificate for root", policy.Request{Principal: &auth.Principal{Name: "root"}, Action: policy.ActionSignSSH, SSHPrincipals: []string{"root"}, CertType: "user", CertTTL: time.Hour}, false, "no-root"},
+		{"Read own key", policy.Request{Principal: payments, Action: policy.ActionRead, KeyName: "payments/card-data", KeyOwner: "payments-api"}, true, "own-keys"},
+		{"Read someone else's key", policy.Request{Principal: payments, Action: policy.ActionRead, KeyName: "hr/salaries", KeyOwner: "hr-api"}, false, ""},
+		{"Owner rule does not cover unowned keys", policy.Request{Principal: payments, Action: policy.ActionRead}, false, ""},
+		{"SSH principal variable is literal", policy.Request{Principal: &auth.Principal{Name: "*"}, Action: policy.ActionSignSSH, SSHPrincipals: []string{"alice"}, CertType: "user", CertTTL: time.Hour}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := engine.Evaluate(tt.req)
+			if d.Allowed != tt.allowed {
+				t.Fatalf("Evaluate() allowed = %v, want %v (reason: %s)", d.Allowed, tt.allowed, d.Reason)
+			}
+			if tt.wantRule != "" && d.Rule != tt.wantRule {
+				t.Errorf("Evaluate() decided by rule %q, want %q", d.Rule, tt.wantRule)
+			}
+		})
+	}
+
+	if d := policy.Disabled().Evaluate(policy.Request{Action: policy.ActionRotate}); !d.Allowed {
+		t.Error("Disabled engine must allow every request")
+	}
+}
+
+func TestNewEngine_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    policy.Rule
+		wantErr string
+	}{
+		{"Unknown action", policy.Rule{Principals: []string{"*"}, Actions: []string{"delete"}}, "unknown action"},
+		{"No subjects", policy.Rule{Actions: []string{"generate"}}, "principals or roles"},
+		{"Bad effect", policy.Rule{Effect: "maybe", Principals: []string{"*"}, Actions: []string{"generate"}}, "effect"},
+		{"Bad glob", policy.Rule{Principals: []string{"[x"}, Actions: []string{"generate"}}, "invalid pattern"},
+		{"SSH signing without principals", policy.Rule{Principals: []string{"*"}, Actions: []string{"ssh_sign"}}, "ssh_principals"},
+		{"Bad SSH principal glob", policy.Rule{Principals: []string{"*"}, Actions: []string{"ssh_sign"}, SSHPrincipals: []string{"[x"}}, "ssh_principals"},
+		{"Bad cert type", policy.Rule{Principals: []string{"*"}, Actions: []string{"ssh_sign"}, SSHPrincipals: []string{"*"}, CertTypes: []string{"robot"}}, "cert_types"},
+		{"Bad owner glob", policy.Rule{Principals: []string{"*"}, Actions: []string{"read"}, Owners: []string{"[x"}}, "owners"},
+		{"Bad max cert TTL", policy.Rule{Principals: []string{"*"}, Actions: []string{"ssh_sign"}, SSHPrincipals: []string{"*"}, MaxCertTTL: "forever"}, "max_cert_ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := policy.NewEngine([]policy.Rule{tt.rule})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewEngine() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEngine_Replace(t *testing.T) {
+	engine := policy.Disabled()
+	req := policy.Request{Action: policy.ActionGenerate, Principal: &auth.Principal{Name: "ci-bot"}, KeySize: 32}
+	if !engine.Evaluate(req).Allowed {
+		t.Fatal("disabled engine denied a request")
+	}
+
+	engine.Replace(loadTestEngine(t))
+	if !engine.Enabled() {
+		t.Error("Enabled() = false after replacing with an enforcing policy")
+	}
+	if d := engine.Evaluate(policy.Request{Action: policy.ActionGenerate, Principal: &auth.Principal{Name: "stranger"}}); d.Allowed {
+		t.Errorf("replaced engine allowed an unknown principal: %+v", d)
+	}
+
+	engine.Replace(policy.Disabled())
+	if !engine.Evaluate(req).Allowed {
+		t.Error("engine still enforcing after replacing with a disabled policy")
+	}
+}
+
+func TestHandler_DryRun(t *testing.T) {
+	h := policy.NewHandler(loadTestEngine(t), logging.Discard())
+
+	t.Run("Caller principal", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/policy/dry-run", strings.NewReader(`{"key_type":"symmetric","key_size":16}`))
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Name: "oncall", Roles: []string{"operator"}}))
+		rr := httptest.NewRecorder()
+		h.DryRun(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+		}
+		var resp struct {
+			Principal string                  `json:"principal"`
+			Decisions []policy.ActionDecision `json:"decisions"`
+		}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if resp.Principal != "oncall" || len(resp.Decisions) != len(policy.Actions) {
+			t.Fatalf("unexpected response: %+v", resp)
+		}
+		allowed := map[string]bool{}
+		for _, d := range resp.Decisions {
+			allowed[d.Action] = d.Allowed
+		}
+		want := map[string]bool{"generate": true, "encrypt": false, "sign": false, "rotate": true}
+		for action, w := range want {
+			if allowed[action] != w {
+				t.Errorf("action %s allowed = %v, want %v", action, allowed[action], w)
+			}
+		}
+	})
+
+	as := func(name string) *http.Request {
+		req := httptest.NewRequest("POST", "/policy/dry-run", nil)
+		return req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Name: name}))
+	}
+	tests := []struct {
+		name     string
+		caller   string
+		body     string
+		wantCode int
+		want     string
+	}{
+		{"Own principal by name", "payments-api", `{"principal":"payments-api","action":"sign","key_name":"payments/receipts"}`, http.StatusOK, `"allowed":true`},
+		{"Other principal", "payments-api", `{"principal":"oncall","action":"rotate"}`, http.StatusForbidden, "admin"},
+		{"Other roles", "payments-api", `{"roles":["operator"],"action":"rotate"}`, http.StatusForbidden, "admin"},
+		{"Admin evaluates other principal", "auditor", `{"principal":"payments-api","action":"sign","key_name":"payments/receipts"}`, http.StatusOK, `"allowed":true`},
+		{"Admin evaluates other roles as self", "auditor", `{"roles":["operator"],"action":"rotate"}`, http.StatusOK, `"principal":"auditor"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := as(tt.caller)
+			req.Body = io.NopCloser(strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			h.DryRun(rr, req)
+			if rr.Code != tt.wantCode || !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want %d containing %q", rr.Code, rr.Body.String(), tt.wantCode, tt.want)
+			}
+		})
+	}
+
+	t.Run("Unknown action", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.DryRun(rr, httptest.NewRequest("POST", "/policy/dry-run", strings.NewReader(`{"action":"delete"}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package ratelimit
+
+import "time"
+
+// SetNow replaces the clock l reads.
+func SetNow(l *Limiter, now func() time.Time) {
+	l.now = now
+}
+
+// Clients returns the number of client entries l tracks.
+func Clients(l *Limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
@@ -0,0 +1,291 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+)
+
+// Reasons a request is throttled, recorded in metrics.
+const (
+	ReasonRate       = "rate"
+	ReasonDailyKeys  = "daily_keys"
+	ReasonDailyBytes = "daily_bytes"
+)
+
+// idleBucketTTL is how long an unused client entry is kept before it is pruned.
+const idleBucketTTL = 10 * time.Minute
+
+// Limiter enforces per-client token-bucket rate limits and daily quotas on
+// the routes it is configured for. Routes are matched by their mux path
+// template (e.g. "/key/{length}").
+// Its rules can be replaced at runtime with SetRules.
+type Limiter struct {
+	rules   atomic.Pointer[map[string]config.RateLimit]
+	metrics *metrics.PrometheusMetrics
+	now     func() time.Time
+
+	mu        sync.Mutex
+	clients   map[clientKey]*clientState
+	lastPrune time.Time
+}
+
+type clientKey struct {
+	route  string
+	client string
+}
+
+// clientState tracks one client on one route.
+type clientState struct {
+	tokens   float64
+	lastSeen time.Time
+
+	day        string // UTC date the quota counters belong to
+	keysUsed   int
+	bytesUsed  int64
+	keysHeld   int   // Reserved by in-flight requests
+	bytesHeld  int64 // Reserved by in-flight requests
+	lastActive time.Time
+}
+
+// NewLimiter creates a Limiter for the configured rules.
+func NewLimiter(rules []config.RateLimit, m *metrics.PrometheusMetrics) *Limiter {
+	l := &Limiter{
+		metrics: m,
+		now:     time.Now,
+		clients: make(map[clientKey]*clientState),
+	}
+	l.SetRules(rules)
+	return l
+}
+
+// SetRules atomically replaces the configured rules. Clients keep their
+// buckets and quota usage on routes that stay limited, so a reload does not
+// hand out fresh allowances.
+func (l *Limiter) SetRules(rules []config.RateLimit) {
+	byRoute := make(map[string]config.RateLimit, len(rules))
+	for _, r := range rules {
+		byRoute[r.Route] = r
+	}
+	l.rules.Store(&byRoute)
+}
+
+// Enabled reports whether any route is limited.
+func (l *Limiter) Enabled() bool {
+	return len(*l.rules.Load()) > 0
+}
+
+// Middleware throttles requests to limited routes, responding 429 Too Many
+// Requests with a Retry-After header when the client is over its limit. It
+// must run after authentication so principals are available.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		rule, ok := (*l.rules.Load())[route]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var bytes int64
+		if length, err := strconv.Atoi(mux.Vars(r)["length"]); err == nil && length > 0 {
+			bytes = int64(length)
+		}
+		key := clientKey{route: route, client: clientID(r, rule.KeyBy)}
+
+		reason, retryAfter := l.reserve(key, rule, bytes)
+		if reason != "" {
+			l.reject(w, route, reason, retryAfter)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		l.settle(key, bytes, sw.status < http.StatusBadRequest)
+	})
+}
+
+// PreAuth throttles every request by client IP under the "*" rule. It must
+// run before authentication, so that clients failing to authenticate are
+// throttled as well.
+func (l *Limiter) PreAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := (*l.rules.Load())[config.RateLimitAllRoutes]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := clientKey{route: config.RateLimitAllRoutes, client: "ip:" + clientIP(r)}
+		reason, retryAfter := l.reserve(key, rule, 0)
+		if reason != "" {
+			l.reject(w, config.RateLimitAllRoutes, reason, retryAfter)
+			return
+		}
+		// The rule has no quotas, so nothing needs to be held while the
+		// request runs.
+		l.settle(key, 0, false)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject responds 429 Too Many Requests and counts the throttled request.
+func (l *Limiter) reject(w http.ResponseWriter, route, reason string, retryAfter time.Duration) {
+	l.metrics.RecordThrottled(route, reason)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, fmt.Sprintf("Too Many Requests: %s limit exceeded", reason), http.StatusTooManyRequests)
+}
+
+// reserve takes a token from the client's bucket and reserves quota for the
+// request. It returns the throttle reason and how long the client should wait
+// when the request is rejected.
+func (l *Limiter) reserve(key clientKey, rule config.RateLimit, bytes int64) (string, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now)
+
+	st, ok := l.clients[key]
+	if !ok {
+		st = &clientState{tokens: float64(rule.Burst), lastSeen: now}
+		l.clients[key] = st
+	}
+	st.lastActive = now
+	st.resetDay(now)
+
+	if rule.DailyKeys > 0 && st.keysUsed+st.keysHeld+1 > rule.DailyKeys {
+		return ReasonDailyKeys, untilMidnight(now)
+	}
+	if rule.DailyBytes > 0 && st.bytesUsed+st.bytesHeld+bytes > rule.DailyBytes {
+		return ReasonDailyBytes, untilMidnight(now)
+	}
+
+	if rule.RequestsPerSecond > 0 {
+		elapsed := now.Sub(st.lastSeen).Seconds()
+		st.tokens = math.Min(float64(rule.Burst), st.tokens+elapsed*rule.RequestsPerSecond)
+		st.lastSeen = now
+		if st.tokens < 1 {
+			wait := time.Duration((1 - st.tokens) / rule.RequestsPerSecond * float64(time.Second))
+			return ReasonRate, wait
+		}
+		st.tokens--
+	}
+
+	st.keysHeld++
+	st.bytesHeld += bytes
+	return "", 0
+}
+
+// settle releases the reservation made by reserve, charging the quota only
+// when the request succeeded.
+func (l *Limiter) settle(key clientKey, bytes int64, success bool) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st, ok := l.clients[key]
+	if !ok {
+		return
+	}
+	st.keysHeld--
+	st.bytesHeld -= bytes
+	st.lastActive = now
+	if success && st.day == utcDay(now) {
+		st.keysUsed++
+		st.bytesUsed += bytes
+	}
+}
+
+// resetDay clears the quota counters when the UTC day has changed.
+func (st *clientState) resetDay(now time.Time) {
+	if day := utcDay(now); st.day != day {
+		st.day = day
+		st.keysUsed = 0
+		st.bytesUsed = 0
+	}
+}
+
+// pruneLocked drops clients that have been idle long enough that their bucket
+// is full again. Clients that used quota today are kept so the quota holds.
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < idleBucketTTL {
+		return
+	}
+	l.lastPrune = now
+	today := utcDay(now)
+	for k, st := range l.clients {
+		idle := now.Sub(st.lastActive) > idleBucketTTL && st.keysHeld == 0
+		if idle && (st.day != today || st.keysUsed == 0) {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// clientID identifies the caller according to keyBy. Requests without the
+// requested identity fall back to the client IP.
+func clientID(r *http.Request, keyBy string) string {
+	switch keyBy {
+	case config.RateLimitByPrincipal:
+		if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+			return "principal:" + p.Name
+		}
+	case config.RateLimitByAPIKey:
+		if key := r.Header.Get(auth.APIKeyHeader); key != "" {
+			// Never keep raw credentials in memory longer than needed.
+			sum := sha256.Sum256([]byte(key))
+			return "api_key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+func utcDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func untilMidnight(now time.Time) time.Duration {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return midnight.Sub(now)
+}
+
+// statusWriter records the status code written by the wrapped handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
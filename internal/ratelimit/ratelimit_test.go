@@ -0,0 +1,276 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/ratelimit"
+)
+
+// newRouter serves /key/{length} behind the limiter. Requests carrying an
+// "X-Principal" header are authenticated as that principal, and "/key/fail/..."
+// always fails so quota refunds can be tested.
+func newRouter(limits []config.RateLimit) (*mux.Router, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	limiter := ratelimit.NewLimiter(limits, metrics.NewPrometheusMetricsWithRegistry(registry, 1024))
+
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name := r.Header.Get("X-Principal"); name != "" {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Name: name}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Use(limiter.Middleware)
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.HandleFunc("/key/fail/{length}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return router, registry
+}
+
+func do(router http.Handler, path, principal, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if principal != "" {
+		req.Header.Set("X-Principal", principal)
+	}
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestLimiter_TokenBucket(t *testing.T) {
+	router, registry := newRouter([]config.RateLimit{
+		{Route: "/key/{length}", KeyBy: config.RateLimitByPrincipal, RequestsPerSecond: 0.01, Burst: 2},
+	})
+
+	for i := 0; i < 2; i++ {
+		if rr := do(router, "/key/16", "alice", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, rr.Code, http.StatusOK)
+		}
+	}
+	rr := do(router, "/key/16", "alice", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || retry < 1 {
+		t.Errorf("expected a positive Retry-After header, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// Other principals and unlimited routes are unaffected.
+	if rr := do(router, "/key/16", "bob", ""); rr.Code != http.StatusOK {
+		t.Errorf("other principal: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if rr := do(router, "/health", "alice", ""); rr.Code != http.StatusOK {
+		t.Errorf("unlimited route: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	if got := throttled(t, registry, "/key/{length}", ratelimit.ReasonRate); got != 1 {
+		t.Errorf("throttled counter = %v, want 1", got)
+	}
+}
+
+func TestLimiter_KeyByIP(t *testing.T) {
+	router, _ := newRouter([]config.RateLimit{
+		{Route: "/key/{length}", KeyBy: config.RateLimitByIP, RequestsPerSecond: 0.01, Burst: 1},
+	})
+
+	if rr := do(router, "/key/16", "alice", "10.0.0.1:1111"); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	// Same IP, different principal and port: still the same client.
+	if rr := do(router, "/key/16", "bob", "10.0.0.1:2222"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := do(router, "/key/16", "alice", "10.0.0.2:1111"); rr.Code != http.StatusOK {
+		t.Errorf("other IP: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestLimiter_DailyQuotas(t *testing.T) {
+	router, registry := newRouter([]config.RateLimit{
+		{Route: "/key/{length}", DailyKeys: 3, DailyBytes: 48},
+		{Route: "/key/fail/{length}", DailyKeys: 1},
+	})
+
+	if rr := do(router, "/key/32", "alice", ""); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	// 32 + 32 bytes exceeds the 48 byte quota.
+	rr := do(router, "/key/32", "alice", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header on quota rejection")
+	}
+	// Smaller keys still fit until the key count is exhausted.
+	for i := 0; i < 2; i++ {
+		if rr := do(router, "/key/8", "alice", ""); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, rr.Code, http.StatusOK)
+		}
+	}
+	if rr := do(router, "/key/1", "alice", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	// Failed requests do not consume quota.
+	for i := 0; i < 3; i++ {
+		if rr := do(router, "/key/fail/8", "alice", ""); rr.Code != http.StatusInternalServerError {
+			t.Errorf("request %d: got status %d, want %d", i, rr.Code, http.StatusInternalServerError)
+		}
+	}
+
+	if got := throttled(t, registry, "/key/{length}", ratelimit.ReasonDailyBytes); got != 1 {
+		t.Errorf("daily_bytes counter = %v, want 1", got)
+	}
+	if got := throttled(t, registry, "/key/{length}", ratelimit.ReasonDailyKeys); got != 1 {
+		t.Errorf("daily_keys counter = %v, want 1", got)
+	}
+}
+
+func TestLimiter_PreAuth(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	limiter := ratelimit.NewLimiter([]config.RateLimit{
+		{Route: config.RateLimitAllRoutes, KeyBy: config.RateLimitByIP, RequestsPerSecond: 0.01, Burst: 2},
+	}, metrics.NewPrometheusMetricsWithRegistry(registry, 1024))
+
+	// Every request fails authentication behind the limiter.
+	router := mux.NewRouter()
+	router.Use(limiter.PreAuth)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	})
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < 2; i++ {
+		if rr := do(router, "/key/16", "", "10.0.0.1:1111"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: got status %d, want %d", i, rr.Code, http.StatusUnauthorized)
+		}
+	}
+	// Failed logins use up the bucket, on any route and from any port.
+	rr := do(router, "/key/32", "", "10.0.0.1:2222")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	if rr := do(router, "/key/16", "", "10.0.0.2:1111"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other IP: got status %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	if got := throttled(t, registry, config.RateLimitAllRoutes, ratelimit.ReasonRate); got != 1 {
+		t.Errorf("throttled counter = %v, want 1", got)
+	}
+}
+
+func TestLimiter_PreAuthPrunesThrottledClients(t *testing.T) {
+	limiter := ratelimit.NewLimiter([]config.RateLimit{
+		{Route: config.RateLimitAllRoutes, KeyBy: config.RateLimitByIP, RequestsPerSecond: 1, Burst: 1},
+	}, metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 1024))
+	now := time.Now()
+	ratelimit.SetNow(limiter, func() time.Time { return now })
+
+	router := mux.NewRouter()
+	router.Use(limiter.PreAuth)
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if rr := do(router, "/key/16", "", "10.0.0.1:1111"); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	for i := 0; i < 3; i++ {
+		if rr := do(router, "/key/16", "", "10.0.0.1:1111"); rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("request %d: got status %d, want %d", i, rr.Code, http.StatusTooManyRequests)
+		}
+	}
+
+	// Once its bucket has refilled, the throttled client is dropped when
+	// the next client arrives.
+	now = now.Add(time.Hour)
+	if rr := do(router, "/key/16", "", "10.0.0.2:1111"); rr.Code != http.StatusOK {
+		t.Fatalf("other IP: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if n := ratelimit.Clients(limiter); n != 1 {
+		t.Errorf("tracked clients = %d, want 1", n)
+	}
+}
+
+// throttled returns the throttled-requests counter for route and reason.
+func throttled(t *testing.T, registry *prometheus.Registry, route, reason string) float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Could not gather metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "key_server_throttled_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["route"] == route && labels["reason"] == reason {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestLimiter_SetRules(t *testing.T) {
+	limiter := ratelimit.NewLimiter(nil, metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 1024))
+	router := mux.NewRouter()
+	router.Use(limiter.Middleware)
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < 3; i++ {
+		if rr := do(router, "/key/16", "", "10.0.0.1:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d without limits: status %d", i, rr.Code)
+		}
+	}
+
+	limiter.SetRules([]config.RateLimit{{Route: "/key/{length}", KeyBy: config.RateLimitByIP, DailyKeys: 1}})
+	if !limiter.Enabled() {
+		t.Error("Enabled() = false after adding a rule")
+	}
+	if rr := do(router, "/key/16", "", "10.0.0.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("first request after SetRules: status %d", rr.Code)
+	}
+	if rr := do(router, "/key/16", "", "10.0.0.1:1234"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("second request after SetRules: status %d, want 429", rr.Code)
+	}
+
+	limiter.SetRules(nil)
+	if rr := do(router, "/key/16", "", "10.0.0.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("request after removing limits: status %d", rr.Code)
+	}
+}
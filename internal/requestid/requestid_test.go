@@ -0,0 +1,51 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"No incoming ID", "", false},
+		{"Valid incoming ID", "trace-1234", true},
+		{"ID with spaces", "bad id", false},
+		{"ID too long", strings.Repeat("a", 200), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestid.FromContext(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestid.Header, tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if seen == "" {
+				t.Fatal("expected a request ID in the context")
+			}
+			if got := rr.Header().Get(requestid.Header); got != seen {
+				t.Errorf("response header %q does not match context ID %q", got, seen)
+			}
+			if tt.keep && seen != tt.incoming {
+				t.Errorf("got ID %q, want incoming %q", seen, tt.incoming)
+			}
+			if !tt.keep && seen == tt.incoming {
+				t.Errorf("expected invalid incoming ID %q to be replaced", tt.incoming)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package sshca
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+)
+
+// maxSignRequestBytes bounds the size of a /ssh/sign request body.
+const maxSignRequestBytes = 64 * 1024
+
+// Handler exposes the SSH CA over HTTP.
+type Handler struct {
+	authority *Authority
+	policy    *policy.Engine
+	metrics   *metrics.PrometheusMetrics
+	audit     *audit.Logger
+	tlog      *transparency.Log
+	logger    *slog.Logger
+}
+
+// NewHandler creates a new Handler for authority that authorizes requests
+// against pe, records issuance in al and publishes issued certificates to tl.
+func NewHandler(authority *Authority, pe *policy.Engine, m *metrics.PrometheusMetrics, al *audit.Logger, tl *transparency.Log, logger *slog.Logger) *Handler {
+	return &Handler{authority: authority, policy: pe, metrics: m, audit: al, tlog: tl, logger: logger}
+}
+
+// CAPublicKey handles the /.well-known/ssh-ca.pub endpoint, serving the CA
+// public key in authorized_keys format.
+func (h *Handler) CAPublicKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.authority.AuthorizedKey())
+}
+
+// Sign handles the /ssh/sign endpoint, returning an OpenSSH-format certificate.
+func (h *Handler) Sign(w http.ResponseWriter, r *http.Request) {
+	var req SignRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSignRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body. Must be a JSON SSH sign request.", http.StatusBadRequest)
+		return
+	}
+
+	event := audit.Event{Operation: audit.OpSignSSH, KeyType: "ssh-" + req.CertType + "-cert"}
+	validAfter, validBefore, err := h.authority.Validity(req)
+	if err != nil {
+		event.Outcome, event.Reason = audit.OutcomeDenied, err.Error()
+		h.audit.RecordOrLog(r.Context(), event)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.authorize(r, req, validAfter, validBefore); err != nil {
+		event.Outcome, event.Reason = audit.OutcomeDenied, err.Error()
+		h.audit.RecordOrLog(r.Context(), event)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	cert, err := h.authority.Sign(req)
+	if err != nil {
+		event.Outcome, event.Reason = audit.OutcomeDenied, err.Error()
+		h.audit.RecordOrLog(r.Context(), event)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event.KeyID = ssh.FingerprintSHA256(cert.Key)
+	if _, err := h.tlog.Append(transparency.Leaf{Type: transparency.LeafSSHCertificate, Data: cert.Marshal()}); err != nil {
+		h.logger.ErrorContext(r.Context(), "Error appending SSH certificate to transparency log", "error", err)
+		event.Outcome, event.Reason = audit.OutcomeError, err.Error()
+		h.audit.RecordOrLog(r.Context(), event)
+		http.Error(w, "Internal server error: Failed to publish certificate.", http.StatusInternalServerError)
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err := h.audit.Record(r.Context(), event); err != nil {
+		h.logger.ErrorContext(r.Context(), "Error recording audit entry, withholding SSH certificate", "error", err)
+		http.Error(w, "Internal server error: Failed to record audit entry.", http.StatusInternalServerError)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "Signed SSH certificate", "cert_type", req.CertType, "serial", cert.Serial, "key_id", cert.KeyId, "principals", cert.ValidPrincipals)
+
+	response := map[string]interface{}{
+		"certificate":  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		"serial":       cert.Serial,
+		"key_id":       cert.KeyId,
+		"principals":   cert.ValidPrincipals,
+		"valid_after":  time.Unix(int64(cert.ValidAfter), 0).UTC().Format(time.RFC3339),
+		"valid_before": time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.ErrorContext(r.Context(), "Error encoding JSON response", "error", err)
+	}
+}
+
+// authorize checks a request for a certificate valid from validAfter to
+// validBefore against the policy, as the "ssh_sign" action with the
+// certificate's principals, type and lifetime. Certificates are only issued
+// under an enforced policy, so that every caller is limited to the
+// principals its rules list.
+func (h *Handler) authorize(r *http.Request, req SignRequest, validAfter, validBefore time.Time) error {
+	if !h.policy.Enabled() {
+		return errors.New("SSH certificates are only issued under an access policy")
+	}
+	start := validAfter
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	p, _ := auth.PrincipalFromContext(r.Context())
+	decision := h.policy.Evaluate(policy.Request{
+		Principal:     p,
+		Action:        policy.ActionSignSSH,
+		SSHPrincipals: req.Principals,
+		CertType:      req.CertType,
+		CertTTL:       validBefore.Sub(start),
+	})
+	if decision.Allowed {
+		return nil
+	}
+	h.metrics.RecordPolicyDenial(policy.ActionSignSSH)
+	return errors.New(decision.Reason)
+}
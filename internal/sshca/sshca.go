@@ -0,0 +1,260 @@
+package sshca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificate types accepted in a SignRequest.
+const (
+	CertTypeUser = "user"
+	CertTypeHost = "host"
+)
+
+// DefaultTTL is used when a request does not specify a validity window.
+const DefaultTTL = time.Hour
+
+// clockSkew backdates ValidAfter so freshly issued certificates are usable on
+// hosts whose clocks run slightly behind.
+const clockSkew = 5 * time.Minute
+
+// supportedCriticalOptions are the critical options OpenSSH understands
+// (PROTOCOL.certkeys). Unknown critical options make certificates unusable,
+// so anything else is rejected up front.
+var supportedCriticalOptions = map[string]bool{
+	"force-command":   true,
+	"source-address":  true,
+	"verify-required": true,
+}
+
+// supportedExtensions are the standard OpenSSH user certificate extensions.
+// Vendor extensions in the "name@domain" form are also accepted.
+var supportedExtensions = map[string]bool{
+	"no-touch-required":       true,
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+}
+
+// defaultUserExtensions mirror what ssh-keygen grants when signing a user key.
+var defaultUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// SignRequest describes the certificate a client wants issued.
+type SignRequest struct {
+	PublicKey       string            `json:"public_key"`       // authorized_keys format
+	CertType        string            `json:"cert_type"`        // "user" or "host"
+	KeyID           string            `json:"key_id"`           // Free-form identifier logged by sshd
+	Principals      []string          `json:"principals"`       // Usernames (user) or hostnames (host)
+	TTL             string            `json:"ttl"`              // Go duration, e.g. "15m"; ignored if ValidBefore is set
+	ValidAfter      time.Time         `json:"valid_after"`      // Optional start of the validity window
+	ValidBefore     time.Time         `json:"valid_before"`     // Optional end of the validity window
+	CriticalOptions map[string]string `json:"critical_options"` // User certificates only
+	Extensions      map[string]string `json:"extensions"`       // User certificates only; nil means OpenSSH defaults
+}
+
+// Authority signs OpenSSH user and host certificates.
+type Authority struct {
+	signer ssh.Signer
+	maxTTL time.Duration
+}
+
+// NewAuthority loads the CA private key from an OpenSSH or PEM private key file.
+func NewAuthority(keyFile string, maxTTL time.Duration) (*Authority, error) {
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH CA key %s: %w", keyFile, err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH CA key: %w", err)
+	}
+	return &Authority{signer: signer, maxTTL: maxTTL}, nil
+}
+
+// NewEphemeralAuthority creates an SSH CA with a freshly generated Ed25519 key.
+// It is intended for development and tests.
+func NewEphemeralAuthority(maxTTL time.Duration) (*Authority, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH CA key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{signer: signer, maxTTL: maxTTL}, nil
+}
+
+// PublicKey returns the CA public key.
+func (a *Authority) PublicKey() ssh.PublicKey {
+	return a.signer.PublicKey()
+}
+
+// AuthorizedKey returns the CA public key in authorized_keys format, suitable
+// for sshd's TrustedUserCAKeys or a known_hosts @cert-authority line.
+func (a *Authority) AuthorizedKey() []byte {
+	return ssh.MarshalAuthorizedKey(a.signer.PublicKey())
+}
+
+// Sign validates req and returns a signed certificate.
+func (a *Authority) Sign(req SignRequest) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, isCert := pub.(*ssh.Certificate); isCert {
+		return nil, errors.New("public key must not be a certificate")
+	}
+	if string(pub.Marshal()) == string(a.signer.PublicKey().Marshal()) {
+		return nil, errors.New("refusing to sign the CA's own key")
+	}
+
+	var certType uint32
+	switch req.CertType {
+	case CertTypeUser:
+		certType = ssh.UserCert
+	case CertTypeHost:
+		certType = ssh.HostCert
+	default:
+		return nil, fmt.Errorf("cert_type must be %q or %q", CertTypeUser, CertTypeHost)
+	}
+
+	if len(req.Principals) == 0 {
+		return nil, errors.New("at least one principal is required")
+	}
+	for _, p := range req.Principals {
+		if p == "" || strings.ContainsAny(p, ", \t\r\n") {
+			return nil, fmt.Errorf("invalid principal %q", p)
+		}
+	}
+
+	validAfter, validBefore, err := a.Validity(req)
+	if err != nil {
+		return nil, err
+	}
+
+	perms := ssh.Permissions{}
+	if certType == ssh.UserCert {
+		for name := range req.CriticalOptions {
+			if !supportedCriticalOptions[name] {
+				return nil, fmt.Errorf("unsupported critical option %q", name)
+			}
+		}
+		extensions := req.Extensions
+		if extensions == nil {
+			extensions = defaultUserExtensions
+		}
+		for name := range extensions {
+			if !supportedExtensions[name] && !strings.Contains(name, "@") {
+				return nil, fmt.Errorf("unsupported extension %q", name)
+			}
+		}
+		perms.CriticalOptions = copyMap(req.CriticalOptions)
+		perms.Extensions = copyMap(extensions)
+	} else if len(req.CriticalOptions) > 0 || len(req.Extensions) > 0 {
+		return nil, errors.New("host certificates do not support critical options or extensions")
+	}
+
+	keyID := req.KeyID
+	if keyID == "" {
+		keyID = req.Principals[0]
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	principals := append([]string(nil), req.Principals...)
+	sort.Strings(principals)
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          serial,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions:     perms,
+	}
+	if err := cert.SignCert(rand.Reader, a.signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// Validity resolves the window a certificate for req is valid for,
+// enforcing the maximum TTL.
+func (a *Authority) Validity(req SignRequest) (time.Time, time.Time, error) {
+	now := time.Now()
+	validAfter := req.ValidAfter
+	if validAfter.IsZero() {
+		validAfter = now.Add(-clockSkew)
+	}
+
+	validBefore := req.ValidBefore
+	if validBefore.IsZero() {
+		ttl := DefaultTTL
+		if req.TTL != "" {
+			parsed, err := time.ParseDuration(req.TTL)
+			if err != nil || parsed <= 0 {
+				return time.Time{}, time.Time{}, fmt.Errorf("invalid ttl %q", req.TTL)
+			}
+			ttl = parsed
+		}
+		if ttl > a.maxTTL {
+			ttl = a.maxTTL
+		}
+		start := validAfter
+		if start.Before(now) {
+			start = now
+		}
+		validBefore = start.Add(ttl)
+	}
+
+	if !validBefore.After(validAfter) {
+		return time.Time{}, time.Time{}, errors.New("valid_before must be after valid_after")
+	}
+	if !validBefore.After(now) {
+		return time.Time{}, time.Time{}, errors.New("valid_before must be in the future")
+	}
+	if validBefore.Sub(now) > a.maxTTL || validBefore.Sub(validAfter) > a.maxTTL+clockSkew {
+		return time.Time{}, time.Time{}, fmt.Errorf("requested validity exceeds the maximum of %s", a.maxTTL)
+	}
+	return validAfter, validBefore, nil
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func randomSerial() (uint64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
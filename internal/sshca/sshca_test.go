@@ -0,0 +1,243 @@
+package sshca_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/sshca"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+)
+
+func newAuthorizedKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Could not convert key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPub))
+}
+
+func TestAuthority_Sign(t *testing.T) {
+	authority, err := sshca.NewEphemeralAuthority(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("NewEphemeralAuthority returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		req     sshca.SignRequest
+		wantErr string
+	}{
+		{
+			name: "User certificate with defaults",
+			req:  sshca.SignRequest{CertType: sshca.CertTypeUser, KeyID: "alice@laptop", Principals: []string{"alice"}},
+		},
+		{
+			name: "User certificate with options",
+			req: sshca.SignRequest{
+				CertType:        sshca.CertTypeUser,
+				Principals:      []string{"deploy"},
+				TTL:             "15m",
+				CriticalOptions: map[string]string{"source-address": "10.0.0.0/8"},
+				Extensions:      map[string]string{"permit-pty": ""},
+			},
+		},
+		{
+			name: "Host certificate",
+			req:  sshca.SignRequest{CertType: sshca.CertTypeHost, Principals: []string{"db1.internal"}},
+		},
+		{
+			name:    "Missing principals",
+			req:     sshca.SignRequest{CertType: sshca.CertTypeUser},
+			wantErr: "at least one principal",
+		},
+		{
+			name:    "Unknown cert type",
+			req:     sshca.SignRequest{CertType: "robot", Principals: []string{"x"}},
+			wantErr: "cert_type",
+		},
+		{
+			name:    "Unsupported critical option",
+			req:     sshca.SignRequest{CertType: sshca.CertTypeUser, Principals: []string{"x"}, CriticalOptions: map[string]string{"no-such-option": ""}},
+			wantErr: "unsupported critical option",
+		},
+		{
+			name:    "Host certificate with extensions",
+			req:     sshca.SignRequest{CertType: sshca.CertTypeHost, Principals: []string{"h"}, Extensions: map[string]string{"permit-pty": ""}},
+			wantErr: "host certificates",
+		},
+		{
+			name:    "Validity beyond max TTL",
+			req:     sshca.SignRequest{CertType: sshca.CertTypeUser, Principals: []string{"x"}, ValidBefore: time.Now().Add(72 * time.Hour)},
+			wantErr: "exceeds the maximum",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.PublicKey = newAuthorizedKey(t)
+			cert, err := authority.Sign(tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Sign() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Sign() returned an error: %v", err)
+			}
+
+			checker := &ssh.CertChecker{
+				IsUserAuthority: func(auth ssh.PublicKey) bool {
+					return bytes.Equal(auth.Marshal(), authority.PublicKey().Marshal())
+				},
+				IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+					return bytes.Equal(auth.Marshal(), authority.PublicKey().Marshal())
+				},
+			}
+			principal := tt.req.Principals[0]
+			if tt.req.CertType == sshca.CertTypeHost {
+				if err := checker.CheckHostKey(principal+":22", &net.TCPAddr{}, cert); err != nil {
+					t.Errorf("host certificate failed validation: %v", err)
+				}
+			} else {
+				if err := checker.CheckCert(principal, cert); err != nil {
+					t.Errorf("user certificate failed validation: %v", err)
+				}
+				if tt.req.Extensions == nil {
+					if _, ok := cert.Permissions.Extensions["permit-pty"]; !ok {
+						t.Error("expected default user extensions to be applied")
+					}
+				}
+			}
+			if ttl := time.Until(time.Unix(int64(cert.ValidBefore), 0)); ttl > 24*time.Hour {
+				t.Errorf("certificate validity %s exceeds max TTL", ttl)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	authority, _ := sshca.NewEphemeralAuthority(time.Hour)
+	tlog, _ := transparency.Open(transparency.Options{})
+	engine, err := policy.NewEngine([]policy.Rule{
+		{Name: "self", Principals: []string{"*"}, Actions: []string{policy.ActionSignSSH}, SSHPrincipals: []string{policy.PrincipalVariable}, CertTypes: []string{sshca.CertTypeUser}, MaxCertTTL: "30m"},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), 64)
+	h := sshca.NewHandler(authority, engine, m, audit.Disabled(), tlog, logging.Discard())
+	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/ssh-ca.pub", h.CAPublicKey).Methods("GET")
+	router.HandleFunc("/ssh/sign", h.Sign).Methods("POST")
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{Name: "alice"})
+
+	t.Run("CA public key", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/ssh-ca.pub", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+		if err != nil {
+			t.Fatalf("CA public key is not in authorized_keys format: %v", err)
+		}
+		if !bytes.Equal(pub.Marshal(), authority.PublicKey().Marshal()) {
+			t.Error("published CA key does not match the authority key")
+		}
+	})
+
+	t.Run("Sign", func(t *testing.T) {
+		body, _ := json.Marshal(sshca.SignRequest{
+			PublicKey:  newAuthorizedKey(t),
+			CertType:   sshca.CertTypeUser,
+			Principals: []string{"alice"},
+			TTL:        "15m",
+		})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/ssh/sign", bytes.NewReader(body)).WithContext(alice))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var resp struct {
+			Certificate string `json:"certificate"`
+		}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Certificate))
+		if err != nil {
+			t.Fatalf("certificate is not in OpenSSH format: %v", err)
+		}
+		if _, ok := pub.(*ssh.Certificate); !ok {
+			t.Errorf("returned key is %T, want *ssh.Certificate", pub)
+		}
+		if tlog.Size() != 1 {
+			t.Errorf("transparency log has %d entries, want 1", tlog.Size())
+		}
+	})
+
+	t.Run("Invalid request", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/ssh/sign", strings.NewReader(`{"cert_type":"user","principals":["alice"],"ttl":"soon"}`)).WithContext(alice))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	denied := []struct {
+		name string
+		ctx  context.Context
+		req  sshca.SignRequest
+	}{
+		{"Other principal", alice, sshca.SignRequest{CertType: sshca.CertTypeUser, Principals: []string{"alice", "root"}, TTL: "15m"}},
+		{"TTL beyond the policy", alice, sshca.SignRequest{CertType: sshca.CertTypeUser, Principals: []string{"alice"}, TTL: "45m"}},
+		{"Host certificate", alice, sshca.SignRequest{CertType: sshca.CertTypeHost, Principals: []string{"alice"}, TTL: "15m"}},
+		{"Anonymous", context.Background(), sshca.SignRequest{CertType: sshca.CertTypeUser, Principals: []string{"alice"}, TTL: "15m"}},
+	}
+	for _, tt := range denied {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.PublicKey = newAuthorizedKey(t)
+			body, _ := json.Marshal(tt.req)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/ssh/sign", bytes.NewReader(body)).WithContext(tt.ctx))
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("got status %d, want %d: %s", rr.Code, http.StatusForbidden, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("Policy disabled", func(t *testing.T) {
+		h := sshca.NewHandler(authority, policy.Disabled(), m, audit.Disabled(), tlog, logging.Discard())
+		body, _ := json.Marshal(sshca.SignRequest{PublicKey: newAuthorizedKey(t), CertType: sshca.CertTypeUser, Principals: []string{"alice"}})
+		rr := httptest.NewRecorder()
+		h.Sign(rr, httptest.NewRequest("POST", "/ssh/sign", bytes.NewReader(body)).WithContext(alice))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusForbidden)
+		}
+	})
+	if tlog.Size() != 1 {
+		t.Errorf("transparency log has %d entries after denials, want 1", tlog.Size())
+	}
+}
@@ -0,0 +1,99 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+	"github.com/bajhalshrey/Key-Server-Application/internal/tracing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestMiddleware_Propagation(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), tracing.Options{}); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	generator := keygenerator.NewCryptoKeyGenerator()
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware)
+	router.HandleFunc("/key/{length}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := generator.Generate(r.Context(), 16); err != nil {
+			t.Errorf("Generate returned an error: %v", err)
+		}
+		if _, err := generator.Generate(r.Context(), 0); err == nil {
+			t.Error("expected an error for a zero-length key")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest("GET", "/key/16", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	ok, failed, server := spans[0], spans[1], spans[2]
+
+	if server.Name() != "GET /key/{length}" {
+		t.Errorf("server span name = %q", server.Name())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span trace ID = %s, want the incoming trace", got)
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+		t.Errorf("server span parent = %s, want the remote caller span", got)
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("server span status = %v, want Error for a 500 response", server.Status().Code)
+	}
+
+	for _, s := range []sdktrace.ReadOnlySpan{ok, failed} {
+		if s.Name() != "CryptoKeyGenerator.Generate" || s.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("span %q has parent %s, want generator span under the server span", s.Name(), s.Parent().SpanID())
+		}
+	}
+	if ok.Status().Code == codes.Error || failed.Status().Code != codes.Error {
+		t.Errorf("generator span statuses = %v, %v; want Unset, Error", ok.Status().Code, failed.Status().Code)
+	}
+}
+
+func TestSetup_StdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    config.TracingExporterStdout,
+		SampleRatio: 1,
+		Stdout:      &buf,
+	})
+	if err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "exported-span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown returned an error: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, `"Name":"exported-span"`) || !strings.Contains(out, tracing.ServiceName) {
+		t.Errorf("stdout exporter output missing span or service name:\n%s", out)
+	}
+
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
@@ -0,0 +1,146 @@
+package transparency
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// PathPrefix is where the transparency log API is mounted.
+const PathPrefix = "/transparency/v1"
+
+// maxEntriesPerRequest bounds get-entries responses.
+const maxEntriesPerRequest = 256
+
+// Handler serves the log over an HTTP API modelled on RFC 6962 §4.
+type Handler struct {
+	log    *Log
+	logger *slog.Logger
+}
+
+// NewHandler creates a new Handler for l.
+func NewHandler(l *Log, logger *slog.Logger) *Handler {
+	return &Handler{log: l, logger: logger}
+}
+
+// RegisterRoutes mounts the read-only log API on router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	sub := router.PathPrefix(PathPrefix).Subrouter()
+	sub.HandleFunc("/get-sth", h.GetSTH).Methods("GET")
+	sub.HandleFunc("/get-sth-consistency", h.GetSTHConsistency).Methods("GET")
+	sub.HandleFunc("/get-proof-by-hash", h.GetProofByHash).Methods("GET")
+	sub.HandleFunc("/get-entries", h.GetEntries).Methods("GET")
+	sub.HandleFunc("/public-key", h.PublicKey).Methods("GET")
+}
+
+// GetSTH returns the latest signed tree head.
+func (h *Handler) GetSTH(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, r, h.log.SignedTreeHead())
+}
+
+// GetSTHConsistency returns a consistency proof between tree sizes first and second.
+func (h *Handler) GetSTHConsistency(w http.ResponseWriter, r *http.Request) {
+	first, err1 := strconv.ParseUint(r.URL.Query().Get("first"), 10, 64)
+	second, err2 := strconv.ParseUint(r.URL.Query().Get("second"), 10, 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Query parameters 'first' and 'second' must be tree sizes.", http.StatusBadRequest)
+		return
+	}
+	proof, err := h.log.ConsistencyProof(first, second)
+	if err != nil {
+		h.writeLogError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, map[string]interface{}{"consistency": encodeHashes(proof)})
+}
+
+// GetProofByHash returns the index and audit path of the leaf with the given
+// base64 leaf hash in the tree of tree_size leaves.
+func (h *Handler) GetProofByHash(w http.ResponseWriter, r *http.Request) {
+	leafHash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+	if err != nil || len(leafHash) != 32 {
+		http.Error(w, "Query parameter 'hash' must be a base64 SHA-256 leaf hash.", http.StatusBadRequest)
+		return
+	}
+	treeSize, err := strconv.ParseUint(r.URL.Query().Get("tree_size"), 10, 64)
+	if err != nil {
+		http.Error(w, "Query parameter 'tree_size' must be a tree size.", http.StatusBadRequest)
+		return
+	}
+	index, proof, err := h.log.InclusionProof(leafHash, treeSize)
+	if err != nil {
+		h.writeLogError(w, r, err)
+		return
+	}
+	h.writeJSON(w, r, map[string]interface{}{"leaf_index": index, "audit_path": encodeHashes(proof)})
+}
+
+// GetEntries returns the leaf inputs with indexes in [start, end].
+func (h *Handler) GetEntries(w http.ResponseWriter, r *http.Request) {
+	start, err1 := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+	end, err2 := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+	if err1 != nil || err2 != nil || start > end {
+		http.Error(w, "Query parameters 'start' and 'end' must be leaf indexes with start <= end.", http.StatusBadRequest)
+		return
+	}
+	if size := h.log.Size(); end >= size && size > 0 {
+		end = size - 1
+	}
+	if end-start >= maxEntriesPerRequest {
+		end = start + maxEntriesPerRequest - 1
+	}
+	leaves, err := h.log.Entries(start, end)
+	if err != nil {
+		h.writeLogError(w, r, err)
+		return
+	}
+	entries := make([]map[string]string, 0, len(leaves))
+	for _, leaf := range leaves {
+		entries = append(entries, map[string]string{"leaf_input": base64.StdEncoding.EncodeToString(leaf)})
+	}
+	h.writeJSON(w, r, map[string]interface{}{"entries": entries})
+}
+
+// PublicKey serves the tree head signing key as a PEM PKIX public key.
+func (h *Handler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	der, err := x509.MarshalPKIXPublicKey(h.log.PublicKey())
+	if err != nil {
+		http.Error(w, "Internal server error: Failed to encode public key.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	out := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		out = append(out, base64.StdEncoding.EncodeToString(h))
+	}
+	return out
+}
+
+func (h *Handler) writeLogError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.logger.ErrorContext(r.Context(), "Transparency log request failed", "error", err)
+	http.Error(w, "Internal server error: Failed to read the transparency log.", http.StatusInternalServerError)
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.ErrorContext(r.Context(), "Error encoding JSON response", "error", err)
+	}
+}
@@ -0,0 +1,353 @@
+package transparency
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Leaf types recorded in the log.
+const (
+	LeafX509Certificate = "x509_certificate" // Data holds the DER certificate
+	LeafSSHCertificate  = "ssh_certificate"  // Data holds the SSH wire-format certificate
+	LeafSymmetricKey    = "symmetric_key"    // Issuance metadata only; never key material
+	LeafNamedKey        = "named_key"        // Named key creation; Data holds the public key of signing keys
+)
+
+// ErrNotFound is returned when a requested leaf or tree size is not in the log.
+var ErrNotFound = errors.New("not found in log")
+
+// Leaf is one issuance record. Its JSON encoding is the Merkle leaf input.
+// Leaves are public, so they name the requesting principal only by
+// PrincipalDigest.
+type Leaf struct {
+	Type            string    `json:"type"`
+	Timestamp       time.Time `json:"timestamp"`
+	PrincipalDigest []byte    `json:"principal_sha256,omitempty"`
+	KeyType         string    `json:"key_type,omitempty"`
+	KeyLength       int       `json:"key_length,omitempty"`
+	KeyName         string    `json:"key_name,omitempty"`
+	Data            []byte    `json:"data,omitempty"`
+}
+
+// PrincipalDigest returns the SHA-256 digest of principal recorded in
+// leaves, with which a principal can find its own entries.
+func PrincipalDigest(principal string) []byte {
+	sum := sha256.Sum256([]byte(principal))
+	return sum[:]
+}
+
+// SignedTreeHead commits to the log's contents at a given size.
+type SignedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"` // Milliseconds since the Unix epoch
+	RootHash  []byte `json:"sha256_root_hash"`
+	Signature []byte `json:"tree_head_signature"` // Ed25519 over SignedData()
+}
+
+// SignedData returns the bytes covered by the tree head signature, laid out
+// as the RFC 6962 TreeHeadSignature structure (v1, tree_hash).
+func (s SignedTreeHead) SignedData() []byte {
+	buf := make([]byte, 0, 2+8+8+len(s.RootHash))
+	buf = append(buf, 0, 1) // version v1, signature_type tree_hash
+	buf = binary.BigEndian.AppendUint64(buf, uint64(s.Timestamp))
+	buf = binary.BigEndian.AppendUint64(buf, s.TreeSize)
+	return append(buf, s.RootHash...)
+}
+
+// VerifySignedTreeHead checks the signature on sth.
+func VerifySignedTreeHead(pub ed25519.PublicKey, sth SignedTreeHead) error {
+	if !ed25519.Verify(pub, sth.SignedData(), sth.Signature) {
+		return errors.New("invalid tree head signature")
+	}
+	return nil
+}
+
+// Options configures a Log.
+type Options struct {
+	File           string // JSON-lines file holding every leaf (empty = in-memory log)
+	SigningKeyFile string // PEM PKCS#8 Ed25519 key that signs tree heads (empty = ephemeral key)
+}
+
+// Log is an append-only Merkle tree of issuance records. It keeps the hash
+// of every complete subtree in memory, so tree heads and proofs take
+// O(log n) hashes. Leaf inputs stay in the file and are read back for
+// get-entries; only a log without a file keeps them in memory.
+type Log struct {
+	signer ed25519.PrivateKey
+
+	mu      sync.RWMutex
+	leaves  [][]byte                     // Leaf inputs of a log without a file
+	offsets []int64                      // File offset of each leaf input, then the end of the file; nil without a file
+	tree    tree                         // Leaf and interior node hashes
+	indexOf map[[sha256.Size]byte]uint64 // Leaf hash to first index
+	file    *os.File
+
+	syncMu  sync.Mutex                     // Serializes fsyncs; taken before mu
+	durable atomic.Uint64                  // Leaves known to be on disk; tree heads only cover these
+	sth     atomic.Pointer[SignedTreeHead] // Latest signed tree head, reused until the tree grows
+}
+
+// Open loads the log from opts.File (if set) and prepares it for appending.
+func Open(opts Options) (*Log, error) {
+	signer, err := loadSigningKey(opts.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{signer: signer, indexOf: make(map[[sha256.Size]byte]uint64)}
+	if opts.File == "" {
+		return l, nil
+	}
+
+	f, err := os.OpenFile(opts.File, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transparency log: %w", err)
+	}
+	var pos int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				f.Close()
+				return nil, fmt.Errorf("transparency log %s ends with a partial entry", opts.File)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read transparency log: %w", err)
+		}
+		if len(line) > 1 {
+			l.offsets = append(l.offsets, pos)
+			l.add(line[:len(line)-1])
+		}
+		pos += int64(len(line))
+	}
+	l.offsets = append(l.offsets, pos)
+	l.file = f
+	l.durable.Store(l.tree.size())
+	return l, nil
+}
+
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate transparency log key: %w", err)
+		}
+		return key, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transparency log key: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transparency log key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("transparency log key must be Ed25519, got %T", key)
+	}
+	return edKey, nil
+}
+
+// add adds the hash of a leaf input to the tree. The caller holds mu (or
+// has exclusive access during Open).
+func (l *Log) add(leafInput []byte) uint64 {
+	index := l.tree.size()
+	hash := LeafHash(leafInput)
+	l.tree.append(hash)
+	if _, ok := l.indexOf[[sha256.Size]byte(hash)]; !ok {
+		l.indexOf[[sha256.Size]byte(hash)] = index
+	}
+	return index
+}
+
+// Append adds leaf to the log and returns its index. A zero Timestamp is set
+// to the current time. The leaf is durable once Append returns.
+func (l *Log) Append(leaf Leaf) (uint64, error) {
+	if leaf.Timestamp.IsZero() {
+		leaf.Timestamp = time.Now().UTC()
+	}
+	leafInput, err := json.Marshal(leaf)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode transparency log leaf: %w", err)
+	}
+
+	l.mu.Lock()
+	if l.offsets == nil {
+		l.leaves = append(l.leaves, leafInput)
+		index := l.add(leafInput)
+		l.durable.Store(index + 1)
+		l.mu.Unlock()
+		return index, nil
+	}
+	if l.file == nil {
+		l.mu.Unlock()
+		return 0, errors.New("failed to write transparency log: log is closed")
+	}
+	line := append(leafInput, '\n')
+	if _, err := l.file.Write(line); err != nil {
+		l.mu.Unlock()
+		return 0, fmt.Errorf("failed to write transparency log: %w", err)
+	}
+	l.offsets = append(l.offsets, l.offsets[len(l.offsets)-1]+int64(len(line)))
+	index := l.add(leafInput)
+	l.mu.Unlock()
+	return index, l.sync(index + 1)
+}
+
+// sync makes the first size leaves durable. It runs without mu, and appends
+// that arrive while an fsync is running share the next one, so a burst of
+// appends costs one fsync per batch rather than one each. Until then the
+// leaves stay out of tree heads, which is the merge delay RFC 6962 allows.
+func (l *Log) sync(size uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.durable.Load() >= size {
+		return nil
+	}
+	l.mu.RLock()
+	f, target := l.file, l.tree.size()
+	l.mu.RUnlock()
+	if f == nil {
+		return errors.New("failed to sync transparency log: log is closed")
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync transparency log: %w", err)
+	}
+	l.durable.Store(target)
+	return nil
+}
+
+// PublicKey returns the key that verifies tree head signatures.
+func (l *Log) PublicKey() ed25519.PublicKey {
+	return l.signer.Public().(ed25519.PublicKey)
+}
+
+// Size returns the number of leaves in the log.
+func (l *Log) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tree.size()
+}
+
+// SignedTreeHead returns a signed tree head for the durable part of the
+// tree. The root is computed under the read lock, so tree heads never hold
+// up appends.
+func (l *Log) SignedTreeHead() SignedTreeHead {
+	l.mu.RLock()
+	size := l.durable.Load()
+	if cached := l.sth.Load(); cached != nil && cached.TreeSize == size {
+		l.mu.RUnlock()
+		return *cached
+	}
+	root := l.tree.hash(0, size)
+	l.mu.RUnlock()
+
+	sth := SignedTreeHead{
+		TreeSize:  size,
+		Timestamp: time.Now().UnixMilli(),
+		RootHash:  root,
+	}
+	sth.Signature = ed25519.Sign(l.signer, sth.SignedData())
+	// Keep the largest tree head when concurrent callers race.
+	for {
+		cached := l.sth.Load()
+		if (cached != nil && cached.TreeSize >= size) || l.sth.CompareAndSwap(cached, &sth) {
+			break
+		}
+	}
+	return sth
+}
+
+// InclusionProof returns the index of the leaf with leafHash and its audit
+// path in the tree of treeSize leaves.
+func (l *Log) InclusionProof(leafHash []byte, treeSize uint64) (uint64, [][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if treeSize == 0 || treeSize > l.tree.size() {
+		return 0, nil, fmt.Errorf("%w: tree size %d", ErrNotFound, treeSize)
+	}
+	if len(leafHash) != sha256.Size {
+		return 0, nil, fmt.Errorf("%w: leaf hash", ErrNotFound)
+	}
+	index, ok := l.indexOf[[sha256.Size]byte(leafHash)]
+	if !ok || index >= treeSize {
+		return 0, nil, fmt.Errorf("%w: leaf hash", ErrNotFound)
+	}
+	return index, l.tree.inclusionPath(index, 0, treeSize), nil
+}
+
+// ConsistencyProof proves that the tree of size first is a prefix of the tree
+// of size second.
+func (l *Log) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if first > second || second > l.tree.size() {
+		return nil, fmt.Errorf("%w: tree sizes %d and %d", ErrNotFound, first, second)
+	}
+	if first == 0 {
+		return [][]byte{}, nil
+	}
+	return l.tree.consistencyProof(first, second), nil
+}
+
+// Entries returns the leaf inputs with indexes in [start, end].
+func (l *Log) Entries(start, end uint64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if start > end || end >= l.tree.size() {
+		return nil, fmt.Errorf("%w: entries %d to %d", ErrNotFound, start, end)
+	}
+	if l.offsets == nil {
+		return append([][]byte(nil), l.leaves[start:end+1]...), nil
+	}
+	if l.file == nil {
+		return nil, errors.New("failed to read transparency log: log is closed")
+	}
+	buf := make([]byte, l.offsets[end+1]-l.offsets[start])
+	if _, err := l.file.ReadAt(buf, l.offsets[start]); err != nil {
+		return nil, fmt.Errorf("failed to read transparency log: %w", err)
+	}
+	var entries [][]byte
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if len(line) > 0 {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// Close closes the backing file, if any.
+func (l *Log) Close() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
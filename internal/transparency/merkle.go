@@ -0,0 +1,208 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Hash prefixes from RFC 6962 §2.1 that separate leaves from interior nodes.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ErrProofInvalid is returned when a proof does not verify.
+var ErrProofInvalid = errors.New("proof does not verify")
+
+// LeafHash returns the Merkle leaf hash of leafInput.
+func LeafHash(leafInput []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(leafInput)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// emptyRoot is the root hash of a tree with no leaves.
+func emptyRoot() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// splitPoint returns the largest power of two strictly less than n (n > 1).
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// tree holds the hash of every complete subtree, so that a root or a proof
+// costs O(log n) hashes however large the log grows. levels[h][i] is the
+// hash of leaves [i<<h, (i+1)<<h).
+type tree struct {
+	levels [][][]byte
+}
+
+// size returns the number of leaves.
+func (t *tree) size() uint64 {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return uint64(len(t.levels[0]))
+}
+
+// append adds a leaf hash and the hashes of the subtrees it completes.
+func (t *tree) append(leafHash []byte) {
+	h := leafHash
+	for level := 0; ; level++ {
+		if level == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		t.levels[level] = append(t.levels[level], h)
+		n := len(t.levels[level])
+		if n%2 == 1 {
+			return
+		}
+		h = nodeHash(t.levels[level][n-2], h)
+	}
+}
+
+// hash computes MTH(D[start:end]). The ranges the RFC 6962 algorithms visit
+// split into complete subtrees, which are looked up rather than rehashed.
+func (t *tree) hash(start, end uint64) []byte {
+	n := end - start
+	switch {
+	case n == 0:
+		return emptyRoot()
+	case n&(n-1) == 0 && start%n == 0:
+		return t.levels[bits.TrailingZeros64(n)][start/n]
+	}
+	k := splitPoint(n)
+	return nodeHash(t.hash(start, start+k), t.hash(start+k, end))
+}
+
+// inclusionPath computes PATH(m, D[start:end]) from RFC 6962 §2.1.1.
+func (t *tree) inclusionPath(m, start, end uint64) [][]byte {
+	n := end - start
+	if n <= 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(t.inclusionPath(m, start, start+k), t.hash(start+k, end))
+	}
+	return append(t.inclusionPath(m-k, start+k, end), t.hash(start, start+k))
+}
+
+// consistencyProof computes PROOF(m, D[0:n]) from RFC 6962 §2.1.2.
+func (t *tree) consistencyProof(m, n uint64) [][]byte {
+	return t.subproof(m, 0, n, true)
+}
+
+func (t *tree) subproof(m, start, end uint64, complete bool) [][]byte {
+	n := end - start
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.hash(start, end)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(t.subproof(m, start, start+k, complete), t.hash(start+k, end))
+	}
+	return append(t.subproof(m-k, start+k, end, false), t.hash(start, start+k))
+}
+
+// VerifyInclusion checks that leafHash is the leaf at index in a tree of
+// treeSize leaves with the given root, using the algorithm of RFC 9162 §2.1.3.2.
+func VerifyInclusion(leafHash []byte, index, treeSize uint64, proof [][]byte, root []byte) error {
+	if index >= treeSize {
+		return fmt.Errorf("%w: index %d is beyond tree size %d", ErrProofInvalid, index, treeSize)
+	}
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof is too long", ErrProofInvalid)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrProofInvalid
+	}
+	return nil
+}
+
+// VerifyConsistency checks that a tree of size2 with root2 is an append-only
+// extension of a tree of size1 with root1, using the algorithm of
+// RFC 9162 §2.1.4.2.
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return fmt.Errorf("%w: first tree is larger than the second", ErrProofInvalid)
+	case size1 == size2:
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return ErrProofInvalid
+		}
+		return nil
+	case size1 == 0:
+		// Every tree is consistent with the empty tree.
+		if len(proof) != 0 {
+			return ErrProofInvalid
+		}
+		return nil
+	case len(proof) == 0:
+		return ErrProofInvalid
+	}
+
+	if size1&(size1-1) == 0 {
+		// size1 is a power of two: its root is the first node of the path.
+		proof = append([][]byte{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof is too long", ErrProofInvalid)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return ErrProofInvalid
+	}
+	return nil
+}
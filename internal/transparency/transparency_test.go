@@ -0,0 +1,265 @@
+package transparency_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+)
+
+// newLog returns an in-memory log with n leaves and the tree heads observed
+// after each append (sths[i] is the head at size i+1).
+func newLog(t *testing.T, n int) (*transparency.Log, []transparency.SignedTreeHead) {
+	l, err := transparency.Open(transparency.Options{})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	var sths []transparency.SignedTreeHead
+	for i := 0; i < n; i++ {
+		if _, err := l.Append(transparency.Leaf{Type: transparency.LeafSymmetricKey, KeyLength: i + 1}); err != nil {
+			t.Fatalf("Append returned an error: %v", err)
+		}
+		sths = append(sths, l.SignedTreeHead())
+	}
+	return l, sths
+}
+
+func TestSignedTreeHead_EmptyTree(t *testing.T) {
+	l, _ := transparency.Open(transparency.Options{})
+	empty := sha256.Sum256(nil)
+	if sth := l.SignedTreeHead(); !bytes.Equal(sth.RootHash, empty[:]) {
+		t.Errorf("empty tree root = %x, want %x", sth.RootHash, empty)
+	}
+}
+
+func TestProofs(t *testing.T) {
+	const n = 17
+	l, sths := newLog(t, n)
+	entries, err := l.Entries(0, n-1)
+	if err != nil {
+		t.Fatalf("Entries returned an error: %v", err)
+	}
+
+	for size := uint64(1); size <= n; size++ {
+		sth := sths[size-1]
+		if err := transparency.VerifySignedTreeHead(l.PublicKey(), sth); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		for i := uint64(0); i < size; i++ {
+			leafHash := transparency.LeafHash(entries[i])
+			index, proof, err := l.InclusionProof(leafHash, size)
+			if err != nil || index != i {
+				t.Fatalf("InclusionProof(%d, %d) = %d, %v", i, size, index, err)
+			}
+			if err := transparency.VerifyInclusion(leafHash, index, size, proof, sth.RootHash); err != nil {
+				t.Errorf("inclusion of %d in tree of %d: %v", i, size, err)
+			}
+		}
+		for first := uint64(0); first <= size; first++ {
+			proof, err := l.ConsistencyProof(first, size)
+			if err != nil {
+				t.Fatalf("ConsistencyProof(%d, %d) returned an error: %v", first, size, err)
+			}
+			var root1 []byte
+			if first > 0 {
+				root1 = sths[first-1].RootHash
+			}
+			if err := transparency.VerifyConsistency(first, size, root1, sth.RootHash, proof); err != nil {
+				t.Errorf("consistency %d -> %d: %v", first, size, err)
+			}
+		}
+	}
+}
+
+func TestProofs_RejectTampering(t *testing.T) {
+	l, sths := newLog(t, 11)
+	sth := sths[10]
+	entries, _ := l.Entries(0, 10)
+	leafHash := transparency.LeafHash(entries[5])
+	index, proof, _ := l.InclusionProof(leafHash, 11)
+
+	bad := append([][]byte(nil), proof...)
+	bad[1] = transparency.LeafHash([]byte("forged"))
+	consistency, _ := l.ConsistencyProof(6, 11)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"Wrong leaf", transparency.VerifyInclusion(transparency.LeafHash([]byte("other")), index, 11, proof, sth.RootHash)},
+		{"Wrong index", transparency.VerifyInclusion(leafHash, index+1, 11, proof, sth.RootHash)},
+		{"Forged path", transparency.VerifyInclusion(leafHash, index, 11, bad, sth.RootHash)},
+		{"Truncated path", transparency.VerifyInclusion(leafHash, index, 11, proof[:len(proof)-1], sth.RootHash)},
+		{"Wrong old root", transparency.VerifyConsistency(6, 11, sths[4].RootHash, sth.RootHash, consistency)},
+		{"Wrong new root", transparency.VerifyConsistency(6, 11, sths[5].RootHash, sths[9].RootHash, consistency)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, transparency.ErrProofInvalid) {
+				t.Errorf("got %v, want ErrProofInvalid", tt.err)
+			}
+		})
+	}
+
+	sth.TreeSize++
+	if err := transparency.VerifySignedTreeHead(l.PublicKey(), sth); err == nil {
+		t.Error("expected a modified tree head to fail signature verification")
+	}
+}
+
+func TestOpen_ReloadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transparency.jsonl")
+	l, err := transparency.Open(transparency.Options{File: path})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Append(transparency.Leaf{Type: transparency.LeafX509Certificate, Data: []byte{byte(i)}})
+	}
+	root := l.SignedTreeHead().RootHash
+	l.Close()
+
+	reopened, err := transparency.Open(transparency.Options{File: path})
+	if err != nil {
+		t.Fatalf("Open returned an error on reload: %v", err)
+	}
+	defer reopened.Close()
+	if sth := reopened.SignedTreeHead(); sth.TreeSize != 5 || !bytes.Equal(sth.RootHash, root) {
+		t.Errorf("reloaded tree = size %d root %x, want size 5 root %x", sth.TreeSize, sth.RootHash, root)
+	}
+	reopened.Append(transparency.Leaf{Type: transparency.LeafNamedKey, PrincipalDigest: transparency.PrincipalDigest("alice")})
+	entries, err := reopened.Entries(3, 5)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Entries(3, 5) = %d entries, %v; want 3", len(entries), err)
+	}
+	var leaf transparency.Leaf
+	if err := json.Unmarshal(entries[2], &leaf); err != nil || !bytes.Equal(leaf.PrincipalDigest, transparency.PrincipalDigest("alice")) {
+		t.Errorf("appended entry = %s, %v; want the digest of alice", entries[2], err)
+	}
+	if bytes.Contains(entries[2], []byte("alice")) {
+		t.Errorf("entry %s names the principal", entries[2])
+	}
+
+	os.WriteFile(path, append(mustRead(t, path), `{"type":`...), 0o600)
+	if _, err := transparency.Open(transparency.Options{File: path}); err == nil {
+		t.Error("expected an error for a log ending in a partial entry")
+	}
+}
+
+func TestAppend_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transparency.jsonl")
+	l, err := transparency.Open(transparency.Options{File: path})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	const n = 64
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := l.Append(transparency.Leaf{Type: transparency.LeafSymmetricKey, KeyLength: i + 1}); err != nil {
+				t.Errorf("Append returned an error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	sth := l.SignedTreeHead()
+	l.Close()
+
+	reopened, err := transparency.Open(transparency.Options{File: path})
+	if err != nil {
+		t.Fatalf("Open returned an error on reload: %v", err)
+	}
+	defer reopened.Close()
+	if got := reopened.SignedTreeHead(); sth.TreeSize != n || got.TreeSize != n || !bytes.Equal(got.RootHash, sth.RootHash) {
+		t.Errorf("tree heads = size %d before and %d after reload, want %d with the same root", sth.TreeSize, got.TreeSize, n)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", path, err)
+	}
+	return raw
+}
+
+func TestHandler(t *testing.T) {
+	l, _ := newLog(t, 6)
+	router := mux.NewRouter()
+	transparency.NewHandler(l, logging.Discard()).RegisterRoutes(router)
+
+	get := func(path string, v interface{}) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", transparency.PathPrefix+path, nil))
+		if v != nil && rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+				t.Fatalf("GET %s: invalid JSON: %v", path, err)
+			}
+		}
+		return rr.Code
+	}
+
+	var sth transparency.SignedTreeHead
+	if code := get("/get-sth", &sth); code != http.StatusOK || sth.TreeSize != 6 {
+		t.Fatalf("get-sth = %d, size %d", code, sth.TreeSize)
+	}
+	if err := transparency.VerifySignedTreeHead(l.PublicKey(), sth); err != nil {
+		t.Fatalf("served tree head does not verify: %v", err)
+	}
+
+	var entries struct {
+		Entries []struct {
+			LeafInput []byte `json:"leaf_input"`
+		} `json:"entries"`
+	}
+	if code := get("/get-entries?start=2&end=100", &entries); code != http.StatusOK || len(entries.Entries) != 4 {
+		t.Fatalf("get-entries = %d, %d entries", code, len(entries.Entries))
+	}
+
+	leafHash := transparency.LeafHash(entries.Entries[1].LeafInput)
+	var inclusion struct {
+		LeafIndex uint64   `json:"leaf_index"`
+		AuditPath [][]byte `json:"audit_path"`
+	}
+	path := fmt.Sprintf("/get-proof-by-hash?tree_size=6&hash=%s", url.QueryEscape(base64.StdEncoding.EncodeToString(leafHash)))
+	if code := get(path, &inclusion); code != http.StatusOK || inclusion.LeafIndex != 3 {
+		t.Fatalf("get-proof-by-hash = %d, index %d", code, inclusion.LeafIndex)
+	}
+	if err := transparency.VerifyInclusion(leafHash, inclusion.LeafIndex, 6, inclusion.AuditPath, sth.RootHash); err != nil {
+		t.Errorf("served inclusion proof does not verify: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/get-sth-consistency?first=2&second=6", http.StatusOK},
+		{"/get-sth-consistency?first=2&second=60", http.StatusNotFound},
+		{"/get-sth-consistency?first=x", http.StatusBadRequest},
+		{"/get-proof-by-hash?tree_size=6&hash=AAAA", http.StatusBadRequest},
+		{"/get-entries?start=10&end=12", http.StatusNotFound},
+		{"/public-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := get(tt.path, nil); code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, code, tt.want)
+		}
+	}
+}
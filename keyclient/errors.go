@@ -0,0 +1,67 @@
+package keyclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors matched by *Error with errors.Is, one per kind of failure
+// the server reports.
+var (
+	ErrInvalidRequest   = errors.New("keyclient: invalid request")     // 400, e.g. a key length out of range
+	ErrUnauthenticated  = errors.New("keyclient: unauthenticated")     // 401, missing or invalid credentials
+	ErrPermissionDenied = errors.New("keyclient: permission denied")   // 403, denied by the authorization policy
+	ErrNotFound         = errors.New("keyclient: not found")           // 404
+	ErrConflict         = errors.New("keyclient: conflict")            // 409, Idempotency-Key reused for a different or unfinished request
+	ErrRateLimited      = errors.New("keyclient: rate limited")        // 429, over a rate limit or daily quota
+	ErrUnavailable      = errors.New("keyclient: service unavailable") // 503, not ready or draining
+	ErrServer           = errors.New("keyclient: server error")        // Any other 5xx
+)
+
+// Error is a non-2xx response from the server.
+type Error struct {
+	StatusCode int
+	Message    string        // Response body, as written by the server
+	RequestID  string        // Server-side request ID, for finding the request in its logs
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("keyclient: server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" && e.Message != http.StatusText(e.StatusCode) {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request ID " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is reports whether target is the sentinel error for e's status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthenticated
+	case http.StatusForbidden:
+		return target == ErrPermissionDenied
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// Temporary reports whether the request may succeed if retried: the server
+// was rate limiting, overloaded or failed internally.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
@@ -0,0 +1,349 @@
+// Package keyclient is a Go client for the key server API.
+//
+//	c, err := keyclient.New("https://key-server:8443", keyclient.Options{
+//		TLSConfig: tlsConfig, // From TLSConfigFromFiles, for a private CA or mutual TLS
+//		APIKey:    os.Getenv("KEY_SERVER_API_KEY"),
+//	})
+//	key, err := c.GenerateKey(ctx, 32)
+//
+// Requests that fail with 429 Too Many Requests or a 5xx status are retried
+// with exponential backoff. GenerateKey sends an Idempotency-Key so that a
+// retry of a request the server did complete returns the same key rather
+// than a second one; WithIdempotencyKey extends that across calls. Failures
+// are returned as *Error values that match the sentinel errors of this
+// package with errors.Is.
+package keyclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default retry and timeout settings.
+const (
+	DefaultMaxAttempts = 4
+	DefaultMinBackoff  = 100 * time.Millisecond
+	DefaultMaxBackoff  = 5 * time.Second
+	DefaultTimeout     = 30 * time.Second
+)
+
+// Request headers understood by the server.
+const (
+	APIKeyHeader         = "X-API-Key"
+	RequestIDHeader      = "X-Request-ID"
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 4 << 10
+
+// Options configures a Client. The zero value verifies the server against
+// the system roots, sends no credentials and retries with the defaults.
+type Options struct {
+	// TLSConfig configures server verification and client certificates.
+	// Ignored when HTTPClient is set.
+	TLSConfig *tls.Config
+
+	// HTTPClient sends the requests. When nil, a client with TLSConfig and
+	// a Timeout per attempt is used.
+	HTTPClient *http.Client
+
+	// Timeout bounds each attempt when HTTPClient is nil. Zero means
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	APIKey string // Sent in the X-API-Key header
+	Token  string // JWT sent as a bearer token
+
+	Retry RetryPolicy
+
+	// UserAgent is sent with every request when set.
+	UserAgent string
+}
+
+// RetryPolicy controls retries of requests that fail with 429 or 5xx. Each
+// wait is a random duration between half and all of MinBackoff doubled for
+// every previous attempt, capped at MaxBackoff. A longer Retry-After from
+// the server is honored up to MaxBackoff; beyond that (e.g. an exhausted daily
+// quota) the error is returned without retrying.
+type RetryPolicy struct {
+	MaxAttempts int           // Including the first; 0 means DefaultMaxAttempts, 1 disables retries
+	MinBackoff  time.Duration // 0 means DefaultMinBackoff
+	MaxBackoff  time.Duration // 0 means DefaultMaxBackoff
+}
+
+// Client calls the key server API. It is safe for concurrent use.
+type Client struct {
+	base      *url.URL
+	http      *http.Client
+	apiKey    string
+	token     string
+	userAgent string
+	retry     RetryPolicy
+}
+
+// New returns a Client for the server at baseURL, e.g.
+// "https://key-server:8443".
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" {
+		return nil, fmt.Errorf("keyclient: invalid base URL %q: must be an http or https URL", baseURL)
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = opts.TLSConfig
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		httpClient = &http.Client{Transport: transport, Timeout: timeout}
+	}
+
+	retry := opts.Retry
+	if retry.MaxAttempts == 0 {
+		retry.MaxAttempts = DefaultMaxAttempts
+	}
+	if retry.MinBackoff == 0 {
+		retry.MinBackoff = DefaultMinBackoff
+	}
+	if retry.MaxBackoff == 0 {
+		retry.MaxBackoff = DefaultMaxBackoff
+	}
+
+	return &Client{
+		base:      base,
+		http:      httpClient,
+		apiKey:    opts.APIKey,
+		token:     opts.Token,
+		userAgent: opts.UserAgent,
+		retry:     retry,
+	}, nil
+}
+
+// TLSConfigFromFiles builds a TLS configuration from PEM files. caFile, if
+// set, replaces the system roots for verifying the server; certFile and
+// keyFile, if set, are presented as the client certificate for mutual TLS.
+func TLSConfigFromFiles(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("keyclient: reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("keyclient: no certificates found in CA bundle %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("keyclient: client certificate and key must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("keyclient: loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx that makes key-issuing calls send
+// key as their Idempotency-Key. Repeating a call with the same key within
+// the server's window returns the first call's result, so an application
+// can retry safely after a crash or a timeout. Keys must be unique per
+// request and at most 255 characters; reusing one for a different request
+// fails with ErrConflict.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// idempotencyKeyFor returns the Idempotency-Key for a key-issuing call: the
+// one from ctx, or a new one shared by the call's retries. No key is sent
+// when retries are disabled and ctx carries none.
+func (c *Client) idempotencyKeyFor(ctx context.Context) string {
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok {
+		return key
+	}
+	if c.retry.MaxAttempts > 1 {
+		return newRequestID()
+	}
+	return ""
+}
+
+// GenerateKey requests a new key of length bytes and returns its raw bytes.
+func (c *Client) GenerateKey(ctx context.Context, length int) ([]byte, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("%w: key length must be positive, got %d", ErrInvalidRequest, length)
+	}
+	var resp struct {
+		Key string `json:"key"`
+	}
+	req := request{method: http.MethodGet, path: "/key/" + strconv.Itoa(length), idempotencyKey: c.idempotencyKeyFor(ctx)}
+	if err := c.send(ctx, req, &resp, c.retry.MaxAttempts); err != nil {
+		return nil, err
+	}
+	key, err := base64.URLEncoding.DecodeString(resp.Key)
+	if err != nil {
+		return nil, fmt.Errorf("keyclient: decoding key: %w", err)
+	}
+	if len(key) != length {
+		return nil, fmt.Errorf("keyclient: server returned a %d-byte key, want %d", len(key), length)
+	}
+	return key, nil
+}
+
+// ReadyReport is the server's readiness report.
+type ReadyReport struct {
+	Status   string                 `json:"status"` // pass, warn or fail
+	Draining bool                   `json:"draining,omitempty"`
+	Checks   map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Ready fetches the readiness report. It is not retried. When the server is
+// not ready the report is returned together with an error matching
+// ErrUnavailable.
+func (c *Client) Ready(ctx context.Context) (*ReadyReport, error) {
+	var report ReadyReport
+	err := c.send(ctx, request{method: http.MethodGet, path: "/ready"}, &report, 1)
+	if report.Status == "" {
+		return nil, err
+	}
+	return &report, err
+}
+
+// request describes an API call.
+type request struct {
+	method         string
+	path           string
+	idempotencyKey string // Sent when set
+}
+
+// send makes up to attempts attempts and decodes the JSON response into out.
+// All attempts carry the same request ID so the server logs can be
+// correlated.
+func (c *Client) send(ctx context.Context, r request, out any, attempts int) error {
+	requestID := newRequestID()
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, r, requestID, out)
+		var apiErr *Error
+		if err == nil || !errors.As(err, &apiErr) || !apiErr.Temporary() || attempt >= attempts {
+			return err
+		}
+		wait := c.backoff(attempt)
+		if apiErr.RetryAfter > c.retry.MaxBackoff {
+			return err
+		}
+		if apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, r request, requestID string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, r.method, c.base.JoinPath(r.path).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(RequestIDHeader, requestID)
+	if r.idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, r.idempotencyKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(APIKeyHeader, c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("keyclient: decoding response: %w", err)
+		}
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RequestID:  resp.Header.Get(RequestIDHeader),
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	if isJSON(resp.Header.Get("Content-Type")) && json.Unmarshal(body, out) == nil {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// backoff returns the wait before the attempt after attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.MaxBackoff
+	if shift := attempt - 1; shift < 30 {
+		d = min(d, c.retry.MinBackoff<<shift)
+	}
+	half := int64(d / 2)
+	n, err := rand.Int(rand.Reader, big.NewInt(half+1))
+	if err != nil {
+		return d
+	}
+	return time.Duration(half + n.Int64())
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,324 @@
+package keyclient_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/handler"
+	"github.com/bajhalshrey/Key-Server-Application/internal/idempotency"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// testServer runs the key server's /key handler in process behind mutual
+// TLS. The next len(failures) requests are answered with those statuses
+// instead.
+type testServer struct {
+	*httptest.Server
+	caFile, certFile, keyFile string // Server CA and client credentials
+	failures                  chan int
+	requests                  atomic.Int32
+	requestIDs                chan string
+	idempotencyKeys           chan string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	cfg := config.Defaults()
+	cfg.MaxSize = 64
+	tlog, err := transparency.Open(transparency.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.NewPrometheusMetricsWithRegistry(prometheus.NewRegistry(), cfg.MaxSize)
+	svc := keyservice.NewKeyService(keygenerator.NewCryptoKeyGenerator(), config.NewStore(cfg), m, policy.Disabled(), audit.Disabled(), tlog, logging.Discard())
+	h := handler.NewHTTPHandler(svc, m, logging.Discard())
+
+	cache, err := idempotency.New(idempotency.Options{TTL: time.Minute, MaxBytes: 1 << 20, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{failures: make(chan int, 10), requestIDs: make(chan string, 10), idempotencyKeys: make(chan string, 10)}
+	router := mux.NewRouter()
+	router.Use(requestid.Middleware, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ts.requests.Add(1)
+			select {
+			case ts.requestIDs <- r.Header.Get(requestid.Header):
+			default:
+			}
+			select {
+			case ts.idempotencyKeys <- r.Header.Get(keyclient.IdempotencyKeyHeader):
+			default:
+			}
+			if r.Header.Get(keyclient.APIKeyHeader) != "secret" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			select {
+			case status := <-ts.failures:
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "1")
+				}
+				http.Error(w, http.StatusText(status), status)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}, cache.Middleware)
+	router.HandleFunc("/key/{length}", h.GenerateKey).Methods("GET")
+
+	dir := t.TempDir()
+	clientCert, clientKey := newCertificate(t)
+	ts.certFile = writePEM(t, dir, "client.crt", "CERTIFICATE", clientCert.Raw)
+	ts.keyFile = writePEM(t, dir, "client.key", "PRIVATE KEY", clientKey)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	ts.Server = httptest.NewUnstartedServer(router)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	ts.caFile = writePEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+	return ts
+}
+
+// newCertificate returns a self-signed client certificate and its PKCS#8 key.
+func newCertificate(t *testing.T) (*x509.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, keyDER
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClient_GenerateKey(t *testing.T) {
+	ts := newTestServer(t)
+	tlsConfig, err := keyclient.TLSConfigFromFiles(ts.caFile, ts.certFile, ts.keyFile)
+	if err != nil {
+		t.Fatalf("TLSConfigFromFiles returned an error: %v", err)
+	}
+	newClient := func(t *testing.T, opts keyclient.Options) *keyclient.Client {
+		t.Helper()
+		if opts.TLSConfig == nil {
+			opts.TLSConfig = tlsConfig
+		}
+		opts.Retry.MinBackoff = time.Millisecond
+		opts.Retry.MaxBackoff = 10 * time.Millisecond
+		c, err := keyclient.New(ts.URL, opts)
+		if err != nil {
+			t.Fatalf("New returned an error: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name         string
+		opts         keyclient.Options
+		length       int
+		failures     []int
+		wantErr      error
+		wantRequests int32
+	}{
+		{"Success", keyclient.Options{APIKey: "secret"}, 32, nil, nil, 1},
+		{"Retries 5xx", keyclient.Options{APIKey: "secret"}, 16, []int{http.StatusInternalServerError, http.StatusBadGateway}, nil, 3},
+		{"Gives up after MaxAttempts", keyclient.Options{APIKey: "secret", Retry: keyclient.RetryPolicy{MaxAttempts: 2}}, 16,
+			[]int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, keyclient.ErrUnavailable, 2},
+		{"Retry-After beyond MaxBackoff", keyclient.Options{APIKey: "secret"}, 16, []int{http.StatusTooManyRequests}, keyclient.ErrRateLimited, 1},
+		{"Out of range", keyclient.Options{APIKey: "secret"}, 65, nil, keyclient.ErrInvalidRequest, 1},
+		{"Unauthenticated", keyclient.Options{}, 16, nil, keyclient.ErrUnauthenticated, 1},
+		{"Non-positive length", keyclient.Options{APIKey: "secret"}, 0, nil, keyclient.ErrInvalidRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.requests.Store(0)
+			for _, status := range tt.failures {
+				ts.failures <- status
+			}
+			t.Cleanup(func() {
+				for len(ts.failures) > 0 {
+					<-ts.failures
+				}
+			})
+
+			key, err := newClient(t, tt.opts).GenerateKey(context.Background(), tt.length)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GenerateKey error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(key) != tt.length {
+				t.Errorf("got a %d-byte key, want %d", len(key), tt.length)
+			}
+			if got := ts.requests.Load(); got != tt.wantRequests {
+				t.Errorf("server saw %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+
+	t.Run("Error details", func(t *testing.T) {
+		_, err := newClient(t, keyclient.Options{APIKey: "secret"}).GenerateKey(context.Background(), 1000)
+		var apiErr *keyclient.Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("error %v is not a *keyclient.Error", err)
+		}
+		if apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Message, "out of allowed range") || apiErr.RequestID == "" {
+			t.Errorf("unexpected error details: %+v", apiErr)
+		}
+	})
+
+	t.Run("Same request and idempotency keys across retries", func(t *testing.T) {
+		for len(ts.requestIDs) > 0 || len(ts.idempotencyKeys) > 0 {
+			select {
+			case <-ts.requestIDs:
+			case <-ts.idempotencyKeys:
+			}
+		}
+		ts.failures <- http.StatusInternalServerError
+		if _, err := newClient(t, keyclient.Options{APIKey: "secret"}).GenerateKey(context.Background(), 8); err != nil {
+			t.Fatalf("GenerateKey returned an error: %v", err)
+		}
+		first, second := <-ts.requestIDs, <-ts.requestIDs
+		if first == "" || first != second {
+			t.Errorf("request IDs %q and %q, want the same ID on the retry", first, second)
+		}
+		first, second = <-ts.idempotencyKeys, <-ts.idempotencyKeys
+		if first == "" || first != second {
+			t.Errorf("idempotency keys %q and %q, want the same key on the retry", first, second)
+		}
+	})
+
+	t.Run("Idempotency key from the context", func(t *testing.T) {
+		c := newClient(t, keyclient.Options{APIKey: "secret"})
+		ctx := keyclient.WithIdempotencyKey(context.Background(), "order-1234")
+		first, err := c.GenerateKey(ctx, 16)
+		if err != nil {
+			t.Fatalf("GenerateKey returned an error: %v", err)
+		}
+		second, err := c.GenerateKey(ctx, 16)
+		if err != nil {
+			t.Fatalf("GenerateKey returned an error on the repeat: %v", err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Error("repeating a call with the same idempotency key returned a different key")
+		}
+		if _, err := c.GenerateKey(ctx, 32); !errors.Is(err, keyclient.ErrConflict) {
+			t.Errorf("reusing the key for another length returned %v, want ErrConflict", err)
+		}
+	})
+
+	t.Run("Context canceled during backoff", func(t *testing.T) {
+		ts.failures <- http.StatusInternalServerError
+		c, err := keyclient.New(ts.URL, keyclient.Options{
+			APIKey:    "secret",
+			TLSConfig: tlsConfig,
+			Retry:     keyclient.RetryPolicy{MinBackoff: time.Hour, MaxBackoff: time.Hour},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if _, err := c.GenerateKey(ctx, 8); !errors.Is(err, keyclient.ErrServer) {
+			t.Errorf("GenerateKey error = %v, want the last server error", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("GenerateKey took %s, want it to return when the context is done", elapsed)
+		}
+	})
+
+	t.Run("Without client certificate", func(t *testing.T) {
+		serverOnly, err := keyclient.TLSConfigFromFiles(ts.caFile, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newClient(t, keyclient.Options{APIKey: "secret", TLSConfig: serverOnly, Retry: keyclient.RetryPolicy{MaxAttempts: 1}})
+		if _, err := c.GenerateKey(context.Background(), 8); err == nil {
+			t.Error("expected the server to reject a connection without a client certificate")
+		}
+	})
+}
+
+func TestClient_Ready(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/health+json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"fail","draining":true,"checks":{"generator":{"status":"fail","error":"broken","duration":"1ms"}}}`))
+	}))
+	defer srv.Close()
+
+	c, err := keyclient.New(srv.URL, keyclient.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := c.Ready(context.Background())
+	if !errors.Is(err, keyclient.ErrUnavailable) {
+		t.Errorf("Ready error = %v, want ErrUnavailable", err)
+	}
+	if report == nil || !report.Draining || report.Checks["generator"].Error != "broken" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:8443", "ftp://host", "https://"} {
+		if _, err := keyclient.New(u, keyclient.Options{}); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", u)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+	"github.com/bajhalshrey/Key-Server-Application/internal/loadgen"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
+	"github.com/bajhalshrey/Key-Server-Application/keyclient"
+)
+
+// runLoad implements "key-server load [flags]". It drives /key/{length} on
+// a remote server (-url) or on an Application started in process, then
+// prints latency percentiles, throughput and an error breakdown. It returns
+// 0 when every request succeeded, 1 when some failed and 2 on usage errors.
+func runLoad(args []string) int {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	target := fs.String("url", "", "base URL of the server to load, e.g. https://localhost:8443 (default: a server started in process)")
+	configFile := fs.String("config", "", "configuration file for the in-process server (CONFIG_FILE and the environment also apply)")
+	caFile := fs.String("cacert", "", "PEM CA bundle used to verify the server instead of the system roots")
+	certFile := fs.String("cert", "", "PEM client certificate for mutual TLS")
+	keyFile := fs.String("key", "", "PEM private key of the client certificate")
+	apiKey := fs.String("api-key", "", "API key sent in the X-API-Key header")
+	token := fs.String("token", "", "JWT sent as a bearer token")
+	concurrency := fs.Int("concurrency", 10, "number of concurrent requests")
+	rate := fs.Float64("rate", 0, "total requests per second (0: as fast as the concurrency allows)")
+	duration := fs.Duration("duration", 10*time.Second, "how long to generate load (0: until -requests are sent)")
+	requests := fs.Int("requests", 0, "stop after this many requests (0: no limit)")
+	mixFlag := fs.String("mix", "32", "key lengths to request, with optional weights, e.g. 16:1,32:3,64:1")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each request")
+	output := fs.String("output", "text", "report format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: key-server load [-url URL] [-concurrency N] [-rate R] [-duration D] [-requests N] [-mix MIX] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	mix, err := loadgen.ParseMix(*mixFlag)
+	if err != nil || fs.NArg() > 0 || (*output != "text" && *output != "json") {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -mix: %v\n", err)
+		}
+		fs.Usage()
+		return exitUsage
+	}
+	opts := loadgen.Options{Concurrency: *concurrency, Rate: *rate, Duration: *duration, Requests: *requests, Mix: mix}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	baseURL := *target
+	if baseURL == "" {
+		var configArgs []string
+		if *configFile != "" {
+			configArgs = []string{"-config", *configFile}
+		}
+		url, shutdown, err := startInProcess(configArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting the in-process server: %v\n", err)
+			return exitError
+		}
+		defer shutdown()
+		baseURL = url
+	}
+
+	tlsConfig, err := keyclient.TLSConfigFromFiles(*caFile, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Keep a connection per worker so the run measures requests, not
+	// handshakes.
+	transport.MaxIdleConnsPerHost = *concurrency
+	client, err := keyclient.New(baseURL, keyclient.Options{
+		HTTPClient: &http.Client{Transport: transport, Timeout: *timeout},
+		APIKey:     *apiKey,
+		Token:      *token,
+		Retry:      keyclient.RetryPolicy{MaxAttempts: 1}, // Measure every attempt
+		UserAgent:  "key-server-load",
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	fmt.Fprintf(os.Stderr, "Loading %s with %d concurrent requests...\n", baseURL, *concurrency)
+	report, err := loadgen.Run(ctx, client, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid options: %v\n", err)
+		return exitUsage
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		return exitError
+	}
+	if report.Successes != report.Requests {
+		return exitError
+	}
+	return exitOK
+}
+
+// startInProcess runs an Application, configured like "serve" from
+// configArgs, CONFIG_FILE and the environment, on a loopback port over plain
+// HTTP. Only warnings and errors are logged so request logs do not slow
+// the run down.
+func startInProcess(configArgs []string) (baseURL string, shutdown func(), err error) {
+	cfg, err := config.Load(configArgs)
+	if err != nil {
+		return "", nil, err
+	}
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(slog.LevelWarn)
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, logLevel)
+	if err != nil {
+		return "", nil, err
+	}
+	app, err := NewApplication(cfg, configArgs, logger, logLevel)
+	if err != nil {
+		return "", nil, err
+	}
+	app.setupRoutes()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go app.server.Serve(l)
+
+	return "http://" + l.Addr().String(), func() {
+		app.server.Close()
+		app.auditLog.Close()
+		app.tlog.Close()
+		app.shutdownTracing(context.Background())
+	}, nil
+}
@@ -2,111 +2,477 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/ssh"
 
+	"github.com/bajhalshrey/Key-Server-Application/internal/acme"
+	"github.com/bajhalshrey/Key-Server-Application/internal/admin"
+	"github.com/bajhalshrey/Key-Server-Application/internal/audit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/auth"
+	"github.com/bajhalshrey/Key-Server-Application/internal/buildinfo"
+	"github.com/bajhalshrey/Key-Server-Application/internal/ca"
 	"github.com/bajhalshrey/Key-Server-Application/internal/config"
 	"github.com/bajhalshrey/Key-Server-Application/internal/handler"
+	"github.com/bajhalshrey/Key-Server-Application/internal/health"
+	"github.com/bajhalshrey/Key-Server-Application/internal/idempotency"
 	"github.com/bajhalshrey/Key-Server-Application/internal/keygenerator"
 	"github.com/bajhalshrey/Key-Server-Application/internal/keyservice"
+	"github.com/bajhalshrey/Key-Server-Application/internal/keystore"
+	"github.com/bajhalshrey/Key-Server-Application/internal/lifecycle"
+	"github.com/bajhalshrey/Key-Server-Application/internal/listener"
+	"github.com/bajhalshrey/Key-Server-Application/internal/logging"
 	"github.com/bajhalshrey/Key-Server-Application/internal/metrics"
+	"github.com/bajhalshrey/Key-Server-Application/internal/policy"
+	"github.com/bajhalshrey/Key-Server-Application/internal/ratelimit"
+	"github.com/bajhalshrey/Key-Server-Application/internal/requestid"
+	"github.com/bajhalshrey/Key-Server-Application/internal/sshca"
+	"github.com/bajhalshrey/Key-Server-Application/internal/tracing"
+	"github.com/bajhalshrey/Key-Server-Application/internal/transparency"
 )
 
 // Application holds the application's dependencies and configuration.
 type Application struct {
-	config          *config.Config
+	config          *config.Store // Current configuration snapshot, replaced on reload
+	configArgs      []string      // Command-line flags the configuration is reloaded with
+	logger          *slog.Logger
+	logLevel        *slog.LevelVar
+	metrics         *metrics.PrometheusMetrics
+	policy          *policy.Engine
+	certificate     atomic.Pointer[tls.Certificate] // Served certificate (nil = TLS disabled)
+	reloadMu        sync.Mutex
 	handler         *handler.HTTPHandler
+	keysHandler     *handler.KeysHandler
+	keyScheduler    *keyservice.StateScheduler
+	authenticator   *auth.Authenticator
+	limiter         *ratelimit.Limiter
+	idempotency     *idempotency.Cache
+	auditLog        *audit.Logger
+	tlog            *transparency.Log
+	policyHandler   *policy.Handler
+	acmeServer      *acme.Server
+	sshCAHandler    *sshca.Handler
 	router          *mux.Router
 	server          *http.Server
+	adminServer     *http.Server // Serves the admin endpoints (nil = they are on the public listeners)
 	metricsRegistry *prometheus.Registry
+	health          *health.Registry // Readiness checks served on /ready
+	lifecycle       *lifecycle.Manager
+	shutdownTracing func(context.Context) error
 }
 
 // NewApplication creates and initializes a new Application instance.
-// It wires up all the dependencies (metrics, key generator, key service, handler).
-func NewApplication(cfg *config.Config) *Application {
+// It wires up all the dependencies (metrics, key generator, key service, handler, CA).
+// configArgs are the command-line flags cfg was loaded with; reloads apply them
+// again. logLevel must be the level logger was created with.
+func NewApplication(cfg *config.Config, configArgs []string, logger *slog.Logger, logLevel *slog.LevelVar) (*Application, error) {
 	appRegistry := prometheus.NewRegistry()
-	appMetrics := metrics.NewPrometheusMetricsWithRegistry(appRegistry, cfg.MaxSize)
+	appMetrics := metrics.NewPrometheusMetricsWithOptions(appRegistry, metrics.Options{
+		MaxKeySize:    cfg.MaxSize,
+		LengthClasses: cfg.MetricsLengthClasses,
+	})
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		SampleRatio:  cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error setting up tracing: %w", err)
+	}
+	if cfg.TracingExporter != "" {
+		logger.Info("Tracing enabled", "exporter", cfg.TracingExporter, "sample_ratio", cfg.TracingSampleRatio)
+	}
+
+	policyEngine, err := newPolicyEngine(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
 
+	auditLog, err := newAuditLogger(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tlog, err := newTransparencyLog(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	store := config.NewStore(cfg)
 	keyGen := keygenerator.NewCryptoKeyGenerator()
-	keySvc := keyservice.NewKeyService(keyGen, cfg, appMetrics)
-	httpHandler := handler.NewHTTPHandler(keySvc, appMetrics)
+	keyStore := keystore.NewStore()
+	healthRegistry := health.NewRegistry()
+	healthRegistry.Register("generator", health.Cached(health.GeneratorCheck(keyGen), selfTestInterval))
+	healthRegistry.Register("entropy", health.Cached(health.EntropyCheck(rand.Reader), selfTestInterval))
+	healthRegistry.Register("key_store", health.Cached(health.KeyStoreCheck(keyStore), selfTestInterval))
+	keySvc := keyservice.NewKeyService(keyGen, store, appMetrics, policyEngine, auditLog, tlog, logger)
+	httpHandler := handler.NewHTTPHandler(keySvc, appMetrics, logger)
+	appMetrics.RegisterKeyUsage(keyStore.UsageTotals)
+	namedKeySvc := keyservice.NewNamedKeyService(keyStore, store, keyGen, appMetrics, policyEngine, auditLog, tlog, logger)
+
+	authenticator, err := auth.NewAuthenticator(auth.Options{
+		APIKeysFile: cfg.APIKeysFile,
+		JWKSFile:    cfg.JWKSFile,
+		Issuer:      cfg.JWTIssuer,
+		Audience:    cfg.JWTAudience,
+	}, appMetrics, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error loading authentication settings: %w", err)
+	}
+
+	authority, err := newAuthority(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	authority.OnIssue(func(cert *x509.Certificate) error {
+		_, err := tlog.Append(transparency.Leaf{Type: transparency.LeafX509Certificate, Data: cert.Raw})
+		return err
+	})
+	if len(cfg.ACMEAllowedDomains) == 0 {
+		logger.Warn("ACME_ALLOWED_DOMAINS not provided. The ACME server rejects every order.")
+	}
+	acmeServer := acme.NewServer(authority, acme.Options{AllowedDomains: cfg.ACMEAllowedDomains, Audit: auditLog}, logger)
+
+	sshAuthority, err := newSSHAuthority(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	idempotencyCache, err := idempotency.New(idempotency.Options{
+		TTL:      cfg.IdempotencyTTL,
+		MaxBytes: cfg.IdempotencyMaxBytes,
+		Metrics:  appMetrics,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	router := mux.NewRouter()
 
 	app := &Application{
-		config:          cfg,
+		config:          store,
+		configArgs:      configArgs,
+		logger:          logger,
+		logLevel:        logLevel,
+		metrics:         appMetrics,
+		policy:          policyEngine,
 		handler:         httpHandler,
+		keysHandler:     handler.NewKeysHandler(namedKeySvc, logger),
+		keyScheduler:    keyservice.NewStateScheduler(namedKeySvc, cfg.KeyStateCheckInterval, logger),
+		authenticator:   authenticator,
+		limiter:         ratelimit.NewLimiter(cfg.RateLimits, appMetrics),
+		idempotency:     idempotencyCache,
+		auditLog:        auditLog,
+		tlog:            tlog,
+		policyHandler:   policy.NewHandler(policyEngine, logger),
+		acmeServer:      acmeServer,
+		sshCAHandler:    sshca.NewHandler(sshAuthority, policyEngine, appMetrics, auditLog, tlog, logger),
 		router:          router,
 		metricsRegistry: appRegistry,
+		health:          healthRegistry,
+		shutdownTracing: shutdownTracing,
+		lifecycle: lifecycle.New(lifecycle.Options{
+			Health:       healthRegistry,
+			Metrics:      appMetrics,
+			Logger:       logger,
+			UnreadyDelay: cfg.ServerShutdownDelay,
+		}),
 	}
 
-	// Use %s for Addr as cfg.Port is a string (e.g., "8443")
+	// Listeners are opened in Start; Addr is only used in log messages.
 	app.server = &http.Server{
-		Addr:         fmt.Sprintf(":%s", app.config.Port), // FIX: Changed %d to %s
-		Handler:      app.router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf(":%s", cfg.Port),
+		Handler:           appMetrics.InstrumentHandler(app.router), // Also counts 404s and 405s from the router
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.ServerMaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
+	app.server.SetKeepAlivesEnabled(cfg.ServerKeepAlives)
 
-	return app
+	if cfg.AdminAddress != "" {
+		// No write timeout: CPU profiles and traces stream for as long as
+		// the caller asks for.
+		app.adminServer = &http.Server{
+			Handler: admin.NewRouter(admin.Options{
+				Metrics: app.metricsHandler(),
+				Health:  httpHandler.HealthCheck,
+				Ready:   healthRegistry.ReadyHandler,
+			}),
+			ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+			IdleTimeout:       cfg.ServerIdleTimeout,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		}
+	}
+
+	return app, nil
+}
+
+// newPolicyEngine loads the authorization policy, or returns a permissive
+// engine when none is configured.
+func newPolicyEngine(cfg *config.Config, logger *slog.Logger) (*policy.Engine, error) {
+	if cfg.PolicyFile == "" {
+		logger.Info("POLICY_FILE not provided. Authorization policy enforcement is disabled.")
+		return policy.Disabled(), nil
+	}
+	engine, err := policy.LoadFile(cfg.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading authorization policy: %w", err)
+	}
+	logger.Info("Loaded authorization policy", "file", cfg.PolicyFile)
+	return engine, nil
+}
+
+// newAuditLogger opens the configured audit sinks, or returns a disabled
+// logger when none is configured.
+func newAuditLogger(cfg *config.Config, logger *slog.Logger) (*audit.Logger, error) {
+	if cfg.AuditLogFile == "" && cfg.AuditSyslogSocket == "" && !cfg.AuditStdout {
+		logger.Warn("No AUDIT_LOG_FILE, AUDIT_SYSLOG_SOCKET or AUDIT_STDOUT configured. Key operations are not audited.")
+		return audit.Disabled(), nil
+	}
+	auditLog, err := audit.Open(audit.Options{
+		File:         cfg.AuditLogFile,
+		SyslogSocket: cfg.AuditSyslogSocket,
+		Stdout:       cfg.AuditStdout,
+		Logger:       logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	seq, head := auditLog.Head()
+	logger.Info("Audit log opened", "seq", seq, "head", head)
+	return auditLog, nil
+}
+
+// newTransparencyLog opens the transparency log, keeping it in memory and
+// signing with an ephemeral key when no files are configured.
+func newTransparencyLog(cfg *config.Config, logger *slog.Logger) (*transparency.Log, error) {
+	if cfg.TransparencyLogFile == "" {
+		logger.Warn("TRANSPARENCY_LOG_FILE not provided. The transparency log is kept in memory and lost on restart.")
+	}
+	if cfg.TransparencySigningKeyFile == "" {
+		logger.Warn("TRANSPARENCY_SIGNING_KEY_FILE not provided. Tree heads are signed with an ephemeral key.")
+	}
+	tlog, err := transparency.Open(transparency.Options{
+		File:           cfg.TransparencyLogFile,
+		SigningKeyFile: cfg.TransparencySigningKeyFile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error opening transparency log: %w", err)
+	}
+	logger.Info("Transparency log opened", "entries", tlog.Size())
+	return tlog, nil
+}
+
+// newAuthority loads the internal CA from the configured files, or creates an
+// ephemeral one when none is configured.
+func newAuthority(cfg *config.Config, logger *slog.Logger) (*ca.Authority, error) {
+	if cfg.CACertFile == "" {
+		logger.Warn("CA_CERT_FILE not provided. Using an ephemeral internal CA; issued certificates will not survive a restart.")
+		return ca.NewSelfSignedAuthority("Key Server Ephemeral CA")
+	}
+	authority, err := ca.NewAuthority(cfg.CACertFile, cfg.CAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading internal CA: %w", err)
+	}
+	logger.Info("Loaded internal CA", "subject", authority.Certificate().Subject.CommonName)
+	return authority, nil
+}
+
+// newSSHAuthority loads the SSH CA key from the configured file, or creates an
+// ephemeral one when none is configured.
+func newSSHAuthority(cfg *config.Config, logger *slog.Logger) (*sshca.Authority, error) {
+	if cfg.SSHCAKeyFile == "" {
+		logger.Warn("SSH_CA_KEY_FILE not provided. Using an ephemeral SSH CA; hosts must re-fetch the CA key after a restart.")
+		return sshca.NewEphemeralAuthority(cfg.SSHCertMaxTTL)
+	}
+	authority, err := sshca.NewAuthority(cfg.SSHCAKeyFile, cfg.SSHCertMaxTTL)
+	if err != nil {
+		return nil, fmt.Errorf("error loading SSH CA: %w", err)
+	}
+	logger.Info("Loaded SSH CA key", "fingerprint", ssh.FingerprintSHA256(authority.PublicKey()))
+	return authority, nil
 }
 
 // setupRoutes configures the HTTP routes for the application.
+// Probes, metrics, public CA material, the transparency log and ACME (which
+// authenticates with its own JWS scheme) are public; key-issuing routes require
+// authentication when enabled.
 func (app *Application) setupRoutes() {
-	app.router.HandleFunc("/health", app.handler.HealthCheck).Methods("GET")
-	app.router.HandleFunc("/key/{length}", app.handler.GenerateKey).Methods("GET")
-	app.router.HandleFunc("/ready", app.handler.ReadinessCheck).Methods("GET")
-	app.router.Handle("/metrics", promhttp.HandlerFor(app.metricsRegistry, promhttp.HandlerOpts{})).Methods("GET")
+	app.router.Use(metrics.RouteMiddleware, requestid.Middleware, tracing.Middleware, logging.Middleware(app.logger))
+	if app.adminServer == nil {
+		app.router.HandleFunc("/health", app.handler.HealthCheck).Methods("GET")
+		app.router.HandleFunc("/ready", app.health.ReadyHandler).Methods("GET")
+		app.router.Handle("/metrics", app.metricsHandler()).Methods("GET")
+	}
+	app.router.HandleFunc("/.well-known/ssh-ca.pub", app.sshCAHandler.CAPublicKey).Methods("GET")
+	app.acmeServer.RegisterRoutes(app.router)
+	transparency.NewHandler(app.tlog, app.logger).RegisterRoutes(app.router)
+
+	protected := app.router.NewRoute().Subrouter()
+	// Counts key requests in flight so shutdown can wait for them.
+	protected.Use(app.lifecycle.Track)
+	// Throttles by client IP ahead of authentication, so floods of failed
+	// logins are limited too.
+	protected.Use(app.limiter.PreAuth)
+	if app.authenticator.Enabled() {
+		protected.Use(app.authenticator.Middleware)
+	} else {
+		app.logger.Warn("No AUTH_API_KEYS_FILE or AUTH_JWKS_FILE configured. Key routes are unauthenticated.")
+	}
+	// Key-issuing routes accept an Idempotency-Key. Replays are answered
+	// before the limiter so retries do not use up quota.
+	issuing := protected.NewRoute().Subrouter()
+	issuing.Use(app.idempotency.Middleware)
+	// Runs after authentication so limits can be keyed by principal. It is
+	// installed even without limits so that limits added by a reload apply.
+	issuing.Use(app.limiter.Middleware)
+	issuing.HandleFunc("/key/{length}", app.handler.GenerateKey).Methods("GET")
+	issuing.HandleFunc("/ssh/sign", app.sshCAHandler.Sign).Methods("POST")
+	app.keysHandler.RegisterCreate(issuing)
+	operations := protected.NewRoute().Subrouter()
+	operations.Use(app.limiter.Middleware)
+	app.keysHandler.RegisterRoutes(operations)
+	operations.HandleFunc("/policy/dry-run", app.policyHandler.DryRun).Methods("POST")
 
-	log.Println("Configured Routes:")
 	err := app.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		path, err := route.GetPathTemplate()
 		if err == nil {
 			methods, _ := route.GetMethods()
 			if methods == nil || len(methods) == 0 {
-				log.Printf("  [ANY] %s", path)
-			} else {
-				log.Printf("  [%s] %s", strings.Join(methods, ", "), path)
+				methods = []string{"ANY"}
 			}
+			app.logger.Debug("Configured route", "methods", strings.Join(methods, ", "), "path", path)
 		}
 		return nil
 	})
 	if err != nil {
-		log.Printf("Error walking routes: %v", err)
+		app.logger.Error("Error walking routes", "error", err)
+	}
+}
+
+// certificateExpiryWarning is how long before expiry the TLS certificate
+// check starts warning.
+const certificateExpiryWarning = 7 * 24 * time.Hour
+
+// selfTestInterval is how long the results of the readiness self-tests,
+// which draw random bytes and exercise the keys, are reused, so that
+// frequent probes stay cheap.
+const selfTestInterval = 30 * time.Second
+
+// tlsVersion maps a configured minimum TLS version onto its crypto/tls
+// constant. The configuration is validated, so anything else means TLS 1.2.
+func tlsVersion(v string) uint16 {
+	if v == config.TLSVersion13 {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// metricsHandler serves the application registry in the Prometheus and
+// OpenMetrics formats.
+func (app *Application) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(app.metricsRegistry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// openListeners opens the configured public listeners (a TCP listener on Port
+// when none are configured) and the admin listener, if any. They are opened
+// together because systemd sockets can only be claimed once.
+func openListeners(cfg *config.Config) (public []net.Listener, adminListener net.Listener, err error) {
+	addrs := cfg.Listeners
+	if len(addrs) == 0 {
+		addrs = []string{":" + cfg.Port}
+	}
+	if cfg.AdminAddress != "" {
+		addrs = append(addrs[:len(addrs):len(addrs)], cfg.AdminAddress)
+	}
+	specs := make([]listener.Spec, len(addrs))
+	for i, addr := range addrs {
+		spec, err := listener.Parse(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		specs[i] = spec
+	}
+	listeners, err := listener.Open(specs, listener.Options{UnixSocketMode: cfg.SocketMode()})
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.AdminAddress != "" {
+		return listeners[:len(listeners)-1], listeners[len(listeners)-1], nil
+	}
+	return listeners, nil, nil
+}
+
+// Process exit statuses.
+const (
+	exitOK      = 0 // Clean shutdown
+	exitError   = 1 // Startup or serving failed
+	exitUsage   = 2 // Invalid command line
+	exitUnclean = 3 // Shutdown timed out or a step failed; work may have been lost
+)
+
+// serve runs srv on l until it is shut down, reporting unexpected failures
+// on errs.
+func (app *Application) serve(name string, srv *http.Server, l net.Listener, useTLS bool, errs chan<- error) {
+	var err error
+	if useTLS {
+		app.logger.Info("Key Server serving HTTPS", "listener", name, "network", l.Addr().Network(), "address", l.Addr().String())
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		app.logger.Info("Key Server serving HTTP (TLS disabled)", "listener", name, "network", l.Addr().Network(), "address", l.Addr().String())
+		err = srv.Serve(l)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errs <- fmt.Errorf("%s listener %s: %w", name, l.Addr(), err)
 	}
 }
 
-// Start runs the application, setting up routes and starting the HTTP server.
-func (app *Application) Start() {
+// Start runs the application until it receives SIGINT or SIGTERM or a
+// listener fails, then shuts down gracefully and returns the process exit
+// status.
+func (app *Application) Start() int {
 	app.setupRoutes()
+	cfg := app.config.Current()
 
-	var cert *tls.Certificate
-	if app.config.CertFile != "" && app.config.KeyFile != "" {
-		loadedCert, err := tls.LoadX509KeyPair(app.config.CertFile, app.config.KeyFile)
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := loadCertificate(cfg)
 		if err != nil {
-			log.Fatalf("Error loading SSL certificates from %s and %s: %v", app.config.CertFile, app.config.KeyFile, err)
+			app.logger.Error("Error loading SSL certificates", "cert_file", cfg.CertFile, "key_file", cfg.KeyFile, "error", err)
+			return exitError
 		}
-		cert = &loadedCert
-		log.Printf("Loaded TLS certificates: %s, %s", app.config.CertFile, app.config.KeyFile)
+		app.certificate.Store(cert)
+		app.health.Register("tls_certificate", health.CertificateCheck(app.certificate.Load, certificateExpiryWarning))
+		app.logger.Info("Loaded TLS certificates", "cert_file", cfg.CertFile, "key_file", cfg.KeyFile)
 	} else {
-		log.Println("TLS certificates not provided. Server will not run with HTTPS.")
+		app.logger.Warn("TLS certificates not provided. Server will not run with HTTPS.")
 	}
 
 	tlsConfig := &tls.Config{
-		MinVersion:               tls.VersionTLS12,
+		MinVersion:               tlsVersion(cfg.TLSMinVersion),
 		PreferServerCipherSuites: true,
 		CurvePreferences: []tls.CurveID{
 			tls.CurveP521,
@@ -119,51 +485,218 @@ func (app *Application) Start() {
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_AES_256_GCM_SHA384,
 		},
+		// Looked up per handshake so reloaded certificates apply to new connections.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return app.certificate.Load(), nil
+		},
 	}
-	if cert != nil {
-		tlsConfig.Certificates = []tls.Certificate{*cert}
+	tlsEnabled := app.certificate.Load() != nil
+	if tlsEnabled {
 		app.server.TLSConfig = tlsConfig
 	}
 
-	go func() {
-		var serveErr error
-		if cert != nil {
-			// FIX: Changed %d to %s for logging port
-			log.Printf("Key Server starting on HTTPS port %s...", app.config.Port)
-			serveErr = app.server.ListenAndServeTLS(app.config.CertFile, app.config.KeyFile)
-		} else {
-			// FIX: Changed %d to %s for logging port
-			log.Printf("Key Server starting on HTTP port %s (TLS disabled)...", app.config.Port)
-			serveErr = app.server.ListenAndServe()
-		}
+	listeners, adminListener, err := openListeners(cfg)
+	if err != nil {
+		app.logger.Error("Error opening listeners", "error", err)
+		return exitError
+	}
+	serveErrs := make(chan error, len(listeners)+1)
+	for _, l := range listeners {
+		go app.serve("public", app.server, l, tlsEnabled && !listener.IsUnix(l), serveErrs)
+	}
+	if adminListener != nil {
+		// Admin endpoints are plain HTTP; bind them to a local or
+		// pod-internal address.
+		go app.serve("admin", app.adminServer, adminListener, false, serveErrs)
+	}
 
-		if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
-			log.Fatalf("Server failed to start: %v", serveErr)
-		}
-	}()
+	stopReloads := make(chan struct{})
+	go app.handleReloads(stopReloads)
+	app.keyScheduler.Start()
+	app.registerShutdownSteps(stopReloads)
 
-	quit := make(chan os.Signal, 1)
+	quit := make(chan os.Signal, 2)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
-
-	log.Println("Shutting down server...")
+	status := exitOK
+	select {
+	case sig := <-quit:
+		app.logger.Info("Shutting down server...", "signal", sig.String())
+	case err := <-serveErrs:
+		app.logger.Error("Server failed, shutting down", "error", err)
+		status = exitError
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// The propagation delay is not part of the drain budget.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ServerShutdownDelay+cfg.ServerShutdownTimeout)
 	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- app.lifecycle.Shutdown(ctx) }()
 
-	if err := app.server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	select {
+	case err := <-done:
+		if err != nil {
+			app.logger.Error("Shutdown did not complete cleanly", "error", err)
+			return exitUnclean
+		}
+	case sig := <-quit:
+		app.logger.Error("Received second signal, exiting without draining", "signal", sig.String(), "in_flight", app.lifecycle.InFlight())
+		return exitUnclean
 	}
+	app.logger.Info("Server exited gracefully.")
+	return status
+}
 
-	log.Println("Server exited gracefully.")
+// registerShutdownSteps registers the shutdown sequence: stop taking new
+// work, drain in-flight requests, then stop the workers those requests
+// used. The admin server stops last so metrics and probes stay available
+// while requests drain.
+func (app *Application) registerShutdownSteps(stopReloads chan struct{}) {
+	app.lifecycle.OnShutdown("config reloads", func(context.Context) error {
+		close(stopReloads)
+		// Wait for a reload that is already running.
+		app.reloadMu.Lock()
+		defer app.reloadMu.Unlock()
+		return nil
+	})
+	app.lifecycle.OnShutdown("public listeners", app.server.Shutdown)
+	app.lifecycle.OnShutdown("key requests", app.lifecycle.Drain)
+	// Stopped before the audit log, which it writes to.
+	app.lifecycle.OnShutdown("key state scheduler", app.keyScheduler.Stop)
+	app.lifecycle.OnShutdown("audit log", func(context.Context) error {
+		// Record the final head so truncation of the log's tail can be
+		// detected with "audit verify -head".
+		seq, head := app.auditLog.Head()
+		if err := app.auditLog.Close(); err != nil {
+			return err
+		}
+		app.logger.Info("Audit log closed", "seq", seq, "head", head)
+		return nil
+	})
+	app.lifecycle.OnShutdown("transparency log", func(context.Context) error {
+		return app.tlog.Close()
+	})
+	app.lifecycle.OnShutdown("tracing", app.shutdownTracing)
+	if app.adminServer != nil {
+		app.lifecycle.OnShutdown("admin listener", app.adminServer.Shutdown)
+	}
+}
+
+// commands are the key-server subcommands. Each returns the process exit
+// status.
+var commands = map[string]func(args []string) int{
+	"serve":   runServe,
+	"gen":     runGen,
+	"bench":   runBench,
+	"load":    runLoad,
+	"audit":   subcommands("audit", map[string]func([]string) int{"verify": runAuditVerify}),
+	"config":  subcommands("config", map[string]func([]string) int{"check": runConfigCheck}),
+	"version": runVersion,
 }
 
 func main() {
-	cfg, err := config.NewConfig()
+	args := os.Args[1:]
+	// Without a command the server runs, so existing deployments that only
+	// pass flags keep working.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(runServe(args))
+	}
+	if args[0] == "help" {
+		usage(os.Stdout)
+		os.Exit(exitOK)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	os.Exit(cmd(args[1:]))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: key-server [COMMAND] [flags] [args]
+
+Commands:
+  serve          Run the key server (the default when no command is given)
+  gen            Generate keys locally, without a server
+  bench          Measure key generation throughput in process
+  load           Load test the key API of a remote or in-process server
+  audit verify   Verify an audit log's hash chain
+  config check   Validate the configuration and print the effective settings
+  version        Print version and build information
+
+Run "key-server COMMAND -h" for the flags of a command.
+`)
+}
+
+// subcommands dispatches "key-server NAME SUBCOMMAND" to cmds.
+func subcommands(name string, cmds map[string]func([]string) int) func([]string) int {
+	return func(args []string) int {
+		if len(args) > 0 {
+			if cmd, ok := cmds[args[0]]; ok {
+				return cmd(args[1:])
+			}
+		}
+		names := make([]string, 0, len(cmds))
+		for sub := range cmds {
+			names = append(names, sub)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "Usage: key-server %s {%s} [flags] [args]\n", name, strings.Join(names, "|"))
+		return exitUsage
+	}
+}
+
+// runVersion implements "key-server version".
+func runVersion(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: key-server version")
+		return exitUsage
+	}
+	info := buildinfo.Get()
+	fmt.Printf("key-server %s\n", info.Version)
+	if info.Revision != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("  revision: %s%s\n", info.Revision, modified)
+		fmt.Printf("  time:     %s\n", info.Time)
+	}
+	fmt.Printf("  go:       %s\n", info.GoVersion)
+	return exitOK
+}
+
+// runServe implements "key-server serve [flags]": it loads the
+// configuration, runs the server until it is stopped and returns the exit
+// status.
+func runServe(args []string) int {
+	cfg, err := config.Load(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, config.ErrUsage):
+		return exitUsage
+	case err != nil:
+		slog.Error("Error loading configuration", "error", err)
+		return exitError
+	}
+
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.LogLevel)
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, logLevel)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		slog.Error("Error creating logger", "error", err)
+		return exitError
 	}
+	// Packages that still use the standard log package write through the
+	// structured logger too.
+	slog.SetDefault(logger)
 
-	app := NewApplication(cfg)
-	app.Start()
+	app, err := NewApplication(cfg, args, logger, logLevel)
+	if err != nil {
+		logger.Error("Error initializing application", "error", err)
+		return exitError
+	}
+	return app.Start()
 }
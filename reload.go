@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bajhalshrey/Key-Server-Application/internal/config"
+)
+
+// handleReloads reloads the configuration on SIGHUP and, when it was loaded
+// from a file and ConfigWatchInterval is set, whenever that file changes. It
+// returns when stop is closed.
+func (app *Application) handleReloads(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	cfg := app.config.Current()
+	var changed <-chan time.Time
+	var last fileVersion
+	if cfg.File != "" && cfg.ConfigWatchInterval > 0 {
+		last = statFile(cfg.File)
+		ticker := time.NewTicker(cfg.ConfigWatchInterval)
+		defer ticker.Stop()
+		changed = ticker.C
+		app.logger.Info("Watching configuration file for changes", "file", cfg.File, "interval", cfg.ConfigWatchInterval)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			app.reload("SIGHUP")
+		case <-changed:
+			// Stat follows symlinks, so the atomic symlink swap used for
+			// Kubernetes ConfigMap volumes is seen as a change too.
+			if v := statFile(cfg.File); v != last {
+				last = v
+				app.reload("file change")
+			}
+		}
+	}
+}
+
+// fileVersion identifies the contents of a watched file well enough to
+// notice edits.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reload loads the configuration again and applies the settings that can
+// change at runtime. An invalid configuration is rejected as a whole and the
+// running configuration is kept.
+func (app *Application) reload(trigger string) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	err := app.applyConfig(trigger)
+	app.metrics.RecordConfigReload(err == nil)
+	if err != nil {
+		app.logger.Error("Configuration reload failed, keeping the current configuration", "trigger", trigger, "error", err)
+	}
+}
+
+// applyConfig prepares everything a new configuration needs (the policy and
+// TLS certificate) before changing anything, so a failure part way through
+// leaves the running configuration untouched.
+func (app *Application) applyConfig(trigger string) error {
+	next, err := config.Load(app.configArgs)
+	if err != nil {
+		return err
+	}
+	current := app.config.Current()
+	next, ignored := current.ApplyReloadable(next)
+	if len(ignored) > 0 {
+		app.logger.Warn("Ignoring configuration changes that require a restart", "settings", ignored)
+	}
+
+	engine, err := newPolicyEngine(next, app.logger)
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if app.certificate.Load() != nil {
+		// Certificates are read again even when the paths are unchanged, so a
+		// rotated certificate is picked up.
+		if cert, err = loadCertificate(next); err != nil {
+			return fmt.Errorf("error loading TLS certificates: %w", err)
+		}
+	} else if next.CertFile != current.CertFile || next.KeyFile != current.KeyFile {
+		app.logger.Warn("Ignoring TLS certificate settings: the server was started without TLS")
+		next.CertFile, next.KeyFile = current.CertFile, current.KeyFile
+	}
+
+	app.policy.Replace(engine)
+	app.limiter.SetRules(next.RateLimits)
+	app.logLevel.Set(next.LogLevel)
+	if cert != nil {
+		app.certificate.Store(cert)
+	}
+	app.config.Swap(next)
+
+	app.logger.Info("Configuration reloaded", "trigger", trigger, "max_key_size", next.MaxSize,
+		"rate_limits", len(next.RateLimits), "policy_file", next.PolicyFile, "log_level", next.LogLevel)
+	return nil
+}
+
+// loadCertificate reads the TLS certificate and key named by cfg.
+func loadCertificate(cfg *config.Config) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}